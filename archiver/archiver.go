@@ -1,13 +1,16 @@
 package archiver
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/httpkit/httpfile"
 	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
 )
 
@@ -44,12 +47,22 @@ type ExtractSettings struct {
 	OnEntryDone             EntryDoneFunc
 	DryRun                  bool
 	Concurrency             int
+
+	// RefuseEscapingSymlinks makes extraction fail with a *safepath.ErrUnsafePath
+	// if any symlink of the archive resolves outside of the destination.
+	// Entries with unsafe paths (absolute, or with '..'), or that are inside
+	// one of the archive's symlinks, are always refused.
+	RefuseEscapingSymlinks bool
 }
 
 func ExtractPath(archive string, destPath string, settings ExtractSettings) (*ExtractResult, error) {
 	var result *ExtractResult
 	var err error
 
+	if IsTar(archive) {
+		return ExtractTar(archive, destPath, settings)
+	}
+
 	file, err := eos.Open(archive)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
@@ -165,3 +178,24 @@ func CopyFile(filename string, mode os.FileMode, fileReader io.Reader) error {
 	}
 	return nil
 }
+
+// checkNotInSymlink returns a *safepath.ErrUnsafePath if one of the parents
+// of the entry at slashPath is one of the archive's symlinks, since
+// writing it would go through that symlink.
+func checkNotInSymlink(links map[string]string, slashPath string) error {
+	for dir := path.Dir(slashPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := links[dir]; ok {
+			return errors.Wrap(&safepath.ErrUnsafePath{
+				Path:   slashPath,
+				Reason: fmt.Sprintf("parent '%s' is a symlink", dir),
+			}, 1)
+		}
+	}
+	return nil
+}
+
+// entryKey normalizes an archive entry name, so that "a/b/" and "a/./b"
+// are recognized as the same entry
+func entryKey(name string) string {
+	return path.Clean(name)
+}
@@ -1,15 +1,28 @@
 package archiver
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/Datadog/zstd"
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/go-brotli/enc"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
+	"github.com/stretchr/testify/assert"
 )
 
 var testSymlinks bool = (runtime.GOOS != "windows")
@@ -144,3 +157,283 @@ func Test_TarUntar(t *testing.T) {
 	_, err = ExtractTar(archivePath, extractedDir, xSettings)
 	assert.NoError(t, err)
 }
+
+func Test_UnsafeZip(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "unsafezip")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	makeZip := func(entries func(zw *zip.Writer)) []byte {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		entries(zw)
+		assert.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	addFile := func(zw *zip.Writer, name string) {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("pwned"))
+		assert.NoError(t, err)
+	}
+
+	addLink := func(zw *zip.Writer, name string, dest string) {
+		fh := &zip.FileHeader{Name: name}
+		fh.SetMode(os.ModeSymlink | 0777)
+		w, err := zw.CreateHeader(fh)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(dest))
+		assert.NoError(t, err)
+	}
+
+	extract := func(archive []byte, settings ExtractSettings) error {
+		settings.Consumer = &state.Consumer{}
+		dest := filepath.Join(tmpPath, "dest")
+		assert.NoError(t, os.RemoveAll(dest))
+		_, err := Extract(bytes.NewReader(archive), int64(len(archive)), dest, settings)
+		return err
+	}
+
+	err = extract(makeZip(func(zw *zip.Writer) {
+		addFile(zw, "../evil")
+	}), ExtractSettings{})
+	assert.True(t, safepath.IsUnsafePath(err), "parent traversal should be refused")
+
+	_, err = os.Lstat(filepath.Join(tmpPath, "evil"))
+	assert.True(t, os.IsNotExist(err))
+
+	if !testSymlinks {
+		return
+	}
+
+	err = extract(makeZip(func(zw *zip.Writer) {
+		addLink(zw, "link", tmpPath)
+		addFile(zw, "link/evil")
+	}), ExtractSettings{})
+	assert.True(t, safepath.IsUnsafePath(err), "writes through symlinks should be refused")
+
+	escaping := makeZip(func(zw *zip.Writer) {
+		addFile(zw, "file")
+		addLink(zw, "link", "../outside")
+	})
+	assert.NoError(t, extract(escaping, ExtractSettings{}))
+
+	err = extract(escaping, ExtractSettings{RefuseEscapingSymlinks: true})
+	assert.True(t, safepath.IsUnsafePath(err), "escaping symlinks should be refused when asked")
+}
+
+// tarCompressors compress tars for each of the names IsTar recognizes
+var tarCompressors = map[string]func(w io.Writer) io.WriteCloser{
+	"archive.tar": func(w io.Writer) io.WriteCloser {
+		return nopWriteCloser{w}
+	},
+	"archive.tar.gz": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	"archive.tzst": func(w io.Writer) io.WriteCloser {
+		return zstd.NewWriterLevel(w, 3)
+	},
+	"archive.tar.br": func(w io.Writer) io.WriteCloser {
+		return enc.NewBrotliWriter(w, &enc.BrotliWriterOptions{Quality: 1})
+	},
+}
+
+func Test_TarFormats(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "tarformats")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	dir := filepath.Join(tmpPath, "dir")
+	makeTestDir(t, dir)
+
+	tarBuf := new(bytes.Buffer)
+	_, err = CompressTar(tarBuf, dir, &state.Consumer{})
+	assert.NoError(t, err)
+
+	for name, compress := range tarCompressors {
+		assert.True(t, IsTar(name))
+
+		archivePath := filepath.Join(tmpPath, name)
+		archiveWriter, err := os.Create(archivePath)
+		assert.NoError(t, err)
+		cw := compress(archiveWriter)
+		_, err = cw.Write(tarBuf.Bytes())
+		assert.NoError(t, err)
+		assert.NoError(t, cw.Close())
+		assert.NoError(t, archiveWriter.Close())
+
+		extractedDir := filepath.Join(tmpPath, "extracted-"+name)
+		res, err := ExtractPath(archivePath, extractedDir, ExtractSettings{
+			Consumer: &state.Consumer{},
+		})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 6, res.Files, "%s: should extract all files", name)
+
+		data, err := ioutil.ReadFile(filepath.Join(extractedDir, "subdir", "file-1"))
+		assert.NoError(t, err)
+		assert.EqualValues(t, []byte{4, 3, 2, 1}, data, "%s: should extract contents", name)
+	}
+
+	assert.False(t, IsTar("archive.zip"))
+}
+
+func Test_TarEntries(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "tarentries")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	longName := "subdir/" + strings.Repeat("long-name-", 20) + "file"
+
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: longName, Typeflag: tar.TypeReg, Mode: 0755, Size: 5}))
+	_, err = tw.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: longName, Mode: 0644}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644}))
+	assert.NoError(t, tw.Close())
+
+	dest := filepath.Join(tmpPath, "dest")
+	res, err := ExtractTarSource(seeksource.FromBytes(tarBuf.Bytes()), dest, ExtractSettings{
+		Consumer: &state.Consumer{},
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, res.Files)
+
+	for _, name := range []string{longName, "hardlink"} {
+		data, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.EqualValues(t, "hello", string(data))
+	}
+
+	_, err = os.Lstat(filepath.Join(dest, "fifo"))
+	assert.True(t, os.IsNotExist(err), "unsupported entries should be skipped")
+
+	tarBuf.Reset()
+	tw = tar.NewWriter(tarBuf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "../outside"}))
+	assert.NoError(t, tw.Close())
+	_, err = ExtractTarSource(seeksource.FromBytes(tarBuf.Bytes()), dest, ExtractSettings{
+		Consumer: &state.Consumer{},
+	})
+	assert.True(t, safepath.IsUnsafePath(err), "hard links outside of the destination should be refused")
+}
+
+// failingSource fails once it has read a given number of bytes
+type failingSource struct {
+	savior.Source
+	left int64
+}
+
+func (fs *failingSource) Read(p []byte) (int, error) {
+	if fs.left <= 0 {
+		return 0, errors.New("interrupted")
+	}
+	if int64(len(p)) > fs.left {
+		p = p[:fs.left]
+	}
+	n, err := fs.Source.Read(p)
+	fs.left -= int64(n)
+	return n, err
+}
+
+func Test_ResumeTar(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "resumetar")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	savedInterval := tarSaveInterval
+	tarSaveInterval = 4096
+	defer func() { tarSaveInterval = savedInterval }()
+
+	// random contents, so that compressed tars are interrupted halfway too
+	prng := rand.New(rand.NewSource(0x45))
+	files := make(map[string][]byte)
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("dir/file-%d", i)
+		data := make([]byte, 30*1024+i)
+		_, err = prng.Read(data)
+		assert.NoError(t, err)
+		files[name] = data
+
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+		_, err = tw.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+
+	for name, compress := range tarCompressors {
+		t.Run(name, func(t *testing.T) {
+			archiveBuf := new(bytes.Buffer)
+			cw := compress(archiveBuf)
+			_, err := cw.Write(tarBuf.Bytes())
+			assert.NoError(t, err)
+			assert.NoError(t, cw.Close())
+
+			newSource := func(source savior.Source) savior.Source {
+				if apply, ok := tarDecompressor(name); ok && apply != nil {
+					return apply(source)
+				}
+				return source
+			}
+
+			dest := filepath.Join(tmpPath, "dest-"+name)
+			resumeFile := filepath.Join(tmpPath, "resume-"+name)
+
+			var entriesDone []string
+			settings := ExtractSettings{
+				Consumer:   &state.Consumer{},
+				ResumeFrom: resumeFile,
+				OnEntryDone: func(slashPath string) {
+					entriesDone = append(entriesDone, slashPath)
+				},
+			}
+
+			source := newSource(&failingSource{
+				Source: seeksource.FromBytes(archiveBuf.Bytes()),
+				left:   int64(archiveBuf.Len()) / 2,
+			})
+			_, err = ExtractTarSource(source, dest, settings)
+			assert.Error(t, err)
+
+			// compressed sources may not be able to save checkpoints (zstd
+			// doesn't), extracting them again must then start over
+			_, err = os.Stat(resumeFile)
+			saved := err == nil
+			if name == "archive.tar" {
+				assert.True(t, saved, "should keep resume file when interrupted")
+			}
+			interrupted := len(entriesDone)
+
+			entriesDone = nil
+			res, err := ExtractTarSource(newSource(seeksource.FromBytes(archiveBuf.Bytes())), dest, settings)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 8, res.Files)
+			if saved {
+				assert.EqualValues(t, 8, interrupted+len(entriesDone), "should resume where it stopped")
+			} else {
+				assert.EqualValues(t, 8, len(entriesDone), "should start over")
+			}
+
+			for name, expected := range files {
+				data, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				assert.NoError(t, err)
+				assert.True(t, bytes.Equal(expected, data), "%s should be extracted", name)
+			}
+
+			_, err = os.Stat(resumeFile)
+			assert.True(t, os.IsNotExist(err), "should remove resume file when done")
+		})
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nwc nopWriteCloser) Close() error {
+	return nil
+}
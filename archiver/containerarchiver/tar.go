@@ -0,0 +1,112 @@
+package containerarchiver
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/counter"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+)
+
+func CompressTar(archiveWriter io.Writer, container *tlc.Container, pool wsync.Pool, consumer *state.Consumer) (*archiver.CompressResult, error) {
+	return CompressTarWithSettings(archiveWriter, container, pool, nil, consumer)
+}
+
+// CompressTarWithSettings is like CompressTar, but settings control entry
+// order and modification times (see archiver.ZipSettings, Compression
+// doesn't apply to tars)
+func CompressTarWithSettings(archiveWriter io.Writer, container *tlc.Container, pool wsync.Pool, settings *archiver.ZipSettings, consumer *state.Consumer) (*archiver.CompressResult, error) {
+	var err error
+	var uncompressedSize int64
+	var compressedSize int64
+
+	archiveCounter := counter.NewWriter(archiveWriter)
+
+	tarWriter := tar.NewWriter(archiveCounter)
+	defer func() {
+		if tarWriter != nil {
+			if tErr := tarWriter.Close(); err == nil && tErr != nil {
+				err = errors.Wrap(tErr, 1)
+			}
+		}
+	}()
+
+	order := entryOrder(container, settings)
+
+	for _, dirIndex := range order.dirs {
+		dir := container.Dirs[dirIndex]
+		th := &tar.Header{
+			Name:     dir.Path + "/",
+			Typeflag: tar.TypeDir,
+			Mode:     int64(os.FileMode(dir.Mode).Perm()),
+			ModTime:  settings.ModTimeFor(dir.Path),
+		}
+
+		hErr := tarWriter.WriteHeader(th)
+		if hErr != nil {
+			return nil, errors.Wrap(hErr, 1)
+		}
+	}
+
+	for _, fileIndex := range order.files {
+		file := container.Files[fileIndex]
+		th := &tar.Header{
+			Name:     file.Path,
+			Typeflag: tar.TypeReg,
+			Mode:     int64(os.FileMode(file.Mode).Perm()),
+			Size:     file.Size,
+			ModTime:  settings.ModTimeFor(file.Path),
+		}
+
+		eErr := tarWriter.WriteHeader(th)
+		if eErr != nil {
+			return nil, errors.Wrap(eErr, 1)
+		}
+
+		entryReader, eErr := pool.GetReader(int64(fileIndex))
+		if eErr != nil {
+			return nil, errors.Wrap(eErr, 1)
+		}
+
+		copiedBytes, eErr := io.Copy(tarWriter, entryReader)
+		if eErr != nil {
+			return nil, errors.Wrap(eErr, 1)
+		}
+
+		uncompressedSize += copiedBytes
+	}
+
+	for _, symlinkIndex := range order.symlinks {
+		symlink := container.Symlinks[symlinkIndex]
+		th := &tar.Header{
+			Name:     symlink.Path,
+			Typeflag: tar.TypeSymlink,
+			Linkname: symlink.Dest,
+			Mode:     int64(os.FileMode(symlink.Mode).Perm()),
+			ModTime:  settings.ModTimeFor(symlink.Path),
+		}
+
+		eErr := tarWriter.WriteHeader(th)
+		if eErr != nil {
+			return nil, errors.Wrap(eErr, 1)
+		}
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+	tarWriter = nil
+
+	compressedSize = archiveCounter.Count()
+
+	return &archiver.CompressResult{
+		UncompressedSize: uncompressedSize,
+		CompressedSize:   compressedSize,
+	}, nil
+}
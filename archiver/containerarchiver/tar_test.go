@@ -0,0 +1,118 @@
+package containerarchiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CompressTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerarchiver")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	entries := []wtest.TestDirEntry{
+		{Path: "subdir/file-1", Seed: 0x1, Size: 1024},
+		{Path: "file-1", Seed: 0x2, Size: 12},
+	}
+	if wtest.TestSymlinks {
+		entries = append(entries, wtest.TestDirEntry{Path: "link", Dest: "file-1"})
+	}
+	wtest.MakeTestDir(t, dir, wtest.TestDirSettings{Entries: entries})
+
+	container, err := tlc.WalkDir(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	pool := fspool.New(container, dir)
+	defer pool.Close()
+
+	tarBuf := new(bytes.Buffer)
+	res, err := CompressTar(tarBuf, container, pool, &state.Consumer{})
+	wtest.Must(t, err)
+	assert.EqualValues(t, container.Size, res.UncompressedSize)
+	assert.EqualValues(t, tarBuf.Len(), res.CompressedSize)
+
+	tarContainer, err := tlc.WalkTar(tarBuf, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	wtest.Must(t, container.EnsureEqual(tarContainer))
+
+	for i, f := range tarContainer.Files {
+		assert.EqualValues(t, os.FileMode(container.Files[i].Mode).Perm(), os.FileMode(f.Mode).Perm(), "%s: should keep permissions", filepath.Base(f.Path))
+	}
+}
+
+func Test_CompressTarReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerarchiver")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	wtest.MakeTestDir(t, dir, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: 1024},
+			{Path: "file-1", Seed: 0x2, Size: 12},
+			{Path: "file-2", Seed: 0x3, Size: 64},
+		},
+	})
+
+	container, err := tlc.WalkDir(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	compress := func(settings *archiver.ZipSettings) []byte {
+		pool := fspool.New(container, dir)
+		defer pool.Close()
+
+		buf := new(bytes.Buffer)
+		_, err := CompressTarWithSettings(buf, container, pool, settings, &state.Consumer{})
+		wtest.Must(t, err)
+		return buf.Bytes()
+	}
+
+	settings := &archiver.ZipSettings{Reproducible: true}
+	first := compress(settings)
+
+	// shuffle the container around, and make sure time passes
+	container.Files[0], container.Files[2] = container.Files[2], container.Files[0]
+	time.Sleep(1100 * time.Millisecond)
+	second := compress(settings)
+	assert.True(t, bytes.Equal(first, second), "reproducible tars should be identical")
+
+	modTimes := func(tarBytes []byte) map[string]time.Time {
+		res := make(map[string]time.Time)
+		tr := tar.NewReader(bytes.NewReader(tarBytes))
+		var names []string
+		for {
+			th, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			wtest.Must(t, err)
+			if th.Typeflag == tar.TypeReg {
+				names = append(names, th.Name)
+			}
+			res[th.Name] = th.ModTime
+		}
+		assert.True(t, sort.StringsAreSorted(names), "files should be sorted by path")
+		return res
+	}
+
+	for name, modTime := range modTimes(first) {
+		assert.True(t, modTime.Equal(archiver.ReproducibleModTime), "%s should have a fixed mtime", name)
+	}
+
+	modTime := time.Date(2020, 2, 2, 20, 20, 20, 0, time.UTC)
+	for name, entryModTime := range modTimes(compress(&archiver.ZipSettings{Reproducible: true, ModTime: modTime})) {
+		assert.True(t, entryModTime.Equal(modTime), "%s should have the given mtime", name)
+	}
+}
@@ -1,10 +1,12 @@
 package containerarchiver
 
 import (
-	"github.com/itchio/arkive/zip"
+	"bytes"
 	"io"
 	"os"
-	"time"
+	"sort"
+
+	"github.com/itchio/arkive/zip"
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/archiver"
@@ -15,6 +17,12 @@ import (
 )
 
 func CompressZip(archiveWriter io.Writer, container *tlc.Container, pool wsync.Pool, consumer *state.Consumer) (*archiver.CompressResult, error) {
+	return CompressZipWithSettings(archiveWriter, container, pool, nil, consumer)
+}
+
+// CompressZipWithSettings is like CompressZip, but settings control entry
+// order, modification times and compression (see archiver.ZipSettings)
+func CompressZipWithSettings(archiveWriter io.Writer, container *tlc.Container, pool wsync.Pool, settings *archiver.ZipSettings, consumer *state.Consumer) (*archiver.CompressResult, error) {
 	var err error
 	var uncompressedSize int64
 	var compressedSize int64
@@ -31,12 +39,15 @@ func CompressZip(archiveWriter io.Writer, container *tlc.Container, pool wsync.P
 		}
 	}()
 
-	for _, dir := range container.Dirs {
+	order := entryOrder(container, settings)
+
+	for _, dirIndex := range order.dirs {
+		dir := container.Dirs[dirIndex]
 		fh := zip.FileHeader{
 			Name: dir.Path + "/",
 		}
 		fh.SetMode(os.FileMode(dir.Mode))
-		fh.SetModTime(time.Now())
+		fh.SetModTime(settings.ModTimeFor(dir.Path))
 
 		_, hErr := zipWriter.CreateHeader(&fh)
 		if hErr != nil {
@@ -44,25 +55,38 @@ func CompressZip(archiveWriter io.Writer, container *tlc.Container, pool wsync.P
 		}
 	}
 
-	for fileIndex, file := range container.Files {
+	for _, fileIndex := range order.files {
+		file := container.Files[fileIndex]
+
+		entryReader, eErr := pool.GetReader(int64(fileIndex))
+		if eErr != nil {
+			return nil, errors.Wrap(eErr, 1)
+		}
+
+		var sample []byte
+		if settings.NeedsSample() {
+			sample = make([]byte, archiver.ZipSampleSize)
+			n, sErr := io.ReadFull(entryReader, sample)
+			if sErr != nil && sErr != io.EOF && sErr != io.ErrUnexpectedEOF {
+				return nil, errors.Wrap(sErr, 1)
+			}
+			sample = sample[:n]
+			entryReader = io.MultiReader(bytes.NewReader(sample), entryReader)
+		}
+
 		fh := zip.FileHeader{
 			Name:               file.Path,
 			UncompressedSize64: uint64(file.Size),
-			Method:             zip.Deflate,
+			Method:             settings.MethodFor(sample),
 		}
 		fh.SetMode(os.FileMode(file.Mode))
-		fh.SetModTime(time.Now())
+		fh.SetModTime(settings.ModTimeFor(file.Path))
 
 		entryWriter, eErr := zipWriter.CreateHeader(&fh)
 		if eErr != nil {
 			return nil, errors.Wrap(eErr, 1)
 		}
 
-		entryReader, eErr := pool.GetReader(int64(fileIndex))
-		if eErr != nil {
-			return nil, errors.Wrap(eErr, 1)
-		}
-
 		copiedBytes, eErr := io.Copy(entryWriter, entryReader)
 		if eErr != nil {
 			return nil, errors.Wrap(eErr, 1)
@@ -71,11 +95,15 @@ func CompressZip(archiveWriter io.Writer, container *tlc.Container, pool wsync.P
 		uncompressedSize += copiedBytes
 	}
 
-	for _, symlink := range container.Symlinks {
+	for _, symlinkIndex := range order.symlinks {
+		symlink := container.Symlinks[symlinkIndex]
 		fh := zip.FileHeader{
 			Name: symlink.Path,
 		}
 		fh.SetMode(os.FileMode(symlink.Mode))
+		if settings != nil && settings.Reproducible {
+			fh.SetModTime(settings.ModTimeFor(symlink.Path))
+		}
 
 		entryWriter, eErr := zipWriter.CreateHeader(&fh)
 		if eErr != nil {
@@ -98,3 +126,34 @@ func CompressZip(archiveWriter io.Writer, container *tlc.Container, pool wsync.P
 		CompressedSize:   compressedSize,
 	}, nil
 }
+
+type containerOrder struct {
+	dirs     []int
+	files    []int
+	symlinks []int
+}
+
+// entryOrder returns the order in which to write a container's entries:
+// the container's own order, or sorted by path for reproducible archives.
+func entryOrder(container *tlc.Container, settings *archiver.ZipSettings) *containerOrder {
+	reproducible := settings != nil && settings.Reproducible
+
+	indices := func(n int, path func(i int) string) []int {
+		res := make([]int, n)
+		for i := range res {
+			res[i] = i
+		}
+		if reproducible {
+			sort.SliceStable(res, func(i, j int) bool {
+				return path(res[i]) < path(res[j])
+			})
+		}
+		return res
+	}
+
+	return &containerOrder{
+		dirs:     indices(len(container.Dirs), func(i int) string { return container.Dirs[i].Path }),
+		files:    indices(len(container.Files), func(i int) string { return container.Files[i].Path }),
+		symlinks: indices(len(container.Symlinks), func(i int) string { return container.Symlinks[i].Path }),
+	}
+}
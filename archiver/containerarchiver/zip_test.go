@@ -0,0 +1,79 @@
+package containerarchiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CompressZipReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containerarchiver")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	wtest.MakeTestDir(t, dir, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/random", Seed: 0x1, Size: 128 * 1024},
+			{Path: "text", Data: bytes.Repeat([]byte("compressible "), 10*1024)},
+			{Path: "empty", Data: []byte{}},
+		},
+	})
+
+	container, err := tlc.WalkDir(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	compress := func(settings *archiver.ZipSettings) []byte {
+		pool := fspool.New(container, dir)
+		defer pool.Close()
+
+		buf := new(bytes.Buffer)
+		_, err := CompressZipWithSettings(buf, container, pool, settings, &state.Consumer{})
+		wtest.Must(t, err)
+		return buf.Bytes()
+	}
+
+	settings := &archiver.ZipSettings{
+		Reproducible: true,
+		Compression:  archiver.ZipAuto,
+	}
+	first := compress(settings)
+
+	// shuffle the container around, and make sure time passes
+	container.Files[0], container.Files[2] = container.Files[2], container.Files[0]
+	time.Sleep(1100 * time.Millisecond)
+	second := compress(settings)
+	assert.True(t, bytes.Equal(first, second), "reproducible zips should be identical")
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	wtest.Must(t, err)
+
+	methods := make(map[string]uint16)
+	for _, f := range zr.File {
+		methods[f.Name] = f.Method
+		assert.True(t, f.ModTime().Equal(archiver.ReproducibleModTime), "%s should have a fixed mtime", f.Name)
+	}
+	assert.EqualValues(t, zip.Store, methods["subdir/random"], "incompressible entries should be stored")
+	assert.EqualValues(t, zip.Deflate, methods["text"], "compressible entries should be deflated")
+	assert.EqualValues(t, zip.Store, methods["empty"])
+
+	modTime := time.Date(2020, 2, 2, 20, 20, 20, 0, time.UTC)
+	third := compress(&archiver.ZipSettings{Reproducible: true, ModTime: modTime})
+	zr, err = zip.NewReader(bytes.NewReader(third), int64(len(third)))
+	wtest.Must(t, err)
+	for _, f := range zr.File {
+		assert.True(t, f.ModTime().Equal(modTime), "%s should have the given mtime", f.Name)
+		if !f.FileInfo().IsDir() {
+			assert.EqualValues(t, zip.Deflate, f.Method)
+		}
+	}
+}
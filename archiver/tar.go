@@ -2,44 +2,204 @@ package archiver
 
 import (
 	"archive/tar"
-	"fmt"
+	"bytes"
+	"encoding/gob"
 	"io"
+	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/brotlisource"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
 	"github.com/itchio/wharf/counter"
 	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/zstdsource"
 )
 
-// Does not preserve users, nor permission, except the executable bit
+// tarSaveInterval is how much of a tar is extracted, at least, between
+// two checkpoints
+var tarSaveInterval int64 = 16 * 1024 * 1024
+
+const tarBlockSize = 512
+
+var tarDecompressors = []struct {
+	suffixes []string
+	apply    func(source savior.Source) savior.Source
+}{
+	{[]string{".tar"}, nil},
+	{[]string{".tar.gz", ".tgz"}, gzipsource.New},
+	{[]string{".tar.zst", ".tzst"}, zstdsource.New},
+	{[]string{".tar.br", ".tbr"}, brotlisource.New},
+}
+
+// IsTar returns true if name is that of a tar archive ExtractTar can
+// extract: .tar, .tar.gz, .tar.zst or .tar.br (and their short forms)
+func IsTar(name string) bool {
+	_, ok := tarDecompressor(name)
+	return ok
+}
+
+func tarDecompressor(name string) (func(source savior.Source) savior.Source, bool) {
+	name = strings.ToLower(name)
+	for _, td := range tarDecompressors {
+		for _, suffix := range td.suffixes {
+			if strings.HasSuffix(name, suffix) {
+				return td.apply, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ExtractTar extracts a tar archive, decompressing it first if its name
+// says so (see IsTar). Does not preserve users, nor permission, except the
+// executable bit.
 func ExtractTar(archive string, dir string, settings ExtractSettings) (*ExtractResult, error) {
 	settings.Consumer.Infof("Extracting %s to %s", eos.Redact(archive), dir)
 
-	dirCount := 0
-	regCount := 0
-	symlinkCount := 0
-
 	file, err := eos.Open(archive)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
-
 	defer file.Close()
 
-	err = Mkdir(dir)
+	var source savior.Source = seeksource.FromFile(file)
+	if apply, ok := tarDecompressor(archive); ok && apply != nil {
+		source = apply(source)
+	}
+
+	return ExtractTarSource(source, dir, settings)
+}
+
+// tarEntry is what's needed of a tar header to finish extracting it
+type tarEntry struct {
+	Name     string
+	Typeflag byte
+	Mode     int64
+	Size     int64
+	// DataOffset is where the entry's data starts in the uncompressed tar
+	DataOffset int64
+}
+
+// tarCheckpoint is saved to ExtractSettings.ResumeFrom while extracting a tar
+type tarCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// Entry is the last entry read, nil if none was. It's extracted up to
+	// EntryDone bytes.
+	Entry     *tarEntry
+	EntryDone int64
+
+	Result    ExtractResult
+	Links     map[string]string
+	LinkOrder []string
+}
+
+// tarCounter counts what's read from the source, and asks it to save
+// a checkpoint every tarSaveInterval
+type tarCounter struct {
+	source   savior.Source
+	offset   int64
+	nextSave int64
+}
+
+func (tc *tarCounter) Read(p []byte) (int, error) {
+	if tc.offset >= tc.nextSave {
+		tc.source.WantSave()
+		tc.nextSave = tc.offset + tarSaveInterval
+	}
+
+	n, err := tc.source.Read(p)
+	tc.offset += int64(n)
+	return n, err
+}
+
+// ExtractTarSource extracts an uncompressed tar from source. If settings.ResumeFrom
+// is set, checkpoints are saved there as extraction progresses, and used to
+// resume an interrupted extraction. Hard links are extracted as copies.
+func ExtractTarSource(source savior.Source, dir string, settings ExtractSettings) (*ExtractResult, error) {
+	err := Mkdir(dir)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
 
-	stats, err := file.Stat()
+	checkpoint := readTarCheckpoint(settings)
+
+	offset, err := source.Resume(checkpoint.SourceCheckpoint)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
-	countingReader := counter.NewReaderCallback(settings.Consumer.CountCallback(stats.Size()), file)
-	tarReader := tar.NewReader(countingReader)
+	if checkpoint.SourceCheckpoint != nil && offset != checkpoint.SourceCheckpoint.Offset {
+		settings.Consumer.Warnf("Couldn't resume at %d (got %d), starting over", checkpoint.SourceCheckpoint.Offset, offset)
+		checkpoint = &tarCheckpoint{}
+		offset, err = source.Resume(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+	}
+
+	if checkpoint.Links == nil {
+		checkpoint.Links = make(map[string]string)
+	}
+	result := &checkpoint.Result
+	// symlinks are only created once everything else is extracted, after
+	// they've all been checked
+	links := checkpoint.Links
+	entry := checkpoint.Entry
+
+	tc := &tarCounter{
+		source:   source,
+		offset:   offset,
+		nextSave: offset + tarSaveInterval,
+	}
+
+	if settings.ResumeFrom != "" {
+		warnedAboutWrite := false
+		source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+			OnSave: func(sc *savior.SourceCheckpoint) error {
+				// only save when the source stopped at a point we can resume
+				// from: inside, or at the end of, an entry's data
+				done := sc.Offset
+				if entry != nil {
+					done -= entry.DataOffset
+					if done < 0 || done > entry.Size {
+						return nil
+					}
+				} else if done != 0 {
+					return nil
+				}
+
+				wErr := writeTarCheckpoint(settings.ResumeFrom, &tarCheckpoint{
+					SourceCheckpoint: sc,
+					Entry:            entry,
+					EntryDone:        done,
+					Result:           *result,
+					Links:            links,
+					LinkOrder:        checkpoint.LinkOrder,
+				})
+				if wErr != nil && !warnedAboutWrite {
+					warnedAboutWrite = true
+					settings.Consumer.Warnf("Couldn't save resume file: %s", wErr.Error())
+				}
+				return nil
+			},
+		})
+	}
+
+	if entry != nil {
+		settings.Consumer.Infof("Resuming %s at %d", entry.Name, checkpoint.EntryDone)
+		err = finishTarEntry(dir, entry, checkpoint.EntryDone, tc, settings)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+	}
+
+	tarReader := tar.NewReader(tc)
 
 	for {
 		header, err := tarReader.Next()
@@ -50,42 +210,223 @@ func ExtractTar(archive string, dir string, settings ExtractSettings) (*ExtractR
 			return nil, errors.Wrap(err, 1)
 		}
 
-		rel := header.Name
-		filename := path.Join(dir, filepath.FromSlash(rel))
+		settings.Consumer.Progress(source.Progress())
+
+		err = checkNotInSymlink(links, entryKey(header.Name))
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+
+		filename, err := safepath.Join(dir, header.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+
+		// tar.Reader doesn't read ahead: the entry's data starts here
+		entry = &tarEntry{
+			Name:       header.Name,
+			Typeflag:   header.Typeflag,
+			Mode:       header.Mode,
+			Size:       header.Size,
+			DataOffset: tc.offset,
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			err = Mkdir(filename)
+			if !settings.DryRun {
+				err = Mkdir(filename)
+				if err != nil {
+					return nil, errors.Wrap(err, 1)
+				}
+			}
+			result.Dirs++
+
+		case tar.TypeReg, tar.TypeRegA:
+			settings.Consumer.Debugf("extract %s", filename)
+			result.Files++
+			if settings.DryRun {
+				_, err = io.Copy(ioutil.Discard, tarReader)
+			} else {
+				err = CopyFile(filename, os.FileMode(header.Mode&LuckyMode|ModeMask), tarReader)
+			}
 			if err != nil {
 				return nil, errors.Wrap(err, 1)
 			}
-			dirCount++
 
-		case tar.TypeReg:
-			settings.Consumer.Debugf("extract %s", filename)
-			err = CopyFile(filename, os.FileMode(header.Mode&LuckyMode|ModeMask), tarReader)
+		case tar.TypeLink:
+			err = checkNotInSymlink(links, entryKey(header.Linkname))
 			if err != nil {
 				return nil, errors.Wrap(err, 1)
 			}
-			regCount++
 
-		case tar.TypeSymlink:
-			err = Symlink(header.Linkname, filename, settings.Consumer)
+			target, err := safepath.Join(dir, header.Linkname)
 			if err != nil {
 				return nil, errors.Wrap(err, 1)
 			}
-			symlinkCount++
+
+			settings.Consumer.Debugf("extract %s (hard link to %s)", filename, target)
+			result.Files++
+			if !settings.DryRun {
+				err = copyHardLink(filename, os.FileMode(header.Mode&LuckyMode|ModeMask), target)
+				if err != nil {
+					return nil, errors.Wrap(err, 1)
+				}
+			}
+
+		case tar.TypeSymlink:
+			key := entryKey(header.Name)
+			if _, ok := links[key]; !ok {
+				checkpoint.LinkOrder = append(checkpoint.LinkOrder, key)
+			}
+			links[key] = header.Linkname
 
 		default:
-			return nil, fmt.Errorf("Unable to untar entry of type %d", header.Typeflag)
+			settings.Consumer.Warnf("Skipping %s: unsupported tar entry type %q", header.Name, header.Typeflag)
+			continue
+		}
+
+		if settings.OnEntryDone != nil && header.Typeflag != tar.TypeDir {
+			settings.OnEntryDone(filepath.ToSlash(entryKey(header.Name)))
+		}
+	}
+
+	if settings.RefuseEscapingSymlinks {
+		err = safepath.CheckSymlinks(links)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+	}
+
+	for _, key := range checkpoint.LinkOrder {
+		filename, err := safepath.Join(dir, key)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+
+		if !settings.DryRun {
+			err = Symlink(links[key], filename, settings.Consumer)
+			if err != nil {
+				return nil, errors.Wrap(err, 1)
+			}
+		}
+		result.Symlinks++
+	}
+
+	if settings.ResumeFrom != "" {
+		rErr := os.Remove(settings.ResumeFrom)
+		if rErr != nil && !os.IsNotExist(rErr) {
+			settings.Consumer.Warnf("Couldn't remove resume file: %s", rErr.Error())
 		}
 	}
 
-	return &ExtractResult{
-		Dirs:     dirCount,
-		Files:    regCount,
-		Symlinks: symlinkCount,
-	}, nil
+	return result, nil
+}
+
+// finishTarEntry extracts the rest of an entry that was interrupted after
+// done bytes, and skips to the next header. Checkpoints are only saved when
+// reading, so if done is the entry's size, it was entirely processed.
+func finishTarEntry(dir string, entry *tarEntry, done int64, r io.Reader, settings ExtractSettings) error {
+	left := entry.Size - done
+	finished := left == 0
+	isRegular := entry.Typeflag == tar.TypeReg || entry.Typeflag == tar.TypeRegA
+
+	if isRegular && !finished && !settings.DryRun {
+		filename, err := safepath.Join(dir, entry.Name)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		err = func() error {
+			writer, err := os.OpenFile(filename, os.O_WRONLY, 0)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+			defer writer.Close()
+
+			err = writer.Truncate(done)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			_, err = writer.Seek(done, io.SeekStart)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			_, err = io.CopyN(writer, r, left)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+		left = 0
+	}
+
+	padding := (tarBlockSize - entry.Size%tarBlockSize) % tarBlockSize
+	_, err := io.CopyN(ioutil.Discard, r, left+padding)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if settings.OnEntryDone != nil && isRegular && !finished {
+		settings.OnEntryDone(filepath.ToSlash(entryKey(entry.Name)))
+	}
+	return nil
+}
+
+// copyHardLink extracts a hard link as a copy of its target, which
+// must have been extracted already
+func copyHardLink(filename string, mode os.FileMode, target string) error {
+	reader, err := os.Open(target)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer reader.Close()
+
+	return CopyFile(filename, mode, reader)
+}
+
+func readTarCheckpoint(settings ExtractSettings) *tarCheckpoint {
+	checkpoint := &tarCheckpoint{}
+	if settings.ResumeFrom == "" {
+		return checkpoint
+	}
+
+	resBytes, err := ioutil.ReadFile(settings.ResumeFrom)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			settings.Consumer.Warnf("Couldn't read resume file: %s", err.Error())
+		}
+		return checkpoint
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(resBytes)).Decode(checkpoint)
+	if err != nil {
+		settings.Consumer.Warnf("Couldn't parse resume file: %s", err.Error())
+		return &tarCheckpoint{}
+	}
+
+	if checkpoint.SourceCheckpoint != nil {
+		settings.Consumer.Infof("Resuming from offset %d", checkpoint.SourceCheckpoint.Offset)
+	}
+	return checkpoint
+}
+
+func writeTarCheckpoint(path string, checkpoint *tarCheckpoint) error {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(checkpoint)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
 }
 
 func CompressTar(archiveWriter io.Writer, dir string, consumer *state.Consumer) (*CompressResult, error) {
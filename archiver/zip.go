@@ -5,7 +5,6 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -15,6 +14,7 @@ import (
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/counter"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
 )
 
@@ -28,9 +28,45 @@ func ExtractZip(readerAt io.ReaderAt, size int64, dir string, settings ExtractSe
 		return nil, errors.Wrap(err, 1)
 	}
 
+	windows := runtime.GOOS == "windows"
+
 	var totalSize int64
+	links := make(map[string]string)
 	for _, file := range reader.File {
 		totalSize += int64(file.UncompressedSize64)
+
+		err = safepath.Validate(file.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+
+		if file.FileInfo().Mode()&os.ModeSymlink > 0 && !windows {
+			var linkname string
+			if settings.RefuseEscapingSymlinks {
+				linkname, err = readZipSymlink(file)
+				if err != nil {
+					return nil, errors.Wrap(err, 1)
+				}
+			}
+			links[entryKey(file.Name)] = linkname
+		}
+	}
+
+	// workers run in parallel, so nothing guarantees a symlink isn't created
+	// on disk right after another worker checked it wasn't there: entries
+	// inside symlinks are refused before anything is extracted.
+	for _, file := range reader.File {
+		err = checkNotInSymlink(links, entryKey(file.Name))
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+	}
+
+	if settings.RefuseEscapingSymlinks {
+		err = safepath.CheckSymlinks(links)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
 	}
 
 	var doneSize uint64
@@ -93,8 +129,6 @@ func ExtractZip(readerAt io.ReaderAt, size int64, dir string, settings ExtractSe
 		settings.OnUncompressedSizeKnown(totalSize)
 	}
 
-	windows := runtime.GOOS == "windows"
-
 	numWorkers := settings.Concurrency
 	if numWorkers < 0 {
 		numWorkers = runtime.NumCPU() - 1
@@ -142,8 +176,10 @@ func ExtractZip(readerAt io.ReaderAt, size int64, dir string, settings ExtractSe
 				}
 
 				err = func() error {
-					rel := file.Name
-					filename := path.Join(dir, filepath.FromSlash(rel))
+					filename, err := safepath.Join(dir, file.Name)
+					if err != nil {
+						return errors.Wrap(err, 1)
+					}
 
 					info := file.FileInfo()
 					mode := info.Mode()
@@ -246,6 +282,20 @@ func ExtractZip(readerAt io.ReaderAt, size int64, dir string, settings ExtractSe
 	}, nil
 }
 
+func readZipSymlink(file *zip.File) (string, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	defer fileReader.Close()
+
+	linkname, err := ioutil.ReadAll(fileReader)
+	if err != nil {
+		return "", errors.Wrap(err, 1)
+	}
+	return string(linkname), nil
+}
+
 func CompressZip(archiveWriter io.Writer, dir string, consumer *state.Consumer) (*CompressResult, error) {
 	var err error
 	var uncompressedSize int64
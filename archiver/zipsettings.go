@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"compress/flate"
+	"io/ioutil"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/counter"
+)
+
+// ZipCompression decides how the file entries of a zip are compressed
+type ZipCompression int
+
+const (
+	// ZipDeflate deflates all file entries
+	ZipDeflate ZipCompression = iota
+	// ZipStore stores all file entries uncompressed
+	ZipStore
+	// ZipAuto deflates file entries, except those whose first ZipSampleSize
+	// bytes don't deflate well, like already-compressed files
+	ZipAuto
+)
+
+// ZipSampleSize is how much of an entry ZipAuto looks at to pick a method
+const ZipSampleSize = 64 * 1024
+
+// zipAutoRatio is the compression ratio above which ZipAuto stores entries
+const zipAutoRatio = 0.95
+
+// ReproducibleModTime stamps the entries of reproducible zips when no
+// other time is given. It's the earliest date zip can store.
+var ReproducibleModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ZipSettings control how zips are written by containerarchiver and
+// zipwriterpool, and how tars are written by containerarchiver (minus
+// Compression). A nil *ZipSettings deflates everything and stamps
+// entries with the current time. Zip64 records are written as needed
+// for huge entries, offsets or entry counts.
+type ZipSettings struct {
+	// Reproducible makes the output byte-for-byte identical for identical
+	// contents: entries are written in a stable order, and stamped with
+	// ModTime instead of the current time.
+	Reproducible bool
+	// ModTime stamps all entries of reproducible zips, ReproducibleModTime if zero
+	ModTime time.Time
+	// EntryModTime, if set, gives each entry of a reproducible zip its
+	// own modification time instead, from its slashed path
+	EntryModTime func(slashPath string) time.Time
+
+	Compression ZipCompression
+}
+
+// ModTimeFor returns the modification time of an entry
+func (zs *ZipSettings) ModTimeFor(slashPath string) time.Time {
+	if zs == nil || !zs.Reproducible {
+		return time.Now()
+	}
+
+	if zs.EntryModTime != nil {
+		return zs.EntryModTime(slashPath)
+	}
+
+	if zs.ModTime.IsZero() {
+		return ReproducibleModTime
+	}
+	return zs.ModTime
+}
+
+// NeedsSample returns true if MethodFor needs the start of an entry
+func (zs *ZipSettings) NeedsSample() bool {
+	return zs != nil && zs.Compression == ZipAuto
+}
+
+// MethodFor returns the method to write a file entry with, given up to
+// ZipSampleSize bytes from its start, if NeedsSample
+func (zs *ZipSettings) MethodFor(sample []byte) uint16 {
+	if zs == nil {
+		return zip.Deflate
+	}
+
+	switch zs.Compression {
+	case ZipStore:
+		return zip.Store
+	case ZipAuto:
+		if len(sample) == 0 {
+			return zip.Store
+		}
+
+		compressedCounter := counter.NewWriter(ioutil.Discard)
+		fw, err := flate.NewWriter(compressedCounter, flate.BestSpeed)
+		if err != nil {
+			return zip.Deflate
+		}
+		fw.Write(sample)
+		fw.Close()
+
+		if float64(compressedCounter.Count()) > float64(len(sample))*zipAutoRatio {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
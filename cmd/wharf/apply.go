@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pools"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/pwr/bowl"
+	"github.com/itchio/wharf/pwr/patcher"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/zipindex"
+)
+
+type applyResult struct {
+	Patch string          `json:"patch"`
+	Out   string          `json:"out"`
+	New   *containerStats `json:"new"`
+
+	// Stopped is true when applying was interrupted after saving a
+	// checkpoint. Running the same command again resumes from it.
+	Stopped    bool   `json:"stopped"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Validated  bool   `json:"validated"`
+}
+
+func runApply(c *cli, args []string) error {
+	flags := c.flags("apply")
+	checkpointPath := flags.String("checkpoint", "", "Save progress to this file regularly and on interrupt, and resume from it if it exists (not when patching in place)")
+	saveInterval := flags.Duration("save-interval", 10*time.Second, "How often to save progress when -checkpoint is set")
+	stagePath := flags.String("stage", "", "Staging folder when patching in place (default: <out>.stage)")
+	signaturePath := flags.String("signature", "", "Verify the result against this signature file")
+	enforceSpace := flags.Bool("enforce-space", false, "Refuse to start if there isn't enough free disk space")
+	zipIndexPath := flags.String("zip-index", "", "When <old> is a zip, seek inside it with this zip index (see zipindex)")
+	err := c.parse(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	patchPath, oldPath, outPath := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	patchReader, closePatch, err := openSource(patchPath)
+	if err != nil {
+		return err
+	}
+	defer closePatch()
+
+	p, err := patcher.New(patchReader, c.consumer)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	p.SetEnforceSpace(*enforceSpace)
+
+	var targetPool wsync.Pool
+	if *zipIndexPath != "" {
+		index, err := zipindex.Load(*zipIndexPath)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		targetPool, err = zipindex.NewPool(p.GetTargetContainer(), oldPath, index)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	} else {
+		targetPool, err = pools.New(p.GetTargetContainer(), oldPath)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+
+	inPlace := filepath.Clean(oldPath) == filepath.Clean(outPath)
+
+	var checkpoint *patcher.Checkpoint
+	if *checkpointPath != "" {
+		if inPlace {
+			// overlay bowls keep track of the files to overlay and move in
+			// memory, they can't be resumed
+			return errors.New("apply: -checkpoint can't be used when patching in place")
+		}
+
+		checkpoint, err = readCheckpoint(*checkpointPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var b bowl.Bowl
+	if inPlace {
+		if *stagePath == "" {
+			*stagePath = filepath.Clean(outPath) + ".stage"
+		}
+
+		c.consumer.Infof("Patching %s in place (staging in %s)", outPath, *stagePath)
+		b, err = bowl.NewOverlayBowl(&bowl.OverlayBowlParams{
+			TargetContainer: p.GetTargetContainer(),
+			SourceContainer: p.GetSourceContainer(),
+			OutputFolder:    outPath,
+			StageFolder:     *stagePath,
+		})
+	} else {
+		c.consumer.Infof("Patching %s into %s", oldPath, outPath)
+		b, err = bowl.NewFreshBowl(&bowl.FreshBowlParams{
+			TargetContainer: p.GetTargetContainer(),
+			SourceContainer: p.GetSourceContainer(),
+			TargetPool:      targetPool,
+			OutputFolder:    outPath,
+			Resuming:        checkpoint != nil,
+		})
+	}
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	result := &applyResult{
+		Patch: patchPath,
+		Out:   outPath,
+		New:   statsOf(p.GetSourceContainer()),
+	}
+
+	if *checkpointPath != "" {
+		if checkpoint != nil {
+			c.consumer.Infof("Resuming from %s", *checkpointPath)
+		}
+
+		saver := &checkpointSaver{
+			path:     *checkpointPath,
+			interval: *saveInterval,
+			last:     time.Now(),
+			stopAt:   stopAtCheckpoint,
+		}
+
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			for range interrupts {
+				c.consumer.Infof("Interrupted, saving checkpoint...")
+				atomic.StoreInt32(&saver.stopRequested, 1)
+			}
+		}()
+
+		p.SetSaveConsumer(saver)
+	}
+
+	err = p.Resume(checkpoint, targetPool, b)
+	if err != nil {
+		if errors.Is(err, patcher.ErrStop) {
+			result.Stopped = true
+			result.Checkpoint = *checkpointPath
+			return c.printResult(result)
+		}
+		return errors.Wrap(err, 0)
+	}
+
+	err = b.Commit()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if *checkpointPath != "" {
+		err = os.Remove(*checkpointPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, 0)
+		}
+	}
+
+	if *signaturePath != "" {
+		sigInfo, err := readSignature(*signaturePath)
+		if err != nil {
+			return err
+		}
+
+		err = pwr.AssertValid(outPath, sigInfo)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		result.Validated = true
+	}
+
+	return c.printResult(result)
+}
+
+// readCheckpoint reads a checkpoint saved by a checkpointSaver, and returns
+// nil if there's none
+func readCheckpoint(path string) (*patcher.Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, 0)
+	}
+	defer f.Close()
+
+	checkpoint := &patcher.Checkpoint{}
+	err = gob.NewDecoder(f).Decode(checkpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return checkpoint, nil
+}
+
+// stopAtCheckpoint, if set, makes apply stop once it has saved a checkpoint
+// it returns true for, as if interrupted. It's used by tests.
+var stopAtCheckpoint func(checkpoint *patcher.Checkpoint) bool
+
+// checkpointSaver saves patcher checkpoints to a file every interval,
+// and stops the patcher once one has been saved after an interrupt
+type checkpointSaver struct {
+	path     string
+	interval time.Duration
+	last     time.Time
+	stopAt   func(checkpoint *patcher.Checkpoint) bool
+
+	stopRequested int32
+}
+
+var _ patcher.SaveConsumer = (*checkpointSaver)(nil)
+
+func (cs *checkpointSaver) ShouldSave() bool {
+	return atomic.LoadInt32(&cs.stopRequested) == 1 || time.Since(cs.last) >= cs.interval
+}
+
+func (cs *checkpointSaver) Save(checkpoint *patcher.Checkpoint) (patcher.AfterSaveAction, error) {
+	// write then rename, so an interrupted save never clobbers a good checkpoint
+	tmpPath := cs.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return patcher.AfterSaveStop, errors.Wrap(err, 0)
+	}
+
+	err = gob.NewEncoder(f).Encode(checkpoint)
+	if err != nil {
+		f.Close()
+		return patcher.AfterSaveStop, errors.Wrap(err, 0)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return patcher.AfterSaveStop, errors.Wrap(err, 0)
+	}
+
+	err = os.Rename(tmpPath, cs.path)
+	if err != nil {
+		return patcher.AfterSaveStop, errors.Wrap(err, 0)
+	}
+
+	cs.last = time.Now()
+	if cs.stopAt != nil && cs.stopAt(checkpoint) {
+		atomic.StoreInt32(&cs.stopRequested, 1)
+	}
+	if atomic.LoadInt32(&cs.stopRequested) == 1 {
+		return patcher.AfterSaveStop, nil
+	}
+	return patcher.AfterSaveContinue, nil
+}
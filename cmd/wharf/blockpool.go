@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pools"
+	"github.com/itchio/wharf/pools/blockpool"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+)
+
+type blockpoolResult struct {
+	Manifest  string          `json:"manifest"`
+	Blocks    string          `json:"blocks"`
+	Dir       string          `json:"dir"`
+	Container *containerStats `json:"container"`
+
+	// only for push, blocks that were already in the store
+	SkippedBlocks int64 `json:"skippedBlocks,omitempty"`
+	SkippedBytes  int64 `json:"skippedBytes,omitempty"`
+}
+
+func runBlockpool(c *cli, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("blockpool: expected push or pull")
+	}
+
+	switch args[0] {
+	case "push":
+		return runBlockpoolPush(c, args[1:])
+	case "pull":
+		return runBlockpoolPull(c, args[1:])
+	}
+	return fmt.Errorf("blockpool: unknown operation %s, expected push or pull", args[0])
+}
+
+// runBlockpoolPush splits a build into blocks, stored by hash, and writes
+// a manifest listing them
+func runBlockpoolPush(c *cli, args []string) error {
+	flags := c.flags("blockpool")
+	getCompression := compressionFlags(flags, "brotli", 1)
+	compressBlocks := flags.Bool("zstd", false, "Compress blocks with zstd")
+	err := c.parse(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	dir, blocksPath, manifestPath := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	compression, err := getCompression()
+	if err != nil {
+		return err
+	}
+
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	inPool, err := pools.New(container, dir)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	blockHashes := blockpool.NewBlockHashMap()
+	sink := &blockpool.DiskSink{
+		BasePath:    blocksPath,
+		Container:   container,
+		BlockHashes: blockHashes,
+	}
+	if *compressBlocks {
+		sink.Compressor = &blockpool.Compressor{}
+	}
+
+	outPool := &blockpool.BlockPool{
+		Container:      container,
+		Downstream:     sink,
+		Consumer:       c.consumer,
+		DedupBatchSize: 16,
+	}
+
+	c.consumer.Infof("Storing %s as blocks in %s", container.Stats(), blocksPath)
+	err = pwr.CopyContainer(container, outPool, inPool, c.consumer)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	manifestWriter, err := os.Create(manifestPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer manifestWriter.Close()
+
+	err = blockpool.WriteManifest(manifestWriter, compression, container, blockHashes)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = manifestWriter.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	dedupStats := outPool.DedupStats()
+	return c.printResult(&blockpoolResult{
+		Manifest:  manifestPath,
+		Blocks:    blocksPath,
+		Dir:       dir,
+		Container: statsOf(container),
+
+		SkippedBlocks: dedupStats.SkippedBlocks,
+		SkippedBytes:  dedupStats.SkippedBytes,
+	})
+}
+
+// runBlockpoolPull rebuilds a build from a manifest and its blocks
+func runBlockpoolPull(c *cli, args []string) error {
+	flags := c.flags("blockpool")
+	compressedBlocks := flags.Bool("zstd", false, "Blocks are compressed with zstd")
+	err := c.parse(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	manifestPath, blocksPath, outPath := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	manifestReader, closeManifest, err := openSource(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer closeManifest()
+
+	container, blockHashes, err := blockpool.ReadManifest(manifestReader)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	blockAddresses, err := blockHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	source := &blockpool.DiskSource{
+		BasePath:       blocksPath,
+		BlockAddresses: blockAddresses,
+		Container:      container,
+	}
+	if *compressedBlocks {
+		source.Decompressor = &blockpool.Decompressor{}
+	}
+
+	inPool := &blockpool.BlockPool{
+		Container: container,
+		Upstream:  source,
+		Consumer:  c.consumer,
+	}
+
+	err = container.Prepare(outPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	c.consumer.Infof("Rebuilding %s from blocks in %s", container.Stats(), blocksPath)
+	err = pwr.CopyContainer(container, fspool.New(container, outPath), inPool, c.consumer)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return c.printResult(&blockpoolResult{
+		Manifest:  manifestPath,
+		Blocks:    blocksPath,
+		Dir:       outPath,
+		Container: statsOf(container),
+	})
+}
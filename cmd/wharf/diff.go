@@ -0,0 +1,317 @@
+package main
+
+import (
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/inspect"
+	"github.com/itchio/wharf/pools"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wsync"
+)
+
+type diffResult struct {
+	Patch     string          `json:"patch"`
+	Signature string          `json:"signature"`
+	Old       *containerStats `json:"old"`
+	New       *containerStats `json:"new"`
+
+	ReusedBytes int64 `json:"reusedBytes"`
+	FreshBytes  int64 `json:"freshBytes"`
+	AddedBytes  int64 `json:"addedBytes"`
+	SavedBytes  int64 `json:"savedBytes"`
+}
+
+func runDiff(c *cli, args []string) error {
+	flags := c.flags("diff")
+	getCompression := compressionFlags(flags, "brotli", 1)
+	signaturePath := flags.String("signature", "", "Where to write the new build's signature (default: <patch.pwr>.sig)")
+	portable := flags.Bool("portable", false, "Refuse new builds with paths that break on Windows or case-insensitive filesystems")
+	err := c.parse(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	oldPath, newPath, patchPath := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	if *signaturePath == "" {
+		*signaturePath = patchPath + ".sig"
+	}
+
+	compression, err := getCompression()
+	if err != nil {
+		return err
+	}
+
+	c.consumer.Infof("Reading old build from %s", oldPath)
+	targetSignature, err := signatureOf(c, oldPath)
+	if err != nil {
+		return err
+	}
+
+	sourceContainer, err := tlc.WalkAny(newPath, &tlc.WalkOpts{})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if *portable {
+		issues := sourceContainer.CheckPortability(&tlc.PortabilityOptions{
+			Previous: targetSignature.Container,
+		})
+		for _, issue := range issues {
+			c.consumer.Warnf("%s", issue)
+		}
+		if len(issues) > 0 {
+			return errors.Wrap(&tlc.ErrNotPortable{Issues: issues}, 0)
+		}
+	}
+
+	sourcePool, err := pools.New(sourceContainer, newPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	patchWriter, err := os.Create(patchPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer patchWriter.Close()
+
+	signatureWriter, err := os.Create(*signaturePath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer signatureWriter.Close()
+
+	c.consumer.Infof("Diffing %s", sourceContainer.Stats())
+	dctx := &pwr.DiffContext{
+		Compression: compression,
+		Consumer:    c.consumer,
+
+		SourceContainer: sourceContainer,
+		Pool:            sourcePool,
+
+		TargetContainer: targetSignature.Container,
+		TargetSignature: targetSignature.Hashes,
+	}
+
+	// this also closes both writers when not compressing
+	err = dctx.WritePatch(patchWriter, signatureWriter)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return c.printResult(&diffResult{
+		Patch:     patchPath,
+		Signature: *signaturePath,
+		Old:       statsOf(targetSignature.Container),
+		New:       statsOf(sourceContainer),
+
+		ReusedBytes: dctx.ReusedBytes,
+		FreshBytes:  dctx.FreshBytes,
+		AddedBytes:  dctx.AddedBytes,
+		SavedBytes:  dctx.SavedBytes,
+	})
+}
+
+// signatureOf reads path if it's a signature file, and computes the
+// signature of the build at path otherwise (a folder or an archive)
+func signatureOf(c *cli, path string) (*pwr.SignatureInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	fileType, _ := inspect.Detect(f)
+	f.Close()
+
+	if fileType == inspect.FileTypeSignature {
+		return readSignature(path)
+	}
+
+	container, err := tlc.WalkAny(path, &tlc.WalkOpts{})
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	pool, err := pools.New(container, path)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	hashes, err := pwr.ComputeSignature(container, pool, c.consumer)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return &pwr.SignatureInfo{
+		Container: container,
+		Hashes:    hashes,
+	}, nil
+}
+
+type rediffResult struct {
+	Patch     string `json:"patch"`
+	Optimized string `json:"optimized"`
+	Size      int64  `json:"size"`
+}
+
+func runRediff(c *cli, args []string) error {
+	flags := c.flags("rediff")
+	getCompression := compressionFlags(flags, "zstd", 9)
+	partitions := flags.Int("partitions", 0, "Number of partitions for suffix sorting (0: default)")
+	err := c.parse(flags, args, 4)
+	if err != nil {
+		return err
+	}
+
+	oldPath, newPath, patchPath, optimizedPath := flags.Arg(0), flags.Arg(1), flags.Arg(2), flags.Arg(3)
+
+	compression, err := getCompression()
+	if err != nil {
+		return err
+	}
+
+	patchReader, closePatch, err := openSource(patchPath)
+	if err != nil {
+		return err
+	}
+	defer closePatch()
+
+	rc := &pwr.RediffContext{
+		Compression: compression,
+		Consumer:    c.consumer,
+		Partitions:  *partitions,
+	}
+
+	c.consumer.Infof("Analyzing %s", patchPath)
+	err = rc.AnalyzePatch(patchReader)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	rc.TargetPool, err = pools.New(rc.TargetContainer, oldPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	rc.SourcePool, err = pools.New(rc.SourceContainer, newPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	_, err = patchReader.Resume(nil)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	optimizedWriter, err := os.Create(optimizedPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer optimizedWriter.Close()
+
+	c.consumer.Infof("Optimizing into %s", optimizedPath)
+	err = rc.OptimizePatch(patchReader, optimizedWriter)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = optimizedWriter.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	stats, err := os.Stat(optimizedPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return c.printResult(&rediffResult{
+		Patch:     patchPath,
+		Optimized: optimizedPath,
+		Size:      stats.Size(),
+	})
+}
+
+type signResult struct {
+	Signature string          `json:"signature"`
+	Container *containerStats `json:"container"`
+}
+
+func runSign(c *cli, args []string) error {
+	flags := c.flags("sign")
+	getCompression := compressionFlags(flags, "brotli", 1)
+	err := c.parse(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	dir, signaturePath := flags.Arg(0), flags.Arg(1)
+
+	compression, err := getCompression()
+	if err != nil {
+		return err
+	}
+
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	pool, err := pools.New(container, dir)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	signatureWriter, err := os.Create(signaturePath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer signatureWriter.Close()
+
+	rawSigWire := wire.NewWriteContext(signatureWriter)
+	err = rawSigWire.WriteMagic(pwr.SignatureMagic)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = rawSigWire.WriteMessage(&pwr.SignatureHeader{
+		Compression: compression,
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	sigWire, err := pwr.CompressWire(rawSigWire, compression)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = sigWire.WriteMessage(container)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	c.consumer.Infof("Signing %s", container.Stats())
+	err = pwr.ComputeSignatureToWriter(container, pool, c.consumer, func(hash wsync.BlockHash) error {
+		return sigWire.WriteMessage(&pwr.BlockHash{
+			WeakHash:   hash.WeakHash,
+			StrongHash: hash.StrongHash,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	// this also closes signatureWriter when not compressing
+	err = sigWire.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return c.printResult(&signResult{
+		Signature: signaturePath,
+		Container: statsOf(container),
+	})
+}
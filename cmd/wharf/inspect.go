@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/inspect"
+	"github.com/itchio/wharf/pwr"
+)
+
+type inspectSummary struct {
+	Type        string                   `json:"type"`
+	Compression *pwr.CompressionSettings `json:"compression,omitempty"`
+	Containers  []*containerStats        `json:"containers"`
+	Messages    map[inspect.Kind]int     `json:"messages"`
+}
+
+func runInspect(c *cli, args []string) error {
+	flags := c.flags("inspect")
+	summary := flags.Bool("summary", false, "Only print the file type, containers and message counts")
+	err := c.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	source, closeSource, err := openSource(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	r, err := inspect.NewReader(source)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if !*summary {
+		return inspect.WriteJSONLines(r, c.stdout)
+	}
+
+	result := &inspectSummary{
+		Type:        r.Type.String(),
+		Compression: r.Compression,
+		Messages:    make(map[inspect.Kind]int),
+	}
+
+	for {
+		msg, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		result.Messages[msg.Kind]++
+	}
+
+	for _, container := range r.Containers {
+		result.Containers = append(result.Containers, statsOf(container))
+	}
+
+	return c.printResult(result)
+}
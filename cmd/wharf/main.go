@@ -0,0 +1,242 @@
+// Command wharf diffs, patches, signs, verifies and heals builds with the
+// wharf protocol, and inspects wharf files. Every command prints a single
+// JSON object on stdout when it's done (inspect prints JSON lines), and
+// logs on stderr.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+
+	_ "github.com/itchio/wharf/compressors/cbrotli"
+	_ "github.com/itchio/wharf/compressors/gzip"
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/cbrotli"
+	_ "github.com/itchio/wharf/decompressors/gzip"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+// cli holds what every command needs: where to print and log, and
+// the global flags
+type cli struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	verbose  bool
+	consumer *state.Consumer
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"diff", "diff [flags] <old> <new> <patch.pwr>", runDiff},
+		{"rediff", "rediff [flags] <old> <new> <patch.pwr> <optimized.pwr>", runRediff},
+		{"apply", "apply [flags] <patch.pwr> <old> <out>", runApply},
+		{"sign", "sign [flags] <dir> <signature.pws>", runSign},
+		{"verify", "verify [flags] <signature.pws> <dir>", runVerify},
+		{"heal", "heal [flags] <signature.pws> <dir> <archive>", runHeal},
+		{"blockpool", "blockpool push [flags] <dir> <blocks> <manifest.pwm>\n       blockpool pull <manifest.pwm> <blocks> <out>", runBlockpool},
+		{"inspect", "inspect [flags] <file>", runInspect},
+		{"zipindex", "zipindex [flags] <build.zip> <index.pzi>", runZipIndex},
+	}
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// run parses global flags and runs a command. Errors are printed as JSON
+// objects on stdout before being returned.
+func run(args []string, stdout io.Writer, stderr io.Writer) error {
+	c := &cli{stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("wharf", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&c.verbose, "v", false, "Log debug messages on stderr")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: wharf [-v] <command> [flags] [args]\n\nCommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(stderr, "  %s\n", cmd.usage)
+		}
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	c.consumer = &state.Consumer{
+		OnMessage: func(level string, message string) {
+			if level == "debug" && !c.verbose {
+				return
+			}
+			fmt.Fprintf(stderr, "[%s] %s\n", level, message)
+		},
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no command given")
+	}
+
+	name := flags.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err = cmd.run(c, flags.Args()[1:])
+			if err != nil && err != errResultFailed {
+				c.printError(err)
+			}
+			return err
+		}
+	}
+
+	flags.Usage()
+	return fmt.Errorf("unknown command %s", name)
+}
+
+// flags returns a flag set for a command, which prints its usage on error
+func (c *cli) flags(name string) *flag.FlagSet {
+	var usage string
+	for _, cmd := range commands {
+		if cmd.name == name {
+			usage = cmd.usage
+		}
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: wharf %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses a command's flags and checks its number of arguments
+func (c *cli) parse(flags *flag.FlagSet, args []string, numArgs int) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != numArgs {
+		flags.Usage()
+		return fmt.Errorf("%s: expected %d arguments, got %d", flags.Name(), numArgs, flags.NArg())
+	}
+	return nil
+}
+
+// printResult prints the result of a command as a JSON object
+func (c *cli) printResult(result interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(result)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// errResultFailed is returned by commands that already printed a result
+// but should still exit with a non-zero status
+var errResultFailed = fmt.Errorf("command failed")
+
+type errorResult struct {
+	Error string `json:"error"`
+	Stack string `json:"stack,omitempty"`
+}
+
+func (c *cli) printError(err error) {
+	result := &errorResult{Error: err.Error()}
+	if se, ok := err.(*errors.Error); ok && c.verbose {
+		result.Stack = se.ErrorStack()
+	}
+	c.printResult(result)
+}
+
+// compressionFlags adds -compression and -quality to a flag set
+func compressionFlags(flags *flag.FlagSet, algorithm string, quality int) func() (*pwr.CompressionSettings, error) {
+	algorithmFlag := flags.String("compression", algorithm, "Compression algorithm: none, brotli, gzip or zstd")
+	qualityFlag := flags.Int("quality", quality, "Compression quality")
+
+	return func() (*pwr.CompressionSettings, error) {
+		value, ok := pwr.CompressionAlgorithm_value[strings.ToUpper(*algorithmFlag)]
+		if !ok {
+			return nil, fmt.Errorf("unknown compression algorithm %s", *algorithmFlag)
+		}
+
+		return &pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm(value),
+			Quality:   int32(*qualityFlag),
+		}, nil
+	}
+}
+
+// containerStats is how containers show up in results
+type containerStats struct {
+	Files    int   `json:"files"`
+	Dirs     int   `json:"dirs"`
+	Symlinks int   `json:"symlinks"`
+	Size     int64 `json:"size"`
+}
+
+func statsOf(container *tlc.Container) *containerStats {
+	return &containerStats{
+		Files:    len(container.Files),
+		Dirs:     len(container.Dirs),
+		Symlinks: len(container.Symlinks),
+		Size:     container.Size,
+	}
+}
+
+// openSource opens a file for use with wharf readers. The returned
+// source is already resumed.
+func openSource(path string) (savior.SeekSource, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, 0)
+	}
+
+	source := seeksource.FromFile(f)
+	_, err = source.Resume(nil)
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Wrap(err, 0)
+	}
+	return source, f.Close, nil
+}
+
+// readSignature reads a signature file
+func readSignature(path string) (*pwr.SignatureInfo, error) {
+	source, closeSource, err := openSource(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	sigInfo, err := pwr.ReadSignature(source)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return sigInfo, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/pwr/patcher"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func runJSON(t *testing.T, result interface{}, args ...string) error {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	err := run(args, stdout, stderr)
+	t.Logf("wharf %v\n%s%s", args, stderr.String(), stdout.String())
+
+	if result != nil && stdout.Len() > 0 {
+		wtest.Must(t, json.Unmarshal(stdout.Bytes(), result))
+	}
+	return err
+}
+
+// cliBuilds are two versions of a build, and the patch between them
+type cliBuilds struct {
+	dir string
+
+	v1 string
+	v2 string
+	// patch is from v1 to v2, uncompressed
+	patch string
+	// signature is that of v2
+	signature string
+}
+
+// makeCLIBuilds writes two versions of a build to a temporary folder, which
+// callers must remove, and diffs them
+func makeCLIBuilds(t *testing.T) *cliBuilds {
+	dir, err := ioutil.TempDir("", "wharf-cli")
+	wtest.Must(t, err)
+
+	b := &cliBuilds{
+		dir:       dir,
+		v1:        filepath.Join(dir, "v1"),
+		v2:        filepath.Join(dir, "v2"),
+		patch:     filepath.Join(dir, "patch.pwr"),
+		signature: filepath.Join(dir, "v2.pws"),
+	}
+
+	wtest.MakeTestDir(t, b.v1, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*4 + 14},
+			{Path: "file-1", Seed: 0x2},
+		},
+	})
+
+	wtest.MakeTestDir(t, b.v2, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*6 + 14},
+			{Path: "file-1", Seed: 0x2},
+			{Path: "dir2/file-2", Seed: 0x3},
+		},
+	})
+
+	wtest.Must(t, runJSON(t, nil, "diff", "-compression", "none", "-signature", b.signature, b.v1, b.v2, b.patch))
+	return b
+}
+
+func Test_Diff(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	var diff diffResult
+	wtest.Must(t, runJSON(t, &diff, "diff", "-compression", "none", b.v1, b.v2, filepath.Join(b.dir, "patch2.pwr")))
+	assert.EqualValues(t, 3, diff.New.Files)
+	assert.True(t, diff.ReusedBytes > 0)
+
+	var sign signResult
+	wtest.Must(t, runJSON(t, &sign, "sign", "-compression", "none", b.v1, filepath.Join(b.dir, "v1.pws")))
+	assert.EqualValues(t, 2, sign.Container.Files)
+
+	t.Logf("Diffing against a signature")
+	wtest.Must(t, runJSON(t, &diff, "diff", "-compression", "none", filepath.Join(b.dir, "v1.pws"), b.v2, filepath.Join(b.dir, "patch3.pwr")))
+	assert.EqualValues(t, 3, diff.New.Files)
+}
+
+func Test_Apply(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	out := filepath.Join(b.dir, "out")
+	checkpoint := filepath.Join(b.dir, "apply.checkpoint")
+	var apply applyResult
+	wtest.Must(t, runJSON(t, &apply, "apply", "-checkpoint", checkpoint, "-save-interval", "0", "-signature", b.signature, b.patch, b.v1, out))
+	assert.False(t, apply.Stopped)
+	assert.True(t, apply.Validated)
+	_, err := os.Stat(checkpoint)
+	assert.True(t, os.IsNotExist(err), "checkpoint should be removed once done")
+
+	t.Logf("Patching in place")
+	wtest.Must(t, runJSON(t, &apply, "apply", "-signature", b.signature, b.patch, b.v1, b.v1))
+	assert.True(t, apply.Validated)
+
+	var failure errorResult
+	assert.Error(t, runJSON(t, &failure, "apply", b.patch, filepath.Join(b.dir, "missing"), filepath.Join(b.dir, "out2")))
+	assert.NotEmpty(t, failure.Error)
+}
+
+func Test_Verify(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	var verify verifyResult
+	wtest.Must(t, runJSON(t, &verify, "verify", b.signature, b.v2))
+	assert.False(t, verify.Wounds)
+
+	wtest.Must(t, os.Remove(filepath.Join(b.v2, "file-1")))
+	wounds := filepath.Join(b.dir, "v2.pww")
+	err := runJSON(t, &verify, "verify", "-wounds", wounds, b.signature, b.v2)
+	assert.Error(t, err)
+	assert.True(t, verify.Wounds)
+
+	var summary inspectSummary
+	wtest.Must(t, runJSON(t, &summary, "inspect", "-summary", wounds))
+	assert.Equal(t, "wounds", summary.Type)
+	assert.EqualValues(t, 1, summary.Messages["wound"])
+}
+
+func Test_ApplyResume(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	out := filepath.Join(b.dir, "out")
+	checkpoint := filepath.Join(b.dir, "apply.checkpoint")
+	args := []string{"apply", "-checkpoint", checkpoint, "-save-interval", "0", "-signature", b.signature, b.patch, b.v1, out}
+
+	// stop once the first file is done, so resuming must keep it
+	stopAtCheckpoint = func(c *patcher.Checkpoint) bool {
+		return c.FileIndex > 0
+	}
+	var apply applyResult
+	err := runJSON(t, &apply, args...)
+	stopAtCheckpoint = nil
+	wtest.Must(t, err)
+	assert.True(t, apply.Stopped)
+	assert.False(t, apply.Validated)
+	_, err = os.Stat(checkpoint)
+	assert.NoError(t, err, "checkpoint should be kept when stopped")
+
+	apply = applyResult{}
+	wtest.Must(t, runJSON(t, &apply, args...))
+	assert.False(t, apply.Stopped)
+	assert.True(t, apply.Validated, "resumed apply should produce a valid build")
+	_, err = os.Stat(checkpoint)
+	assert.True(t, os.IsNotExist(err), "checkpoint should be removed once done")
+
+	t.Logf("Refusing checkpoints when patching in place")
+	err = runJSON(t, nil, "apply", "-checkpoint", checkpoint, b.patch, b.v1, b.v1)
+	assert.Error(t, err)
+}
+
+func Test_Blockpool(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	blocks := filepath.Join(b.dir, "blocks")
+	manifest := filepath.Join(b.dir, "v2.pwm")
+	var pushed blockpoolResult
+	wtest.Must(t, runJSON(t, &pushed, "blockpool", "push", b.v2, blocks, manifest))
+	assert.EqualValues(t, 3, pushed.Container.Files)
+	assert.EqualValues(t, 0, pushed.SkippedBlocks)
+
+	wtest.Must(t, runJSON(t, &pushed, "blockpool", "push", b.v2, blocks, manifest))
+	assert.EqualValues(t, 3, pushed.SkippedBlocks, "blocks should only be stored once")
+
+	pulled := filepath.Join(b.dir, "pulled")
+	wtest.Must(t, runJSON(t, nil, "blockpool", "pull", manifest, blocks, pulled))
+
+	var verify verifyResult
+	wtest.Must(t, runJSON(t, &verify, "verify", b.signature, pulled))
+	assert.False(t, verify.Wounds)
+}
+
+func Test_ZipIndex(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	v1Zip := filepath.Join(b.dir, "v1.zip")
+	zipWriter, err := os.Create(v1Zip)
+	wtest.Must(t, err)
+	zw := zip.NewWriter(zipWriter)
+	for _, name := range []string{"subdir/file-1", "file-1"} {
+		data, err := ioutil.ReadFile(filepath.Join(b.v1, filepath.FromSlash(name)))
+		wtest.Must(t, err)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		wtest.Must(t, err)
+		_, err = w.Write(data)
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, zw.Close())
+	wtest.Must(t, zipWriter.Close())
+
+	v1Index := filepath.Join(b.dir, "v1.pzi")
+	var indexed zipIndexResult
+	wtest.Must(t, runJSON(t, &indexed, "zipindex", "-span", "65536", v1Zip, v1Index))
+	assert.EqualValues(t, 2, indexed.Entries)
+	assert.True(t, indexed.Checkpoints > 0)
+
+	t.Logf("Patching from a zip, with a zip index")
+	var apply applyResult
+	wtest.Must(t, runJSON(t, &apply, "apply", "-zip-index", v1Index, "-signature", b.signature, b.patch, v1Zip, filepath.Join(b.dir, "out")))
+	assert.True(t, apply.Validated)
+}
+
+func Test_ApplyFromTar(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	v1Tar := filepath.Join(b.dir, "v1.tar.gz")
+	tarWriter, err := os.Create(v1Tar)
+	wtest.Must(t, err)
+	gw := gzip.NewWriter(tarWriter)
+	_, err = archiver.CompressTar(gw, b.v1, &state.Consumer{})
+	wtest.Must(t, err)
+	wtest.Must(t, gw.Close())
+	wtest.Must(t, tarWriter.Close())
+
+	var apply applyResult
+	wtest.Must(t, runJSON(t, &apply, "apply", "-signature", b.signature, b.patch, v1Tar, filepath.Join(b.dir, "out")))
+	assert.True(t, apply.Validated)
+}
+
+func Test_DiffPortable(t *testing.T) {
+	b := makeCLIBuilds(t)
+	defer os.RemoveAll(b.dir)
+
+	var diff diffResult
+	wtest.Must(t, runJSON(t, &diff, "diff", "-compression", "none", "-portable", b.v1, b.v2, filepath.Join(b.dir, "portable.pwr")))
+
+	renamed := filepath.Join(b.dir, "renamed")
+	wtest.MakeTestDir(t, renamed, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "Subdir/file-1", Seed: 0x1},
+			{Path: "file-1", Seed: 0x2},
+		},
+	})
+	err := runJSON(t, nil, "diff", "-compression", "none", "-portable", b.v1, renamed, filepath.Join(b.dir, "renamed.pwr"))
+	assert.Error(t, err, "case-only renames should not be portable")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pwr"
+)
+
+type verifyResult struct {
+	Dir       string          `json:"dir"`
+	Container *containerStats `json:"container"`
+
+	// Wounds is true if anything was missing or corrupted
+	Wounds         bool   `json:"wounds"`
+	TotalCorrupted int64  `json:"totalCorrupted"`
+	WoundsPath     string `json:"woundsPath,omitempty"`
+	TotalHealed    int64  `json:"totalHealed,omitempty"`
+}
+
+func runVerify(c *cli, args []string) error {
+	flags := c.flags("verify")
+	woundsPath := flags.String("wounds", "", "Write wounds to this file (.pww)")
+	healSpec := flags.String("heal", "", "Heal wounds with this healer spec, like archive,<path>")
+	err := c.parse(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	return verify(c, flags.Arg(0), flags.Arg(1), *woundsPath, *healSpec)
+}
+
+func runHeal(c *cli, args []string) error {
+	flags := c.flags("heal")
+	err := c.parse(flags, args, 3)
+	if err != nil {
+		return err
+	}
+
+	return verify(c, flags.Arg(0), flags.Arg(1), "", "archive,"+flags.Arg(2))
+}
+
+func verify(c *cli, signaturePath string, dir string, woundsPath string, healSpec string) error {
+	if woundsPath != "" && healSpec != "" {
+		return errors.New("verify: -wounds and -heal can't be used together")
+	}
+
+	sigInfo, err := readSignature(signaturePath)
+	if err != nil {
+		return err
+	}
+
+	vctx := &pwr.ValidatorContext{
+		Consumer:   c.consumer,
+		WoundsPath: woundsPath,
+		HealPath:   healSpec,
+	}
+
+	c.consumer.Infof("Verifying %s against %s", dir, sigInfo.Container.Stats())
+	err = vctx.Validate(dir, sigInfo)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	result := &verifyResult{
+		Dir:            dir,
+		Container:      statsOf(sigInfo.Container),
+		Wounds:         vctx.WoundsConsumer.HasWounds(),
+		TotalCorrupted: vctx.WoundsConsumer.TotalCorrupted(),
+	}
+
+	if result.Wounds && woundsPath != "" {
+		result.WoundsPath = woundsPath
+	}
+
+	if healer, ok := vctx.WoundsConsumer.(pwr.Healer); ok {
+		result.TotalHealed = healer.TotalHealed()
+	}
+
+	err = c.printResult(result)
+	if err != nil {
+		return err
+	}
+
+	if result.Wounds && healSpec == "" {
+		return errResultFailed
+	}
+	return nil
+}
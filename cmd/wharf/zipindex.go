@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/zipindex"
+)
+
+type zipIndexResult struct {
+	Zip         string `json:"zip"`
+	Index       string `json:"index"`
+	Entries     int    `json:"entries"`
+	Checkpoints int    `json:"checkpoints"`
+	IndexSize   int64  `json:"indexSize"`
+}
+
+// runZipIndex writes a zip index, so that the zip can be used as an
+// efficient patch target (see apply -zip-index)
+func runZipIndex(c *cli, args []string) error {
+	flags := c.flags("zipindex")
+	getCompression := compressionFlags(flags, "brotli", 1)
+	span := flags.Int64("span", zipindex.DefaultSpan, "Minimum amount of uncompressed data between checkpoints")
+	err := c.parse(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	zipPath, indexPath := flags.Arg(0), flags.Arg(1)
+
+	compression, err := getCompression()
+	if err != nil {
+		return err
+	}
+
+	zipFile, err := os.Open(zipPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer zipFile.Close()
+
+	stats, err := zipFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	zr, err := zip.NewReader(zipFile, stats.Size())
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	c.consumer.Infof("Indexing %s", zipPath)
+	index, err := zipindex.Build(zr, zipFile, zipindex.BuildOptions{
+		Span:     *span,
+		Consumer: c.consumer,
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	indexWriter, err := os.Create(indexPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer indexWriter.Close()
+
+	err = zipindex.WriteIndex(indexWriter, compression, index)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = indexWriter.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	result := &zipIndexResult{
+		Zip:     zipPath,
+		Index:   indexPath,
+		Entries: len(index.Entries),
+	}
+	for _, entry := range index.Entries {
+		result.Checkpoints += len(entry.Checkpoints)
+	}
+
+	indexStats, err := os.Stat(indexPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	result.IndexSize = indexStats.Size()
+
+	return c.printResult(result)
+}
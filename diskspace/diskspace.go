@@ -0,0 +1,220 @@
+// Package diskspace estimates how much disk space patching operations
+// need, and checks that estimate against the free space of the filesystems
+// involved, so that we can refuse early instead of failing with ENOSPC
+// halfway through.
+package diskspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+)
+
+// Usage describes the filesystem a path is on
+type Usage struct {
+	// Path is the path that was queried
+	Path string
+	// Device identifies the filesystem, paths with the same device
+	// share the same free space
+	Device string
+	// Free is the number of bytes available to the current user
+	Free int64
+}
+
+// Stat returns usage information for the filesystem path is on.
+// path doesn't have to exist yet: its closest existing parent is used.
+func Stat(path string) (*Usage, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	existingPath := absPath
+	for {
+		_, err := os.Stat(existingPath)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		parent := filepath.Dir(existingPath)
+		if parent == existingPath {
+			return nil, errors.Wrap(err, 0)
+		}
+		existingPath = parent
+	}
+
+	device, free, err := statfs(existingPath)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return &Usage{
+		Path:   path,
+		Device: device,
+		Free:   free,
+	}, nil
+}
+
+// A Need is a number of extra bytes an operation will write at a given path
+type Need struct {
+	Path  string
+	Bytes int64
+}
+
+// An Estimate is the peak extra disk space an operation needs, over
+// one or several locations (for example an install folder and a staging
+// folder). Estimates are made before anything is written, so they're
+// an upper bound.
+type Estimate struct {
+	Needs []Need
+
+	// FinalDelta is how much bigger (or smaller, if negative) the
+	// destination will be once the operation is done
+	FinalDelta int64
+}
+
+// Total returns the sum of all needs, as if all the locations were
+// on the same filesystem
+func (e *Estimate) Total() int64 {
+	var total int64
+	for _, need := range e.Needs {
+		total += need.Bytes
+	}
+	return total
+}
+
+// ErrInsufficientSpace is returned by Check when a filesystem doesn't
+// have enough free space for an operation
+type ErrInsufficientSpace struct {
+	// Path is the (first) location that's on the full filesystem
+	Path string
+	// Needed is the number of bytes the operation needs on that filesystem
+	Needed int64
+	// Available is the number of free bytes on that filesystem
+	Available int64
+}
+
+var _ error = (*ErrInsufficientSpace)(nil)
+
+func (e *ErrInsufficientSpace) Error() string {
+	return fmt.Sprintf("not enough disk space for %s: need %s, only %s available (missing %s)",
+		e.Path,
+		humanize.IBytes(uint64(e.Needed)),
+		humanize.IBytes(uint64(e.Available)),
+		humanize.IBytes(uint64(e.Needed-e.Available)),
+	)
+}
+
+// Check compares the estimate against the free space of the filesystems
+// it concerns. Needs on the same filesystem are added together.
+// It returns an *ErrInsufficientSpace if one of them is too full.
+func (e *Estimate) Check() error {
+	type deviceNeed struct {
+		path   string
+		needed int64
+		free   int64
+	}
+	var devices []*deviceNeed
+	devicesByID := make(map[string]*deviceNeed)
+
+	for _, need := range e.Needs {
+		if need.Bytes <= 0 {
+			continue
+		}
+
+		usage, err := Stat(need.Path)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		dn, ok := devicesByID[usage.Device]
+		if !ok {
+			dn = &deviceNeed{
+				path: need.Path,
+				free: usage.Free,
+			}
+			devicesByID[usage.Device] = dn
+			devices = append(devices, dn)
+		}
+		dn.needed += need.Bytes
+	}
+
+	for _, dn := range devices {
+		if dn.needed > dn.free {
+			return errors.Wrap(&ErrInsufficientSpace{
+				Path:      dn.path,
+				Needed:    dn.needed,
+				Available: dn.free,
+			}, 0)
+		}
+	}
+
+	return nil
+}
+
+// IsInsufficientSpace returns the *ErrInsufficientSpace wrapped in err, if any
+func IsInsufficientSpace(err error) (*ErrInsufficientSpace, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *ErrInsufficientSpace:
+			return e, true
+		case *errors.Error:
+			err = e.Err
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// ForFresh estimates the space needed to write all of source's files
+// in an empty outputPath
+func ForFresh(source *tlc.Container, outputPath string) *Estimate {
+	return &Estimate{
+		Needs: []Need{
+			{Path: outputPath, Bytes: source.Size},
+		},
+		FinalDelta: source.Size,
+	}
+}
+
+// ForInPlace estimates the space needed to turn target into source,
+// in-place, at outputPath, using stagePath to store temporary files.
+//
+// Since the patch hasn't been read yet, it assumes the worst: that every
+// file of source is rebuilt in the staging folder. Files of target are
+// only deleted at the very end, so the output folder needs to hold both
+// the growth of every file that keeps its path, and every file that's
+// new (whether it's renamed, duplicated, or actually new).
+func ForInPlace(target *tlc.Container, source *tlc.Container, outputPath string, stagePath string) *Estimate {
+	targetSizes := make(map[string]int64)
+	for _, f := range target.Files {
+		targetSizes[f.Path] = f.Size
+	}
+
+	var outputBytes int64
+	for _, f := range source.Files {
+		if targetSize, ok := targetSizes[f.Path]; ok {
+			if f.Size > targetSize {
+				outputBytes += f.Size - targetSize
+			}
+		} else {
+			outputBytes += f.Size
+		}
+	}
+
+	return &Estimate{
+		Needs: []Need{
+			{Path: stagePath, Bytes: source.Size},
+			{Path: outputPath, Bytes: outputBytes},
+		},
+		FinalDelta: source.Size - target.Size,
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package diskspace
+
+import (
+	"math"
+)
+
+// on platforms we don't know how to query, pretend there's always
+// enough space, so that Check never gets in the way
+func statfs(path string) (string, int64, error) {
+	return path, math.MaxInt64, nil
+}
@@ -0,0 +1,86 @@
+package diskspace
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/wharf/tlc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	usage, err := Stat(dir)
+	assert.NoError(t, err)
+	assert.True(t, usage.Free > 0)
+
+	nested, err := Stat(filepath.Join(dir, "does", "not", "exist", "yet"))
+	assert.NoError(t, err)
+	assert.Equal(t, usage.Device, nested.Device, "should use closest existing parent")
+}
+
+func Test_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	small := &Estimate{
+		Needs: []Need{
+			{Path: dir, Bytes: 1},
+		},
+	}
+	assert.NoError(t, small.Check())
+
+	huge := &Estimate{
+		Needs: []Need{
+			{Path: filepath.Join(dir, "output"), Bytes: math.MaxInt64 / 2},
+			{Path: filepath.Join(dir, "stage"), Bytes: math.MaxInt64 / 2},
+		},
+	}
+	err = huge.Check()
+	assert.Error(t, err)
+
+	eis, ok := IsInsufficientSpace(err)
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, filepath.Join(dir, "output"), eis.Path)
+		assert.EqualValues(t, huge.Total(), eis.Needed, "needs on the same filesystem should add up")
+	}
+}
+
+func Test_Estimates(t *testing.T) {
+	target := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "grows", Size: 10},
+			{Path: "shrinks", Size: 30},
+			{Path: "deleted", Size: 50},
+		},
+		Size: 90,
+	}
+
+	source := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "grows", Size: 25},
+			{Path: "shrinks", Size: 5},
+			{Path: "new", Size: 40},
+		},
+		Size: 70,
+	}
+
+	fresh := ForFresh(source, "out")
+	assert.EqualValues(t, 70, fresh.Total())
+	assert.EqualValues(t, 70, fresh.FinalDelta)
+
+	inPlace := ForInPlace(target, source, "out", "stage")
+	assert.Equal(t, []Need{
+		{Path: "stage", Bytes: 70},
+		{Path: "out", Bytes: 15 + 40},
+	}, inPlace.Needs)
+	assert.EqualValues(t, -20, inPlace.FinalDelta)
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package diskspace
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/go-errors/errors"
+)
+
+func statfs(path string) (string, int64, error) {
+	var st syscall.Stat_t
+	err := syscall.Stat(path, &st)
+	if err != nil {
+		return "", 0, errors.Wrap(err, 0)
+	}
+
+	var fs syscall.Statfs_t
+	err = syscall.Statfs(path, &fs)
+	if err != nil {
+		return "", 0, errors.Wrap(err, 0)
+	}
+
+	device := fmt.Sprintf("%d", st.Dev)
+	free := int64(fs.Bavail) * int64(fs.Bsize)
+	return device, free, nil
+}
@@ -0,0 +1,36 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-errors/errors"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = modkernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+func statfs(path string) (string, int64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", 0, errors.Wrap(err, 0)
+	}
+
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+	ret, _, callErr := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFreeBytes)),
+	)
+	if ret == 0 {
+		return "", 0, errors.Wrap(callErr, 0)
+	}
+
+	device := strings.ToLower(filepath.VolumeName(path))
+	return device, int64(freeBytesAvailable), nil
+}
@@ -0,0 +1,100 @@
+package inspect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wire"
+)
+
+// FileType is one of wharf's file formats, as identified by its magic number
+type FileType int
+
+const (
+	// FileTypeUnknown is anything that doesn't start with a wharf magic number
+	FileTypeUnknown FileType = iota
+	// FileTypePatch is a wharf patch (.pwr)
+	FileTypePatch
+	// FileTypeSignature is a wharf signature (.pws)
+	FileTypeSignature
+	// FileTypeManifest is a blockpool manifest (.pwm)
+	FileTypeManifest
+	// FileTypeWounds is a list of wounds (.pww)
+	FileTypeWounds
+	// FileTypeZipIndex is a zip index (.pzi)
+	FileTypeZipIndex
+)
+
+type fileTypeInfo struct {
+	name      string
+	extension string
+	magic     int32
+}
+
+var fileTypeInfos = map[FileType]fileTypeInfo{
+	FileTypePatch:     {"patch", ".pwr", pwr.PatchMagic},
+	FileTypeSignature: {"signature", ".pws", pwr.SignatureMagic},
+	FileTypeManifest:  {"manifest", ".pwm", pwr.ManifestMagic},
+	FileTypeWounds:    {"wounds", ".pww", pwr.WoundsMagic},
+	FileTypeZipIndex:  {"zip-index", ".pzi", pwr.ZipIndexMagic},
+}
+
+func (ft FileType) String() string {
+	if info, ok := fileTypeInfos[ft]; ok {
+		return info.name
+	}
+	return "unknown"
+}
+
+// Extension returns the usual file extension for this type, dot included,
+// or an empty string for FileTypeUnknown
+func (ft FileType) Extension() string {
+	return fileTypeInfos[ft].extension
+}
+
+// Magic returns the magic number files of this type start with
+func (ft FileType) Magic() int32 {
+	return fileTypeInfos[ft].magic
+}
+
+// FromMagic returns the type of file that starts with a given magic number,
+// or FileTypeUnknown
+func FromMagic(magic int32) FileType {
+	for ft, info := range fileTypeInfos {
+		if info.magic == magic {
+			return ft
+		}
+	}
+	return FileTypeUnknown
+}
+
+// ErrUnknownMagic is returned when a file doesn't start with any of
+// wharf's magic numbers
+type ErrUnknownMagic struct {
+	Magic int32
+}
+
+var _ error = (*ErrUnknownMagic)(nil)
+
+func (e *ErrUnknownMagic) Error() string {
+	return fmt.Sprintf("inspect: unknown magic number 0x%x, not a wharf file", e.Magic)
+}
+
+// Detect reads the first four bytes of a file and returns its type.
+// It returns an *ErrUnknownMagic if it's not a wharf file.
+func Detect(r io.Reader) (FileType, error) {
+	var magic int32
+	err := binary.Read(r, wire.Endianness, &magic)
+	if err != nil {
+		return FileTypeUnknown, errors.Wrap(err, 0)
+	}
+
+	ft := FromMagic(magic)
+	if ft == FileTypeUnknown {
+		return ft, errors.Wrap(&ErrUnknownMagic{Magic: magic}, 0)
+	}
+	return ft, nil
+}
@@ -0,0 +1,299 @@
+package inspect_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/inspect"
+	"github.com/itchio/wharf/pools/blockpool"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wtest"
+	"github.com/itchio/wharf/zipindex"
+	"github.com/stretchr/testify/assert"
+)
+
+func open(t *testing.T, data []byte) *inspect.Reader {
+	source := seeksource.FromBytes(data)
+	_, err := source.Resume(nil)
+	wtest.Must(t, err)
+
+	r, err := inspect.NewReader(source)
+	wtest.Must(t, err)
+	return r
+}
+
+func countKinds(t *testing.T, r *inspect.Reader) map[inspect.Kind]int {
+	kinds := make(map[inspect.Kind]int)
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return kinds
+		}
+		wtest.Must(t, err)
+		kinds[msg.Kind]++
+	}
+}
+
+func Test_Detect(t *testing.T) {
+	for _, ft := range []inspect.FileType{
+		inspect.FileTypePatch,
+		inspect.FileTypeSignature,
+		inspect.FileTypeManifest,
+		inspect.FileTypeWounds,
+		inspect.FileTypeZipIndex,
+	} {
+		buf := new(bytes.Buffer)
+		wtest.Must(t, wire.NewWriteContext(buf).WriteMagic(ft.Magic()))
+
+		detected, err := inspect.Detect(buf)
+		wtest.Must(t, err)
+		assert.Equal(t, ft, detected)
+		assert.NotEmpty(t, ft.Extension())
+	}
+
+	_, err := inspect.Detect(bytes.NewReader([]byte("PK\x03\x04")))
+	assert.Error(t, err)
+	assert.Equal(t, inspect.FileTypeUnknown, inspect.FromMagic(0x04034b50))
+}
+
+func Test_InspectPatchAndSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	v1 := filepath.Join(dir, "v1")
+	wtest.MakeTestDir(t, v1, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*4 + 14},
+			{Path: "file-1", Seed: 0x2},
+		},
+	})
+
+	v2 := filepath.Join(dir, "v2")
+	wtest.MakeTestDir(t, v2, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*6 + 14},
+			{Path: "file-1", Seed: 0x2},
+			{Path: "dir2/file-2", Seed: 0x3},
+		},
+	})
+
+	consumer := &state.Consumer{}
+
+	targetContainer, err := tlc.WalkAny(v1, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	sourceContainer, err := tlc.WalkAny(v2, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	targetSignature, err := pwr.ComputeSignature(targetContainer, fspool.New(targetContainer, v1), consumer)
+	wtest.Must(t, err)
+
+	patchBuffer := new(bytes.Buffer)
+	signatureBuffer := new(bytes.Buffer)
+	dctx := &pwr.DiffContext{
+		Compression: &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE},
+		Consumer:    consumer,
+
+		SourceContainer: sourceContainer,
+		Pool:            fspool.New(sourceContainer, v2),
+
+		TargetContainer: targetContainer,
+		TargetSignature: targetSignature,
+	}
+	wtest.Must(t, dctx.WritePatch(patchBuffer, signatureBuffer))
+
+	r := open(t, patchBuffer.Bytes())
+	assert.Equal(t, inspect.FileTypePatch, r.Type)
+	_, ok := r.Header.(*pwr.PatchHeader)
+	assert.True(t, ok)
+	assert.Equal(t, pwr.CompressionAlgorithm_NONE, r.Compression.Algorithm)
+
+	kinds := countKinds(t, r)
+	assert.Equal(t, 1, kinds[inspect.KindTargetContainer])
+	assert.Equal(t, 1, kinds[inspect.KindSourceContainer])
+	assert.Equal(t, len(sourceContainer.Files), kinds[inspect.KindSyncHeader])
+	assert.True(t, kinds[inspect.KindSyncOp] > len(sourceContainer.Files))
+	if assert.Len(t, r.Containers, 2) {
+		assert.EqualValues(t, sourceContainer.Size, r.Containers[1].Size)
+	}
+
+	r = open(t, signatureBuffer.Bytes())
+	assert.Equal(t, inspect.FileTypeSignature, r.Type)
+	kinds = countKinds(t, r)
+	assert.Equal(t, 1, kinds[inspect.KindContainer])
+	assert.True(t, kinds[inspect.KindBlockHash] > 0)
+
+	t.Logf("Truncated patches are an error")
+	r = open(t, patchBuffer.Bytes()[:patchBuffer.Len()-4])
+	for {
+		_, err = r.Next()
+		if err != nil {
+			break
+		}
+	}
+	assert.NotEqual(t, io.EOF, err)
+
+	t.Logf("Writing JSON lines")
+	jsonBuffer := new(bytes.Buffer)
+	wtest.Must(t, inspect.WriteJSONLines(open(t, patchBuffer.Bytes()), jsonBuffer))
+
+	scanner := bufio.NewScanner(jsonBuffer)
+	scanner.Buffer(nil, 16*1024*1024)
+	numLines := 0
+	for scanner.Scan() {
+		line := make(map[string]interface{})
+		wtest.Must(t, json.Unmarshal(scanner.Bytes(), &line))
+		if numLines == 0 {
+			assert.Equal(t, "patch", line["type"])
+		} else {
+			assert.NotEmpty(t, line["kind"])
+		}
+		numLines++
+	}
+	wtest.Must(t, scanner.Err())
+	assert.True(t, numLines > 3)
+}
+
+func Test_InspectBsdiffPatch(t *testing.T) {
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "a", Size: 10, Mode: 0644}, {Path: "b", Size: 0, Mode: 0644}},
+		Size:  10,
+	}
+
+	buf := new(bytes.Buffer)
+	wctx := wire.NewWriteContext(buf)
+	wtest.Must(t, wctx.WriteMagic(pwr.PatchMagic))
+	wtest.Must(t, wctx.WriteMessage(&pwr.PatchHeader{
+		Compression: &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE},
+	}))
+	wtest.Must(t, wctx.WriteMessage(container))
+	wtest.Must(t, wctx.WriteMessage(container))
+	wtest.Must(t, wctx.WriteMessage(&pwr.SyncHeader{FileIndex: 0, Type: pwr.SyncHeader_BSDIFF}))
+	wtest.Must(t, wctx.WriteMessage(&pwr.BsdiffHeader{TargetIndex: 0}))
+	wtest.Must(t, wctx.WriteMessage(&bsdiff.Control{Add: make([]byte, 10)}))
+	wtest.Must(t, wctx.WriteMessage(&bsdiff.Control{Eof: true}))
+	wtest.Must(t, wctx.WriteMessage(&pwr.SyncOp{Type: pwr.SyncOp_HEY_YOU_DID_IT}))
+	wtest.Must(t, wctx.WriteMessage(&pwr.SyncHeader{FileIndex: 1, Type: pwr.SyncHeader_RSYNC}))
+	wtest.Must(t, wctx.WriteMessage(&pwr.SyncOp{Type: pwr.SyncOp_HEY_YOU_DID_IT}))
+
+	var kinds []inspect.Kind
+	r := open(t, buf.Bytes())
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		wtest.Must(t, err)
+		kinds = append(kinds, msg.Kind)
+	}
+
+	assert.Equal(t, []inspect.Kind{
+		inspect.KindTargetContainer,
+		inspect.KindSourceContainer,
+		inspect.KindSyncHeader,
+		inspect.KindBsdiffHeader,
+		inspect.KindBsdiffControl,
+		inspect.KindBsdiffControl,
+		inspect.KindSyncOp,
+		inspect.KindSyncHeader,
+		inspect.KindSyncOp,
+	}, kinds)
+}
+
+func Test_InspectManifestAndWounds(t *testing.T) {
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "a", Size: blockpool.BigBlockSize + 1, Mode: 0644}, {Path: "b", Mode: 0644}},
+		Size:  blockpool.BigBlockSize + 1,
+	}
+
+	blockHashes := blockpool.NewBlockHashMap()
+	blockHashes.Set(blockpool.BlockLocation{FileIndex: 0, BlockIndex: 0}, make([]byte, 32))
+	blockHashes.Set(blockpool.BlockLocation{FileIndex: 0, BlockIndex: 1}, make([]byte, 32))
+
+	manifestBuffer := new(bytes.Buffer)
+	compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+	wtest.Must(t, blockpool.WriteManifest(manifestBuffer, compression, container, blockHashes))
+
+	r := open(t, manifestBuffer.Bytes())
+	assert.Equal(t, inspect.FileTypeManifest, r.Type)
+	kinds := countKinds(t, r)
+	assert.Equal(t, 1, kinds[inspect.KindContainer])
+	assert.Equal(t, 2, kinds[inspect.KindSyncHeader])
+	assert.Equal(t, 2, kinds[inspect.KindManifestBlockHash])
+
+	dir, err := ioutil.TempDir("", "inspect")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	woundsPath := filepath.Join(dir, "wounds.pww")
+	wounds := make(chan *pwr.Wound, 2)
+	wounds <- &pwr.Wound{Index: 0, Start: 0, End: 10, Kind: pwr.WoundKind_FILE}
+	wounds <- &pwr.Wound{Index: 1, Start: 0, End: 0, Kind: pwr.WoundKind_CLOSED_FILE}
+	close(wounds)
+	wtest.Must(t, (&pwr.WoundsWriter{WoundsPath: woundsPath}).Do(container, wounds))
+
+	woundsBytes, err := ioutil.ReadFile(woundsPath)
+	wtest.Must(t, err)
+
+	r = open(t, woundsBytes)
+	assert.Equal(t, inspect.FileTypeWounds, r.Type)
+	assert.Nil(t, r.Compression)
+	kinds = countKinds(t, r)
+	assert.Equal(t, 1, kinds[inspect.KindContainer])
+	assert.Equal(t, 1, kinds[inspect.KindWound])
+}
+
+func Test_InspectZipIndex(t *testing.T) {
+	index := &zipindex.Index{
+		Entries: map[string]*zipindex.Entry{
+			"big": {
+				Path:             "big",
+				CompressedSize:   1024,
+				UncompressedSize: 4096,
+				Checkpoints: []*pwr.ZipIndexCheckpoint{
+					{Offset: 1024, BitOffset: 2051, Window: make([]byte, 1024)},
+					{Offset: 2048, BitOffset: 4099, Window: make([]byte, 2048)},
+				},
+			},
+			"small": {
+				Path:             "small",
+				CompressedSize:   10,
+				UncompressedSize: 20,
+			},
+		},
+	}
+
+	indexBuffer := new(bytes.Buffer)
+	compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+	wtest.Must(t, zipindex.WriteIndex(indexBuffer, compression, index))
+
+	r := open(t, indexBuffer.Bytes())
+	assert.Equal(t, inspect.FileTypeZipIndex, r.Type)
+	assert.EqualValues(t, pwr.CompressionAlgorithm_NONE, r.Compression.Algorithm)
+	kinds := countKinds(t, r)
+	assert.Equal(t, 2, kinds[inspect.KindZipIndexEntry])
+	assert.Equal(t, 2, kinds[inspect.KindZipIndexCheckpoint])
+	assert.Empty(t, r.Containers)
+
+	t.Logf("Refusing truncated indices")
+	r = open(t, indexBuffer.Bytes()[:indexBuffer.Len()-10])
+	for {
+		_, err := r.Next()
+		if err != nil {
+			assert.NotEqual(t, io.EOF, err)
+			break
+		}
+	}
+}
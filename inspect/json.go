@@ -0,0 +1,70 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+type jsonHeader struct {
+	Type   string          `json:"type"`
+	Header json.RawMessage `json:"header"`
+}
+
+type jsonMessage struct {
+	Kind  Kind            `json:"kind"`
+	Value json.RawMessage `json:"value"`
+}
+
+var jsonMarshaler = &jsonpb.Marshaler{OrigName: true}
+
+func marshalJSON(msg proto.Message) (json.RawMessage, error) {
+	buf := new(bytes.Buffer)
+	err := jsonMarshaler.Marshal(buf, msg)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+// WriteJSONLines writes everything left in a Reader as JSON lines: first
+// a line with the file's type and header, like {"type":"patch","header":{...}},
+// then a line per message, like {"kind":"sync-op","value":{...}}.
+// Byte fields are encoded in base64.
+func WriteJSONLines(r *Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	header, err := marshalJSON(r.Header)
+	if err != nil {
+		return err
+	}
+
+	err = enc.Encode(&jsonHeader{Type: r.Type.String(), Header: header})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	for {
+		msg, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		value, err := marshalJSON(msg.Value)
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(&jsonMessage{Kind: msg.Kind, Value: value})
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+}
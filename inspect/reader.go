@@ -0,0 +1,335 @@
+package inspect
+
+import (
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/pools/blockpool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+)
+
+// Kind identifies what a message is, since several formats share
+// message types (containers, sync headers)
+type Kind string
+
+const (
+	// KindContainer is the only container of signatures, manifests and wounds files
+	KindContainer Kind = "container"
+	// KindTargetContainer is the first container of a patch, describing the old version
+	KindTargetContainer Kind = "target-container"
+	// KindSourceContainer is the second container of a patch, describing the new version
+	KindSourceContainer Kind = "source-container"
+	// KindSyncHeader starts the series of ops (patches) or hashes (manifests) of a file
+	KindSyncHeader Kind = "sync-header"
+	// KindSyncOp is an rsync op, or the end of a series in patches
+	KindSyncOp Kind = "sync-op"
+	// KindBsdiffHeader follows the sync header of a bsdiff series
+	KindBsdiffHeader Kind = "bsdiff-header"
+	// KindBsdiffControl is one of the controls of a bsdiff series
+	KindBsdiffControl Kind = "bsdiff-control"
+	// KindBlockHash is a block's hash in a signature
+	KindBlockHash Kind = "block-hash"
+	// KindManifestBlockHash is a block's hash in a manifest
+	KindManifestBlockHash Kind = "manifest-block-hash"
+	// KindWound is a wound in a wounds file
+	KindWound Kind = "wound"
+	// KindZipIndexEntry starts the checkpoints of an entry in a zip index
+	KindZipIndexEntry Kind = "zip-index-entry"
+	// KindZipIndexCheckpoint is one of the checkpoints of an entry in a zip index
+	KindZipIndexCheckpoint Kind = "zip-index-checkpoint"
+)
+
+// Message is one of the messages following a file's header
+type Message struct {
+	Kind Kind
+	// Value is the decoded message, e.g. a *tlc.Container, a *pwr.SyncOp
+	// or a *bsdiff.Control
+	Value proto.Message
+}
+
+// Reader decodes any wharf file, one message at a time, without
+// having to know in advance what type of file it is.
+type Reader struct {
+	// Type is the type of file being read
+	Type FileType
+	// Header is the file's header, e.g. a *pwr.PatchHeader
+	Header proto.Message
+	// Compression is the compression of everything after the header,
+	// nil for wounds files, which are never compressed
+	Compression *pwr.CompressionSettings
+
+	// Containers holds the containers read so far: the target then the
+	// source container for patches, nothing for zip indices, the only
+	// container for other types
+	Containers []*tlc.Container
+
+	rctx   *wire.ReadContext
+	limits pwr.WireLimits
+	err    error
+
+	fileIndex  int
+	series     *pwr.SyncHeader
+	stage      seriesStage
+	blocksLeft int64
+
+	checkpointsLeft int64
+}
+
+type seriesStage int
+
+const (
+	stageOps seriesStage = iota
+	stageBsdiffHeader
+	stageBsdiffControls
+	stageBsdiffEnd
+)
+
+// NewReader reads the magic number and header of a wharf file, and
+// returns a reader for the rest of its messages.
+func NewReader(source savior.SeekSource) (*Reader, error) {
+	rawWire := wire.NewReadContext(source)
+	magic, err := rawWire.ReadMagic()
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	r := &Reader{Type: FromMagic(magic)}
+
+	switch r.Type {
+	case FileTypePatch:
+		r.Header, r.limits = &pwr.PatchHeader{}, pwr.PatchWireLimits
+	case FileTypeSignature:
+		r.Header, r.limits = &pwr.SignatureHeader{}, pwr.SignatureWireLimits
+	case FileTypeManifest:
+		r.Header, r.limits = &pwr.ManifestHeader{}, pwr.ManifestWireLimits
+	case FileTypeWounds:
+		r.Header, r.limits = &pwr.WoundsHeader{}, pwr.WoundsWireLimits
+	case FileTypeZipIndex:
+		r.Header, r.limits = &pwr.ZipIndexHeader{}, pwr.ZipIndexWireLimits
+	default:
+		return nil, errors.Wrap(&ErrUnknownMagic{Magic: magic}, 0)
+	}
+
+	rawWire.SetLimits(r.limits.ContainerLimits())
+	err = rawWire.ReadMessage(r.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	switch header := r.Header.(type) {
+	case *pwr.PatchHeader:
+		r.Compression = header.Compression
+	case *pwr.SignatureHeader:
+		r.Compression = header.Compression
+	case *pwr.ManifestHeader:
+		r.Compression = header.Compression
+	case *pwr.ZipIndexHeader:
+		r.Compression = header.Compression
+	}
+
+	r.rctx = rawWire
+	if r.Type != FileTypeWounds {
+		r.rctx, err = pwr.DecompressWire(rawWire, r.Compression)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+
+	if r.numContainers() == 0 {
+		r.rctx.SetLimits(r.limits.BodyLimits())
+	}
+
+	return r, nil
+}
+
+// Next returns the next message in the file, or io.EOF once the whole
+// file has been read. Any other error is returned for all subsequent calls.
+func (r *Reader) Next() (*Message, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	msg, err := r.next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.EOF
+		}
+		r.err = err
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (r *Reader) numContainers() int {
+	switch r.Type {
+	case FileTypePatch:
+		return 2
+	case FileTypeZipIndex:
+		return 0
+	}
+	return 1
+}
+
+func (r *Reader) next() (*Message, error) {
+	if len(r.Containers) < r.numContainers() {
+		return r.nextContainer()
+	}
+
+	switch r.Type {
+	case FileTypePatch:
+		return r.nextPatchMessage()
+	case FileTypeManifest:
+		return r.nextManifestMessage()
+	case FileTypeSignature:
+		return r.read(KindBlockHash, &pwr.BlockHash{})
+	case FileTypeWounds:
+		return r.read(KindWound, &pwr.Wound{})
+	case FileTypeZipIndex:
+		return r.nextZipIndexMessage()
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) read(kind Kind, value proto.Message) (*Message, error) {
+	err := r.rctx.ReadMessage(value)
+	if err != nil {
+		if errors.Is(err, io.EOF) && !r.mayEnd() {
+			return nil, errors.Wrap(io.ErrUnexpectedEOF, 0)
+		}
+		return nil, err
+	}
+	return &Message{Kind: kind, Value: value}, nil
+}
+
+// mayEnd returns true if the file may end before the next message:
+// signatures, wounds files and zip indices don't say how many messages
+// they hold (zip indices do say how many checkpoints an entry has)
+func (r *Reader) mayEnd() bool {
+	if len(r.Containers) < r.numContainers() {
+		return false
+	}
+	if r.Type == FileTypeZipIndex {
+		return r.checkpointsLeft == 0
+	}
+	return r.Type == FileTypeSignature || r.Type == FileTypeWounds
+}
+
+func (r *Reader) nextContainer() (*Message, error) {
+	kind := KindContainer
+	if r.Type == FileTypePatch {
+		kind = KindTargetContainer
+		if len(r.Containers) == 1 {
+			kind = KindSourceContainer
+		}
+	}
+
+	container := &tlc.Container{}
+	msg, err := r.read(kind, container)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Containers = append(r.Containers, container)
+	if len(r.Containers) == r.numContainers() {
+		r.rctx.SetLimits(r.limits.BodyLimits())
+		r.blocksLeft = -1
+	}
+	return msg, nil
+}
+
+// patches have one series per file of the source container, see ApplyContext
+func (r *Reader) nextPatchMessage() (*Message, error) {
+	if r.series == nil {
+		if r.fileIndex >= len(r.Containers[1].Files) {
+			return nil, io.EOF
+		}
+
+		sh := &pwr.SyncHeader{}
+		msg, err := r.read(KindSyncHeader, sh)
+		if err != nil {
+			return nil, err
+		}
+
+		r.series = sh
+		r.stage = stageOps
+		if sh.Type == pwr.SyncHeader_BSDIFF {
+			r.stage = stageBsdiffHeader
+		}
+		return msg, nil
+	}
+
+	switch r.stage {
+	case stageBsdiffHeader:
+		r.stage = stageBsdiffControls
+		return r.read(KindBsdiffHeader, &pwr.BsdiffHeader{})
+	case stageBsdiffControls:
+		ctrl := &bsdiff.Control{}
+		msg, err := r.read(KindBsdiffControl, ctrl)
+		if err != nil {
+			return nil, err
+		}
+		if ctrl.Eof {
+			r.stage = stageBsdiffEnd
+		}
+		return msg, nil
+	}
+
+	op := &pwr.SyncOp{}
+	msg, err := r.read(KindSyncOp, op)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.stage == stageBsdiffEnd || op.Type == pwr.SyncOp_HEY_YOU_DID_IT {
+		r.series = nil
+		r.fileIndex++
+	}
+	return msg, nil
+}
+
+// manifests have a sync header followed by one hash per block, for each file
+func (r *Reader) nextManifestMessage() (*Message, error) {
+	if r.blocksLeft == 0 {
+		r.fileIndex++
+		r.blocksLeft = -1
+	}
+
+	files := r.Containers[0].Files
+	if r.fileIndex >= len(files) {
+		return nil, io.EOF
+	}
+
+	if r.blocksLeft < 0 {
+		msg, err := r.read(KindSyncHeader, &pwr.SyncHeader{})
+		if err != nil {
+			return nil, err
+		}
+		r.blocksLeft = blockpool.ComputeNumBlocks(files[r.fileIndex].Size)
+		return msg, nil
+	}
+
+	r.blocksLeft--
+	return r.read(KindManifestBlockHash, &pwr.ManifestBlockHash{})
+}
+
+// zip indices have an entry message followed by its checkpoints, for each
+// deflated entry of the zip
+func (r *Reader) nextZipIndexMessage() (*Message, error) {
+	if r.checkpointsLeft > 0 {
+		r.checkpointsLeft--
+		return r.read(KindZipIndexCheckpoint, &pwr.ZipIndexCheckpoint{})
+	}
+
+	entry := &pwr.ZipIndexEntry{}
+	msg, err := r.read(KindZipIndexEntry, entry)
+	if err != nil {
+		return nil, err
+	}
+	r.checkpointsLeft = entry.NumCheckpoints
+	return msg, nil
+}
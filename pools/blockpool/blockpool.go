@@ -18,7 +18,57 @@ type BlockPool struct {
 	Downstream Sink
 	Consumer   *state.Consumer
 
-	reader *Reader
+	// ReadAhead is how many blocks past the one being read are fetched
+	// concurrently, through clones of Upstream. 0 disables read-ahead.
+	ReadAhead int
+	// ReadAheadMaxBytes bounds how much memory prefetched blocks may use.
+	// It defaults to ReadAhead big blocks, and at least one block is always
+	// prefetched when ReadAhead is set.
+	ReadAheadMaxBytes int64
+
+	// DedupBatchSize, if non-zero and Downstream is a HasSink, makes writers
+	// hash blocks and ask Downstream which ones it already has, DedupBatchSize
+	// blocks at a time, so that those aren't stored again.
+	DedupBatchSize int
+
+	reader         *Reader
+	readAheadStats ReadAheadStats
+	dedupStats     DedupStats
+	// addresses of blocks stored (or skipped) by this pool's writers
+	knownAddresses map[string]bool
+}
+
+// DedupStats tells how much storing was avoided by checking for existing blocks
+type DedupStats struct {
+	StoredBlocks  int64
+	StoredBytes   int64
+	SkippedBlocks int64
+	SkippedBytes  int64
+	// Lookups counts calls to HasSink.Has
+	Lookups int64
+}
+
+// DedupStats returns deduplication statistics for all writers of this pool
+// so far. It must not be called concurrently with writes.
+func (np *BlockPool) DedupStats() DedupStats {
+	return np.dedupStats
+}
+
+// ReadAheadStats tells how useful read-ahead was
+type ReadAheadStats struct {
+	// Hits counts blocks that were prefetched by the time they were read
+	Hits int64
+	// Misses counts blocks that were fetched synchronously, because they
+	// weren't prefetched, or their prefetch failed
+	Misses int64
+	// Cancelled counts prefetches that were thrown away after a seek
+	Cancelled int64
+}
+
+// ReadAheadStats returns read-ahead statistics for all readers of this pool
+// so far. It must not be called concurrently with reads.
+func (np *BlockPool) ReadAheadStats() ReadAheadStats {
+	return np.readAheadStats
 }
 
 var _ wsync.Pool = (*BlockPool)(nil)
@@ -62,6 +112,7 @@ func (np *BlockPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
 		blockIndex: -1,
 		blockBuf:   make([]byte, BigBlockSize),
 	}
+	np.reader.setupReadAhead()
 	return np.reader, nil
 }
 
@@ -78,6 +129,12 @@ func (np *BlockPool) GetWriter(fileIndex int64) (io.WriteCloser, error) {
 		size:     np.Container.Files[fileIndex].Size,
 		blockBuf: make([]byte, BigBlockSize),
 	}
+
+	if np.DedupBatchSize > 0 {
+		if hasSink, ok := np.Downstream.(HasSink); ok {
+			npw.hasSink = hasSink
+		}
+	}
 	return npw, nil
 }
 
@@ -0,0 +1,184 @@
+package blockpool
+
+import (
+	"container/list"
+	osync "sync"
+
+	"github.com/itchio/wharf/tlc"
+)
+
+// A BlockCache keeps recently-fetched blocks in memory, up to a number of
+// bytes, evicting the least recently used ones first. It's safe for
+// concurrent use, so it can be shared by several CachingSources.
+type BlockCache struct {
+	mutex osync.Mutex
+
+	maxBytes  int64
+	usedBytes int64
+	lru       *list.List
+	entries   map[cacheKey]*list.Element
+	stats     BlockCacheStats
+}
+
+// BlockCacheStats tells how useful a BlockCache has been
+type BlockCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// UsedBytes is how much memory cached blocks use right now
+	UsedBytes int64
+}
+
+// blocks are cached by address when known, so that identical blocks
+// are only stored once, and by location otherwise
+type cacheKey struct {
+	addr string
+	loc  BlockLocation
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// NewBlockCache returns an empty cache holding at most maxBytes of blocks
+func NewBlockCache(maxBytes int64) *BlockCache {
+	return &BlockCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+// Stats returns the cache's statistics so far
+func (bc *BlockCache) Stats() BlockCacheStats {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	stats := bc.stats
+	stats.UsedBytes = bc.usedBytes
+	return stats
+}
+
+// get copies a cached block into data
+func (bc *BlockCache) get(key cacheKey, data []byte) (int, bool) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	el, ok := bc.entries[key]
+	if !ok {
+		bc.stats.Misses++
+		return 0, false
+	}
+
+	bc.stats.Hits++
+	bc.lru.MoveToFront(el)
+	return copy(data, el.Value.(*cacheEntry).data), true
+}
+
+// put stores a copy of data, evicting older blocks if needed
+func (bc *BlockCache) put(key cacheKey, data []byte) {
+	size := int64(len(data))
+	if size > bc.maxBytes {
+		return
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if el, ok := bc.entries[key]; ok {
+		bc.remove(el)
+	}
+
+	for bc.usedBytes+size > bc.maxBytes {
+		bc.remove(bc.lru.Back())
+		bc.stats.Evictions++
+	}
+
+	entry := &cacheEntry{
+		key:  key,
+		data: append([]byte{}, data...),
+	}
+	bc.entries[key] = bc.lru.PushFront(entry)
+	bc.usedBytes += size
+}
+
+// remove drops an entry. The mutex must be held.
+func (bc *BlockCache) remove(el *list.Element) {
+	entry := bc.lru.Remove(el).(*cacheEntry)
+	delete(bc.entries, entry.key)
+	bc.usedBytes -= int64(len(entry.data))
+}
+
+// A CachingSource serves blocks from a BlockCache when it can, and fetches
+// them from the underlying source otherwise. If BlockAddresses is set,
+// blocks are cached by address, so duplicate blocks (in different files,
+// or different builds) are only fetched once.
+type CachingSource struct {
+	// required
+	Source Source
+	Cache  *BlockCache
+
+	// optional
+	BlockAddresses BlockAddressMap
+}
+
+var _ RefetchingSource = (*CachingSource)(nil)
+
+// Clone returns a caching source for a clone of the underlying source,
+// sharing the same cache
+func (cs *CachingSource) Clone() Source {
+	return &CachingSource{
+		Source:         cs.Source.Clone(),
+		Cache:          cs.Cache,
+		BlockAddresses: cs.BlockAddresses,
+	}
+}
+
+// Fetch returns a cached block, or fetches and caches it
+func (cs *CachingSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	key := cs.key(loc)
+	if readBytes, ok := cs.Cache.get(key, data); ok {
+		return readBytes, nil
+	}
+
+	readBytes, err := cs.Source.Fetch(loc, data)
+	if err != nil {
+		return 0, err
+	}
+
+	cs.Cache.put(key, data[:readBytes])
+	return readBytes, nil
+}
+
+// Refetch bypasses the cache (the cached copy might be what's corrupted),
+// and replaces the cached copy with what the underlying source returns.
+func (cs *CachingSource) Refetch(loc BlockLocation, data []byte) (int, error) {
+	var readBytes int
+	var err error
+	if rs, ok := cs.Source.(RefetchingSource); ok {
+		readBytes, err = rs.Refetch(loc, data)
+	} else {
+		readBytes, err = cs.Source.Fetch(loc, data)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cs.Cache.put(cs.key(loc), data[:readBytes])
+	return readBytes, nil
+}
+
+func (cs *CachingSource) key(loc BlockLocation) cacheKey {
+	if cs.BlockAddresses != nil {
+		if addr := cs.BlockAddresses.Get(loc); addr != "" {
+			return cacheKey{addr: addr}
+		}
+	}
+	return cacheKey{loc: loc}
+}
+
+// GetContainer returns the underlying source's container
+func (cs *CachingSource) GetContainer() *tlc.Container {
+	return cs.Source.GetContainer()
+}
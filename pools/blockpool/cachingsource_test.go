@@ -0,0 +1,134 @@
+package blockpool
+
+import (
+	"bytes"
+	"fmt"
+	osync "sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CachingSource(t *testing.T) {
+	const blockSize = 1024
+
+	// "a" and "b" have the same contents, "c" is different
+	a := bytes.Repeat([]byte{0x1}, int(BigBlockSize)+blockSize)
+	c := bytes.Repeat([]byte{0x2}, blockSize)
+	container := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "a", Size: int64(len(a)), Mode: 0644},
+			{Path: "b", Size: int64(len(a)), Mode: 0644},
+			{Path: "c", Size: int64(len(c)), Mode: 0644},
+		},
+	}
+
+	var fetches int64
+	source := &memorySource{
+		container: container,
+		files:     [][]byte{a, a, c},
+		fetches:   &fetches,
+	}
+
+	blockAddresses := make(BlockAddressMap)
+	hasher := &blockHasher{}
+	for fileIndex, f := range container.Files {
+		data := source.files[fileIndex]
+		for blockIndex := int64(0); blockIndex < ComputeNumBlocks(f.Size); blockIndex++ {
+			block := data[blockIndex*BigBlockSize : blockIndex*BigBlockSize+ComputeBlockSize(f.Size, blockIndex)]
+			blockAddresses.Set(BlockLocation{FileIndex: int64(fileIndex), BlockIndex: blockIndex}, hasher.address(block))
+		}
+	}
+
+	fetchAll := func(s Source, fileIndex int64) {
+		f := container.Files[fileIndex]
+		buf := make([]byte, BigBlockSize)
+		for blockIndex := int64(0); blockIndex < ComputeNumBlocks(f.Size); blockIndex++ {
+			size := ComputeBlockSize(f.Size, blockIndex)
+			readBytes, err := s.Fetch(BlockLocation{FileIndex: fileIndex, BlockIndex: blockIndex}, buf[:size])
+			wtest.Must(t, err)
+			expected := source.files[fileIndex][blockIndex*BigBlockSize:][:size]
+			assert.True(t, bytes.Equal(expected, buf[:readBytes]), "block %d of %s", blockIndex, f.Path)
+		}
+	}
+
+	t.Logf("Duplicate blocks are served from memory")
+	cache := NewBlockCache(BigBlockSize * 2)
+	cs := &CachingSource{
+		Source:         source,
+		Cache:          cache,
+		BlockAddresses: blockAddresses,
+	}
+	fetchAll(cs, 0)
+	fetchAll(cs.Clone(), 1)
+	assert.EqualValues(t, 2, atomic.LoadInt64(&fetches))
+	stats := cache.Stats()
+	assert.EqualValues(t, 2, stats.Hits)
+	assert.EqualValues(t, 2, stats.Misses)
+	assert.EqualValues(t, BigBlockSize+blockSize, stats.UsedBytes)
+
+	t.Logf("Without addresses, blocks are cached by location")
+	atomic.StoreInt64(&fetches, 0)
+	byLocation := &CachingSource{
+		Source: source,
+		Cache:  NewBlockCache(BigBlockSize * 3),
+	}
+	fetchAll(byLocation, 0)
+	fetchAll(byLocation, 1)
+	fetchAll(byLocation, 0)
+	assert.EqualValues(t, 4, atomic.LoadInt64(&fetches))
+
+	t.Logf("Least recently used blocks are evicted")
+	atomic.StoreInt64(&fetches, 0)
+	small := NewBlockCache(blockSize * 2)
+	cs = &CachingSource{
+		Source:         source,
+		Cache:          small,
+		BlockAddresses: blockAddresses,
+	}
+	buf := make([]byte, blockSize)
+	aTail := BlockLocation{FileIndex: 0, BlockIndex: 1}
+	cTail := BlockLocation{FileIndex: 2, BlockIndex: 0}
+	for _, loc := range []BlockLocation{aTail, cTail, aTail, cTail} {
+		_, err := cs.Fetch(loc, buf)
+		wtest.Must(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt64(&fetches))
+
+	_, err := cs.Fetch(BlockLocation{FileIndex: 0, BlockIndex: 0}, make([]byte, BigBlockSize))
+	wtest.Must(t, err)
+	assert.EqualValues(t, 0, small.Stats().Evictions, "blocks bigger than the cache aren't cached")
+
+	small.put(cacheKey{addr: "other"}, buf)
+	assert.EqualValues(t, 1, small.Stats().Evictions)
+	_, err = cs.Fetch(aTail, buf)
+	wtest.Must(t, err)
+	assert.EqualValues(t, 4, atomic.LoadInt64(&fetches), "aTail was the least recently used")
+
+	t.Logf("Refetching bypasses the cache")
+	_, err = cs.Refetch(aTail, buf)
+	wtest.Must(t, err)
+	assert.EqualValues(t, 5, atomic.LoadInt64(&fetches))
+
+	t.Logf("Clones share the cache safely")
+	shared := NewBlockCache(BigBlockSize * 4)
+	cs = &CachingSource{
+		Source:         source,
+		Cache:          shared,
+		BlockAddresses: blockAddresses,
+	}
+	var wg osync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(clone Source, fileIndex int64) {
+			defer wg.Done()
+			fetchAll(clone, fileIndex)
+		}(cs.Clone(), int64(i%3))
+	}
+	wg.Wait()
+	stats = shared.Stats()
+	assert.EqualValues(t, 14, stats.Hits+stats.Misses, fmt.Sprintf("%+v", stats))
+}
@@ -0,0 +1,138 @@
+package blockpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSink counts blocks that actually reach the underlying sink
+type countingSink struct {
+	*DiskSink
+	stores *int64
+}
+
+var _ HasSink = (*countingSink)(nil)
+
+func (cs *countingSink) Store(loc BlockLocation, data []byte) error {
+	atomic.AddInt64(cs.stores, 1)
+	return cs.DiskSink.Store(loc, data)
+}
+
+func (cs *countingSink) Clone() Sink {
+	return &countingSink{
+		DiskSink: cs.DiskSink.Clone().(*DiskSink),
+		stores:   cs.stores,
+	}
+}
+
+func Test_Dedup(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "dedup")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	consumer := &state.Consumer{}
+
+	makeBuild := func(name string, entries []wtest.TestDirEntry) (string, *tlc.Container) {
+		dir := filepath.Join(mainDir, name)
+		wtest.MakeTestDir(t, dir, wtest.TestDirSettings{Entries: entries})
+		container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+		wtest.Must(t, err)
+		return dir, container
+	}
+
+	v1, v1Container := makeBuild("v1", []wtest.TestDirEntry{
+		{Path: "big", Seed: 0x1, Size: BigBlockSize*2 + 14},
+		{Path: "big-copy", Seed: 0x1, Size: BigBlockSize*2 + 14},
+		{Path: "small", Seed: 0x2},
+	})
+	v2, v2Container := makeBuild("v2", []wtest.TestDirEntry{
+		{Path: "big", Seed: 0x1, Size: BigBlockSize*2 + 14},
+		{Path: "small", Seed: 0x3},
+	})
+
+	for _, fanOut := range []bool{false, true} {
+		storePath := filepath.Join(mainDir, "store")
+		wtest.Must(t, os.RemoveAll(storePath))
+
+		var stores int64
+		push := func(dir string, container *tlc.Container) (*BlockHashMap, DedupStats) {
+			blockHashes := NewBlockHashMap()
+			var sink Sink = &countingSink{
+				DiskSink: &DiskSink{
+					BasePath:    storePath,
+					Container:   container,
+					BlockHashes: blockHashes,
+				},
+				stores: &stores,
+			}
+
+			var fos *FanOutSink
+			if fanOut {
+				fos, err = NewFanOutSink(sink, 2)
+				wtest.Must(t, err)
+				fos.Start()
+				sink = fos
+			}
+
+			outPool := &BlockPool{
+				Container:      container,
+				Downstream:     sink,
+				DedupBatchSize: 2,
+			}
+			wtest.Must(t, pwr.CopyContainer(container, outPool, fspool.New(container, dir), consumer))
+			if fos != nil {
+				wtest.Must(t, fos.Close())
+			}
+			return blockHashes, outPool.DedupStats()
+		}
+
+		checkManifest := func(container *tlc.Container, blockHashes *BlockHashMap) {
+			manifest := new(bytes.Buffer)
+			compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+			wtest.Must(t, WriteManifest(manifest, compression, container, blockHashes))
+
+			_, readHashes, err := readManifestBytes(manifest.Bytes())
+			wtest.Must(t, err)
+			blockAddresses, err := readHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+			wtest.Must(t, err)
+
+			inPool := &BlockPool{
+				Container: container,
+				Upstream:  &DiskSource{BasePath: storePath, BlockAddresses: blockAddresses, Container: container},
+			}
+			outPool := &BlockPool{
+				Container:  container,
+				Downstream: &LoggingSink{Container: container},
+			}
+			wtest.Must(t, pwr.CopyContainer(container, outPool, inPool, consumer))
+		}
+
+		t.Logf("Pushing v1 (fan-out: %v)", fanOut)
+		blockHashes, stats := push(v1, v1Container)
+		assert.EqualValues(t, 4, stats.StoredBlocks, "big-copy should be skipped")
+		assert.EqualValues(t, 3, stats.SkippedBlocks)
+		assert.EqualValues(t, BigBlockSize*2+14, stats.SkippedBytes)
+		assert.EqualValues(t, 4, atomic.LoadInt64(&stores))
+		checkManifest(v1Container, blockHashes)
+
+		t.Logf("Pushing v2")
+		atomic.StoreInt64(&stores, 0)
+		blockHashes, stats = push(v2, v2Container)
+		assert.EqualValues(t, 1, stats.StoredBlocks, "only v2's small should be stored")
+		assert.EqualValues(t, 3, stats.SkippedBlocks)
+		assert.True(t, stats.Lookups > 0)
+		assert.EqualValues(t, 1, atomic.LoadInt64(&stores))
+		checkManifest(v2Container, blockHashes)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/tlc"
@@ -93,11 +94,21 @@ func (ds *DiskSink) Store(loc BlockLocation, data []byte) error {
 
 	// create file only if it doesn't exist yet
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
-	if err != nil {
-		if os.IsExist(err) {
-			// block's already there!
+	for os.IsExist(err) {
+		// block's already there!
+		var has bool
+		has, err = touchBlock(path)
+		if err != nil {
+			return err
+		}
+		if has {
 			return nil
 		}
+
+		// ...but it was just swept, write it again
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
 		return errors.Wrap(err, 1)
 	}
 
@@ -118,6 +129,60 @@ func (ds *DiskSink) Store(loc BlockLocation, data []byte) error {
 	return nil
 }
 
+var _ HasSink = (*DiskSink)(nil)
+
+// Has checks whether blocks exist on disk
+func (ds *DiskSink) Has(addrs []string) ([]bool, error) {
+	has := make([]bool, len(addrs))
+	for i, addr := range addrs {
+		_, err := os.Stat(filepath.Join(ds.BasePath, addr))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, 1)
+		}
+		has[i] = true
+	}
+	return has, nil
+}
+
+// Skip records the hash of a block that's already on disk, and refreshes
+// it so a garbage collector doesn't sweep it before the manifest is written.
+func (ds *DiskSink) Skip(loc BlockLocation, hash []byte) error {
+	if ds.BlockHashes != nil {
+		ds.BlockHashes.Set(loc, append([]byte{}, hash...))
+	}
+
+	fileSize := ds.Container.Files[int(loc.FileIndex)].Size
+	blockSize := ComputeBlockSize(fileSize, loc.BlockIndex)
+	addr := fmt.Sprintf("shake128-32/%x/%d", hash, blockSize)
+
+	has, err := touchBlock(filepath.Join(ds.BasePath, addr))
+	if err != nil {
+		return err
+	}
+	if !has {
+		return errors.Wrap(fmt.Errorf("block %s was removed from the store", addr), 0)
+	}
+	return nil
+}
+
+// touchBlock refreshes the modification time of a block file, so that a
+// garbage collector considers it recent. It returns false if the block
+// doesn't exist (anymore).
+func touchBlock(path string) (bool, error) {
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, 1)
+	}
+	return true, nil
+}
+
 // GetContainer returns the container associated with this disk sink
 func (ds *DiskSink) GetContainer() *tlc.Container {
 	return ds.Container
@@ -140,6 +140,25 @@ func (fos *FanOutSink) Store(loc BlockLocation, data []byte) error {
 	return nil
 }
 
+var _ HasSink = (*FanOutSink)(nil)
+
+// Has asks the underlying sinks whether they have blocks. If they can't
+// tell, all blocks are reported as missing.
+func (fos *FanOutSink) Has(addrs []string) ([]bool, error) {
+	if hasSink, ok := fos.sinks[0].(HasSink); ok {
+		return hasSink.Has(addrs)
+	}
+	return make([]bool, len(addrs)), nil
+}
+
+// Skip lets the underlying sinks know about a block that was already stored
+func (fos *FanOutSink) Skip(loc BlockLocation, hash []byte) error {
+	if hasSink, ok := fos.sinks[0].(HasSink); ok {
+		return hasSink.Skip(loc, hash)
+	}
+	return nil
+}
+
 // GetContainer returns the container associated with this fan-in sink
 func (fos *FanOutSink) GetContainer() *tlc.Container {
 	return fos.sinks[0].GetContainer()
@@ -0,0 +1,258 @@
+package blockpool
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+)
+
+// A GarbageCollector removes blocks that aren't referenced by any live
+// manifest from a block store. Mark all live manifests first, then Sweep.
+type GarbageCollector struct {
+	// BasePath is the root of the store
+	BasePath string
+	// Packed is true for stores written by a PackSink, false for DiskSink
+	Packed bool
+
+	// GracePeriod protects blocks (or packs) younger than this, so that
+	// blocks uploaded by a build that doesn't have a manifest yet survive.
+	// Packs younger than this are never rewritten, so it must be longer
+	// than a PackSink may go without writing.
+	GracePeriod time.Duration
+	// DryRun only reports what would be swept
+	DryRun bool
+
+	Consumer *state.Consumer
+
+	live map[string]bool
+}
+
+// GCReport describes what a sweep did (or would do, for a dry run)
+type GCReport struct {
+	// LiveBlocks is the number of distinct addresses marked as live
+	LiveBlocks int64
+	// KeptBlocks is the number of blocks in the store that are referenced
+	KeptBlocks int64
+	// RecentBlocks is the number of unreferenced blocks kept because of the grace period
+	RecentBlocks int64
+
+	SweptBlocks int64
+	SweptBytes  int64
+	// Swept lists the addresses of swept blocks
+	Swept []string
+}
+
+// Mark records all the blocks of a container as live
+func (gc *GarbageCollector) Mark(container *tlc.Container, blockHashes *BlockHashMap) error {
+	blockAddresses, err := blockHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if gc.live == nil {
+		gc.live = make(map[string]bool)
+	}
+
+	for _, blocks := range blockAddresses {
+		for _, addr := range blocks {
+			gc.live[addr] = true
+		}
+	}
+	return nil
+}
+
+// MarkManifest reads a manifest and records all its blocks as live
+func (gc *GarbageCollector) MarkManifest(manifestReader savior.SeekSource) error {
+	container, blockHashes, err := ReadManifest(manifestReader)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return gc.Mark(container, blockHashes)
+}
+
+// Sweep removes all unreferenced blocks older than the grace period
+func (gc *GarbageCollector) Sweep() (*GCReport, error) {
+	report := &GCReport{
+		LiveBlocks: int64(len(gc.live)),
+	}
+
+	var err error
+	if gc.Packed {
+		err = gc.sweepPacks(report)
+	} else {
+		err = gc.sweepFiles(report)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	verb := "Swept"
+	if gc.DryRun {
+		verb = "Would sweep"
+	}
+	gc.logf("%s %d blocks (%d bytes), kept %d live and %d recent blocks",
+		verb, report.SweptBlocks, report.SweptBytes, report.KeptBlocks, report.RecentBlocks)
+
+	return report, nil
+}
+
+func (gc *GarbageCollector) isRecent(modTime time.Time) bool {
+	return time.Since(modTime) < gc.GracePeriod
+}
+
+// packIsRecent returns true if a pack was modified within the grace period,
+// or if it can't be stat'd, which happens when a PackSink has just created
+// its index.
+func (gc *GarbageCollector) packIsRecent(pack int64) bool {
+	stats, err := os.Stat(packPath(gc.BasePath, pack))
+	if err != nil {
+		return true
+	}
+	return gc.isRecent(stats.ModTime())
+}
+
+// sweepFiles sweeps a store written by a DiskSink, with one file per block
+func (gc *GarbageCollector) sweepFiles(report *GCReport) error {
+	algoPath := filepath.Join(gc.BasePath, "shake128-32")
+
+	var dirs []string
+	err := filepath.Walk(algoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == algoPath {
+				// empty store
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			if path != algoPath {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(gc.BasePath, path)
+		if err != nil {
+			return err
+		}
+		addr := filepath.ToSlash(rel)
+
+		if gc.live[addr] {
+			report.KeptBlocks++
+			return nil
+		}
+
+		if gc.isRecent(info.ModTime()) {
+			report.RecentBlocks++
+			return nil
+		}
+
+		report.SweptBlocks++
+		report.SweptBytes += info.Size()
+		report.Swept = append(report.Swept, addr)
+
+		if gc.DryRun {
+			return nil
+		}
+
+		gc.debugf("sweeping %s", addr)
+		return os.Remove(path)
+	})
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if gc.DryRun {
+		return nil
+	}
+
+	// remove hash directories that are now empty, deepest first. Walk
+	// lists directories before their children.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// fails (harmlessly) if not empty
+		os.Remove(dirs[i])
+	}
+
+	return nil
+}
+
+// sweepPacks sweeps a store written by a PackSink, by repacking it
+func (gc *GarbageCollector) sweepPacks(report *GCReport) error {
+	packs, err := listPacks(gc.BasePath)
+	if err != nil {
+		return err
+	}
+
+	// blocks in recent packs are kept, even if they're also in older packs
+	recent := make(map[string]bool)
+	entries := make(map[string]packEntry)
+	for _, pack := range packs {
+		packIsRecent := gc.packIsRecent(pack)
+
+		err = readPackIdx(gc.BasePath, pack, func(addr string, entry packEntry) {
+			if packIsRecent {
+				recent[addr] = true
+			}
+			if _, ok := entries[addr]; !ok {
+				entries[addr] = entry
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for addr, entry := range entries {
+		switch {
+		case gc.live[addr]:
+			report.KeptBlocks++
+		case recent[addr]:
+			report.RecentBlocks++
+		default:
+			report.SweptBlocks++
+			report.SweptBytes += entry.Length
+			report.Swept = append(report.Swept, addr)
+		}
+	}
+	sort.Strings(report.Swept)
+
+	if gc.DryRun || report.SweptBlocks == 0 {
+		return nil
+	}
+
+	// recent packs are left alone, a PackSink may still be appending to
+	// them. The blocks they hold survive with them.
+	stats, err := Repack(gc.BasePath, RepackOptions{
+		Keep: func(addr string) bool {
+			return gc.live[addr]
+		},
+		SkipPack: gc.packIsRecent,
+	})
+	if err != nil {
+		return err
+	}
+
+	gc.debugf("repacked %d packs into %d", stats.PacksBefore, stats.PacksAfter)
+	return nil
+}
+
+func (gc *GarbageCollector) logf(msg string, args ...interface{}) {
+	if gc.Consumer != nil {
+		gc.Consumer.Infof(msg, args...)
+	}
+}
+
+func (gc *GarbageCollector) debugf(msg string, args ...interface{}) {
+	if gc.Consumer != nil {
+		gc.Consumer.Debugf(msg, args...)
+	}
+}
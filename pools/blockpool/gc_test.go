@@ -0,0 +1,310 @@
+package blockpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// gcStore pushes test builds to a block store, and pulls them back
+type gcStore struct {
+	t        *testing.T
+	mainDir  string
+	path     string
+	packed   bool
+	consumer *state.Consumer
+}
+
+func newGCStore(t *testing.T, mainDir string, packed bool) *gcStore {
+	path := filepath.Join(mainDir, "store")
+	wtest.Must(t, os.RemoveAll(path))
+
+	return &gcStore{
+		t:        t,
+		mainDir:  mainDir,
+		path:     path,
+		packed:   packed,
+		consumer: &state.Consumer{},
+	}
+}
+
+func (gs *gcStore) makeBuild(name string, entries []wtest.TestDirEntry) (string, *tlc.Container) {
+	dir := filepath.Join(gs.mainDir, name)
+	wtest.MakeTestDir(gs.t, dir, wtest.TestDirSettings{Entries: entries})
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	wtest.Must(gs.t, err)
+	return dir, container
+}
+
+func (gs *gcStore) sink(container *tlc.Container, blockHashes *BlockHashMap) HasSink {
+	if gs.packed {
+		return &PackSink{BasePath: gs.path, Container: container, BlockHashes: blockHashes}
+	}
+	return &DiskSink{BasePath: gs.path, Container: container, BlockHashes: blockHashes}
+}
+
+// pushWith copies a build to sink, skipping blocks already in the store,
+// and returns its manifest
+func (gs *gcStore) pushWith(dir string, container *tlc.Container, sink HasSink, blockHashes *BlockHashMap) []byte {
+	outPool := &BlockPool{
+		Container:      container,
+		Downstream:     sink,
+		DedupBatchSize: 16,
+	}
+	wtest.Must(gs.t, pwr.CopyContainer(container, outPool, fspool.New(container, dir), gs.consumer))
+
+	if hs, ok := sink.(*hookSink); ok {
+		sink = hs.HasSink
+	}
+	if ps, ok := sink.(*PackSink); ok {
+		wtest.Must(gs.t, ps.Close())
+	}
+
+	manifest := new(bytes.Buffer)
+	compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+	wtest.Must(gs.t, WriteManifest(manifest, compression, container, blockHashes))
+	return manifest.Bytes()
+}
+
+func (gs *gcStore) push(dir string, container *tlc.Container) []byte {
+	blockHashes := NewBlockHashMap()
+	return gs.pushWith(dir, container, gs.sink(container, blockHashes), blockHashes)
+}
+
+// age makes everything in the store older than the grace period
+func (gs *gcStore) age() {
+	past := time.Now().Add(-2 * time.Hour)
+	wtest.Must(gs.t, filepath.Walk(gs.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, past, past)
+	}))
+}
+
+func (gs *gcStore) newGC(dryRun bool, liveManifests ...[]byte) *GarbageCollector {
+	gc := &GarbageCollector{
+		BasePath:    gs.path,
+		Packed:      gs.packed,
+		GracePeriod: time.Hour,
+		DryRun:      dryRun,
+		Consumer:    gs.consumer,
+	}
+
+	for _, manifest := range liveManifests {
+		manifestSource := seeksource.FromBytes(manifest)
+		_, err := manifestSource.Resume(nil)
+		wtest.Must(gs.t, err)
+		wtest.Must(gs.t, gc.MarkManifest(manifestSource))
+	}
+	return gc
+}
+
+func (gs *gcStore) pullable(container *tlc.Container, manifest []byte) error {
+	_, blockHashes, err := readManifestBytes(manifest)
+	wtest.Must(gs.t, err)
+	blockAddresses, err := blockHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+	wtest.Must(gs.t, err)
+
+	var source Source
+	if gs.packed {
+		source = &PackSource{BasePath: gs.path, BlockAddresses: blockAddresses, Container: container}
+	} else {
+		source = &DiskSource{BasePath: gs.path, BlockAddresses: blockAddresses, Container: container}
+	}
+	inPool := &BlockPool{
+		Container: container,
+		Upstream:  source,
+	}
+	return pwr.CopyContainer(container, &BlockPool{Container: container, Downstream: &LoggingSink{Container: container}}, inPool, gs.consumer)
+}
+
+// hookSink calls afterStore after each block it stores
+type hookSink struct {
+	HasSink
+	afterStore func()
+}
+
+func (hs *hookSink) Store(loc BlockLocation, data []byte) error {
+	err := hs.HasSink.Store(loc, data)
+	if err != nil {
+		return err
+	}
+	hs.afterStore()
+	return nil
+}
+
+func Test_GC(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "gc")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	for _, packed := range []bool{false, true} {
+		gs := newGCStore(t, mainDir, packed)
+
+		// v1 and v2 share "shared", v3 is being uploaded and has no manifest yet
+		v1, v1Container := gs.makeBuild("v1", []wtest.TestDirEntry{
+			{Path: "shared", Seed: 0x1},
+			{Path: "old", Seed: 0x2},
+			{Path: "old-2", Seed: 0x3},
+		})
+		v2, v2Container := gs.makeBuild("v2", []wtest.TestDirEntry{
+			{Path: "shared", Seed: 0x1},
+			{Path: "new", Seed: 0x4},
+		})
+		v3, v3Container := gs.makeBuild("v3", []wtest.TestDirEntry{
+			{Path: "in-flight", Seed: 0x5},
+		})
+
+		v1Manifest := gs.push(v1, v1Container)
+		gs.age()
+		v2Manifest := gs.push(v2, v2Container)
+		gs.age()
+		gs.push(v3, v3Container)
+
+		t.Logf("Dry run (packed: %v)", packed)
+		report, err := gs.newGC(true, v2Manifest).Sweep()
+		wtest.Must(t, err)
+		assert.EqualValues(t, 2, report.LiveBlocks)
+		assert.EqualValues(t, 2, report.KeptBlocks)
+		assert.EqualValues(t, 1, report.RecentBlocks)
+		assert.EqualValues(t, 2, report.SweptBlocks)
+		assert.EqualValues(t, 2, len(report.Swept))
+		assert.True(t, report.SweptBytes > 0)
+
+		assert.NoError(t, gs.pullable(v1Container, v1Manifest), "dry run shouldn't remove anything")
+
+		t.Logf("Sweeping")
+		report, err = gs.newGC(false, v2Manifest).Sweep()
+		wtest.Must(t, err)
+		assert.EqualValues(t, 2, report.SweptBlocks)
+
+		assert.NoError(t, gs.pullable(v2Container, v2Manifest))
+		assert.Error(t, gs.pullable(v1Container, v1Manifest))
+
+		report, err = gs.newGC(false, v2Manifest).Sweep()
+		wtest.Must(t, err)
+		assert.EqualValues(t, 0, report.SweptBlocks)
+		assert.EqualValues(t, 1, report.RecentBlocks, "in-flight block should survive")
+	}
+}
+
+func Test_GCConcurrentPackSink(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "gc-concurrent")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	gs := newGCStore(t, mainDir, true)
+
+	v1, v1Container := gs.makeBuild("v1", []wtest.TestDirEntry{
+		{Path: "shared", Seed: 0x1},
+		{Path: "old", Seed: 0x2},
+	})
+	v2, v2Container := gs.makeBuild("v2", []wtest.TestDirEntry{
+		{Path: "shared", Seed: 0x1},
+	})
+	v3, v3Container := gs.makeBuild("v3", []wtest.TestDirEntry{
+		{Path: "in-flight-1", Seed: 0x3},
+		{Path: "in-flight-2", Seed: 0x4},
+	})
+
+	v1Manifest := gs.push(v1, v1Container)
+	v2Manifest := gs.push(v2, v2Container)
+	gs.age()
+
+	// v3 is still being pushed when the GC runs: it must not lose the
+	// blocks it has appended to its open pack, nor trip on the packs
+	// written by the repack.
+	swept := false
+	blockHashes := NewBlockHashMap()
+	sink := &hookSink{
+		HasSink: &PackSink{
+			BasePath:    gs.path,
+			Container:   v3Container,
+			BlockHashes: blockHashes,
+			// one block per pack
+			MaxPackSize: 1,
+		},
+		afterStore: func() {
+			if swept {
+				return
+			}
+			swept = true
+
+			report, err := gs.newGC(false, v2Manifest).Sweep()
+			wtest.Must(t, err)
+			assert.EqualValues(t, 1, report.KeptBlocks)
+			assert.EqualValues(t, 1, report.RecentBlocks)
+			assert.EqualValues(t, 1, report.SweptBlocks)
+		},
+	}
+	v3Manifest := gs.pushWith(v3, v3Container, sink, blockHashes)
+	assert.True(t, swept)
+
+	assert.NoError(t, gs.pullable(v2Container, v2Manifest))
+	assert.NoError(t, gs.pullable(v3Container, v3Manifest))
+	assert.Error(t, gs.pullable(v1Container, v1Manifest))
+}
+
+func Test_GCDuringDedupPush(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "gc-dedup")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	for _, packed := range []bool{false, true} {
+		gs := newGCStore(t, mainDir, packed)
+
+		v1, v1Container := gs.makeBuild("v1", []wtest.TestDirEntry{
+			{Path: "a-shared", Seed: 0x1},
+			{Path: "old", Seed: 0x2},
+		})
+		v2, v2Container := gs.makeBuild("v2", []wtest.TestDirEntry{
+			{Path: "a-shared", Seed: 0x1},
+			{Path: "b-new", Seed: 0x3},
+		})
+
+		gs.push(v1, v1Container)
+		gs.age()
+
+		// v2 skips "a-shared", which is already in the store, then stores
+		// "b-new". The GC runs in between, before v2 has a manifest.
+		swept := false
+		blockHashes := NewBlockHashMap()
+		sink := &hookSink{
+			HasSink: gs.sink(v2Container, blockHashes),
+			afterStore: func() {
+				if swept {
+					return
+				}
+				swept = true
+
+				report, err := gs.newGC(false).Sweep()
+				wtest.Must(t, err)
+				assert.EqualValues(t, 0, report.KeptBlocks)
+				if packed {
+					// the whole pack was refreshed
+					assert.EqualValues(t, 3, report.RecentBlocks)
+					assert.EqualValues(t, 0, report.SweptBlocks)
+				} else {
+					assert.EqualValues(t, 2, report.RecentBlocks)
+					assert.EqualValues(t, 1, report.SweptBlocks)
+				}
+			},
+		}
+		v2Manifest := gs.pushWith(v2, v2Container, sink, blockHashes)
+		assert.True(t, swept)
+
+		assert.NoError(t, gs.pullable(v2Container, v2Manifest), "skipped block should survive (packed: %v)", packed)
+	}
+}
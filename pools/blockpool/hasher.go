@@ -0,0 +1,34 @@
+package blockpool
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// blockHasher computes shake128-32 hashes and addresses of blocks, the same
+// way DiskSink does. It's not safe for concurrent use.
+type blockHasher struct {
+	shake   sha3.ShakeHash
+	hashBuf []byte
+}
+
+// sum returns the hash of data. It's only valid until the next call.
+func (bh *blockHasher) sum(data []byte) []byte {
+	if bh.shake == nil {
+		bh.shake = sha3.NewShake128()
+		bh.hashBuf = make([]byte, 32)
+	}
+
+	bh.shake.Reset()
+	// ShakeHash's Write and Read never return errors
+	bh.shake.Write(data)
+	io.ReadFull(bh.shake, bh.hashBuf)
+	return bh.hashBuf
+}
+
+// address returns the address of a block, given its contents
+func (bh *blockHasher) address(data []byte) string {
+	return fmt.Sprintf("shake128-32/%x/%d", bh.sum(data), len(data))
+}
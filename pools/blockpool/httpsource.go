@@ -0,0 +1,293 @@
+package blockpool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	osync "sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+)
+
+const (
+	defaultHTTPMaxTries      = 5
+	defaultHTTPRetryDelay    = 250 * time.Millisecond
+	defaultHTTPMaxRetryDelay = 10 * time.Second
+)
+
+// HTTPSource fetches blocks over HTTP by their address, as stored by a
+// DiskSink and served by the server package, from one or several mirrors.
+// Every fetched block is checked against the hash in its address, and
+// failed fetches are retried with exponential backoff, on the next mirror.
+// It's hard-coded to use shake128-32 as a hashing algorithm.
+type HTTPSource struct {
+	// required
+
+	// BaseURLs lists the mirrors to fetch blocks from, so that blocks are
+	// at <BaseURL>/<address>. At least one is required.
+	BaseURLs       []string
+	BlockAddresses BlockAddressMap
+	Container      *tlc.Container
+
+	// optional
+
+	// Client is used for all requests, http.DefaultClient if nil
+	Client       *http.Client
+	Decompressor *Decompressor
+	Consumer     *state.Consumer
+
+	// MaxTries is how many times a block is fetched before giving up (default 5)
+	MaxTries int
+	// RetryDelay is how long to wait before the first retry (default 250ms), it's
+	// doubled after every failed try, up to MaxRetryDelay (default 10s)
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Parallelism is how many blocks FetchAll fetches concurrently (default 1)
+	Parallelism int
+
+	// internal
+	mirror   int
+	hasher   blockHasher
+	fetching bool
+}
+
+var _ Source = (*HTTPSource)(nil)
+
+// Clone returns a copy of this HTTP source, suitable for fan-in. Clones start
+// on different mirrors, to spread the load.
+func (hs *HTTPSource) Clone() Source {
+	hsc := &HTTPSource{
+		BaseURLs:       hs.BaseURLs,
+		BlockAddresses: hs.BlockAddresses,
+		Container:      hs.Container,
+
+		Client:   hs.Client,
+		Consumer: hs.Consumer,
+
+		MaxTries:      hs.MaxTries,
+		RetryDelay:    hs.RetryDelay,
+		MaxRetryDelay: hs.MaxRetryDelay,
+		Parallelism:   hs.Parallelism,
+
+		mirror: hs.mirror + 1,
+	}
+
+	if hs.Decompressor != nil {
+		hsc.Decompressor = hs.Decompressor.Clone()
+	}
+
+	return hsc
+}
+
+// Fetch downloads a block, retrying on errors and hash mismatches. It should
+// not be called concurrently: use clones, or FetchAll, for that.
+func (hs *HTTPSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	if hs.fetching {
+		return 0, errors.Wrap(fmt.Errorf("concurrent fetch from httpsource is unsupported"), 1)
+	}
+
+	hs.fetching = true
+	defer func() {
+		hs.fetching = false
+	}()
+
+	if len(hs.BaseURLs) == 0 {
+		return 0, errors.Wrap(fmt.Errorf("httpsource: no base URLs"), 1)
+	}
+
+	addr := hs.BlockAddresses.Get(loc)
+	if addr == "" {
+		return 0, errors.Wrap(fmt.Errorf("no address for block %+v", loc), 1)
+	}
+
+	hash, err := addressHash(addr)
+	if err != nil {
+		return 0, errors.Wrap(err, 1)
+	}
+
+	maxTries := hs.MaxTries
+	if maxTries <= 0 {
+		maxTries = defaultHTTPMaxTries
+	}
+
+	delay := hs.RetryDelay
+	if delay <= 0 {
+		delay = defaultHTTPRetryDelay
+	}
+
+	maxDelay := hs.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultHTTPMaxRetryDelay
+	}
+
+	var lastErr error
+	for try := 0; try < maxTries; try++ {
+		if try > 0 {
+			hs.logf("retrying block %+v in %s (%s)", loc, delay, lastErr.Error())
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+
+		url := strings.TrimSuffix(hs.BaseURLs[hs.mirror%len(hs.BaseURLs)], "/") + "/" + addr
+
+		readBytes, err := hs.fetchOnce(url, data)
+		if err == nil {
+			if bytes.Equal(hs.hasher.sum(data[:readBytes]), hash) {
+				return readBytes, nil
+			}
+			err = &ErrCorruptedBlock{Location: loc, Address: addr}
+		}
+
+		// whatever went wrong, maybe another mirror will do better
+		lastErr = err
+		hs.mirror++
+	}
+
+	return 0, errors.Wrap(lastErr, 1)
+}
+
+func (hs *HTTPSource) fetchOnce(url string, data []byte) (int, error) {
+	client := hs.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("fetching %s: HTTP %d", url, res.StatusCode)
+	}
+
+	if hs.Decompressor != nil {
+		return hs.Decompressor.Decompress(data, res.Body)
+	}
+
+	readBytes, err := io.ReadFull(res.Body, data)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// all good, the hash check will tell
+		} else {
+			return 0, err
+		}
+	}
+
+	return readBytes, nil
+}
+
+var _ RefetchingSource = (*HTTPSource)(nil)
+
+// Refetch downloads a block again, starting with the next mirror
+func (hs *HTTPSource) Refetch(loc BlockLocation, data []byte) (int, error) {
+	hs.mirror++
+	return hs.Fetch(loc, data)
+}
+
+// A FetchedFunc receives blocks fetched by FetchAll. data is only valid
+// until the function returns.
+type FetchedFunc func(loc BlockLocation, data []byte) error
+
+// FetchAll fetches blocks with Parallelism clones of this source, calling
+// onFetched for each of them (one at a time, in no particular order). It stops
+// at the first error.
+func (hs *HTTPSource) FetchAll(locs []BlockLocation, onFetched FetchedFunc) error {
+	parallelism := hs.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	locsChan := make(chan BlockLocation)
+	errs := make(chan error, parallelism)
+	cancelled := make(chan struct{})
+	var cancelOnce osync.Once
+	var callbackMutex osync.Mutex
+
+	cancel := func() {
+		cancelOnce.Do(func() {
+			close(cancelled)
+		})
+	}
+
+	for i := 0; i < parallelism; i++ {
+		source := hs.Clone()
+		go func() {
+			buf := make([]byte, BigBlockSize)
+			for loc := range locsChan {
+				size := ComputeBlockSize(hs.Container.Files[loc.FileIndex].Size, loc.BlockIndex)
+				readBytes, err := source.Fetch(loc, buf[:size])
+				if err == nil {
+					callbackMutex.Lock()
+					err = onFetched(loc, buf[:readBytes])
+					callbackMutex.Unlock()
+				}
+
+				if err != nil {
+					cancel()
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+
+	func() {
+		defer close(locsChan)
+		for _, loc := range locs {
+			select {
+			case <-cancelled:
+				return
+			case locsChan <- loc:
+			}
+		}
+	}()
+
+	var firstErr error
+	for i := 0; i < parallelism; i++ {
+		err := <-errs
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// GetContainer returns the tlc container this HTTP source is paired with
+func (hs *HTTPSource) GetContainer() *tlc.Container {
+	return hs.Container
+}
+
+func (hs *HTTPSource) logf(msg string, args ...interface{}) {
+	if hs.Consumer != nil {
+		hs.Consumer.Debugf(msg, args...)
+	}
+}
+
+// addressHash extracts the hash from a shake128-32/<hash>/<size> address
+func addressHash(addr string) ([]byte, error) {
+	tokens := strings.Split(addr, "/")
+	if len(tokens) != 3 || tokens[0] != "shake128-32" {
+		return nil, fmt.Errorf("invalid block address %s, expected shake128-32/<hash>/<size>", addr)
+	}
+
+	hash, err := hex.DecodeString(tokens[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid block address %s: %s", addr, err.Error())
+	}
+
+	return hash, nil
+}
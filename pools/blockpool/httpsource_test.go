@@ -0,0 +1,162 @@
+package blockpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	osync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HTTPSource(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "httpsource")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	dir := filepath.Join(mainDir, "build")
+	wtest.MakeTestDir(t, dir, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "big", Seed: 0x1, Size: BigBlockSize*2 + 14},
+			{Path: "subdir/small", Seed: 0x2},
+			{Path: "subdir/other", Seed: 0x3},
+		},
+	})
+
+	consumer := &state.Consumer{}
+
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	blocksDir := filepath.Join(mainDir, "blocks")
+	blockHashes := NewBlockHashMap()
+	outPool := &BlockPool{
+		Container: container,
+		Downstream: &DiskSink{
+			BasePath:    blocksDir,
+			Container:   container,
+			BlockHashes: blockHashes,
+		},
+	}
+	wtest.Must(t, pwr.CopyContainer(container, outPool, fspool.New(container, dir), consumer))
+
+	blockAddresses, err := blockHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+	wtest.Must(t, err)
+
+	files := http.FileServer(http.Dir(blocksDir))
+
+	good := httptest.NewServer(files)
+	defer good.Close()
+
+	var flakyRequests int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&flakyRequests, 1)%2 == 1 {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	corrupted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte{0x42}, 1024))
+	}))
+	defer corrupted.Close()
+
+	newSource := func(baseURLs ...string) *HTTPSource {
+		return &HTTPSource{
+			BaseURLs:       baseURLs,
+			BlockAddresses: blockAddresses,
+			Container:      container,
+
+			RetryDelay: time.Millisecond,
+		}
+	}
+
+	pull := func(source Source) error {
+		out := filepath.Join(mainDir, "out")
+		wtest.Must(t, os.RemoveAll(out))
+		wtest.Must(t, container.Prepare(out))
+
+		inPool := &BlockPool{
+			Container: container,
+			Upstream:  source,
+		}
+		err := pwr.CopyContainer(container, fspool.New(container, out), inPool, consumer)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range container.Files {
+			expected, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+			wtest.Must(t, err)
+			actual, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(f.Path)))
+			wtest.Must(t, err)
+			assert.True(t, bytes.Equal(expected, actual), "%s should be identical", f.Path)
+		}
+		return nil
+	}
+
+	t.Logf("Single mirror")
+	wtest.Must(t, pull(newSource(good.URL)))
+
+	t.Logf("Flaky and corrupted mirrors")
+	wtest.Must(t, pull(newSource(corrupted.URL, flaky.URL+"/")))
+	assert.True(t, atomic.LoadInt64(&flakyRequests) > 0)
+
+	t.Logf("Only corrupted mirrors")
+	source := newSource(corrupted.URL)
+	source.MaxTries = 2
+	err = pull(source)
+	assert.Error(t, err)
+	if se, ok := err.(*errors.Error); ok {
+		err = se.Err
+	}
+	if assert.IsType(t, &ErrCorruptedBlock{}, err) {
+		assert.EqualValues(t, blockAddresses.Get(err.(*ErrCorruptedBlock).Location), err.(*ErrCorruptedBlock).Address)
+	}
+
+	t.Logf("Fetching all blocks in parallel")
+	var locs []BlockLocation
+	for fileIndex, f := range container.Files {
+		for blockIndex := int64(0); blockIndex < ComputeNumBlocks(f.Size); blockIndex++ {
+			locs = append(locs, BlockLocation{FileIndex: int64(fileIndex), BlockIndex: blockIndex})
+		}
+	}
+
+	source = newSource(good.URL, flaky.URL)
+	source.Parallelism = 4
+
+	var fetchedMutex osync.Mutex
+	fetched := make(map[BlockLocation]int)
+	wtest.Must(t, source.FetchAll(locs, func(loc BlockLocation, data []byte) error {
+		fetchedMutex.Lock()
+		defer fetchedMutex.Unlock()
+		fetched[loc] = len(data)
+		return nil
+	}))
+	assert.EqualValues(t, len(locs), len(fetched))
+	for _, loc := range locs {
+		assert.EqualValues(t, ComputeBlockSize(container.Files[loc.FileIndex].Size, loc.BlockIndex), fetched[loc])
+	}
+
+	t.Logf("Parallel fetches stop at the first error")
+	source = newSource(corrupted.URL)
+	source.MaxTries = 1
+	source.Parallelism = 4
+	assert.Error(t, source.FetchAll(locs, func(loc BlockLocation, data []byte) error {
+		return nil
+	}))
+}
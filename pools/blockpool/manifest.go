@@ -83,6 +83,7 @@ func ReadManifest(manifestReader savior.SeekSource) (*tlc.Container, *BlockHashM
 	blockHashes := NewBlockHashMap()
 
 	rawWire := wire.NewReadContext(manifestReader)
+	rawWire.SetLimits(pwr.ManifestWireLimits.ContainerLimits())
 	err := rawWire.ExpectMagic(pwr.ManifestMagic)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, 1)
@@ -109,6 +110,8 @@ func ReadManifest(manifestReader savior.SeekSource) (*tlc.Container, *BlockHashM
 		return nil, nil, errors.Wrap(err, 1)
 	}
 
+	wire.SetLimits(pwr.ManifestWireLimits.BodyLimits())
+
 	sh := &pwr.SyncHeader{}
 	mbh := &pwr.ManifestBlockHash{}
 
@@ -0,0 +1,69 @@
+package blockpool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func readManifestBytes(data []byte) (*tlc.Container, *BlockHashMap, error) {
+	source := seeksource.FromBytes(data)
+	_, err := source.Resume(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ReadManifest(source)
+}
+
+func sampleManifest(hashSize int) ([]byte, error) {
+	container := &tlc.Container{
+		Dirs:  []*tlc.Dir{{Path: "d", Mode: 0755}},
+		Files: []*tlc.File{{Path: "d/a", Size: BigBlockSize + 1, Mode: 0644}, {Path: "b", Mode: 0644}},
+		Size:  BigBlockSize + 1,
+	}
+
+	blockHashes := NewBlockHashMap()
+	blockHashes.Set(BlockLocation{FileIndex: 0, BlockIndex: 0}, make([]byte, 32))
+	blockHashes.Set(BlockLocation{FileIndex: 0, BlockIndex: 1}, make([]byte, hashSize))
+
+	buf := new(bytes.Buffer)
+	compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+	err := WriteManifest(buf, compression, container, blockHashes)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func Test_ManifestWireLimits(t *testing.T) {
+	manifest, err := sampleManifest(32)
+	wtest.Must(t, err)
+
+	container, blockHashes, err := readManifestBytes(manifest)
+	wtest.Must(t, err)
+	assert.EqualValues(t, 2, len(container.Files))
+	assert.EqualValues(t, 32, len(blockHashes.Get(BlockLocation{FileIndex: 0, BlockIndex: 1})))
+
+	manifest, err = sampleManifest(64 * 1024)
+	wtest.Must(t, err)
+
+	_, _, err = readManifestBytes(manifest)
+	assert.Error(t, err, "oversized hash should be refused")
+}
+
+func FuzzReadManifest(f *testing.F) {
+	manifest, err := sampleManifest(32)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(manifest)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readManifestBytes(data)
+	})
+}
@@ -0,0 +1,308 @@
+package blockpool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Packfiles store many blocks back to back, to avoid having one file per
+// block. Each pack-<n>.pack file comes with a pack-<n>.idx text file, with
+// one "<address> <offset> <length>\n" line per block. Index lines are only
+// appended after the block itself has been written, so that a process crash
+// never leaves an index pointing to missing data. Packs aren't synced to
+// disk though, so an OS crash or a power loss still might.
+
+// DefaultMaxPackSize is the size after which a new packfile is started
+const DefaultMaxPackSize int64 = 1024 * 1024 * 1024 // 1GB
+
+const (
+	packPrefix    = "pack-"
+	packExtension = ".pack"
+	idxExtension  = ".idx"
+)
+
+// A packEntry is where a block lies in a pack
+type packEntry struct {
+	Pack   int64
+	Offset int64
+	Length int64
+}
+
+func packPath(basePath string, pack int64) string {
+	return filepath.Join(basePath, fmt.Sprintf("%s%06d%s", packPrefix, pack, packExtension))
+}
+
+func idxPath(basePath string, pack int64) string {
+	return filepath.Join(basePath, fmt.Sprintf("%s%06d%s", packPrefix, pack, idxExtension))
+}
+
+// listPacks returns the numbers of all packs that have an index in basePath, sorted
+func listPacks(basePath string) ([]int64, error) {
+	matches, err := filepath.Glob(filepath.Join(basePath, packPrefix+"*"+idxExtension))
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	var packs []int64
+	for _, match := range matches {
+		var pack int64
+		name := strings.TrimSuffix(filepath.Base(match), idxExtension)
+		_, err := fmt.Sscanf(name, packPrefix+"%d", &pack)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		packs = append(packs, pack)
+	}
+
+	sort.Slice(packs, func(i, j int) bool { return packs[i] < packs[j] })
+	return packs, nil
+}
+
+// readPackIndex reads the indices of all the packs in basePath. It returns
+// the index and the number of the next pack to write. A missing basePath
+// is an empty store.
+func readPackIndex(basePath string) (map[string]packEntry, int64, error) {
+	entries := make(map[string]packEntry)
+
+	packs, err := listPacks(basePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nextPack := int64(1)
+	for _, pack := range packs {
+		err := readPackIdx(basePath, pack, func(addr string, entry packEntry) {
+			if _, ok := entries[addr]; !ok {
+				entries[addr] = entry
+			}
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if pack >= nextPack {
+			nextPack = pack + 1
+		}
+	}
+
+	return entries, nextPack, nil
+}
+
+// readPackIdx calls onEntry for every complete line of a pack's index
+func readPackIdx(basePath string, pack int64, onEntry func(addr string, entry packEntry)) error {
+	f, err := os.Open(idxPath(basePath, pack))
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				// an incomplete last line is a write that never finished, ignore it
+				return nil
+			}
+			return errors.Wrap(err, 0)
+		}
+
+		var addr string
+		entry := packEntry{Pack: pack}
+		_, err = fmt.Sscanf(line, "%s %d %d\n", &addr, &entry.Offset, &entry.Length)
+		if err != nil {
+			return errors.Wrap(fmt.Errorf("%s:%d: invalid index line: %s", idxPath(basePath, pack), lineNumber, err.Error()), 0)
+		}
+		onEntry(addr, entry)
+	}
+}
+
+// RepackOptions determines what Repack keeps, and how big packs are
+type RepackOptions struct {
+	// Keep returns true for addresses of blocks to keep. All blocks are kept if nil.
+	Keep func(addr string) bool
+	// MaxPackSize is the size after which a new packfile is started,
+	// DefaultMaxPackSize if 0.
+	MaxPackSize int64
+	// SkipPack, if set, returns true for packs that must be left as they
+	// are, for example because a PackSink may still be appending to them.
+	// It's asked again before each old pack is removed.
+	SkipPack func(pack int64) bool
+}
+
+// RepackStats describes what Repack did
+type RepackStats struct {
+	PacksBefore int
+	PacksAfter  int
+	BytesBefore int64
+	BytesAfter  int64
+	Blocks      int64
+	Dropped     int64
+}
+
+// Repack rewrites all the packs in basePath into as few packs as possible,
+// dropping duplicate blocks and blocks that aren't kept. New packs are
+// fully written before old ones are removed, so a store stays readable if
+// Repack is interrupted. It must not run concurrently with a PackSink
+// writing to the same store, unless opts.SkipPack leaves alone the packs
+// that sink writes to.
+func Repack(basePath string, opts RepackOptions) (*RepackStats, error) {
+	allPacks, err := listPacks(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RepackStats{
+		PacksBefore: len(allPacks),
+	}
+
+	skipPack := func(pack int64) bool {
+		return opts.SkipPack != nil && opts.SkipPack(pack)
+	}
+
+	var oldPacks []int64
+	nextPack := int64(1)
+	for _, pack := range allPacks {
+		if pack >= nextPack {
+			nextPack = pack + 1
+		}
+		if !skipPack(pack) {
+			oldPacks = append(oldPacks, pack)
+		}
+	}
+
+	entries := make(map[string]packEntry)
+	for _, pack := range oldPacks {
+		err := readPackIdx(basePath, pack, func(addr string, entry packEntry) {
+			if _, ok := entries[addr]; !ok {
+				entries[addr] = entry
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pack := range oldPacks {
+		packStats, err := os.Stat(packPath(basePath, pack))
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		stats.BytesBefore += packStats.Size()
+	}
+
+	// copy blocks in their original order, it helps with locality
+	addrs := make([]string, 0, len(entries))
+	for addr := range entries {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		a, b := entries[addrs[i]], entries[addrs[j]]
+		if a.Pack != b.Pack {
+			return a.Pack < b.Pack
+		}
+		return a.Offset < b.Offset
+	})
+
+	pw := &packWriter{
+		basePath:    basePath,
+		maxPackSize: opts.MaxPackSize,
+		entries:     make(map[string]packEntry),
+		nextPack:    nextPack,
+		loaded:      true,
+	}
+
+	packFiles := make(map[int64]*os.File)
+	defer func() {
+		for _, f := range packFiles {
+			f.Close()
+		}
+	}()
+
+	var buf []byte
+	for _, addr := range addrs {
+		if opts.Keep != nil && !opts.Keep(addr) {
+			stats.Dropped++
+			continue
+		}
+
+		entry := entries[addr]
+		f := packFiles[entry.Pack]
+		if f == nil {
+			f, err = os.Open(packPath(basePath, entry.Pack))
+			if err != nil {
+				return nil, errors.Wrap(err, 0)
+			}
+			packFiles[entry.Pack] = f
+		}
+
+		if int64(cap(buf)) < entry.Length {
+			buf = make([]byte, entry.Length)
+		}
+		buf = buf[:entry.Length]
+
+		_, err = f.ReadAt(buf, entry.Offset)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		err = pw.write(addr, buf)
+		if err != nil {
+			return nil, err
+		}
+		stats.Blocks++
+	}
+
+	err = pw.close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range packFiles {
+		f.Close()
+	}
+	packFiles = nil
+
+	// removing the index first makes the old pack invisible
+	for _, pack := range oldPacks {
+		if skipPack(pack) {
+			// it was written to while we were repacking, and now
+			// has blocks we haven't copied
+			continue
+		}
+
+		err = os.Remove(idxPath(basePath, pack))
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		err = os.Remove(packPath(basePath, pack))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, 0)
+		}
+	}
+
+	newPacks, err := listPacks(basePath)
+	if err != nil {
+		return nil, err
+	}
+	stats.PacksAfter = len(newPacks)
+
+	for _, pack := range newPacks {
+		packStats, err := os.Stat(packPath(basePath, pack))
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		stats.BytesAfter += packStats.Size()
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,165 @@
+package blockpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Pack(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "pack")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	consumer := &state.Consumer{}
+
+	dir := filepath.Join(mainDir, "build")
+	wtest.MakeTestDir(t, dir, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "big", Seed: 0x1, Size: BigBlockSize*3 + 14},
+			{Path: "big-copy", Seed: 0x1, Size: BigBlockSize*3 + 14},
+			{Path: "subdir/small", Seed: 0x2},
+			{Path: "subdir/other", Seed: 0x3},
+		},
+	})
+
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	// big and big-copy share all their blocks
+	uniqueBlocks := 4 + 1 + 1
+
+	for _, compressed := range []bool{false, true} {
+		packsDir := filepath.Join(mainDir, "packs")
+		wtest.Must(t, os.RemoveAll(packsDir))
+
+		push := func() []byte {
+			sink := &PackSink{
+				BasePath:    packsDir,
+				Container:   container,
+				BlockHashes: NewBlockHashMap(),
+				MaxPackSize: BigBlockSize * 2,
+			}
+			if compressed {
+				sink.Compressor = &Compressor{}
+			}
+
+			fos, err := NewFanOutSink(sink, 4)
+			wtest.Must(t, err)
+			fos.Start()
+
+			outPool := &BlockPool{
+				Container:  container,
+				Downstream: fos,
+			}
+			wtest.Must(t, pwr.CopyContainer(container, outPool, fspool.New(container, dir), consumer))
+			wtest.Must(t, fos.Close())
+			wtest.Must(t, sink.Close())
+
+			manifest := new(bytes.Buffer)
+			compression := &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}
+			wtest.Must(t, WriteManifest(manifest, compression, container, sink.BlockHashes))
+			return manifest.Bytes()
+		}
+
+		pull := func(manifest []byte) {
+			manifestSource := seeksource.FromBytes(manifest)
+			_, err := manifestSource.Resume(nil)
+			wtest.Must(t, err)
+
+			manifestContainer, blockHashes, err := ReadManifest(manifestSource)
+			wtest.Must(t, err)
+
+			blockAddresses, err := blockHashes.ToAddressMap(manifestContainer, pwr.HashAlgorithm_SHAKE128_32)
+			wtest.Must(t, err)
+
+			source := &PackSource{
+				BasePath:       packsDir,
+				BlockAddresses: blockAddresses,
+				Container:      manifestContainer,
+			}
+			if compressed {
+				source.Decompressor = &Decompressor{}
+			}
+			defer source.Close()
+
+			out := filepath.Join(mainDir, "out")
+			wtest.Must(t, os.RemoveAll(out))
+			wtest.Must(t, manifestContainer.Prepare(out))
+
+			inPool := &BlockPool{
+				Container: manifestContainer,
+				Upstream:  source.Clone(),
+			}
+			wtest.Must(t, pwr.CopyContainer(manifestContainer, fspool.New(manifestContainer, out), inPool, consumer))
+
+			for _, f := range manifestContainer.Files {
+				expected, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+				wtest.Must(t, err)
+				actual, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(f.Path)))
+				wtest.Must(t, err)
+				assert.True(t, bytes.Equal(expected, actual), "%s should be identical", f.Path)
+			}
+		}
+
+		countBlocks := func() int {
+			entries, _, err := readPackIndex(packsDir)
+			wtest.Must(t, err)
+			return len(entries)
+		}
+
+		t.Logf("Pushing (compressed: %v)", compressed)
+		manifest := push()
+		assert.EqualValues(t, uniqueBlocks, countBlocks())
+		pull(manifest)
+
+		packs, err := listPacks(packsDir)
+		wtest.Must(t, err)
+		assert.True(t, len(packs) > 1, "should have started several packs")
+
+		t.Logf("Pushing again, nothing new should be stored")
+		manifest = push()
+		packsAgain, err := listPacks(packsDir)
+		wtest.Must(t, err)
+		assert.EqualValues(t, packs, packsAgain)
+
+		t.Logf("Ignoring an interrupted index write")
+		idx, err := os.OpenFile(idxPath(packsDir, packs[0]), os.O_APPEND|os.O_WRONLY, 0644)
+		wtest.Must(t, err)
+		_, err = idx.WriteString("shake128-32/")
+		wtest.Must(t, err)
+		wtest.Must(t, idx.Close())
+		assert.EqualValues(t, uniqueBlocks, countBlocks())
+
+		t.Logf("Repacking")
+		stats, err := Repack(packsDir, RepackOptions{})
+		wtest.Must(t, err)
+		assert.EqualValues(t, len(packs), stats.PacksBefore)
+		assert.EqualValues(t, 1, stats.PacksAfter)
+		assert.EqualValues(t, uniqueBlocks, stats.Blocks)
+		assert.EqualValues(t, stats.BytesBefore, stats.BytesAfter)
+		assert.EqualValues(t, uniqueBlocks, countBlocks())
+		pull(manifest)
+
+		t.Logf("Repacking without small blocks")
+		stats, err = Repack(packsDir, RepackOptions{
+			Keep: func(addr string) bool {
+				return filepath.Base(addr) == "4194304"
+			},
+		})
+		wtest.Must(t, err)
+		assert.EqualValues(t, 3, stats.Blocks)
+		assert.EqualValues(t, 3, stats.Dropped)
+		assert.EqualValues(t, 3, countBlocks())
+	}
+}
@@ -0,0 +1,376 @@
+package blockpool
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	osync "sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+)
+
+// PackSink stores blocks into packfiles, see pack.go. Blocks already in the
+// store are not stored again. Clones share the same packfiles, so they're
+// suitable for a FanOutSink: hashing and compression happen concurrently,
+// only appending to the current pack is serialized. Close must be called
+// once all blocks are stored (on any clone).
+// It's hard-coded to use shake128-32 as a hashing algorithm.
+type PackSink struct {
+	BasePath string
+
+	Container   *tlc.Container
+	BlockHashes *BlockHashMap
+
+	Compressor *Compressor
+
+	// MaxPackSize is the size after which a new packfile is started,
+	// DefaultMaxPackSize if 0.
+	MaxPackSize int64
+
+	writer        *packWriter
+	hasher        blockHasher
+	compressedBuf *bytes.Buffer
+	writing       bool
+}
+
+var _ Sink = (*PackSink)(nil)
+
+// Clone returns a copy of this pack sink, writing to the same packs
+func (ps *PackSink) Clone() Sink {
+	psc := &PackSink{
+		BasePath: ps.BasePath,
+
+		Container:   ps.Container,
+		BlockHashes: ps.BlockHashes,
+
+		MaxPackSize: ps.MaxPackSize,
+
+		writer: ps.getWriter(),
+	}
+
+	if ps.Compressor != nil {
+		psc.Compressor = ps.Compressor.Clone()
+	}
+
+	return psc
+}
+
+func (ps *PackSink) getWriter() *packWriter {
+	if ps.writer == nil {
+		ps.writer = &packWriter{
+			basePath:    ps.BasePath,
+			maxPackSize: ps.MaxPackSize,
+		}
+	}
+	return ps.writer
+}
+
+// Store hashes a block and appends it to the current pack, unless it's
+// already in the store. It should not be called concurrently on the same
+// sink, use clones for that.
+func (ps *PackSink) Store(loc BlockLocation, data []byte) error {
+	if ps.writing {
+		return fmt.Errorf("concurrent write to packsink is unsupported")
+	}
+
+	ps.writing = true
+	defer func() {
+		ps.writing = false
+	}()
+
+	hash := ps.hasher.sum(data)
+	if ps.BlockHashes != nil {
+		ps.BlockHashes.Set(loc, append([]byte{}, hash...))
+	}
+
+	fileSize := ps.Container.Files[int(loc.FileIndex)].Size
+	blockSize := ComputeBlockSize(fileSize, loc.BlockIndex)
+	addr := fmt.Sprintf("shake128-32/%x/%d", hash, blockSize)
+
+	writer := ps.getWriter()
+	has, err := writer.touch(addr)
+	if err != nil {
+		return err
+	}
+	if has {
+		// block's already there!
+		return nil
+	}
+
+	if ps.Compressor != nil {
+		if ps.compressedBuf == nil {
+			ps.compressedBuf = new(bytes.Buffer)
+		}
+		ps.compressedBuf.Reset()
+
+		err = ps.Compressor.Compress(ps.compressedBuf, data)
+		if err != nil {
+			return errors.Wrap(err, 1)
+		}
+		data = ps.compressedBuf.Bytes()
+	}
+
+	return writer.write(addr, data)
+}
+
+var _ HasSink = (*PackSink)(nil)
+
+// Has looks up blocks in the index of the store
+func (ps *PackSink) Has(addrs []string) ([]bool, error) {
+	return ps.getWriter().has(addrs...)
+}
+
+// Skip records the hash of a block that's already in the store, and
+// refreshes the pack holding it so a garbage collector doesn't sweep it
+// before the manifest is written.
+func (ps *PackSink) Skip(loc BlockLocation, hash []byte) error {
+	if ps.BlockHashes != nil {
+		ps.BlockHashes.Set(loc, append([]byte{}, hash...))
+	}
+
+	fileSize := ps.Container.Files[int(loc.FileIndex)].Size
+	blockSize := ComputeBlockSize(fileSize, loc.BlockIndex)
+	addr := fmt.Sprintf("shake128-32/%x/%d", hash, blockSize)
+
+	has, err := ps.getWriter().touch(addr)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return errors.Wrap(fmt.Errorf("block %s was removed from the store", addr), 0)
+	}
+	return nil
+}
+
+// Close finishes writing the current pack and its index
+func (ps *PackSink) Close() error {
+	if ps.writer == nil {
+		return nil
+	}
+	return ps.writer.close()
+}
+
+// GetContainer returns the container associated with this pack sink
+func (ps *PackSink) GetContainer() *tlc.Container {
+	return ps.Container
+}
+
+// packWriter appends blocks to packs, it's shared by all clones of a PackSink
+type packWriter struct {
+	mutex osync.Mutex
+
+	basePath    string
+	maxPackSize int64
+
+	loaded   bool
+	entries  map[string]packEntry
+	nextPack int64
+
+	pack     int64
+	packFile *os.File
+	idxFile  *os.File
+	packSize int64
+}
+
+// load reads the existing index, so that known blocks aren't stored again.
+// The mutex must be held.
+func (pw *packWriter) load() error {
+	if pw.loaded {
+		return nil
+	}
+
+	entries, nextPack, err := readPackIndex(pw.basePath)
+	if err != nil {
+		return err
+	}
+
+	pw.entries = entries
+	pw.nextPack = nextPack
+	pw.loaded = true
+	return nil
+}
+
+func (pw *packWriter) has(addrs ...string) ([]bool, error) {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	err := pw.load()
+	if err != nil {
+		return nil, err
+	}
+
+	has := make([]bool, len(addrs))
+	for i, addr := range addrs {
+		_, has[i] = pw.entries[addr]
+	}
+	return has, nil
+}
+
+// touch refreshes the modification time of the pack holding a block, so
+// that a garbage collector considers it recent. It returns false if the
+// block isn't in the store (anymore).
+func (pw *packWriter) touch(addr string) (bool, error) {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	err := pw.load()
+	if err != nil {
+		return false, err
+	}
+
+	for reloaded := false; ; reloaded = true {
+		entry, ok := pw.entries[addr]
+		if !ok {
+			return false, nil
+		}
+
+		if pw.packFile != nil && entry.Pack == pw.pack {
+			// we're writing to it
+			return true, nil
+		}
+
+		now := time.Now()
+		err := os.Chtimes(packPath(pw.basePath, entry.Pack), now, now)
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, errors.Wrap(err, 0)
+		}
+
+		if reloaded {
+			delete(pw.entries, addr)
+			return false, nil
+		}
+
+		// the pack was removed by a repack, which may have kept the block
+		entries, nextPack, err := readPackIndex(pw.basePath)
+		if err != nil {
+			return false, err
+		}
+		pw.entries = entries
+		if nextPack > pw.nextPack {
+			pw.nextPack = nextPack
+		}
+	}
+}
+
+// write appends a block (as it should be stored, ie. maybe compressed) to
+// the current pack, starting a new one if needed
+func (pw *packWriter) write(addr string, data []byte) error {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	err := pw.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := pw.entries[addr]; ok {
+		// another clone beat us to it
+		return nil
+	}
+
+	maxPackSize := pw.maxPackSize
+	if maxPackSize <= 0 {
+		maxPackSize = DefaultMaxPackSize
+	}
+
+	if pw.packFile != nil && pw.packSize+int64(len(data)) > maxPackSize {
+		err = pw.closePack()
+		if err != nil {
+			return err
+		}
+	}
+
+	if pw.packFile == nil {
+		err = pw.openPack()
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := packEntry{
+		Pack:   pw.pack,
+		Offset: pw.packSize,
+		Length: int64(len(data)),
+	}
+
+	_, err = pw.packFile.Write(data)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	pw.packSize += entry.Length
+
+	_, err = fmt.Fprintf(pw.idxFile, "%s %d %d\n", addr, entry.Offset, entry.Length)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	pw.entries[addr] = entry
+	return nil
+}
+
+// openPack starts a new pack. The mutex must be held.
+func (pw *packWriter) openPack() error {
+	err := os.MkdirAll(pw.basePath, 0755)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	pack := pw.nextPack
+	packFile, err := os.OpenFile(packPath(pw.basePath, pack), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	for os.IsExist(err) {
+		// a concurrent Repack or PackSink took that number
+		pack++
+		packFile, err = os.OpenFile(packPath(pw.basePath, pack), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	idxFile, err := os.OpenFile(idxPath(pw.basePath, pack), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		packFile.Close()
+		return errors.Wrap(err, 0)
+	}
+
+	pw.nextPack = pack + 1
+	pw.pack = pack
+	pw.packFile = packFile
+	pw.idxFile = idxFile
+	pw.packSize = 0
+	return nil
+}
+
+// closePack finishes the current pack, if any. The mutex must be held.
+func (pw *packWriter) closePack() error {
+	if pw.packFile == nil {
+		return nil
+	}
+
+	packFile, idxFile := pw.packFile, pw.idxFile
+	pw.packFile, pw.idxFile = nil, nil
+
+	err := packFile.Close()
+	if err != nil {
+		idxFile.Close()
+		return errors.Wrap(err, 0)
+	}
+
+	err = idxFile.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return nil
+}
+
+func (pw *packWriter) close() error {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	return pw.closePack()
+}
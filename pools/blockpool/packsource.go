@@ -0,0 +1,129 @@
+package blockpool
+
+import (
+	"fmt"
+	"io"
+	"os"
+	osync "sync"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+)
+
+// PackSource reads blocks stored by a PackSink. The index of all packs is
+// read on first fetch, and shared by clones.
+type PackSource struct {
+	BasePath       string
+	BlockAddresses BlockAddressMap
+
+	Decompressor *Decompressor
+
+	Container *tlc.Container
+
+	index     *packIndex
+	packFiles map[int64]*os.File
+}
+
+var _ Source = (*PackSource)(nil)
+
+// packIndex is loaded once, and shared by all clones of a PackSource
+type packIndex struct {
+	once    osync.Once
+	entries map[string]packEntry
+	err     error
+}
+
+// Clone returns a copy of this pack source, suitable for fan-in
+func (ps *PackSource) Clone() Source {
+	psc := &PackSource{
+		BasePath:       ps.BasePath,
+		BlockAddresses: ps.BlockAddresses,
+
+		Container: ps.Container,
+
+		index: ps.getIndex(),
+	}
+
+	if ps.Decompressor != nil {
+		psc.Decompressor = ps.Decompressor.Clone()
+	}
+
+	return psc
+}
+
+func (ps *PackSource) getIndex() *packIndex {
+	if ps.index == nil {
+		ps.index = &packIndex{}
+	}
+	return ps.index
+}
+
+// Fetch reads a block from its pack
+func (ps *PackSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	addr := ps.BlockAddresses.Get(loc)
+	if addr == "" {
+		return 0, errors.Wrap(fmt.Errorf("no address for block %+v", loc), 1)
+	}
+
+	index := ps.getIndex()
+	index.once.Do(func() {
+		index.entries, _, index.err = readPackIndex(ps.BasePath)
+	})
+	if index.err != nil {
+		return 0, errors.Wrap(index.err, 1)
+	}
+
+	entry, ok := index.entries[addr]
+	if !ok {
+		return 0, errors.Wrap(fmt.Errorf("block %s not found in packs", addr), 1)
+	}
+
+	if ps.packFiles == nil {
+		ps.packFiles = make(map[int64]*os.File)
+	}
+
+	packFile := ps.packFiles[entry.Pack]
+	if packFile == nil {
+		var err error
+		packFile, err = os.Open(packPath(ps.BasePath, entry.Pack))
+		if err != nil {
+			return 0, errors.Wrap(err, 1)
+		}
+		ps.packFiles[entry.Pack] = packFile
+	}
+
+	sr := io.NewSectionReader(packFile, entry.Offset, entry.Length)
+
+	if ps.Decompressor == nil {
+		bytesRead, err := io.ReadFull(sr, data)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// all good
+			} else {
+				return 0, errors.Wrap(err, 1)
+			}
+		}
+
+		return bytesRead, nil
+	}
+
+	return ps.Decompressor.Decompress(data, sr)
+}
+
+// Close closes the packfiles this source has opened so far
+func (ps *PackSource) Close() error {
+	var firstErr error
+	for pack, packFile := range ps.packFiles {
+		err := packFile.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, 0)
+		}
+		delete(ps.packFiles, pack)
+	}
+	return firstErr
+}
+
+// GetContainer returns the tlc container this pack source is paired with
+func (ps *PackSource) GetContainer() *tlc.Container {
+	return ps.Container
+}
@@ -0,0 +1,181 @@
+package blockpool
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+)
+
+// A DownloadPlan tells which blocks of a new build have to be downloaded,
+// and which ones can be read from an old build instead. It's computed from
+// two manifests, without needing a patch.
+type DownloadPlan struct {
+	// Download has all the blocks of the new build that the old one
+	// doesn't have. It can be used with a FilteringSource.
+	Download BlockFilter
+	// Reuse maps blocks of the new build to a block with the same contents
+	// in the old build
+	Reuse map[BlockLocation]BlockLocation
+
+	DownloadBlocks int64
+	DownloadBytes  int64
+	ReuseBlocks    int64
+	ReuseBytes     int64
+}
+
+// PlanDownload compares the block hashes of two builds, as read by
+// ReadManifest, and returns a plan to get the new build from the old one.
+func PlanDownload(oldContainer *tlc.Container, oldHashes *BlockHashMap, newContainer *tlc.Container, newHashes *BlockHashMap) (*DownloadPlan, error) {
+	oldAddresses, err := oldHashes.ToAddressMap(oldContainer, pwr.HashAlgorithm_SHAKE128_32)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	// addresses include the size, so identical addresses are identical blocks
+	oldLocations := make(map[string]BlockLocation)
+	for fileIndex, f := range oldContainer.Files {
+		for blockIndex := int64(0); blockIndex < ComputeNumBlocks(f.Size); blockIndex++ {
+			loc := BlockLocation{FileIndex: int64(fileIndex), BlockIndex: blockIndex}
+			addr := oldAddresses.Get(loc)
+			if addr == "" {
+				return nil, errors.Wrap(fmt.Errorf("old build: missing hash for block %+v", loc), 0)
+			}
+			if _, ok := oldLocations[addr]; !ok {
+				oldLocations[addr] = loc
+			}
+		}
+	}
+
+	newAddresses, err := newHashes.ToAddressMap(newContainer, pwr.HashAlgorithm_SHAKE128_32)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	plan := &DownloadPlan{
+		Download: make(BlockFilter),
+		Reuse:    make(map[BlockLocation]BlockLocation),
+	}
+
+	for fileIndex, f := range newContainer.Files {
+		for blockIndex := int64(0); blockIndex < ComputeNumBlocks(f.Size); blockIndex++ {
+			loc := BlockLocation{FileIndex: int64(fileIndex), BlockIndex: blockIndex}
+			addr := newAddresses.Get(loc)
+			if addr == "" {
+				return nil, errors.Wrap(fmt.Errorf("new build: missing hash for block %+v", loc), 0)
+			}
+
+			size := ComputeBlockSize(f.Size, blockIndex)
+			if oldLoc, ok := oldLocations[addr]; ok {
+				plan.Reuse[loc] = oldLoc
+				plan.ReuseBlocks++
+				plan.ReuseBytes += size
+			} else {
+				plan.Download.Set(loc)
+				plan.DownloadBlocks++
+				plan.DownloadBytes += size
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// A PlanSource gets the blocks of a new build according to a DownloadPlan:
+// from the old build when possible, and from the new build's source otherwise.
+type PlanSource struct {
+	Plan *DownloadPlan
+
+	// OldSource reads blocks from the old build, for example a FolderSource
+	OldSource Source
+	// NewSource fetches blocks of the new build, for example an HTTPSource
+	NewSource Source
+}
+
+var _ Source = (*PlanSource)(nil)
+
+// Clone returns a copy of this plan source, with clones of both sources
+func (ps *PlanSource) Clone() Source {
+	return &PlanSource{
+		Plan:      ps.Plan,
+		OldSource: ps.OldSource.Clone(),
+		NewSource: ps.NewSource.Clone(),
+	}
+}
+
+// Fetch reads a block from the old build if the plan allows it, or
+// fetches it from the new build's source
+func (ps *PlanSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	if oldLoc, ok := ps.Plan.Reuse[loc]; ok {
+		return ps.OldSource.Fetch(oldLoc, data)
+	}
+	return ps.NewSource.Fetch(loc, data)
+}
+
+// GetContainer returns the new build's container
+func (ps *PlanSource) GetContainer() *tlc.Container {
+	return ps.NewSource.GetContainer()
+}
+
+// A FolderSource reads blocks from a build installed in a folder, so
+// that an old build can serve as a Source for a PlanSource.
+type FolderSource struct {
+	BasePath  string
+	Container *tlc.Container
+
+	pool *fspool.FsPool
+}
+
+var _ Source = (*FolderSource)(nil)
+
+// Clone returns a copy of this folder source, with its own file handles
+func (fs *FolderSource) Clone() Source {
+	return &FolderSource{
+		BasePath:  fs.BasePath,
+		Container: fs.Container,
+	}
+}
+
+// Fetch reads a block from its file
+func (fs *FolderSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	if fs.pool == nil {
+		fs.pool = fspool.New(fs.Container, fs.BasePath)
+	}
+
+	r, err := fs.pool.GetReadSeeker(loc.FileIndex)
+	if err != nil {
+		return 0, errors.Wrap(err, 1)
+	}
+
+	_, err = r.Seek(loc.BlockIndex*BigBlockSize, io.SeekStart)
+	if err != nil {
+		return 0, errors.Wrap(err, 1)
+	}
+
+	readBytes, err := io.ReadFull(r, data)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// all good
+		} else {
+			return 0, errors.Wrap(err, 1)
+		}
+	}
+
+	return readBytes, nil
+}
+
+// Close closes the file this source has open, if any
+func (fs *FolderSource) Close() error {
+	if fs.pool == nil {
+		return nil
+	}
+	return fs.pool.Close()
+}
+
+// GetContainer returns the container of the build in the folder
+func (fs *FolderSource) GetContainer() *tlc.Container {
+	return fs.Container
+}
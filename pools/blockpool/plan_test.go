@@ -0,0 +1,133 @@
+package blockpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PlanDownload(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "plan")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	consumer := &state.Consumer{}
+
+	makeBuild := func(name string, entries []wtest.TestDirEntry) (string, *tlc.Container, *BlockHashMap) {
+		dir := filepath.Join(mainDir, name)
+		wtest.MakeTestDir(t, dir, wtest.TestDirSettings{Entries: entries})
+		container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+		wtest.Must(t, err)
+
+		blockHashes := NewBlockHashMap()
+		outPool := &BlockPool{
+			Container: container,
+			Downstream: &DiskSink{
+				BasePath:    filepath.Join(mainDir, "blocks"),
+				Container:   container,
+				BlockHashes: blockHashes,
+			},
+		}
+		wtest.Must(t, pwr.CopyContainer(container, outPool, fspool.New(container, dir), consumer))
+		return dir, container, blockHashes
+	}
+
+	v1, v1Container, v1Hashes := makeBuild("v1", []wtest.TestDirEntry{
+		{Path: "big", Seed: 0x1, Size: BigBlockSize*2 + 14},
+		{Path: "small", Seed: 0x2},
+	})
+	v2, v2Container, v2Hashes := makeBuild("v2", []wtest.TestDirEntry{
+		// same first two blocks as v1's big, different tail
+		{Path: "moved/big", Seed: 0x1, Size: BigBlockSize*2 + 28},
+		{Path: "small-copy", Seed: 0x2},
+		{Path: "new", Seed: 0x3},
+	})
+
+	plan, err := PlanDownload(v1Container, v1Hashes, v2Container, v2Hashes)
+	wtest.Must(t, err)
+
+	bigIndex, smallIndex, newIndex := int64(0), int64(2), int64(1)
+	for i, f := range v2Container.Files {
+		switch f.Path {
+		case "moved/big":
+			bigIndex = int64(i)
+		case "small-copy":
+			smallIndex = int64(i)
+		case "new":
+			newIndex = int64(i)
+		}
+	}
+
+	assert.EqualValues(t, 3, plan.ReuseBlocks)
+	assert.EqualValues(t, 2, plan.DownloadBlocks)
+	assert.True(t, plan.Download.Has(BlockLocation{FileIndex: bigIndex, BlockIndex: 2}))
+	assert.True(t, plan.Download.Has(BlockLocation{FileIndex: newIndex, BlockIndex: 0}))
+	assert.False(t, plan.Download.Has(BlockLocation{FileIndex: smallIndex, BlockIndex: 0}))
+	assert.EqualValues(t, 1, plan.Reuse[BlockLocation{FileIndex: bigIndex, BlockIndex: 1}].BlockIndex)
+	t.Logf("Downloading %s", plan.Download.Stats(v2Container))
+
+	v2Addresses, err := v2Hashes.ToAddressMap(v2Container, pwr.HashAlgorithm_SHAKE128_32)
+	wtest.Must(t, err)
+
+	var fetches int64
+	newSource := &countingSource{
+		Source: &DiskSource{
+			BasePath:       filepath.Join(mainDir, "blocks"),
+			BlockAddresses: v2Addresses,
+			Container:      v2Container,
+		},
+		fetches: &fetches,
+	}
+
+	oldSource := &FolderSource{
+		BasePath:  v1,
+		Container: v1Container,
+	}
+	defer oldSource.Close()
+
+	inPool := &BlockPool{
+		Container: v2Container,
+		Upstream: &PlanSource{
+			Plan:      plan,
+			OldSource: oldSource,
+			NewSource: &FilteringSource{
+				Source: newSource,
+				Filter: plan.Download,
+			},
+		},
+	}
+
+	out := filepath.Join(mainDir, "out")
+	wtest.Must(t, v2Container.Prepare(out))
+	wtest.Must(t, pwr.CopyContainer(v2Container, fspool.New(v2Container, out), inPool, consumer))
+	assert.EqualValues(t, plan.DownloadBlocks, atomic.LoadInt64(&fetches))
+
+	for _, f := range v2Container.Files {
+		expected, err := ioutil.ReadFile(filepath.Join(v2, filepath.FromSlash(f.Path)))
+		wtest.Must(t, err)
+		actual, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(f.Path)))
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(expected, actual), "%s should be identical", f.Path)
+	}
+}
+
+// countingSource counts fetches that reach the underlying source
+type countingSource struct {
+	Source
+	fetches *int64
+}
+
+func (cs *countingSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	atomic.AddInt64(cs.fetches, 1)
+	return cs.Source.Fetch(loc, data)
+}
@@ -3,6 +3,7 @@ package blockpool
 import (
 	"io"
 	"os"
+	osync "sync"
 )
 
 // A Reader provides an io.ReadSeeker on top of a blockpool, knowing
@@ -16,6 +17,23 @@ type Reader struct {
 	numBlocks  int64
 	blockIndex int64
 	blockBuf   []byte
+
+	// read-ahead, see setupReadAhead
+	readAhead   int64
+	prefetches  map[int64]*prefetch
+	freeBufs    chan []byte
+	freeSources chan Source
+	inFlight    osync.WaitGroup
+}
+
+// a prefetch is a block being fetched in the background. Its fields
+// may only be read once done is closed.
+type prefetch struct {
+	blockIndex int64
+	source     Source
+	buf        []byte
+	err        error
+	done       chan struct{}
 }
 
 var _ io.ReadSeeker = (*Reader)(nil)
@@ -28,12 +46,9 @@ func (npr *Reader) Read(buf []byte) (int, error) {
 		}
 
 		npr.blockIndex = blockIndex
-		loc := BlockLocation{FileIndex: npr.fileIndex, BlockIndex: blockIndex}
-		blockSize := ComputeBlockSize(npr.size, npr.blockIndex)
-
-		// FIXME: should we check readBytes here? it would break filtering sources though.
-		_, err := npr.pool.Upstream.Fetch(loc, npr.blockBuf[:blockSize])
+		err := npr.fetchBlock(blockIndex)
 		if err != nil {
+			npr.blockIndex = -1
 			return 0, err
 		}
 	}
@@ -71,10 +86,153 @@ func (npr *Reader) Seek(offset int64, whence int) (int64, error) {
 	case os.SEEK_SET:
 		npr.offset = offset
 	}
+
+	if npr.prefetches != nil {
+		npr.cancelPrefetches(npr.offset / BigBlockSize)
+	}
 	return npr.offset, nil
 }
 
-// Close is actually a no-op
+// Close waits for prefetches in flight, if any, and throws them away
 func (npr *Reader) Close() error {
+	if npr.prefetches != nil {
+		npr.cancelPrefetches(-1)
+		npr.inFlight.Wait()
+	}
 	return nil
 }
+
+// setupReadAhead sizes the prefetch buffers according to the pool's settings
+func (npr *Reader) setupReadAhead() {
+	if npr.pool.ReadAhead <= 0 || npr.numBlocks <= 1 {
+		return
+	}
+
+	readAhead := int64(npr.pool.ReadAhead)
+	maxBytes := npr.pool.ReadAheadMaxBytes
+	if maxBytes > 0 && maxBytes/BigBlockSize < readAhead {
+		readAhead = maxBytes / BigBlockSize
+		if readAhead < 1 {
+			readAhead = 1
+		}
+	}
+
+	npr.readAhead = readAhead
+	npr.prefetches = make(map[int64]*prefetch)
+	npr.freeBufs = make(chan []byte, readAhead)
+	npr.freeSources = make(chan Source, readAhead)
+	for i := int64(0); i < readAhead; i++ {
+		// buffers and sources are allocated lazily
+		npr.freeBufs <- nil
+		npr.freeSources <- nil
+	}
+}
+
+// fetchBlock fills blockBuf with the given block, from a prefetch if there's
+// one, then schedules prefetches for the next blocks.
+func (npr *Reader) fetchBlock(blockIndex int64) error {
+	blockSize := ComputeBlockSize(npr.size, blockIndex)
+
+	if npr.prefetches == nil {
+		loc := BlockLocation{FileIndex: npr.fileIndex, BlockIndex: blockIndex}
+		// FIXME: should we check readBytes here? it would break filtering sources though.
+		_, err := npr.pool.Upstream.Fetch(loc, npr.blockBuf[:blockSize])
+		return err
+	}
+
+	// prefetches behind us, or too far ahead, are no longer useful
+	npr.cancelPrefetches(blockIndex)
+
+	hit := false
+	if p, ok := npr.prefetches[blockIndex]; ok {
+		delete(npr.prefetches, blockIndex)
+		<-p.done
+		if p.err == nil {
+			hit = true
+			npr.blockBuf, p.buf = p.buf, npr.blockBuf
+		}
+		npr.release(p)
+	}
+
+	if hit {
+		npr.pool.readAheadStats.Hits++
+	} else {
+		npr.pool.readAheadStats.Misses++
+		loc := BlockLocation{FileIndex: npr.fileIndex, BlockIndex: blockIndex}
+		_, err := npr.pool.Upstream.Fetch(loc, npr.blockBuf[:blockSize])
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := blockIndex + 1; i <= blockIndex+npr.readAhead && i < npr.numBlocks; i++ {
+		if _, ok := npr.prefetches[i]; ok {
+			continue
+		}
+		if !npr.startPrefetch(i) {
+			// out of memory budget
+			break
+		}
+	}
+	return nil
+}
+
+// startPrefetch fetches a block in the background, if there's a
+// free buffer for it
+func (npr *Reader) startPrefetch(blockIndex int64) bool {
+	var p *prefetch
+	select {
+	case buf := <-npr.freeBufs:
+		if buf == nil {
+			buf = make([]byte, BigBlockSize)
+		}
+		p = &prefetch{
+			blockIndex: blockIndex,
+			buf:        buf,
+			source:     <-npr.freeSources,
+			done:       make(chan struct{}),
+		}
+	default:
+		return false
+	}
+
+	if p.source == nil {
+		p.source = npr.pool.Upstream.Clone()
+	}
+
+	npr.prefetches[blockIndex] = p
+	npr.inFlight.Add(1)
+
+	loc := BlockLocation{FileIndex: npr.fileIndex, BlockIndex: blockIndex}
+	blockSize := ComputeBlockSize(npr.size, blockIndex)
+	go func() {
+		defer npr.inFlight.Done()
+		_, p.err = p.source.Fetch(loc, p.buf[:blockSize])
+		close(p.done)
+	}()
+	return true
+}
+
+// cancelPrefetches throws away prefetches that won't be read next when
+// reading from blockIndex onwards. A negative blockIndex cancels everything.
+func (npr *Reader) cancelPrefetches(blockIndex int64) {
+	for i, p := range npr.prefetches {
+		if blockIndex >= 0 && i >= blockIndex && i <= blockIndex+npr.readAhead {
+			continue
+		}
+
+		delete(npr.prefetches, i)
+		npr.pool.readAheadStats.Cancelled++
+		go func(p *prefetch) {
+			// fetches can't be interrupted, but their results can be ignored
+			<-p.done
+			npr.release(p)
+		}(p)
+	}
+}
+
+// release makes a prefetch's buffer and source available again
+func (npr *Reader) release(p *prefetch) {
+	npr.freeBufs <- p.buf
+	npr.freeSources <- p.source
+}
@@ -0,0 +1,124 @@
+package blockpool
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// memorySource serves blocks from in-memory files, counting fetches
+type memorySource struct {
+	container *tlc.Container
+	files     [][]byte
+	fetches   *int64
+}
+
+var _ Source = (*memorySource)(nil)
+
+func (ms *memorySource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	atomic.AddInt64(ms.fetches, 1)
+	file := ms.files[loc.FileIndex]
+	return copy(data, file[loc.BlockIndex*BigBlockSize:]), nil
+}
+
+func (ms *memorySource) GetContainer() *tlc.Container {
+	return ms.container
+}
+
+func (ms *memorySource) Clone() Source {
+	return ms
+}
+
+func Test_ReaderReadAhead(t *testing.T) {
+	data := make([]byte, BigBlockSize*6+14)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "big", Size: int64(len(data)), Mode: 0644}},
+		Size:  int64(len(data)),
+	}
+
+	var fetches int64
+	source := &DelayedSource{
+		Source: &memorySource{
+			container: container,
+			files:     [][]byte{data},
+			fetches:   &fetches,
+		},
+		Latency: 20 * time.Millisecond,
+	}
+
+	readAll := func(pool *BlockPool) time.Duration {
+		r, err := pool.GetReadSeeker(0)
+		wtest.Must(t, err)
+
+		startTime := time.Now()
+		actual, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(data, actual), "should read identical data")
+		wtest.Must(t, pool.Close())
+		return time.Since(startTime)
+	}
+
+	sequential := &BlockPool{
+		Container: container,
+		Upstream:  source,
+	}
+	sequentialDuration := readAll(sequential)
+	assert.EqualValues(t, ReadAheadStats{}, sequential.ReadAheadStats())
+
+	readAhead := &BlockPool{
+		Container: container,
+		Upstream:  source,
+		ReadAhead: 4,
+	}
+	readAheadDuration := readAll(readAhead)
+	t.Logf("sequential: %s, read-ahead: %s", sequentialDuration, readAheadDuration)
+
+	stats := readAhead.ReadAheadStats()
+	assert.EqualValues(t, 1, stats.Misses)
+	assert.EqualValues(t, 6, stats.Hits)
+	assert.True(t, readAheadDuration < sequentialDuration, "read-ahead should be faster")
+
+	t.Logf("Seeking around")
+	bounded := &BlockPool{
+		Container:         container,
+		Upstream:          source,
+		ReadAhead:         4,
+		ReadAheadMaxBytes: BigBlockSize * 2,
+	}
+	r, err := bounded.GetReadSeeker(0)
+	wtest.Must(t, err)
+
+	atomic.StoreInt64(&fetches, 0)
+	buf := make([]byte, 16)
+	for _, offset := range []int64{10, BigBlockSize + 3, BigBlockSize*5 + 1, BigBlockSize*6 + 2, 0} {
+		_, err := r.Seek(offset, io.SeekStart)
+		wtest.Must(t, err)
+
+		readBytes, err := io.ReadFull(r, buf)
+		if err != io.ErrUnexpectedEOF {
+			wtest.Must(t, err)
+		}
+		assert.True(t, bytes.Equal(data[offset:offset+int64(readBytes)], buf[:readBytes]), "should read data at %d", offset)
+	}
+	wtest.Must(t, bounded.Close())
+
+	stats = bounded.ReadAheadStats()
+	t.Logf("stats: %+v, fetches: %d", stats, atomic.LoadInt64(&fetches))
+	// cancelled prefetches hold onto their buffer until they're done,
+	// so whether the last block was prefetched depends on timing
+	assert.True(t, stats.Hits >= 1)
+	assert.EqualValues(t, 5, stats.Hits+stats.Misses)
+	assert.True(t, stats.Cancelled > 0)
+	assert.True(t, atomic.LoadInt64(&fetches) <= 5+2*5, "memory budget should bound prefetches")
+}
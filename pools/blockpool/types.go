@@ -30,6 +30,21 @@ type Sink interface {
 	Clone() Sink
 }
 
+// A HasSink is a Sink that can tell which blocks it already stores, so that
+// writers can skip storing them again. See BlockPool.DedupBatchSize.
+type HasSink interface {
+	Sink
+
+	// Has returns, for each address (as in BlockAddressMap), whether that
+	// block is already stored. It must be safe to call concurrently with Store.
+	Has(addrs []string) ([]bool, error)
+
+	// Skip is called instead of Store for blocks that are already stored,
+	// so that the sink can record their hash like Store would, and refresh
+	// the block so a garbage collector doesn't sweep it.
+	Skip(location BlockLocation, hash []byte) error
+}
+
 // A BlockLocation determines where a block lies in a given container (at which
 // offset of which file).
 type BlockLocation struct {
@@ -0,0 +1,176 @@
+package blockpool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/crc32c"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+)
+
+// ErrCorruptedBlock is returned when a fetched block doesn't match the
+// hash it was expected to have
+type ErrCorruptedBlock struct {
+	Location BlockLocation
+	Address  string
+}
+
+var _ error = (*ErrCorruptedBlock)(nil)
+
+func (e *ErrCorruptedBlock) Error() string {
+	return fmt.Sprintf("block %+v is corrupted: contents don't match address %s", e.Location, e.Address)
+}
+
+// IsCorruptedBlock returns true if err is (or wraps) an *ErrCorruptedBlock
+func IsCorruptedBlock(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *ErrCorruptedBlock:
+			return true
+		case *errors.Error:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
+
+// A RefetchingSource can fetch a block again, bypassing whatever it got
+// the first copy from (a mirror, a cache, etc.)
+type RefetchingSource interface {
+	Source
+
+	Refetch(location BlockLocation, data []byte) (readBytes int, err error)
+}
+
+const defaultMaxRefetches = 2
+
+// A ValidatingSource checks blocks fetched from the underlying source against
+// their hash, as read from a manifest. Corrupted blocks are fetched again if
+// the underlying source is a RefetchingSource, up to MaxRefetches times.
+// Otherwise, Fetch fails with an *ErrCorruptedBlock.
+type ValidatingSource struct {
+	// required
+	Source      Source
+	BlockHashes *BlockHashMap
+
+	// optional
+
+	// Algorithm is the algorithm BlockHashes were computed with, shake128-32
+	// by default. CRC32C hashes are 4 bytes, big-endian.
+	Algorithm pwr.HashAlgorithm
+	// MaxRefetches is how many times a corrupted block is fetched again (default 2)
+	MaxRefetches int
+	Consumer     *state.Consumer
+
+	// internal
+	hasher blockHasher
+}
+
+var _ Source = (*ValidatingSource)(nil)
+
+// Clone returns a copy of this validating source, validating a clone
+// of the underlying source
+func (vs *ValidatingSource) Clone() Source {
+	return &ValidatingSource{
+		Source:      vs.Source.Clone(),
+		BlockHashes: vs.BlockHashes,
+
+		Algorithm:    vs.Algorithm,
+		MaxRefetches: vs.MaxRefetches,
+		Consumer:     vs.Consumer,
+	}
+}
+
+// Fetch fetches a block from the underlying source and checks its hash
+func (vs *ValidatingSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	expected := vs.BlockHashes.Get(loc)
+	if expected == nil {
+		return 0, errors.Wrap(fmt.Errorf("no hash for block %+v", loc), 1)
+	}
+
+	readBytes, err := vs.Source.Fetch(loc, data)
+	if err != nil {
+		return 0, err
+	}
+
+	ok, err := vs.matches(data[:readBytes], expected)
+	if err != nil {
+		return 0, errors.Wrap(err, 1)
+	}
+	if ok {
+		return readBytes, nil
+	}
+
+	corrupted := &ErrCorruptedBlock{
+		Location: loc,
+		Address:  vs.address(expected, len(data)),
+	}
+
+	rs, canRefetch := vs.Source.(RefetchingSource)
+	if !canRefetch {
+		return 0, errors.Wrap(corrupted, 1)
+	}
+
+	maxRefetches := vs.MaxRefetches
+	if maxRefetches <= 0 {
+		maxRefetches = defaultMaxRefetches
+	}
+
+	for i := 0; i < maxRefetches; i++ {
+		vs.logf("%s, fetching it again", corrupted.Error())
+
+		readBytes, err = rs.Refetch(loc, data)
+		if err != nil {
+			return 0, err
+		}
+
+		ok, err := vs.matches(data[:readBytes], expected)
+		if err != nil {
+			return 0, errors.Wrap(err, 1)
+		}
+		if ok {
+			return readBytes, nil
+		}
+	}
+
+	return 0, errors.Wrap(corrupted, 1)
+}
+
+func (vs *ValidatingSource) matches(data []byte, expected []byte) (bool, error) {
+	switch vs.Algorithm {
+	case pwr.HashAlgorithm_SHAKE128_32:
+		return bytes.Equal(vs.hasher.sum(data), expected), nil
+	case pwr.HashAlgorithm_CRC32C:
+		actual := make([]byte, 4)
+		binary.BigEndian.PutUint32(actual, crc32.Checksum(data, crc32c.Table))
+		return bytes.Equal(actual, expected), nil
+	}
+	return false, fmt.Errorf("unsupported hash algorithm %s", vs.Algorithm)
+}
+
+// address formats a block address the way DiskSink does (for shake128-32),
+// for error messages
+func (vs *ValidatingSource) address(hash []byte, size int) string {
+	algo := "shake128-32"
+	if vs.Algorithm == pwr.HashAlgorithm_CRC32C {
+		algo = "crc32c"
+	}
+	return fmt.Sprintf("%s/%x/%d", algo, hash, size)
+}
+
+// GetContainer returns the underlying source's container
+func (vs *ValidatingSource) GetContainer() *tlc.Container {
+	return vs.Source.GetContainer()
+}
+
+func (vs *ValidatingSource) logf(msg string, args ...interface{}) {
+	if vs.Consumer != nil {
+		vs.Consumer.Debugf(msg, args...)
+	}
+}
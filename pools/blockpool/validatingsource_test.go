@@ -0,0 +1,121 @@
+package blockpool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/itchio/wharf/crc32c"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptingSource flips a byte of the first few blocks it returns
+type corruptingSource struct {
+	Source
+	corruptions int
+}
+
+func (cs *corruptingSource) Fetch(loc BlockLocation, data []byte) (int, error) {
+	readBytes, err := cs.Source.Fetch(loc, data)
+	if err == nil && cs.corruptions > 0 {
+		cs.corruptions--
+		data[0] ^= 0xff
+	}
+	return readBytes, err
+}
+
+// refetchingSource is a corruptingSource that can be asked to fetch again
+type refetchingSource struct {
+	*corruptingSource
+	refetches int
+}
+
+var _ RefetchingSource = (*refetchingSource)(nil)
+
+func (rs *refetchingSource) Refetch(loc BlockLocation, data []byte) (int, error) {
+	rs.refetches++
+	return rs.Fetch(loc, data)
+}
+
+func Test_ValidatingSource(t *testing.T) {
+	data := make([]byte, BigBlockSize+14)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "file", Size: int64(len(data)), Mode: 0644}},
+		Size:  int64(len(data)),
+	}
+
+	var fetches int64
+	source := &memorySource{
+		container: container,
+		files:     [][]byte{data},
+		fetches:   &fetches,
+	}
+
+	hasher := &blockHasher{}
+	shakeHashes := NewBlockHashMap()
+	crcHashes := NewBlockHashMap()
+	for blockIndex := int64(0); blockIndex < ComputeNumBlocks(int64(len(data))); blockIndex++ {
+		loc := BlockLocation{FileIndex: 0, BlockIndex: blockIndex}
+		block := data[blockIndex*BigBlockSize : blockIndex*BigBlockSize+ComputeBlockSize(int64(len(data)), blockIndex)]
+
+		shakeHashes.Set(loc, append([]byte{}, hasher.sum(block)...))
+
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.Checksum(block, crc32c.Table))
+		crcHashes.Set(loc, crc)
+	}
+
+	fetch := func(vs *ValidatingSource, blockIndex int64) ([]byte, error) {
+		buf := make([]byte, ComputeBlockSize(int64(len(data)), blockIndex))
+		readBytes, err := vs.Fetch(BlockLocation{FileIndex: 0, BlockIndex: blockIndex}, buf)
+		return buf[:readBytes], err
+	}
+
+	for _, algorithm := range []pwr.HashAlgorithm{pwr.HashAlgorithm_SHAKE128_32, pwr.HashAlgorithm_CRC32C} {
+		blockHashes := shakeHashes
+		if algorithm == pwr.HashAlgorithm_CRC32C {
+			blockHashes = crcHashes
+		}
+
+		t.Logf("Healthy source (%s)", algorithm)
+		vs := &ValidatingSource{
+			Source:      source,
+			BlockHashes: blockHashes,
+			Algorithm:   algorithm,
+		}
+		block, err := fetch(vs, 1)
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(data[BigBlockSize:], block))
+
+		t.Logf("Corrupted source")
+		vs.Source = &corruptingSource{Source: source, corruptions: 1}
+		_, err = fetch(vs, 0)
+		assert.Error(t, err)
+		assert.True(t, IsCorruptedBlock(err))
+		assert.Contains(t, err.Error(), vs.address(blockHashes.Get(BlockLocation{FileIndex: 0, BlockIndex: 0}), int(BigBlockSize)))
+
+		t.Logf("Refetching source")
+		rs := &refetchingSource{corruptingSource: &corruptingSource{Source: source, corruptions: 2}}
+		vs.Source = rs
+		block, err = fetch(vs, 0)
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(data[:BigBlockSize], block))
+		assert.EqualValues(t, 2, rs.refetches)
+
+		t.Logf("Refetching doesn't go on forever")
+		rs = &refetchingSource{corruptingSource: &corruptingSource{Source: source, corruptions: 10}}
+		vs.Source = rs
+		vs.MaxRefetches = 3
+		_, err = fetch(vs, 0)
+		assert.True(t, IsCorruptedBlock(err))
+		assert.EqualValues(t, 3, rs.refetches)
+	}
+}
@@ -17,9 +17,23 @@ type Writer struct {
 	size     int64
 	blockBuf []byte
 
+	// dedup, see BlockPool.DedupBatchSize
+	hasSink   HasSink
+	hasher    blockHasher
+	pending   []pendingBlock
+	spareBufs [][]byte
+
 	closed bool
 }
 
+// a pendingBlock is waiting for an existence check before being stored
+type pendingBlock struct {
+	loc  BlockLocation
+	addr string
+	hash []byte
+	data []byte
+}
+
 var _ io.WriteCloser = (*Writer)(nil)
 
 // Write is an io.Writer-compliant implementation
@@ -45,7 +59,7 @@ func (npw *Writer) Write(buf []byte) (int, error) {
 		copy(npw.blockBuf[blockBufOffset:], buf[bufOffset:bufOffset+bytesWritten])
 
 		if writeEnd%BigBlockSize == 0 {
-			err := npw.Pool.Downstream.Store(BlockLocation{FileIndex: npw.FileIndex, BlockIndex: blockIndex}, npw.blockBuf)
+			err := npw.store(BlockLocation{FileIndex: npw.FileIndex, BlockIndex: blockIndex}, npw.blockBuf)
 			if err != nil {
 				return 0, errors.Wrap(err, 1)
 			}
@@ -72,11 +86,94 @@ func (npw *Writer) Close() error {
 
 	if blockBufOffset > 0 {
 		blockIndex := npw.offset / BigBlockSize
-		err := npw.Pool.Downstream.Store(BlockLocation{FileIndex: npw.FileIndex, BlockIndex: blockIndex}, npw.blockBuf[:blockBufOffset])
+		err := npw.store(BlockLocation{FileIndex: npw.FileIndex, BlockIndex: blockIndex}, npw.blockBuf[:blockBufOffset])
 		if err != nil {
 			return errors.Wrap(err, 1)
 		}
 	}
 
+	return npw.flush()
+}
+
+// store stores a block downstream, or queues it for an existence check
+// when deduplicating
+func (npw *Writer) store(loc BlockLocation, data []byte) error {
+	if npw.hasSink == nil {
+		return npw.Pool.Downstream.Store(loc, data)
+	}
+
+	var buf []byte
+	if len(npw.spareBufs) > 0 {
+		buf = npw.spareBufs[len(npw.spareBufs)-1]
+		npw.spareBufs = npw.spareBufs[:len(npw.spareBufs)-1]
+	} else {
+		buf = make([]byte, BigBlockSize)
+	}
+
+	hash := append([]byte{}, npw.hasher.sum(data)...)
+	npw.pending = append(npw.pending, pendingBlock{
+		loc:  loc,
+		addr: fmt.Sprintf("shake128-32/%x/%d", hash, len(data)),
+		hash: hash,
+		data: append(buf[:0], data...),
+	})
+
+	if len(npw.pending) >= npw.Pool.DedupBatchSize {
+		return npw.flush()
+	}
+	return nil
+}
+
+// flush checks which pending blocks are already stored, in one lookup, and
+// stores the others
+func (npw *Writer) flush() error {
+	if len(npw.pending) == 0 {
+		return nil
+	}
+
+	pool := npw.Pool
+	if pool.knownAddresses == nil {
+		pool.knownAddresses = make(map[string]bool)
+	}
+
+	var addrs []string
+	for _, pb := range npw.pending {
+		if !pool.knownAddresses[pb.addr] {
+			addrs = append(addrs, pb.addr)
+		}
+	}
+
+	stored := make(map[string]bool)
+	if len(addrs) > 0 {
+		pool.dedupStats.Lookups++
+		has, err := npw.hasSink.Has(addrs)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		for i, addr := range addrs {
+			stored[addr] = has[i]
+		}
+	}
+
+	for _, pb := range npw.pending {
+		var err error
+		if pool.knownAddresses[pb.addr] || stored[pb.addr] {
+			pool.dedupStats.SkippedBlocks++
+			pool.dedupStats.SkippedBytes += int64(len(pb.data))
+			err = npw.hasSink.Skip(pb.loc, pb.hash)
+		} else {
+			pool.dedupStats.StoredBlocks++
+			pool.dedupStats.StoredBytes += int64(len(pb.data))
+			err = npw.hasSink.Store(pb.loc, pb.data)
+		}
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		pool.knownAddresses[pb.addr] = true
+		npw.spareBufs = append(npw.spareBufs, pb.data[:cap(pb.data)])
+	}
+
+	npw.pending = npw.pending[:0]
 	return nil
 }
@@ -0,0 +1,429 @@
+package cachepool
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+)
+
+// An EvictablePool is a cache that files can be removed from, like an FsPool
+type EvictablePool interface {
+	wsync.WritablePool
+
+	Remove(fileIndex int64) error
+}
+
+// BoundedOptions determines how much a BoundedCachePool keeps, and how it preloads
+type BoundedOptions struct {
+	// MaxBytes is how much the cache should hold at most. Files being read
+	// or preloaded are never evicted, so a file bigger than MaxBytes still
+	// works, the cache just goes over the limit while it's in use.
+	MaxBytes int64
+
+	// Parallelism is how many files PreloadInBackground copies at once, 1 if 0
+	Parallelism int
+
+	// OpenSource returns a new pool reading from the same place as the
+	// source. Pools only support one reader at a time, so it's needed for
+	// files to be copied concurrently. If nil, copies take turns on the
+	// source passed to NewBounded.
+	OpenSource func() (wsync.Pool, error)
+}
+
+// Stats tells how useful a BoundedCachePool has been
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Preloaded is the number of files copied by PreloadInBackground
+	Preloaded int64
+	// UsedBytes is the size of the files in the cache right now
+	UsedBytes int64
+}
+
+type fileState int
+
+const (
+	fileMissing fileState = iota
+	fileLoading
+	fileCached
+)
+
+type cachedFile struct {
+	fileIndex int64
+	state     fileState
+	// pins counts readers and preloads using the file, pinned files aren't evicted
+	pins int
+	// el is the file's place in the LRU list, while it's cached
+	el *list.Element
+}
+
+// A BoundedCachePool is like a CachePool, except the cache holds at most
+// a given number of bytes: least recently used files are evicted to make
+// room, and copied again from the source if they're needed later. Files
+// that aren't cached yet are copied on first read, so Preload is optional.
+type BoundedCachePool struct {
+	container *tlc.Container
+	source    wsync.Pool
+	cache     EvictablePool
+	opts      BoundedOptions
+
+	sourceMutex sync.Mutex
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	files     []*cachedFile
+	lru       *list.List
+	usedBytes int64
+	reading   int64
+	stats     Stats
+	closed    bool
+
+	preloads   sync.WaitGroup
+	preloadErr error
+}
+
+var _ wsync.Pool = (*BoundedCachePool)(nil)
+
+// NewBounded creates a cachepool that reads from source and stores in
+// cache as an intermediary, keeping the cache under opts.MaxBytes
+func NewBounded(c *tlc.Container, source wsync.Pool, cache EvictablePool, opts BoundedOptions) *BoundedCachePool {
+	cp := &BoundedCachePool{
+		container: c,
+		source:    source,
+		cache:     cache,
+		opts:      opts,
+
+		files:   make([]*cachedFile, len(c.Files)),
+		lru:     list.New(),
+		reading: -1,
+	}
+	cp.cond = sync.NewCond(&cp.mutex)
+
+	for i := range cp.files {
+		cp.files[i] = &cachedFile{fileIndex: int64(i)}
+	}
+
+	return cp
+}
+
+// Stats returns the pool's statistics so far
+func (cp *BoundedCachePool) Stats() Stats {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	stats := cp.stats
+	stats.UsedBytes = cp.usedBytes
+	return stats
+}
+
+// Preload copies a file from source to cache now, unless it's already
+// cached. It may evict other files to make room.
+func (cp *BoundedCachePool) Preload(fileIndex int64) error {
+	err := cp.acquire(fileIndex, false)
+	if err != nil {
+		return err
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.files[fileIndex].pins--
+	return nil
+}
+
+// PreloadInBackground starts copying files from source to cache, in
+// order, opts.Parallelism at a time. Background preloads only use free
+// space: they never evict anything, files that don't fit are skipped and
+// copied on first read instead. Use WaitPreloads to know when they're done.
+func (cp *BoundedCachePool) PreloadInBackground(fileIndices []int64) {
+	parallelism := cp.opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	cp.preloads.Add(1)
+	go func() {
+		defer cp.preloads.Done()
+
+		sem := make(chan struct{}, parallelism)
+		var wg sync.WaitGroup
+
+		for _, fileIndex := range fileIndices {
+			sem <- struct{}{}
+
+			// reserving here rather than in the goroutine keeps preloads in order
+			f, ok := cp.reserve(fileIndex)
+			if !ok {
+				<-sem
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				err := cp.copy(f.fileIndex)
+
+				cp.mutex.Lock()
+				defer cp.mutex.Unlock()
+
+				err = cp.finishLoad(f, err)
+				if err != nil {
+					if cp.preloadErr == nil {
+						cp.preloadErr = err
+					}
+					return
+				}
+
+				cp.stats.Preloaded++
+				f.pins--
+			}()
+		}
+
+		wg.Wait()
+	}()
+}
+
+// WaitPreloads waits for all background preloads to be done, and returns
+// the first error they ran into, if any
+func (cp *BoundedCachePool) WaitPreloads() error {
+	cp.preloads.Wait()
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	return cp.preloadErr
+}
+
+// GetReader returns a reader for the file at index fileIndex, copying
+// it to the cache first if needed
+func (cp *BoundedCachePool) GetReader(fileIndex int64) (io.Reader, error) {
+	rs, err := cp.GetReadSeeker(fileIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return rs, nil
+}
+
+// GetReadSeeker is a version of GetReader that returns an io.ReadSeeker.
+// The file stays pinned in the cache until another file is read.
+func (cp *BoundedCachePool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
+	// the previous reader is done, it may be evicted to make room
+	cp.mutex.Lock()
+	if cp.reading >= 0 {
+		cp.files[cp.reading].pins--
+		cp.reading = -1
+	}
+	cp.mutex.Unlock()
+
+	err := cp.acquire(fileIndex, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.reading = fileIndex
+
+	return cp.cache.GetReadSeeker(fileIndex)
+}
+
+// GetSize returns the size of the file at index fileIndex
+func (cp *BoundedCachePool) GetSize(fileIndex int64) int64 {
+	return cp.container.Files[fileIndex].Size
+}
+
+// Close stops background preloads, waits for them, then attempts to
+// close both the source and the cache, relaying any error it encounters.
+// Cached files are left in the cache.
+func (cp *BoundedCachePool) Close() error {
+	cp.mutex.Lock()
+	cp.closed = true
+	cp.cond.Broadcast()
+	cp.mutex.Unlock()
+
+	cp.preloads.Wait()
+
+	err := cp.source.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = cp.cache.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return nil
+}
+
+// acquire makes sure a file is in the cache and pins it. Reads count
+// towards hits and misses, preloads don't.
+func (cp *BoundedCachePool) acquire(fileIndex int64, read bool) error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	f := cp.files[fileIndex]
+	for f.state == fileLoading {
+		// if it fails, we'll try ourselves
+		cp.cond.Wait()
+	}
+
+	if cp.closed {
+		return errors.Wrap(fmt.Errorf("cachepool: closed"), 0)
+	}
+
+	if f.state == fileCached {
+		if read {
+			cp.stats.Hits++
+		}
+		f.pins++
+		cp.lru.MoveToFront(f.el)
+		return nil
+	}
+
+	if read {
+		cp.stats.Misses++
+	}
+
+	err := cp.evict(cp.GetSize(fileIndex))
+	if err != nil {
+		return err
+	}
+
+	return cp.load(f)
+}
+
+// reserve starts loading a file in the background, if it fits in the
+// free space. The file is pinned.
+func (cp *BoundedCachePool) reserve(fileIndex int64) (*cachedFile, bool) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	f := cp.files[fileIndex]
+	if cp.closed || f.state != fileMissing {
+		return nil, false
+	}
+
+	if cp.usedBytes+cp.GetSize(fileIndex) > cp.opts.MaxBytes {
+		return nil, false
+	}
+
+	cp.startLoad(f)
+	return f, true
+}
+
+// load copies a file to the cache and pins it. The mutex must be held,
+// it's released during the copy.
+func (cp *BoundedCachePool) load(f *cachedFile) error {
+	cp.startLoad(f)
+
+	cp.mutex.Unlock()
+	err := cp.copy(f.fileIndex)
+	cp.mutex.Lock()
+
+	return cp.finishLoad(f, err)
+}
+
+// startLoad marks a file as loading, counts it towards used bytes and
+// pins it. The mutex must be held.
+func (cp *BoundedCachePool) startLoad(f *cachedFile) {
+	f.state = fileLoading
+	f.pins++
+	cp.usedBytes += cp.GetSize(f.fileIndex)
+}
+
+// finishLoad marks a file as cached, or missing again if copying it
+// failed, and wakes up anyone waiting for it. The mutex must be held.
+func (cp *BoundedCachePool) finishLoad(f *cachedFile, err error) error {
+	defer cp.cond.Broadcast()
+
+	if err != nil {
+		f.state = fileMissing
+		f.pins--
+		cp.usedBytes -= cp.GetSize(f.fileIndex)
+		return err
+	}
+
+	f.state = fileCached
+	f.el = cp.lru.PushFront(f)
+	return nil
+}
+
+// evict removes least recently used files that aren't pinned, until
+// there's room for size more bytes or nothing else can be evicted.
+// The mutex must be held.
+func (cp *BoundedCachePool) evict(size int64) error {
+	el := cp.lru.Back()
+	for el != nil && cp.usedBytes+size > cp.opts.MaxBytes {
+		prev := el.Prev()
+
+		f := el.Value.(*cachedFile)
+		if f.pins == 0 {
+			err := cp.cache.Remove(f.fileIndex)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			cp.lru.Remove(el)
+			f.el = nil
+			f.state = fileMissing
+			cp.usedBytes -= cp.GetSize(f.fileIndex)
+			cp.stats.Evictions++
+		}
+
+		el = prev
+	}
+
+	return nil
+}
+
+// copy copies a file from source to cache
+func (cp *BoundedCachePool) copy(fileIndex int64) error {
+	source := cp.source
+	if cp.opts.OpenSource != nil {
+		var err error
+		source, err = cp.opts.OpenSource()
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		defer source.Close()
+	} else {
+		cp.sourceMutex.Lock()
+		defer cp.sourceMutex.Unlock()
+	}
+
+	reader, err := source.GetReader(fileIndex)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	writer, err := cp.cache.GetWriter(fileIndex)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		writer.Close()
+		return errors.Wrap(err, 0)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package cachepool
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingPool counts the files read from a pool
+type countingPool struct {
+	wsync.Pool
+	reads *int64
+}
+
+func (cp *countingPool) GetReader(fileIndex int64) (io.Reader, error) {
+	atomic.AddInt64(cp.reads, 1)
+	return cp.Pool.GetReader(fileIndex)
+}
+
+func Test_BoundedCachePool(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "cachepool")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	fileSize := wtest.BlockSize * 4
+	sourceDir := filepath.Join(mainDir, "source")
+	wtest.MakeTestDir(t, sourceDir, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "a", Seed: 0x1, Size: fileSize},
+			{Path: "b", Seed: 0x2, Size: fileSize},
+			{Path: "subdir/c", Seed: 0x3, Size: fileSize},
+			{Path: "subdir/d", Seed: 0x4, Size: fileSize},
+		},
+	})
+
+	container, err := tlc.WalkAny(sourceDir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	var reads int64
+	openSource := func() (wsync.Pool, error) {
+		return &countingPool{
+			Pool:  fspool.New(container, sourceDir),
+			reads: &reads,
+		}, nil
+	}
+
+	cacheDir := filepath.Join(mainDir, "cache")
+	newPool := func(opts BoundedOptions) *BoundedCachePool {
+		wtest.Must(t, os.RemoveAll(cacheDir))
+		atomic.StoreInt64(&reads, 0)
+		source, err := openSource()
+		wtest.Must(t, err)
+		return NewBounded(container, source, fspool.New(container, cacheDir), opts)
+	}
+
+	checkRead := func(cp *BoundedCachePool, fileIndex int64) {
+		r, err := cp.GetReader(fileIndex)
+		wtest.Must(t, err)
+		actual, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+
+		f := container.Files[fileIndex]
+		expected, err := ioutil.ReadFile(filepath.Join(sourceDir, filepath.FromSlash(f.Path)))
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(expected, actual), "%s should be identical", f.Path)
+	}
+
+	isCached := func(fileIndex int64) bool {
+		_, err := os.Stat(filepath.Join(cacheDir, filepath.FromSlash(container.Files[fileIndex].Path)))
+		return err == nil
+	}
+
+	t.Logf("Evicting least recently used files")
+	{
+		cp := newPool(BoundedOptions{MaxBytes: fileSize * 2})
+
+		checkRead(cp, 0)
+		checkRead(cp, 1)
+		checkRead(cp, 0)
+		checkRead(cp, 2)
+		assert.True(t, isCached(0))
+		assert.False(t, isCached(1), "b should have been evicted")
+		assert.True(t, isCached(2))
+
+		checkRead(cp, 1)
+		stats := cp.Stats()
+		assert.EqualValues(t, 1, stats.Hits)
+		assert.EqualValues(t, 4, stats.Misses)
+		assert.EqualValues(t, 2, stats.Evictions)
+		assert.EqualValues(t, fileSize*2, stats.UsedBytes)
+		assert.EqualValues(t, 4, atomic.LoadInt64(&reads))
+		wtest.Must(t, cp.Close())
+	}
+
+	t.Logf("Not evicting the file being read")
+	{
+		cp := newPool(BoundedOptions{MaxBytes: fileSize / 2})
+
+		r, err := cp.GetReadSeeker(3)
+		wtest.Must(t, err)
+		wtest.Must(t, cp.Preload(0))
+		assert.True(t, isCached(3))
+		assert.EqualValues(t, 0, cp.Stats().Evictions)
+
+		_, err = r.Seek(0, io.SeekStart)
+		wtest.Must(t, err)
+		data, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+		assert.EqualValues(t, fileSize, len(data))
+
+		checkRead(cp, 1)
+		assert.False(t, isCached(0))
+		assert.False(t, isCached(3))
+		assert.EqualValues(t, 2, cp.Stats().Evictions)
+		wtest.Must(t, cp.Close())
+	}
+
+	t.Logf("Preloading in the background")
+	{
+		cp := newPool(BoundedOptions{
+			MaxBytes:    fileSize * 3,
+			Parallelism: 2,
+			OpenSource:  openSource,
+		})
+
+		cp.PreloadInBackground([]int64{0, 1, 2, 3})
+		wtest.Must(t, cp.WaitPreloads())
+
+		stats := cp.Stats()
+		assert.EqualValues(t, 3, stats.Preloaded, "only files that fit should be preloaded")
+		assert.EqualValues(t, 0, stats.Evictions)
+		assert.EqualValues(t, fileSize*3, stats.UsedBytes)
+
+		for i := range container.Files {
+			checkRead(cp, int64(i))
+		}
+		stats = cp.Stats()
+		assert.EqualValues(t, 3, stats.Hits)
+		assert.EqualValues(t, 1, stats.Misses)
+		assert.EqualValues(t, 4, atomic.LoadInt64(&reads))
+		wtest.Must(t, cp.Close())
+	}
+}
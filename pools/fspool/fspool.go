@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
 )
@@ -133,10 +134,20 @@ func (cfp *FsPool) Close() error {
 	return nil
 }
 
+// GetWriter returns a writer for the file at index fileIndex, truncating it.
+// It refuses to write outside of the FsPool's base path (see safepath.Join).
 func (cfp *FsPool) GetWriter(fileIndex int64) (io.WriteCloser, error) {
-	path := cfp.GetPath(fileIndex)
+	path, err := safepath.Join(cfp.basePath, cfp.container.Files[fileIndex].Path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.FileMode(0755))
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
 
-	err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755))
+	err = safepath.RemoveIfSymlink(path)
 	if err != nil {
 		return nil, errors.Wrap(err, 0)
 	}
@@ -149,3 +160,29 @@ func (cfp *FsPool) GetWriter(fileIndex int64) (io.WriteCloser, error) {
 
 	return f, nil
 }
+
+// Remove deletes the file at index fileIndex from disk, closing it first if
+// it's the one currently open for reading. Removing a missing file is not an error.
+func (cfp *FsPool) Remove(fileIndex int64) error {
+	if cfp.reader != nil && cfp.fileIndex == fileIndex {
+		err := cfp.reader.Close()
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		cfp.reader = nil
+		cfp.fileIndex = -1
+	}
+
+	path, err := safepath.Join(cfp.basePath, cfp.container.Files[fileIndex].Path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, 0)
+	}
+
+	return nil
+}
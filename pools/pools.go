@@ -1,6 +1,7 @@
 package pools
 
 import (
+	"io"
 	"strings"
 
 	"github.com/itchio/arkive/zip"
@@ -10,6 +11,7 @@ import (
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pools/tarpool"
 	"github.com/itchio/wharf/pools/zippool"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
@@ -47,8 +49,53 @@ func New(c *tlc.Container, basePath string) (wsync.Pool, error) {
 		return zippool.New(c, zr), nil
 	}
 
+	if compression, ok := tlc.TarCompressionOf(targetInfo.Name()); ok {
+		if compression == tlc.TarNone {
+			return tarpool.New(c, fr, targetInfo.Size())
+		}
+
+		err := fr.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+
+		return tarpool.NewSequential(c, func() (io.ReadCloser, error) {
+			return openTar(basePath, compression)
+		}), nil
+	}
+
 	// assume single-file container
 	fsp := fspool.New(c, filepath.Dir(basePath))
 	fsp.UniqueReader = fr
 	return fsp, nil
 }
+
+type tarStream struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (ts *tarStream) Close() error {
+	err := ts.ReadCloser.Close()
+	if fErr := ts.file.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
+
+// openTar returns a stream of the uncompressed contents of a tar, that
+// closes the file along with the decompressor
+func openTar(tarPath string, compression tlc.TarCompression) (io.ReadCloser, error) {
+	f, err := eos.Open(tarPath)
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+
+	tr, err := tlc.NewTarDecompressor(f, compression)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, 1)
+	}
+
+	return &tarStream{ReadCloser: tr, file: f}, nil
+}
@@ -0,0 +1,263 @@
+package tarpool
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+)
+
+// OpenFunc returns a fresh stream of the uncompressed contents of a tar
+type OpenFunc func() (io.ReadCloser, error)
+
+// TarPool implements wsync.Pool for a tar archive. Uncompressed tars are
+// indexed once, then read at random. Compressed tars are streamed: reading
+// files in archive order is cheap, going back restarts the stream. The
+// container's order doesn't have to match the archive's.
+type TarPool struct {
+	container *tlc.Container
+
+	// random access
+	r       io.ReaderAt
+	offsets []int64
+
+	// sequential access
+	open       OpenFunc
+	fileByPath map[string]int64
+	stream     io.ReadCloser
+	tr         *tar.Reader
+	// entry is the position in the archive of the last entry read from
+	// the stream, -1 at its start
+	entry int64
+	// positions are those of the files seen so far, since every stream
+	// starts at the beginning, files not in there are further along
+	positions map[int64]int64
+
+	seekFileIndex int64
+	readSeeker    io.ReadSeeker
+}
+
+var _ wsync.Pool = (*TarPool)(nil)
+
+// New returns a TarPool that reads an uncompressed tar at random. It reads
+// all the tar's headers to find where files are.
+func New(c *tlc.Container, r io.ReaderAt, size int64) (*TarPool, error) {
+	fileByPath := make(map[string]int64)
+	for i, f := range c.Files {
+		fileByPath[f.Path] = int64(i)
+	}
+
+	offsets := make([]int64, len(c.Files))
+	for i := range offsets {
+		offsets[i] = -1
+	}
+
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.Wrap(err, 0)
+		}
+
+		fileIndex, ok := fileByPath[tlc.TarEntryKey(header.Name)]
+		if !ok {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeLink {
+			// hard links share the data of an earlier file
+			if targetIndex, ok := fileByPath[tlc.TarEntryKey(header.Linkname)]; ok {
+				offsets[fileIndex] = offsets[targetIndex]
+			}
+			continue
+		}
+
+		if !isRegular(header) {
+			continue
+		}
+
+		// tar.Reader doesn't read ahead: the file's data starts here
+		offset, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		offsets[fileIndex] = offset
+	}
+
+	return &TarPool{
+		container: c,
+		r:         r,
+		offsets:   offsets,
+
+		seekFileIndex: -1,
+	}, nil
+}
+
+// NewSequential returns a TarPool that streams a (typically compressed) tar,
+// calling open every time it needs to start over.
+func NewSequential(c *tlc.Container, open OpenFunc) *TarPool {
+	fileByPath := make(map[string]int64)
+	for i, f := range c.Files {
+		fileByPath[f.Path] = int64(i)
+	}
+
+	return &TarPool{
+		container:  c,
+		open:       open,
+		fileByPath: fileByPath,
+		entry:      -1,
+		positions:  make(map[int64]int64),
+
+		seekFileIndex: -1,
+	}
+}
+
+func isRegular(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA
+}
+
+// GetSize returns the size of the file at index fileIndex
+func (tp *TarPool) GetSize(fileIndex int64) int64 {
+	return tp.container.Files[fileIndex].Size
+}
+
+// GetRelativePath returns the slashed path of a file, relative to
+// the container's root.
+func (tp *TarPool) GetRelativePath(fileIndex int64) string {
+	return tp.container.Files[fileIndex].Path
+}
+
+// GetReader returns an io.Reader for the file at index fileIndex. For
+// sequential pools, it's only valid until the next call.
+func (tp *TarPool) GetReader(fileIndex int64) (io.Reader, error) {
+	if tp.open == nil {
+		return tp.section(fileIndex)
+	}
+
+	if position, ok := tp.positions[fileIndex]; ok && position <= tp.entry {
+		err := tp.closeStream()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tp.stream == nil {
+		stream, err := tp.open()
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		tp.stream = stream
+		tp.tr = tar.NewReader(stream)
+	}
+
+	for {
+		header, err := tp.tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = os.ErrNotExist
+			}
+			return nil, errors.WrapPrefix(err, tp.GetRelativePath(fileIndex), 0)
+		}
+
+		tp.entry++
+
+		current, ok := tp.fileByPath[tlc.TarEntryKey(header.Name)]
+		if !ok || !(isRegular(header) || header.Typeflag == tar.TypeLink) {
+			continue
+		}
+
+		if _, seen := tp.positions[current]; !seen {
+			tp.positions[current] = tp.entry
+		}
+		if current != fileIndex {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeLink {
+			// the data of hard links comes earlier in the stream, with their target
+			targetIndex, ok := tp.fileByPath[tlc.TarEntryKey(header.Linkname)]
+			if !ok {
+				return nil, errors.WrapPrefix(os.ErrNotExist, tp.GetRelativePath(fileIndex), 0)
+			}
+			if _, seen := tp.positions[targetIndex]; !seen {
+				return nil, errors.WrapPrefix(os.ErrNotExist, tp.GetRelativePath(fileIndex), 0)
+			}
+			return tp.GetReader(targetIndex)
+		}
+		return tp.tr, nil
+	}
+}
+
+func (tp *TarPool) section(fileIndex int64) (*io.SectionReader, error) {
+	offset := tp.offsets[fileIndex]
+	if offset < 0 {
+		return nil, errors.WrapPrefix(os.ErrNotExist, tp.GetRelativePath(fileIndex), 1)
+	}
+	return io.NewSectionReader(tp.r, offset, tp.GetSize(fileIndex)), nil
+}
+
+// GetReadSeeker is like GetReader but the returned object allows seeking.
+// Sequential pools read the whole file into memory.
+func (tp *TarPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
+	if tp.open == nil {
+		return tp.section(fileIndex)
+	}
+
+	if tp.seekFileIndex != fileIndex {
+		tp.readSeeker = nil
+		tp.seekFileIndex = -1
+
+		reader, err := tp.GetReader(fileIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		buf, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		if int64(len(buf)) != tp.GetSize(fileIndex) {
+			err = fmt.Errorf("%s: expected %d bytes in tar, got %d", tp.GetRelativePath(fileIndex), tp.GetSize(fileIndex), len(buf))
+			return nil, errors.Wrap(err, 0)
+		}
+
+		tp.readSeeker = bytes.NewReader(buf)
+		tp.seekFileIndex = fileIndex
+	}
+
+	return tp.readSeeker, nil
+}
+
+func (tp *TarPool) closeStream() error {
+	tp.tr = nil
+	tp.entry = -1
+
+	if tp.stream != nil {
+		err := tp.stream.Close()
+		tp.stream = nil
+		if err != nil {
+			return errors.Wrap(err, 1)
+		}
+	}
+	return nil
+}
+
+// Close closes the stream of sequential pools. It doesn't close the
+// io.ReaderAt of random access pools.
+func (tp *TarPool) Close() error {
+	tp.readSeeker = nil
+	tp.seekFileIndex = -1
+
+	return tp.closeStream()
+}
@@ -0,0 +1,222 @@
+package tarpool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TarPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarpool")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	wtest.MakeTestDir(t, src, wtest.TestDirSettings{
+		Seed: 0x91,
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1},
+			{Path: "file-1", Seed: 0x2, Size: 1},
+			{Path: "dir2/file-2", Seed: 0x3, Size: 1024},
+			{Path: "empty", Data: []byte{}},
+		},
+	})
+
+	container, err := tlc.WalkDir(src, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	fsPool := fspool.New(container, src)
+	defer fsPool.Close()
+
+	tarBuf := new(bytes.Buffer)
+	_, err = archiver.CompressTar(tarBuf, src, &state.Consumer{})
+	wtest.Must(t, err)
+	tarData := tarBuf.Bytes()
+
+	tarContainer, err := tlc.WalkTar(bytes.NewReader(tarData), &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	wtest.Must(t, container.EnsureEqual(tarContainer))
+
+	// read in a different order than the tar's
+	var order []int64
+	for i := len(tarContainer.Files) - 1; i >= 0; i-- {
+		order = append(order, int64(i))
+	}
+	order = append(order, 0, 0, 1, 3, 2)
+
+	check := func(name string, pool wsync.Pool) {
+		for _, fileIndex := range order {
+			path := tarContainer.Files[fileIndex].Path
+			expected, err := ioutil.ReadFile(filepath.Join(src, filepath.FromSlash(path)))
+			wtest.Must(t, err)
+
+			r, err := pool.GetReader(fileIndex)
+			wtest.Must(t, err)
+			actual, err := ioutil.ReadAll(r)
+			wtest.Must(t, err)
+			assert.True(t, bytes.Equal(expected, actual), "%s: should read %s", name, path)
+
+			rs, err := pool.GetReadSeeker(fileIndex)
+			wtest.Must(t, err)
+			offset := int64(len(expected) / 2)
+			_, err = rs.Seek(offset, io.SeekStart)
+			wtest.Must(t, err)
+			actual, err = ioutil.ReadAll(rs)
+			wtest.Must(t, err)
+			assert.True(t, bytes.Equal(expected[offset:], actual), "%s: should seek in %s", name, path)
+		}
+		wtest.Must(t, pool.Close())
+	}
+
+	randomPool, err := New(tarContainer, bytes.NewReader(tarData), int64(len(tarData)))
+	wtest.Must(t, err)
+	check("random", randomPool)
+
+	opens := 0
+	sequentialPool := NewSequential(tarContainer, func() (io.ReadCloser, error) {
+		opens++
+		return ioutil.NopCloser(bytes.NewReader(tarData)), nil
+	})
+	check("sequential", sequentialPool)
+	assert.True(t, opens > 1, "going back should restart the stream")
+
+	opens = 0
+	sequentialPool = NewSequential(tarContainer, func() (io.ReadCloser, error) {
+		opens++
+		return ioutil.NopCloser(bytes.NewReader(tarData)), nil
+	})
+	for fileIndex := range tarContainer.Files {
+		_, err := sequentialPool.GetReader(int64(fileIndex))
+		wtest.Must(t, err)
+	}
+	assert.EqualValues(t, 1, opens, "reading in order should stream once")
+
+	t.Logf("Missing files")
+	missing := &tlc.Container{Files: []*tlc.File{{Path: "nope", Size: 1}}}
+	randomPool, err = New(missing, bytes.NewReader(tarData), int64(len(tarData)))
+	wtest.Must(t, err)
+	_, err = randomPool.GetReader(0)
+	assert.Error(t, err)
+
+	sequentialPool = NewSequential(missing, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(tarData)), nil
+	})
+	_, err = sequentialPool.GetReader(0)
+	assert.Error(t, err)
+}
+
+func Test_TarPoolHardLinks(t *testing.T) {
+	contents := map[string][]byte{
+		"a":     []byte("original contents"),
+		"dir/c": []byte("after the link"),
+	}
+
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	wtest.Must(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents["a"]))}))
+	_, err := tw.Write(contents["a"])
+	wtest.Must(t, err)
+	wtest.Must(t, tw.WriteHeader(&tar.Header{Name: "dir/b", Typeflag: tar.TypeLink, Linkname: "a", Mode: 0644}))
+	wtest.Must(t, tw.WriteHeader(&tar.Header{Name: "dir/c", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents["dir/c"]))}))
+	_, err = tw.Write(contents["dir/c"])
+	wtest.Must(t, err)
+	wtest.Must(t, tw.Close())
+	tarData := tarBuf.Bytes()
+	contents["dir/b"] = contents["a"]
+
+	container, err := tlc.WalkTar(bytes.NewReader(tarData), &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	assert.Equal(t, "3 files, 1 dirs, 0 symlinks", container.Stats())
+
+	check := func(name string, pool wsync.Pool) {
+		for _, fileIndex := range []int64{0, 1, 2, 1, 0} {
+			f := container.Files[fileIndex]
+			r, err := pool.GetReader(fileIndex)
+			wtest.Must(t, err)
+			actual, err := ioutil.ReadAll(r)
+			wtest.Must(t, err)
+			assert.EqualValues(t, string(contents[f.Path]), string(actual), "%s: should read %s", name, f.Path)
+		}
+		wtest.Must(t, pool.Close())
+	}
+
+	randomPool, err := New(container, bytes.NewReader(tarData), int64(len(tarData)))
+	wtest.Must(t, err)
+	check("random", randomPool)
+
+	check("sequential", NewSequential(container, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(tarData)), nil
+	}))
+}
+
+func Test_TarPoolUnsorted(t *testing.T) {
+	contents := map[string][]byte{
+		"a":     []byte("first in the container"),
+		"b":     []byte("first in the tar"),
+		"dir/c": []byte("a hard link to b"),
+	}
+
+	// like 'tar czf' storing entries in readdir order
+	tarGzBuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(tarGzBuf)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"b", "a"} {
+		wtest.Must(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents[name]))}))
+		_, err := tw.Write(contents[name])
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, tw.WriteHeader(&tar.Header{Name: "dir/c", Typeflag: tar.TypeLink, Linkname: "b", Mode: 0644}))
+	wtest.Must(t, tw.Close())
+	wtest.Must(t, gw.Close())
+	tarGzData := tarGzBuf.Bytes()
+	contents["dir/c"] = contents["b"]
+
+	// as diffed from the unpacked, sorted directory
+	container := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "a", Mode: 0644, Size: int64(len(contents["a"]))},
+			{Path: "b", Mode: 0644, Size: int64(len(contents["b"]))},
+			{Path: "dir/c", Mode: 0644, Size: int64(len(contents["b"]))},
+		},
+	}
+
+	opens := 0
+	pool := NewSequential(container, func() (io.ReadCloser, error) {
+		opens++
+		return gzip.NewReader(bytes.NewReader(tarGzData))
+	})
+	defer pool.Close()
+
+	for _, fileIndex := range []int64{0, 1, 2, 0, 2, 1} {
+		f := container.Files[fileIndex]
+		r, err := pool.GetReader(fileIndex)
+		wtest.Must(t, err)
+		actual, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+		assert.EqualValues(t, string(contents[f.Path]), string(actual), "should read %s", f.Path)
+	}
+	assert.True(t, opens > 1, "going back should restart the stream")
+
+	missing := &tlc.Container{Files: []*tlc.File{{Path: "nope", Size: 1}, {Path: "a", Size: 1}}}
+	pool = NewSequential(missing, func() (io.ReadCloser, error) {
+		return gzip.NewReader(bytes.NewReader(tarGzData))
+	})
+	_, err := pool.GetReader(1)
+	wtest.Must(t, err)
+	_, err = pool.GetReader(0)
+	assert.Error(t, err)
+	_, err = pool.GetReader(1)
+	assert.NoError(t, err, "should find files again after reaching the end")
+}
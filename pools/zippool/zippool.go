@@ -24,6 +24,15 @@ type ZipPool struct {
 
 	seekFileIndex int64
 	readSeeker    ReadCloseSeeker
+
+	indexReader io.ReaderAt
+	index       Index
+}
+
+// An Index opens zip entries in a way that seeks efficiently, see zipindex.Index.
+// It returns nil, nil for entries it can't open.
+type Index interface {
+	Open(r io.ReaderAt, f *zip.File) (io.ReadSeeker, error)
 }
 
 var _ wsync.Pool = (*ZipPool)(nil)
@@ -121,6 +130,14 @@ func (cfp *ZipPool) GetReader(fileIndex int64) (io.Reader, error) {
 	return cfp.reader, nil
 }
 
+// UseIndex makes GetReadSeeker open entries with an index, instead of
+// decompressing them fully into memory. r must be what the zip reader
+// reads from. Entries the index can't open are still read into memory.
+func (cfp *ZipPool) UseIndex(r io.ReaderAt, index Index) {
+	cfp.indexReader = r
+	cfp.index = index
+}
+
 // GetReadSeeker is like GetReader but the returned object allows seeking
 func (cfp *ZipPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
 	if cfp.seekFileIndex != fileIndex {
@@ -139,6 +156,19 @@ func (cfp *ZipPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
 			return nil, errors.Wrap(os.ErrNotExist, 1)
 		}
 
+		if cfp.index != nil {
+			rs, err := cfp.index.Open(cfp.indexReader, f)
+			if err != nil {
+				return nil, errors.Wrap(err, 1)
+			}
+
+			if rs != nil {
+				cfp.readSeeker = &closableBuf{rs}
+				cfp.seekFileIndex = fileIndex
+				return cfp.readSeeker, nil
+			}
+		}
+
 		reader, err := f.Open()
 		if err != nil {
 			return nil, errors.Wrap(err, 1)
@@ -4,11 +4,12 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
+	"sort"
 
 	"github.com/itchio/arkive/zip"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/archiver"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
 )
@@ -16,14 +17,24 @@ import (
 type ZipWriterPool struct {
 	container *tlc.Container
 	zw        *zip.Writer
+	settings  *archiver.ZipSettings
 }
 
 var _ wsync.WritablePool = (*ZipWriterPool)(nil)
 
 func New(container *tlc.Container, zw *zip.Writer) *ZipWriterPool {
+	return NewWithSettings(container, zw, nil)
+}
+
+// NewWithSettings is like New, but settings control modification times
+// and compression (see archiver.ZipSettings). Files are written in the
+// order GetWriter is called, so reproducible zips need callers to write
+// them in a stable order.
+func NewWithSettings(container *tlc.Container, zw *zip.Writer, settings *archiver.ZipSettings) *ZipWriterPool {
 	return &ZipWriterPool{
 		container: container,
 		zw:        zw,
+		settings:  settings,
 	}
 }
 
@@ -42,15 +53,20 @@ func (zwp *ZipWriterPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error)
 func (zwp *ZipWriterPool) GetWriter(fileIndex int64) (io.WriteCloser, error) {
 	file := zwp.container.Files[fileIndex]
 
-	fh := zip.FileHeader{
+	fh := &zip.FileHeader{
 		Name:               file.Path,
 		UncompressedSize64: uint64(file.Size),
-		Method:             zip.Deflate,
 	}
 	fh.SetMode(os.FileMode(file.Mode))
-	fh.SetModTime(time.Now())
+	fh.SetModTime(zwp.settings.ModTimeFor(file.Path))
 
-	w, err := zwp.zw.CreateHeader(&fh)
+	if zwp.settings.NeedsSample() {
+		// the header can only be written once the method is known
+		return &sampledWriter{zwp: zwp, fh: fh}, nil
+	}
+
+	fh.Method = zwp.settings.MethodFor(nil)
+	w, err := zwp.zw.CreateHeader(fh)
 	if err != nil {
 		return nil, errors.Wrap(err, 1)
 	}
@@ -61,11 +77,29 @@ func (zwp *ZipWriterPool) GetWriter(fileIndex int64) (io.WriteCloser, error) {
 // Close writes symlinks and dirs of the container, then closes
 // the zip writer.
 func (zwp *ZipWriterPool) Close() error {
-	for _, symlink := range zwp.container.Symlinks {
+	reproducible := zwp.settings != nil && zwp.settings.Reproducible
+
+	symlinks := zwp.container.Symlinks
+	dirs := zwp.container.Dirs
+	if reproducible {
+		symlinks = append([]*tlc.Symlink(nil), symlinks...)
+		sort.SliceStable(symlinks, func(i, j int) bool {
+			return symlinks[i].Path < symlinks[j].Path
+		})
+		dirs = append([]*tlc.Dir(nil), dirs...)
+		sort.SliceStable(dirs, func(i, j int) bool {
+			return dirs[i].Path < dirs[j].Path
+		})
+	}
+
+	for _, symlink := range symlinks {
 		fh := zip.FileHeader{
 			Name: symlink.Path,
 		}
 		fh.SetMode(os.FileMode(symlink.Mode))
+		if reproducible {
+			fh.SetModTime(zwp.settings.ModTimeFor(symlink.Path))
+		}
 
 		entryWriter, eErr := zwp.zw.CreateHeader(&fh)
 		if eErr != nil {
@@ -75,12 +109,12 @@ func (zwp *ZipWriterPool) Close() error {
 		entryWriter.Write([]byte(symlink.Dest))
 	}
 
-	for _, dir := range zwp.container.Dirs {
+	for _, dir := range dirs {
 		fh := zip.FileHeader{
 			Name: dir.Path + "/",
 		}
 		fh.SetMode(os.FileMode(dir.Mode))
-		fh.SetModTime(time.Now())
+		fh.SetModTime(zwp.settings.ModTimeFor(dir.Path))
 
 		_, hErr := zwp.zw.CreateHeader(&fh)
 		if hErr != nil {
@@ -111,3 +145,61 @@ func (nwc *nopWriteCloser) Write(data []byte) (int, error) {
 func (nwc *nopWriteCloser) Close() error {
 	return nil
 }
+
+// sampledWriter holds back the start of an entry until it knows which
+// method to write it with
+type sampledWriter struct {
+	zwp    *ZipWriterPool
+	fh     *zip.FileHeader
+	sample []byte
+	w      io.Writer
+}
+
+var _ io.WriteCloser = (*sampledWriter)(nil)
+
+func (sw *sampledWriter) Write(data []byte) (int, error) {
+	if sw.w != nil {
+		return sw.w.Write(data)
+	}
+
+	n := archiver.ZipSampleSize - len(sw.sample)
+	if n > len(data) {
+		n = len(data)
+	}
+	sw.sample = append(sw.sample, data[:n]...)
+	if len(sw.sample) < archiver.ZipSampleSize {
+		return n, nil
+	}
+
+	err := sw.flush()
+	if err != nil {
+		return n, err
+	}
+
+	m, err := sw.w.Write(data[n:])
+	return n + m, err
+}
+
+func (sw *sampledWriter) flush() error {
+	sw.fh.Method = sw.zwp.settings.MethodFor(sw.sample)
+	w, err := sw.zwp.zw.CreateHeader(sw.fh)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	_, err = w.Write(sw.sample)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	sw.w = w
+	sw.sample = nil
+	return nil
+}
+
+func (sw *sampledWriter) Close() error {
+	if sw.w != nil {
+		return nil
+	}
+	return sw.flush()
+}
@@ -0,0 +1,89 @@
+package zipwriterpool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ZipWriterPool(t *testing.T) {
+	random := make([]byte, archiver.ZipSampleSize+1234)
+	rand.New(rand.NewSource(0x5eed)).Read(random)
+	contents := [][]byte{
+		bytes.Repeat([]byte("compressible "), 20*1024),
+		random,
+		nil,
+	}
+
+	container := &tlc.Container{
+		Dirs: []*tlc.Dir{{Path: "b", Mode: 0755}, {Path: "a", Mode: 0755}},
+		Files: []*tlc.File{
+			{Path: "a/text", Mode: 0644, Size: int64(len(contents[0]))},
+			{Path: "b/random", Mode: 0644, Size: int64(len(contents[1]))},
+			{Path: "empty", Mode: 0644},
+		},
+	}
+
+	write := func(settings *archiver.ZipSettings) []byte {
+		buf := new(bytes.Buffer)
+		zwp := NewWithSettings(container, zip.NewWriter(buf), settings)
+		for fileIndex, data := range contents {
+			w, err := zwp.GetWriter(int64(fileIndex))
+			wtest.Must(t, err)
+			// write in small chunks, to go across the sample size
+			for len(data) > 0 {
+				n := 1000
+				if n > len(data) {
+					n = len(data)
+				}
+				_, err = w.Write(data[:n])
+				wtest.Must(t, err)
+				data = data[n:]
+			}
+			wtest.Must(t, w.Close())
+		}
+		wtest.Must(t, zwp.Close())
+		return buf.Bytes()
+	}
+
+	settings := &archiver.ZipSettings{Reproducible: true, Compression: archiver.ZipAuto}
+	first := write(settings)
+	second := write(settings)
+	assert.True(t, bytes.Equal(first, second), "reproducible zips should be identical")
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	wtest.Must(t, err)
+
+	var names []string
+	methods := make(map[string]uint16)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		methods[f.Name] = f.Method
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		wtest.Must(t, err)
+		data, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+		wtest.Must(t, r.Close())
+
+		for fileIndex, file := range container.Files {
+			if file.Path == f.Name {
+				assert.True(t, bytes.Equal(contents[fileIndex], data), "%s should have its contents", f.Name)
+			}
+		}
+	}
+	assert.EqualValues(t, []string{"a/text", "b/random", "empty", "a/", "b/"}, names)
+	assert.EqualValues(t, zip.Deflate, methods["a/text"])
+	assert.EqualValues(t, zip.Store, methods["b/random"])
+	assert.EqualValues(t, zip.Store, methods["empty"])
+}
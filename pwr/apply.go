@@ -12,9 +12,11 @@ import (
 	"github.com/itchio/savior"
 	"github.com/itchio/wharf/bsdiff"
 	"github.com/itchio/wharf/counter"
+	"github.com/itchio/wharf/diskspace"
 	"github.com/itchio/wharf/pools"
 	"github.com/itchio/wharf/pools/fspool"
 	"github.com/itchio/wharf/pools/nullpool"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wire"
@@ -35,6 +37,7 @@ var (
 // limits on container size, or number of files, for example.
 // By the time it's called, TargetContainer and SourceContainer are
 // valid. A VetApplyFunc should only read data from actx, not write to it.
+// VetApplyLimits returns one that enforces PatchLimits.
 type VetApplyFunc func(actx *ApplyContext) error
 
 // ApplyStats keeps track of various metrics while applying a patch, such as
@@ -83,6 +86,13 @@ type ApplyContext struct {
 
 	VetApply VetApplyFunc
 
+	// SpaceEstimate is the (worst-case) peak extra disk space the apply needs,
+	// set before VetApply is called. It's nil when writing to a custom OutputPool.
+	SpaceEstimate *diskspace.Estimate
+	// EnforceSpace makes ApplyPatch fail with a *diskspace.ErrInsufficientSpace
+	// before writing anything, if the output or stage filesystems are too full
+	EnforceSpace bool
+
 	Signature *SignatureInfo
 
 	Stats ApplyStats
@@ -90,7 +100,26 @@ type ApplyContext struct {
 	// optional, for checking
 	SourceIndexWhiteList map[int64]bool
 
+	// Selection restricts the apply to a subset of the files, for partial
+	// installs. Unselected files are neither written nor deleted, and
+	// selected files may only use data from selected files of the old build.
+	Selection *tlc.Selection
+
+	// RefuseEscapingSymlinks makes ApplyPatch fail with a *safepath.ErrUnsafePath
+	// if any symlink of the new build resolves outside of OutputPath. Entries
+	// with unsafe paths (absolute, or with '..') are always refused.
+	RefuseEscapingSymlinks bool
+
+	// Strict makes ApplyPatch check the containers and every op of the patch
+	// like CheckPatch does, failing before an op that doesn't make sense is applied.
+	// The resumable patcher (package patcher) has no strict mode: callers
+	// should run CheckPatch on untrusted patches before giving them to it.
+	Strict bool
+	// Limits bounds what the patch may declare, in strict mode
+	Limits *PatchLimits
+
 	// internal
+	checker          *patchChecker
 	actualOutputPath string
 	transpositions   map[string][]*Transposition
 
@@ -127,7 +156,13 @@ type Ghost struct {
 
 // ApplyPatch reads a patch, parses it, and generates the new file tree
 func (actx *ApplyContext) ApplyPatch(patchReader savior.SeekSource) error {
+	err := actx.Selection.Validate()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	actx.actualOutputPath = actx.OutputPath
+	actx.SpaceEstimate = nil
 	if actx.OutputPool == nil {
 		if actx.DryRun {
 			if actx.actualOutputPath != "" {
@@ -160,7 +195,8 @@ func (actx *ApplyContext) ApplyPatch(patchReader savior.SeekSource) error {
 	}
 
 	rawPatchWire := wire.NewReadContext(patchReader)
-	err := rawPatchWire.ExpectMagic(PatchMagic)
+	rawPatchWire.SetLimits(PatchWireLimits.ContainerLimits())
+	err = rawPatchWire.ExpectMagic(PatchMagic)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
@@ -189,6 +225,25 @@ func (actx *ApplyContext) ApplyPatch(patchReader savior.SeekSource) error {
 		return errors.Wrap(err, 0)
 	}
 	actx.SourceContainer = sourceContainer
+	patchWire.SetLimits(PatchWireLimits.BodyLimits())
+
+	err = actx.validatePaths()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	actx.checker = nil
+	if actx.Strict {
+		actx.checker = newPatchChecker(targetContainer, sourceContainer, actx.Limits)
+		err = actx.checker.checkContainers()
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+
+	if actx.OutputPool == nil {
+		actx.SpaceEstimate = actx.estimateSpace()
+	}
 
 	if actx.VetApply != nil {
 		err = actx.VetApply(actx)
@@ -197,6 +252,13 @@ func (actx *ApplyContext) ApplyPatch(patchReader savior.SeekSource) error {
 		}
 	}
 
+	if actx.EnforceSpace && actx.SpaceEstimate != nil {
+		err = actx.SpaceEstimate.Check()
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+
 	var ghosts []Ghost
 
 	// when not working with a custom output pool
@@ -206,11 +268,13 @@ func (actx *ApplyContext) ApplyPatch(patchReader savior.SeekSource) error {
 		} else if actx.InPlace {
 			// when working in-place, we have to keep track of which files were deleted
 			// from one version to the other, so that we too may delete them in the end.
-			ghosts = detectGhosts(actx.SourceContainer, actx.TargetContainer)
+			// entries that aren't selected are left alone, even when they're
+			// gone from the new version
+			ghosts = detectGhosts(actx.SourceContainer, actx.TargetContainer.Subset(actx.Selection))
 		} else {
 			// when rebuilding in a fresh directory, there's no need to worry about
 			// deleted files, because they won't even exist in the first place.
-			err = sourceContainer.Prepare(actx.OutputPath)
+			err = sourceContainer.Subset(actx.Selection).Prepare(actx.OutputPath)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
@@ -405,17 +469,24 @@ func (actx *ApplyContext) patchAll(patchWire *wire.ReadContext, signature *Signa
 			return
 		}
 
-		skip := false
-		if actx.SourceIndexWhiteList != nil && !actx.SourceIndexWhiteList[int64(fileIndex)] {
-			skip = true
+		if !actx.isSelected(int64(fileIndex)) {
+			err = SkipSeries(patchWire, sh)
+			if err != nil {
+				retErr = errors.Wrap(err, 0)
+				return
+			}
+			continue
 		}
 
-		if sh.Type == SyncHeader_BSDIFF {
-			if skip {
-				retErr = errors.Wrap(fmt.Errorf("don't know how to skip bsdiff entry"), 0)
+		if actx.checker != nil {
+			err = actx.checker.beginSeries(sh, int64(fileIndex))
+			if err != nil {
+				retErr = errors.Wrap(err, 0)
 				return
 			}
+		}
 
+		if sh.Type == SyncHeader_BSDIFF {
 			bh := &BsdiffHeader{}
 			err := patchWire.ReadMessage(bh)
 			if err != nil {
@@ -423,6 +494,22 @@ func (actx *ApplyContext) patchAll(patchWire *wire.ReadContext, signature *Signa
 				return
 			}
 
+			readMessage := patchWire.ReadMessage
+			if actx.checker != nil {
+				err = actx.checker.checkBsdiffHeader(bh)
+				if err != nil {
+					retErr = errors.Wrap(err, 0)
+					return
+				}
+				readMessage = actx.checker.checkingReadMessage(readMessage)
+			}
+
+			err = CheckSelectedDependency(actx.Selection, sourceContainer, sh.FileIndex, targetContainer, bh.TargetIndex)
+			if err != nil {
+				retErr = errors.Wrap(err, 0)
+				return
+			}
+
 			targetReader, err := targetPool.GetReadSeeker(bh.TargetIndex)
 			if err != nil {
 				retErr = errors.Wrap(err, 0)
@@ -445,7 +532,7 @@ func (actx *ApplyContext) patchAll(patchWire *wire.ReadContext, signature *Signa
 
 			newSize := actx.SourceContainer.Files[sh.FileIndex].Size
 
-			err = bctx.Patch(targetReader, writeCounter, newSize, patchWire.ReadMessage)
+			err = bctx.Patch(targetReader, writeCounter, newSize, readMessage)
 			if err != nil {
 				retErr = errors.Wrap(err, 0)
 				return
@@ -470,30 +557,20 @@ func (actx *ApplyContext) patchAll(patchWire *wire.ReadContext, signature *Signa
 				return
 			}
 
-			actx.Stats.TouchedFiles++
-		} else if sh.Type == SyncHeader_RSYNC {
-			if skip {
-				rop := &SyncOp{}
-
-				for {
-					err = patchWire.ReadMessage(rop)
-					if err != nil {
-						retErr = errors.Wrap(err, 0)
-						return
-					}
-
-					if rop.Type == SyncOp_HEY_YOU_DID_IT {
-						break
-					}
+			if actx.checker != nil {
+				err = actx.checker.checkSyncOp(rop)
+				if err != nil {
+					retErr = errors.Wrap(err, 0)
+					return
 				}
-
-				continue
 			}
 
+			actx.Stats.TouchedFiles++
+		} else if sh.Type == SyncHeader_RSYNC {
 			errc := make(chan error, 1)
 			ops := make(chan wsync.Operation)
 
-			go readOps(patchWire, ops, errc)
+			go readOps(patchWire, actx.checker, ops, errc)
 
 			transposition, err := actx.lazilyPatchFile(sctx, targetContainer, targetPool, sourceContainer, outputPool, sh.FileIndex, onSourceWrite, ops, actx.InPlace)
 			if err != nil {
@@ -535,6 +612,33 @@ func (actx *ApplyContext) patchAll(patchWire *wire.ReadContext, signature *Signa
 	return
 }
 
+// estimateSpace returns how much disk space applying the patch needs at
+// most, depending on whether we're doing a dry run, or applying in-place
+func (actx *ApplyContext) estimateSpace() *diskspace.Estimate {
+	if actx.DryRun {
+		return &diskspace.Estimate{}
+	}
+
+	sourceContainer := actx.SourceContainer.Subset(actx.Selection)
+	if actx.InPlace {
+		targetContainer := actx.TargetContainer.Subset(actx.Selection)
+		// when applying in-place, OutputPath is the stage folder
+		return diskspace.ForInPlace(targetContainer, sourceContainer, actx.actualOutputPath, actx.OutputPath)
+	}
+
+	return diskspace.ForFresh(sourceContainer, actx.OutputPath)
+}
+
+// isSelected returns true if the source file at fileIndex is part of
+// both SourceIndexWhiteList and Selection (when they're set)
+func (actx *ApplyContext) isSelected(fileIndex int64) bool {
+	if actx.SourceIndexWhiteList != nil && !actx.SourceIndexWhiteList[fileIndex] {
+		return false
+	}
+
+	return actx.Selection.Matches(actx.SourceContainer.Files[fileIndex].Path)
+}
+
 func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Transposition) error {
 	if len(transpositions) == 0 {
 		return nil
@@ -571,9 +675,12 @@ func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Trans
 				continue
 			}
 
-			oldAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(targetPath))
-			newAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(transpo.OutputPath))
-			err := actx.copy(oldAbsolutePath, newAbsolutePath, mkdirBehaviorIfNeeded)
+			oldAbsolutePath, newAbsolutePath, err := actx.transpositionPaths(targetPath, transpo.OutputPath)
+			if err != nil {
+				return err
+			}
+
+			err = actx.copy(oldAbsolutePath, newAbsolutePath, mkdirBehaviorIfNeeded)
 			if err != nil {
 				return err
 			}
@@ -583,9 +690,12 @@ func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Trans
 		if noop == nil {
 			// we treated the first transpo as being the rename, gotta do it now
 			transpo := group[0]
-			oldAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(targetPath))
-			newAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(transpo.OutputPath))
-			err := actx.move(oldAbsolutePath, newAbsolutePath)
+			oldAbsolutePath, newAbsolutePath, err := actx.transpositionPaths(targetPath, transpo.OutputPath)
+			if err != nil {
+				return err
+			}
+
+			err = actx.move(oldAbsolutePath, newAbsolutePath)
 			if err != nil {
 				return err
 			}
@@ -635,9 +745,12 @@ func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Trans
 				actx.Stats.NoopFiles++
 			} else {
 				// file was renamed
-				oldAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(transpo.TargetPath))
-				newAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(transpo.OutputPath))
-				err := actx.move(oldAbsolutePath, newAbsolutePath)
+				oldAbsolutePath, newAbsolutePath, err := actx.transpositionPaths(transpo.TargetPath, transpo.OutputPath)
+				if err != nil {
+					return err
+				}
+
+				err = actx.move(oldAbsolutePath, newAbsolutePath)
 				if err != nil {
 					return err
 				}
@@ -652,9 +765,35 @@ func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Trans
 	}
 
 	for _, rename := range cleanupRenames {
-		oldAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(rename.TargetPath))
-		newAbsolutePath := filepath.Join(actx.actualOutputPath, filepath.FromSlash(rename.OutputPath))
-		err := actx.move(oldAbsolutePath, newAbsolutePath)
+		oldAbsolutePath, newAbsolutePath, err := actx.transpositionPaths(rename.TargetPath, rename.OutputPath)
+		if err != nil {
+			return err
+		}
+
+		err = actx.move(oldAbsolutePath, newAbsolutePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePaths refuses containers with entries that would be read from,
+// or written to, outside of their folders
+func (actx *ApplyContext) validatePaths() error {
+	err := actx.TargetContainer.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	err = actx.SourceContainer.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	if actx.RefuseEscapingSymlinks {
+		err = actx.SourceContainer.CheckSymlinks()
 		if err != nil {
 			return err
 		}
@@ -663,6 +802,20 @@ func (actx *ApplyContext) applyTranspositions(transpositions map[string][]*Trans
 	return nil
 }
 
+func (actx *ApplyContext) transpositionPaths(targetPath string, outputPath string) (string, string, error) {
+	oldAbsolutePath, err := safepath.Join(actx.actualOutputPath, targetPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	newAbsolutePath, err := safepath.Join(actx.actualOutputPath, outputPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return oldAbsolutePath, newAbsolutePath, nil
+}
+
 func (actx *ApplyContext) move(oldAbsolutePath string, newAbsolutePath string) error {
 	err := os.Remove(newAbsolutePath)
 	if err != nil {
@@ -711,6 +864,11 @@ func (actx *ApplyContext) copy(oldAbsolutePath string, newAbsolutePath string, m
 		}
 	}
 
+	err := safepath.RemoveIfSymlink(newAbsolutePath)
+	if err != nil {
+		return err
+	}
+
 	// fall back to copy + remove
 	reader, err := os.Open(oldAbsolutePath)
 	if err != nil {
@@ -790,11 +948,13 @@ func (actx *ApplyContext) mergeFolders(outPath string, stagePath string) (int64,
 	}
 
 	for _, f := range stageContainer.Files {
-		p := filepath.FromSlash(f.Path)
-		op := filepath.Join(outPath, p)
-		sp := filepath.Join(stagePath, p)
+		op, err := safepath.Join(outPath, f.Path)
+		if err != nil {
+			return 0, err
+		}
+		sp := filepath.Join(stagePath, filepath.FromSlash(f.Path))
 
-		err := actx.move(sp, op)
+		err = actx.move(sp, op)
 		if err != nil {
 			return 0, errors.Wrap(err, 0)
 		}
@@ -826,9 +986,12 @@ func (actx *ApplyContext) deleteGhosts(outPath string, ghosts []Ghost) error {
 			continue
 		}
 
-		op := filepath.Join(outPath, filepath.FromSlash(ghost.Path))
+		op, err := safepath.Join(outPath, ghost.Path)
+		if err != nil {
+			return err
+		}
 
-		err := os.Remove(op)
+		err = os.Remove(op)
 		if err == nil || os.IsNotExist(err) {
 			// removed or already removed, good
 			switch ghost.Kind {
@@ -881,6 +1044,18 @@ func (actx *ApplyContext) lazilyPatchFile(sctx *wsync.Context, targetContainer *
 	first := true
 
 	for op := range ops {
+		if op.Type == wsync.OpBlockRange && actx.WoundsConsumer == nil {
+			// with a wounds consumer, missing data is healed later
+			err = CheckSelectedDependency(actx.Selection, outputContainer, fileIndex, targetContainer, op.FileIndex)
+			if err != nil {
+				if transposition == nil && realops != nil {
+					close(realops)
+					<-errs
+				}
+				return nil, errors.Wrap(err, 0)
+			}
+		}
+
 		if first {
 			first = false
 
@@ -961,7 +1136,9 @@ func (actx *ApplyContext) lazilyPatchFile(sctx *wsync.Context, targetContainer *
 	return
 }
 
-func readOps(rc *wire.ReadContext, ops chan wsync.Operation, errc chan error) {
+// readOps relays the rsync ops of a series. If checker is non-nil,
+// each op is checked before it's relayed.
+func readOps(rc *wire.ReadContext, checker *patchChecker, ops chan wsync.Operation, errc chan error) {
 	defer close(ops)
 	rop := &SyncOp{}
 
@@ -975,6 +1152,14 @@ func readOps(rc *wire.ReadContext, ops chan wsync.Operation, errc chan error) {
 			return
 		}
 
+		if checker != nil {
+			err = checker.checkSyncOp(rop)
+			if err != nil {
+				errc <- errors.Wrap(err, 0)
+				return
+			}
+		}
+
 		switch rop.Type {
 		case SyncOp_BLOCK_RANGE:
 			ops <- wsync.Operation{
@@ -1008,10 +1193,15 @@ func readOps(rc *wire.ReadContext, ops chan wsync.Operation, errc chan error) {
 }
 
 func (actx *ApplyContext) ensureDirsAndSymlinks(actualOutputPath string) error {
-	for _, dir := range actx.SourceContainer.Dirs {
-		path := filepath.Join(actualOutputPath, filepath.FromSlash(dir.Path))
+	container := actx.SourceContainer.Subset(actx.Selection)
 
-		err := os.MkdirAll(path, 0755)
+	for _, dir := range container.Dirs {
+		path, err := safepath.Join(actualOutputPath, dir.Path)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(path, 0755)
 		if err != nil {
 			// If path is already a directory, MkdirAll does nothing and returns nil.
 			// so if we get a non-nil error, we know it's serious business (permissions, etc.)
@@ -1019,8 +1209,12 @@ func (actx *ApplyContext) ensureDirsAndSymlinks(actualOutputPath string) error {
 		}
 	}
 
-	for _, symlink := range actx.SourceContainer.Symlinks {
-		path := filepath.Join(actualOutputPath, filepath.FromSlash(symlink.Path))
+	for _, symlink := range container.Symlinks {
+		path, err := safepath.Join(actualOutputPath, symlink.Path)
+		if err != nil {
+			return err
+		}
+
 		dest, err := os.Readlink(path)
 		if err != nil {
 			if os.IsNotExist(err) {
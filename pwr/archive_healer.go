@@ -16,6 +16,7 @@ import (
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/pools/fspool"
 	"github.com/itchio/wharf/pools/zippool"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
@@ -95,19 +96,25 @@ func (ah *ArchiveHealer) Do(container *tlc.Container, wounds chan *Wound) error
 		switch wound.Kind {
 		case WoundKind_DIR:
 			dirEntry := container.Dirs[wound.Index]
-			path := filepath.Join(ah.Target, filepath.FromSlash(dirEntry.Path))
+			path, pErr := safepath.Join(ah.Target, dirEntry.Path)
+			if pErr != nil {
+				return pErr
+			}
 
-			pErr := os.MkdirAll(path, 0755)
+			pErr = os.MkdirAll(path, 0755)
 			if pErr != nil {
 				return pErr
 			}
 
 		case WoundKind_SYMLINK:
 			symlinkEntry := container.Symlinks[wound.Index]
-			path := filepath.Join(ah.Target, filepath.FromSlash(symlinkEntry.Path))
+			path, pErr := safepath.Join(ah.Target, symlinkEntry.Path)
+			if pErr != nil {
+				return pErr
+			}
 
 			dir := filepath.Dir(path)
-			pErr := os.MkdirAll(dir, 0755)
+			pErr = os.MkdirAll(dir, 0755)
 			if pErr != nil {
 				return pErr
 			}
@@ -3,6 +3,9 @@ package bowl
 import (
 	"fmt"
 	"io"
+
+	"github.com/itchio/wharf/diskspace"
+	"github.com/itchio/wharf/tlc"
 )
 
 type Bowl interface {
@@ -12,6 +15,10 @@ type Bowl interface {
 
 	// phase 2: committing
 	Commit() error
+
+	// EstimateSpace returns the peak extra disk space needed to write
+	// all entries and commit, before anything is written
+	EstimateSpace() (*diskspace.Estimate, error)
 }
 
 type EntryWriter interface {
@@ -32,3 +39,26 @@ type Transposition struct {
 	TargetIndex int64
 	SourceIndex int64
 }
+
+// validateContainers makes sure bowls never read or write outside of
+// their folders, whatever the containers they're given.
+func validateContainers(targetContainer *tlc.Container, sourceContainer *tlc.Container, refuseEscapingSymlinks bool) error {
+	err := targetContainer.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	err = sourceContainer.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	if refuseEscapingSymlinks {
+		err = sourceContainer.CheckSymlinks()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/diskspace"
 	"github.com/itchio/wharf/tlc"
 )
 
@@ -63,6 +64,11 @@ func (db *dryBowl) Commit() error {
 	return nil
 }
 
+func (db *dryBowl) EstimateSpace() (*diskspace.Estimate, error) {
+	// we don't write anything, so we don't need any space
+	return &diskspace.Estimate{}, nil
+}
+
 // nopEntryWriter
 
 type nopEntryWriter struct {
@@ -1,12 +1,15 @@
 package bowl
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/diskspace"
 	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
 )
@@ -15,6 +18,8 @@ type freshBowl struct {
 	TargetContainer *tlc.Container
 	SourceContainer *tlc.Container
 	TargetPath      string
+	OutputFolder    string
+	Selection       *tlc.Selection
 
 	TargetPool wsync.Pool
 	OutputPool *fspool.FsPool
@@ -32,6 +37,18 @@ type FreshBowlParams struct {
 
 	TargetPool   wsync.Pool
 	OutputFolder string
+
+	// Selection, if set, only prepares the selected entries (partial install)
+	Selection *tlc.Selection
+
+	// RefuseEscapingSymlinks, if true, refuses source containers with
+	// symlinks that resolve outside of OutputFolder
+	RefuseEscapingSymlinks bool
+
+	// Resuming, if true, leaves OutputFolder as an interrupted patch left
+	// it, instead of preparing it again, which would truncate the files
+	// already written. Set it when resuming from a patcher checkpoint.
+	Resuming bool
 }
 
 // NewFreshBowl returns a bowl that applies all writes to
@@ -56,24 +73,43 @@ func NewFreshBowl(params *FreshBowlParams) (Bowl, error) {
 		return nil, errors.New("freshbowl: must specify either OutputFolder")
 	}
 
+	err := validateContainers(params.TargetContainer, params.SourceContainer, params.RefuseEscapingSymlinks)
+	if err != nil {
+		return nil, err
+	}
+
 	outputPool := fspool.New(params.SourceContainer, params.OutputFolder)
 
-	err := params.SourceContainer.Prepare(params.OutputFolder)
-	if err != nil {
-		return nil, errors.Wrap(err, 0)
+	if params.Resuming {
+		_, err = os.Stat(params.OutputFolder)
+		if err != nil {
+			return nil, errors.Wrap(fmt.Errorf("freshbowl: can't resume, OutputFolder must exist, but got: %s", err.Error()), 0)
+		}
+	} else {
+		err = params.SourceContainer.Subset(params.Selection).Prepare(params.OutputFolder)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
 	}
 
 	return &freshBowl{
 		TargetContainer: params.TargetContainer,
 		SourceContainer: params.SourceContainer,
 		TargetPool:      params.TargetPool,
+		OutputFolder:    params.OutputFolder,
+		Selection:       params.Selection,
 
 		OutputPool: outputPool,
 	}, nil
 }
 
 func (fb *freshBowl) GetWriter(index int64) (EntryWriter, error) {
-	return &freshEntryWriter{path: fb.OutputPool.GetPath(index)}, nil
+	path, err := safepath.Join(fb.OutputFolder, fb.SourceContainer.Files[index].Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &freshEntryWriter{path: path}, nil
 }
 
 func (fb *freshBowl) Transpose(t Transposition) (rErr error) {
@@ -115,6 +151,10 @@ func (fb *freshBowl) Commit() error {
 	return nil
 }
 
+func (fb *freshBowl) EstimateSpace() (*diskspace.Estimate, error) {
+	return diskspace.ForFresh(fb.SourceContainer.Subset(fb.Selection), fb.OutputFolder), nil
+}
+
 // freshEntryWriter
 
 type freshEntryWriter struct {
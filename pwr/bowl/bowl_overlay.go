@@ -11,7 +11,9 @@ import (
 	"github.com/itchio/wharf/pwr/overlay"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/diskspace"
 	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
 )
@@ -32,6 +34,7 @@ type overlayBowl struct {
 
 	OutputFolder string
 	StageFolder  string
+	Selection    *tlc.Selection
 
 	stagePool         *fspool.FsPool
 	transpositions    []Transposition
@@ -51,6 +54,13 @@ type OverlayBowlParams struct {
 
 	OutputFolder string
 	StageFolder  string
+
+	// Selection, if set, leaves unselected entries alone (partial install)
+	Selection *tlc.Selection
+
+	// RefuseEscapingSymlinks, if true, refuses source containers with
+	// symlinks that resolve outside of OutputFolder
+	RefuseEscapingSymlinks bool
 }
 
 func NewOverlayBowl(params *OverlayBowlParams) (Bowl, error) {
@@ -83,7 +93,10 @@ func NewOverlayBowl(params *OverlayBowlParams) (Bowl, error) {
 		return nil, errors.New("overlaybowl: StageFolder must not be nil")
 	}
 
-	var err error
+	err := validateContainers(params.TargetContainer, params.SourceContainer, params.RefuseEscapingSymlinks)
+	if err != nil {
+		return nil, err
+	}
 
 	err = os.MkdirAll(params.OutputFolder, 0755)
 	if err != nil {
@@ -110,6 +123,7 @@ func NewOverlayBowl(params *OverlayBowlParams) (Bowl, error) {
 
 		OutputFolder: params.OutputFolder,
 		StageFolder:  params.StageFolder,
+		Selection:    params.Selection,
 
 		stagePool:         stagePool,
 		targetFilesByPath: targetFilesByPath,
@@ -230,13 +244,22 @@ func (ob *overlayBowl) Commit() error {
 	return nil
 }
 
+func (ob *overlayBowl) EstimateSpace() (*diskspace.Estimate, error) {
+	targetContainer := ob.TargetContainer.Subset(ob.Selection)
+	sourceContainer := ob.SourceContainer.Subset(ob.Selection)
+	return diskspace.ForInPlace(targetContainer, sourceContainer, ob.OutputFolder, ob.StageFolder), nil
+}
+
 func (ob *overlayBowl) ensureDirsAndSymlinks() error {
-	outputPath := ob.OutputFolder
+	container := ob.SourceContainer.Subset(ob.Selection)
 
-	for _, dir := range ob.SourceContainer.Dirs {
-		path := filepath.Join(outputPath, filepath.FromSlash(dir.Path))
+	for _, dir := range container.Dirs {
+		path, err := ob.outputPath(dir.Path)
+		if err != nil {
+			return err
+		}
 
-		err := os.MkdirAll(path, 0755)
+		err = os.MkdirAll(path, 0755)
 		if err != nil {
 			// If path is already a directory, MkdirAll does nothing and returns nil.
 			// so if we get a non-nil error, we know it's serious business (permissions, etc.)
@@ -246,8 +269,12 @@ func (ob *overlayBowl) ensureDirsAndSymlinks() error {
 
 	// TODO: behave like github.com/itchio/savior for symlinks on windows ?
 
-	for _, symlink := range ob.SourceContainer.Symlinks {
-		path := filepath.Join(outputPath, filepath.FromSlash(symlink.Path))
+	for _, symlink := range container.Symlinks {
+		path, err := ob.outputPath(symlink.Path)
+		if err != nil {
+			return err
+		}
+
 		dest, err := os.Readlink(path)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -281,6 +308,26 @@ func (ob *overlayBowl) ensureDirsAndSymlinks() error {
 	return nil
 }
 
+// outputPath returns the native path of an entry in the output folder,
+// making sure writing to it cannot escape the output folder
+func (ob *overlayBowl) outputPath(entryPath string) (string, error) {
+	return safepath.Join(ob.OutputFolder, entryPath)
+}
+
+func (ob *overlayBowl) transpositionPaths(targetPath string, outputPath string) (string, string, error) {
+	oldAbsolutePath, err := ob.outputPath(targetPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	newAbsolutePath, err := ob.outputPath(outputPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return oldAbsolutePath, newAbsolutePath, nil
+}
+
 type pathTranspo struct {
 	TargetPath string
 	OutputPath string
@@ -295,7 +342,6 @@ const (
 
 func (ob *overlayBowl) applyTranspositions() error {
 	transpositions := make(map[string][]*pathTranspo)
-	outputPath := ob.OutputFolder
 
 	for _, t := range ob.transpositions {
 		targetFile := ob.TargetContainer.Files[t.TargetIndex]
@@ -329,9 +375,12 @@ func (ob *overlayBowl) applyTranspositions() error {
 				continue
 			}
 
-			oldAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(targetPath))
-			newAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(transpo.OutputPath))
-			err := ob.copy(oldAbsolutePath, newAbsolutePath, mkdirBehaviorIfNeeded)
+			oldAbsolutePath, newAbsolutePath, err := ob.transpositionPaths(targetPath, transpo.OutputPath)
+			if err != nil {
+				return err
+			}
+
+			err = ob.copy(oldAbsolutePath, newAbsolutePath, mkdirBehaviorIfNeeded)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
@@ -340,9 +389,12 @@ func (ob *overlayBowl) applyTranspositions() error {
 		if noop == nil {
 			// we treated the first transpo as being the rename, gotta do it now
 			transpo := group[0]
-			oldAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(targetPath))
-			newAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(transpo.OutputPath))
-			err := ob.move(oldAbsolutePath, newAbsolutePath)
+			oldAbsolutePath, newAbsolutePath, err := ob.transpositionPaths(targetPath, transpo.OutputPath)
+			if err != nil {
+				return err
+			}
+
+			err = ob.move(oldAbsolutePath, newAbsolutePath)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
@@ -390,9 +442,12 @@ func (ob *overlayBowl) applyTranspositions() error {
 				// file wasn't touched at all
 			} else {
 				// file was renamed
-				oldAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(transpo.TargetPath))
-				newAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(transpo.OutputPath))
-				err := ob.move(oldAbsolutePath, newAbsolutePath)
+				oldAbsolutePath, newAbsolutePath, err := ob.transpositionPaths(transpo.TargetPath, transpo.OutputPath)
+				if err != nil {
+					return err
+				}
+
+				err = ob.move(oldAbsolutePath, newAbsolutePath)
 				if err != nil {
 					return errors.Wrap(err, 0)
 				}
@@ -406,9 +461,12 @@ func (ob *overlayBowl) applyTranspositions() error {
 	}
 
 	for _, rename := range cleanupRenames {
-		oldAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(rename.TargetPath))
-		newAbsolutePath := filepath.Join(outputPath, filepath.FromSlash(rename.OutputPath))
-		err := ob.move(oldAbsolutePath, newAbsolutePath)
+		oldAbsolutePath, newAbsolutePath, err := ob.transpositionPaths(rename.TargetPath, rename.OutputPath)
+		if err != nil {
+			return err
+		}
+
+		err = ob.move(oldAbsolutePath, newAbsolutePath)
 		if err != nil {
 			return errors.Wrap(err, 0)
 		}
@@ -426,6 +484,11 @@ func (ob *overlayBowl) copy(oldAbsolutePath string, newAbsolutePath string, mkdi
 		}
 	}
 
+	err := safepath.RemoveIfSymlink(newAbsolutePath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	// fall back to copy + remove
 	reader, err := os.Open(oldAbsolutePath)
 	if err != nil {
@@ -502,8 +565,12 @@ func (ob *overlayBowl) applyMoves() error {
 		nativePath := filepath.FromSlash(file.Path)
 
 		stagePath := filepath.Join(ob.StageFolder, nativePath)
-		outputPath := filepath.Join(ob.OutputFolder, nativePath)
-		err := ob.move(stagePath, outputPath)
+		outputPath, err := ob.outputPath(file.Path)
+		if err != nil {
+			return err
+		}
+
+		err = ob.move(stagePath, outputPath)
 		if err != nil {
 			return errors.Wrap(err, 0)
 		}
@@ -530,7 +597,21 @@ func (ob *overlayBowl) applyOverlays() error {
 		}
 		defer r.Close()
 
-		outputPath := filepath.Join(ob.OutputFolder, nativePath)
+		outputPath, err := ob.outputPath(file.Path)
+		if err != nil {
+			return err
+		}
+
+		outputStats, err := os.Lstat(outputPath)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		if outputStats.Mode()&os.ModeSymlink != 0 {
+			// the overlay would be applied to whatever the symlink points to
+			return errors.Wrap(&safepath.ErrUnsafePath{Path: file.Path, Reason: "expected a file, found a symlink"}, 0)
+		}
+
 		w, err := os.OpenFile(outputPath, os.O_WRONLY, 0644)
 		if err != nil {
 			return errors.Wrap(err, 0)
@@ -643,16 +724,21 @@ func (s byDecreasingLength) Less(i, j int) bool {
 }
 
 func (ob *overlayBowl) deleteGhosts() error {
-	ghosts := detectGhosts(ob.SourceContainer, ob.TargetContainer)
+	// entries that aren't selected are left alone, even when they're
+	// gone from the new version
+	ghosts := detectGhosts(ob.SourceContainer, ob.TargetContainer.Subset(ob.Selection))
 	debugf("%d total ghosts", len(ghosts))
 
 	sort.Sort(byDecreasingLength(ghosts))
 
 	for _, ghost := range ghosts {
 		debugf("ghost: %v", ghost)
-		op := filepath.Join(ob.OutputFolder, filepath.FromSlash(ghost.Path))
+		op, err := ob.outputPath(ghost.Path)
+		if err != nil {
+			return err
+		}
 
-		err := os.Remove(op)
+		err = os.Remove(op)
 		if err == nil || os.IsNotExist(err) {
 			// removed or already removed, good
 			debugf("ghost removed or already gone '%s'", ghost.Path)
@@ -1,9 +1,14 @@
 package bowl_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/itchio/wharf/pwr/bowl"
+	"github.com/itchio/wharf/tlc"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_PatchOneSameLength(t *testing.T) {
@@ -187,3 +192,49 @@ func runScenario(t *testing.T, params *bowlerParams) {
 		runBowler(t, params)
 	})
 }
+
+func Test_OverlayGhostsSelection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay-ghosts")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	outputFolder := filepath.Join(dir, "out")
+	for _, name := range []string{"kept", "en/removed", "fr/removed"} {
+		path := filepath.Join(outputFolder, filepath.FromSlash(name))
+		must(t, os.MkdirAll(filepath.Dir(path), 0755))
+		must(t, ioutil.WriteFile(path, []byte(name), 0644))
+	}
+
+	targetContainer := &tlc.Container{
+		Dirs: []*tlc.Dir{
+			{Path: "en", Mode: 0755},
+			{Path: "fr", Mode: 0755},
+		},
+		Files: []*tlc.File{
+			{Path: "en/removed", Mode: 0644, Size: 10},
+			{Path: "fr/removed", Mode: 0644, Size: 10},
+			{Path: "kept", Mode: 0644, Size: 4},
+		},
+	}
+	sourceContainer := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "kept", Mode: 0644, Size: 4},
+		},
+	}
+
+	b, err := bowl.NewOverlayBowl(&bowl.OverlayBowlParams{
+		TargetContainer: targetContainer,
+		SourceContainer: sourceContainer,
+		OutputFolder:    outputFolder,
+		StageFolder:     filepath.Join(dir, "stage"),
+		Selection:       &tlc.Selection{Exclude: []string{"fr"}},
+	})
+	must(t, err)
+	must(t, b.Commit())
+
+	_, err = os.Lstat(filepath.Join(outputFolder, "en", "removed"))
+	assert.True(t, os.IsNotExist(err), "selected ghosts should be deleted")
+
+	_, err = os.Lstat(filepath.Join(outputFolder, "fr", "removed"))
+	assert.NoError(t, err, "unselected ghosts should be left alone")
+}
@@ -0,0 +1,454 @@
+package pwr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wsync"
+)
+
+// PatchLimits bounds what an untrusted patch may declare. Zero
+// fields mean "no limit".
+type PatchLimits struct {
+	// MaxFiles is the maximum number of files in either container
+	MaxFiles int64
+	// MaxTotalSize is the maximum total size of the files in either container
+	MaxTotalSize int64
+	// MaxDataSize is the maximum size of the fresh data carried
+	// by a single DATA op
+	MaxDataSize int64
+	// MaxControlSize is the maximum size of the data carried by
+	// a single bsdiff control
+	MaxControlSize int64
+	// MaxPathDepth is the maximum number of elements in an entry path
+	MaxPathDepth int
+}
+
+// DefaultPatchLimits are generous limits that no build pushed with
+// wharf should hit, but that keep a hostile patch from making us
+// allocate or write absurd amounts.
+var DefaultPatchLimits = PatchLimits{
+	MaxFiles:     1024 * 1024,
+	MaxTotalSize: 1024 * 1024 * 1024 * 1024,
+	// wsync flushes DATA ops once they reach MaxDataOp, and
+	// its buffer is only a few blocks bigger than that
+	MaxDataSize: 2 * wsync.MaxDataOp,
+	// bsdiff controls aren't split, so a single one may hold a whole file
+	MaxControlSize: 2 * bsdiff.MaxFileSize,
+	MaxPathDepth:   128,
+}
+
+// ErrInvalidPatch is returned by CheckPatch (and by ApplyPatch in strict
+// mode) when a patch is well-formed but doesn't make sense: ops referring
+// to data that doesn't exist, files that don't end up the declared size, etc.
+type ErrInvalidPatch struct {
+	// Path of the entry being checked, if any
+	Path   string
+	Reason string
+}
+
+var _ error = (*ErrInvalidPatch)(nil)
+
+func (e *ErrInvalidPatch) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("invalid patch: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid patch: '%s': %s", e.Path, e.Reason)
+}
+
+// ErrPatchLimitExceeded is returned when a patch exceeds one of the PatchLimits
+type ErrPatchLimitExceeded struct {
+	// Limit is the name of the PatchLimits field that was exceeded
+	Limit string
+	Value int64
+	Max   int64
+}
+
+var _ error = (*ErrPatchLimitExceeded)(nil)
+
+func (e *ErrPatchLimitExceeded) Error() string {
+	return fmt.Sprintf("patch exceeds %s: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// VetApplyLimits returns a VetApplyFunc that refuses patches whose
+// containers exceed limits, before anything is written.
+func VetApplyLimits(limits PatchLimits) VetApplyFunc {
+	return func(actx *ApplyContext) error {
+		pc := newPatchChecker(actx.TargetContainer, actx.SourceContainer, &limits)
+		return pc.checkContainers()
+	}
+}
+
+// CheckPatch reads a whole patch and checks its containers and every one
+// of its ops against both containers and limits (which may be nil), without
+// touching the disk. It returns an *ErrInvalidPatch or *ErrPatchLimitExceeded
+// for patches that would misbehave when applied.
+func CheckPatch(patchReader savior.SeekSource, limits *PatchLimits) error {
+	rawPatchWire := wire.NewReadContext(patchReader)
+	rawPatchWire.SetLimits(PatchWireLimits.ContainerLimits())
+	err := rawPatchWire.ExpectMagic(PatchMagic)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	header := &PatchHeader{}
+	err = rawPatchWire.ReadMessage(header)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	patchWire, err := DecompressWire(rawPatchWire, header.Compression)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	targetContainer := &tlc.Container{}
+	err = patchWire.ReadMessage(targetContainer)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	sourceContainer := &tlc.Container{}
+	err = patchWire.ReadMessage(sourceContainer)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	patchWire.SetLimits(PatchWireLimits.BodyLimits())
+
+	pc := newPatchChecker(targetContainer, sourceContainer, limits)
+	err = pc.checkContainers()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	sh := &SyncHeader{}
+	rop := &SyncOp{}
+	for fileIndex := range sourceContainer.Files {
+		sh.Reset()
+		err = patchWire.ReadMessage(sh)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		err = pc.beginSeries(sh, int64(fileIndex))
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		if sh.Type == SyncHeader_BSDIFF {
+			bh := &BsdiffHeader{}
+			err = patchWire.ReadMessage(bh)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			err = pc.checkBsdiffHeader(bh)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			readMessage := pc.checkingReadMessage(patchWire.ReadMessage)
+			ctrl := &bsdiff.Control{}
+			for {
+				ctrl.Reset()
+				err = readMessage(ctrl)
+				if err != nil {
+					return errors.Wrap(err, 0)
+				}
+
+				if ctrl.Eof {
+					break
+				}
+			}
+		}
+
+		for {
+			rop.Reset()
+			err = patchWire.ReadMessage(rop)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			err = pc.checkSyncOp(rop)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			if rop.Type == SyncOp_HEY_YOU_DID_IT {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// A patchChecker follows a patch, one series at a time, and makes sure
+// every op makes sense, given both containers and limits. It's used
+// by CheckPatch, and by ApplyPatch in strict mode.
+type patchChecker struct {
+	limits          *PatchLimits
+	targetContainer *tlc.Container
+	sourceContainer *tlc.Container
+
+	// state of the current series
+	fileIndex int64
+	kind      SyncHeader_Type
+	produced  int64
+	// for bsdiff series
+	targetSize int64
+	oldOffset  int64
+	bsdiffDone bool
+}
+
+func newPatchChecker(targetContainer *tlc.Container, sourceContainer *tlc.Container, limits *PatchLimits) *patchChecker {
+	if limits == nil {
+		limits = &PatchLimits{}
+	}
+
+	return &patchChecker{
+		limits:          limits,
+		targetContainer: targetContainer,
+		sourceContainer: sourceContainer,
+		fileIndex:       -1,
+	}
+}
+
+func (pc *patchChecker) invalid(format string, args ...interface{}) error {
+	var path string
+	if pc.fileIndex >= 0 && pc.fileIndex < int64(len(pc.sourceContainer.Files)) {
+		path = pc.sourceContainer.Files[pc.fileIndex].Path
+	}
+	return errors.Wrap(&ErrInvalidPatch{Path: path, Reason: fmt.Sprintf(format, args...)}, 1)
+}
+
+func (pc *patchChecker) exceeds(limit string, value int64, max int64) error {
+	if max <= 0 || value <= max {
+		return nil
+	}
+	return errors.Wrap(&ErrPatchLimitExceeded{Limit: limit, Value: value, Max: max}, 1)
+}
+
+func (pc *patchChecker) checkContainers() error {
+	for _, c := range []*tlc.Container{pc.targetContainer, pc.sourceContainer} {
+		err := pc.checkContainer(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pc *patchChecker) checkContainer(c *tlc.Container) error {
+	err := c.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	err = pc.exceeds("MaxFiles", int64(len(c.Files)), pc.limits.MaxFiles)
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for _, f := range c.Files {
+		if f.Size < 0 {
+			return errors.Wrap(&ErrInvalidPatch{Path: f.Path, Reason: fmt.Sprintf("negative size %d", f.Size)}, 0)
+		}
+		totalSize += f.Size
+		if totalSize < 0 {
+			return errors.Wrap(&ErrInvalidPatch{Path: f.Path, Reason: "total size overflows"}, 0)
+		}
+	}
+
+	if totalSize != c.Size {
+		return errors.Wrap(&ErrInvalidPatch{Reason: fmt.Sprintf("container declares size %d, but its files add up to %d", c.Size, totalSize)}, 0)
+	}
+
+	err = pc.exceeds("MaxTotalSize", totalSize, pc.limits.MaxTotalSize)
+	if err != nil {
+		return err
+	}
+
+	if pc.limits.MaxPathDepth > 0 {
+		checkDepth := func(entryPath string) error {
+			depth := int64(len(strings.Split(strings.TrimSuffix(entryPath, "/"), "/")))
+			return pc.exceeds("MaxPathDepth", depth, int64(pc.limits.MaxPathDepth))
+		}
+
+		for _, d := range c.Dirs {
+			err = checkDepth(d.Path)
+			if err != nil {
+				return err
+			}
+		}
+		for _, f := range c.Files {
+			err = checkDepth(f.Path)
+			if err != nil {
+				return err
+			}
+		}
+		for _, s := range c.Symlinks {
+			err = checkDepth(s.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// beginSeries checks the header of the series of ops for
+// the source file at expectedIndex
+func (pc *patchChecker) beginSeries(sh *SyncHeader, expectedIndex int64) error {
+	pc.fileIndex = expectedIndex
+	pc.kind = sh.Type
+	pc.produced = 0
+	pc.targetSize = 0
+	pc.oldOffset = 0
+	pc.bsdiffDone = false
+
+	if sh.FileIndex != expectedIndex {
+		return pc.invalid("expected series for file %d, got %d", expectedIndex, sh.FileIndex)
+	}
+
+	switch sh.Type {
+	case SyncHeader_RSYNC, SyncHeader_BSDIFF:
+		return nil
+	default:
+		return pc.invalid("unknown series kind %d", sh.Type)
+	}
+}
+
+func (pc *patchChecker) checkBsdiffHeader(bh *BsdiffHeader) error {
+	if pc.kind != SyncHeader_BSDIFF {
+		return pc.invalid("unexpected bsdiff header")
+	}
+
+	if bh.TargetIndex < 0 || bh.TargetIndex >= int64(len(pc.targetContainer.Files)) {
+		return pc.invalid("bsdiff refers to old file %d, which doesn't exist", bh.TargetIndex)
+	}
+
+	pc.targetSize = pc.targetContainer.Files[bh.TargetIndex].Size
+	return nil
+}
+
+// checkingReadMessage wraps readMessage so that every bsdiff
+// control read through it is checked
+func (pc *patchChecker) checkingReadMessage(readMessage bsdiff.ReadMessageFunc) bsdiff.ReadMessageFunc {
+	return func(msg proto.Message) error {
+		err := readMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		if ctrl, ok := msg.(*bsdiff.Control); ok {
+			return pc.checkControl(ctrl)
+		}
+		return nil
+	}
+}
+
+func (pc *patchChecker) checkControl(ctrl *bsdiff.Control) error {
+	if pc.kind != SyncHeader_BSDIFF || pc.bsdiffDone {
+		return pc.invalid("unexpected bsdiff control")
+	}
+
+	if ctrl.Eof {
+		pc.bsdiffDone = true
+		return pc.checkProduced()
+	}
+
+	addLen := int64(len(ctrl.Add))
+	copyLen := int64(len(ctrl.Copy))
+
+	err := pc.exceeds("MaxControlSize", addLen+copyLen, pc.limits.MaxControlSize)
+	if err != nil {
+		return err
+	}
+
+	if pc.oldOffset+addLen > pc.targetSize {
+		return pc.invalid("bsdiff reads old bytes %d-%d, past the end of the old file (%d bytes)", pc.oldOffset, pc.oldOffset+addLen, pc.targetSize)
+	}
+	pc.oldOffset += addLen
+
+	pc.oldOffset += ctrl.Seek
+	if pc.oldOffset < 0 || pc.oldOffset > pc.targetSize {
+		return pc.invalid("bsdiff seeks to %d, outside of the old file (%d bytes)", pc.oldOffset, pc.targetSize)
+	}
+
+	return pc.produce(addLen + copyLen)
+}
+
+// checkSyncOp checks one rsync op, or the op that ends a series
+func (pc *patchChecker) checkSyncOp(rop *SyncOp) error {
+	switch rop.Type {
+	case SyncOp_HEY_YOU_DID_IT:
+		if pc.kind == SyncHeader_BSDIFF {
+			if !pc.bsdiffDone {
+				return pc.invalid("bsdiff series ended early")
+			}
+			return nil
+		}
+		return pc.checkProduced()
+	case SyncOp_BLOCK_RANGE:
+		if pc.kind != SyncHeader_RSYNC {
+			return pc.invalid("unexpected block range op in bsdiff series")
+		}
+
+		if rop.FileIndex < 0 || rop.FileIndex >= int64(len(pc.targetContainer.Files)) {
+			return pc.invalid("block range refers to old file %d, which doesn't exist", rop.FileIndex)
+		}
+
+		targetFile := pc.targetContainer.Files[rop.FileIndex]
+		numBlocks := ComputeNumBlocks(targetFile.Size)
+		if rop.BlockIndex < 0 || rop.BlockSpan <= 0 || rop.BlockIndex > numBlocks-rop.BlockSpan {
+			return pc.invalid("block range %d+%d is outside of old file '%s' (%d blocks)", rop.BlockIndex, rop.BlockSpan, targetFile.Path, numBlocks)
+		}
+
+		end := (rop.BlockIndex + rop.BlockSpan) * BlockSize
+		if end > targetFile.Size {
+			end = targetFile.Size
+		}
+		return pc.produce(end - rop.BlockIndex*BlockSize)
+	case SyncOp_DATA:
+		if pc.kind != SyncHeader_RSYNC {
+			return pc.invalid("unexpected data op in bsdiff series")
+		}
+
+		dataLen := int64(len(rop.Data))
+		err := pc.exceeds("MaxDataSize", dataLen, pc.limits.MaxDataSize)
+		if err != nil {
+			return err
+		}
+		return pc.produce(dataLen)
+	default:
+		return pc.invalid("unknown op type %d", rop.Type)
+	}
+}
+
+// produce accounts for bytes written to the current source file
+func (pc *patchChecker) produce(n int64) error {
+	pc.produced += n
+	size := pc.sourceContainer.Files[pc.fileIndex].Size
+	if pc.produced > size {
+		return pc.invalid("ops write past the declared size (%d bytes)", size)
+	}
+	return nil
+}
+
+func (pc *patchChecker) checkProduced() error {
+	size := pc.sourceContainer.Files[pc.fileIndex].Size
+	if pc.produced != size {
+		return pc.invalid("ops write %d bytes, but the declared size is %d", pc.produced, size)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package pwr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wsync"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckPatch(t *testing.T) {
+	oldSize := BlockSize*2 + 10
+	newSize := BlockSize
+
+	targetContainer := &tlc.Container{
+		Files: []*tlc.File{{Path: "old", Size: oldSize, Mode: 0644}},
+		Size:  oldSize,
+	}
+	sourceContainer := &tlc.Container{
+		Dirs:  []*tlc.Dir{{Path: "a", Mode: 0755}},
+		Files: []*tlc.File{{Path: "a/new", Size: newSize, Mode: 0644}},
+		Size:  newSize,
+	}
+
+	cook := func(target *tlc.Container, source *tlc.Container, msgs ...proto.Message) *bytes.Buffer {
+		return cookPatch(t, func(wctx *wire.WriteContext) {
+			must(t, wctx.WriteMagic(PatchMagic))
+			must(t, wctx.WriteMessage(&PatchHeader{
+				Compression: &CompressionSettings{Algorithm: CompressionAlgorithm_NONE},
+			}))
+			must(t, wctx.WriteMessage(target))
+			must(t, wctx.WriteMessage(source))
+			for _, msg := range msgs {
+				must(t, wctx.WriteMessage(msg))
+			}
+		})
+	}
+
+	check := func(patch *bytes.Buffer, limits *PatchLimits) error {
+		patchReader := seeksource.FromBytes(patch.Bytes())
+		_, err := patchReader.Resume(nil)
+		must(t, err)
+		return CheckPatch(patchReader, limits)
+	}
+
+	rsync := &SyncHeader{FileIndex: 0, Type: SyncHeader_RSYNC}
+	bsdiffSeries := &SyncHeader{FileIndex: 0, Type: SyncHeader_BSDIFF}
+	done := &SyncOp{Type: SyncOp_HEY_YOU_DID_IT}
+	blockRange := func(fileIndex int64, blockIndex int64, blockSpan int64) *SyncOp {
+		return &SyncOp{Type: SyncOp_BLOCK_RANGE, FileIndex: fileIndex, BlockIndex: blockIndex, BlockSpan: blockSpan}
+	}
+	data := func(size int64) *SyncOp {
+		return &SyncOp{Type: SyncOp_DATA, Data: make([]byte, size)}
+	}
+
+	valid := cook(targetContainer, sourceContainer, rsync, blockRange(0, 1, 1), done)
+	assert.NoError(t, check(valid, nil))
+	assert.NoError(t, check(valid, &DefaultPatchLimits))
+
+	validBsdiff := cook(targetContainer, sourceContainer, bsdiffSeries,
+		&BsdiffHeader{TargetIndex: 0},
+		&bsdiff.Control{Seek: BlockSize},
+		&bsdiff.Control{Add: make([]byte, 10), Copy: make([]byte, newSize-10)},
+		&bsdiff.Control{Eof: true},
+		done)
+	assert.NoError(t, check(validBsdiff, nil))
+
+	isInvalid := func(err error, msgAndArgs ...interface{}) {
+		_, ok := unwrapError(err).(*ErrInvalidPatch)
+		assert.True(t, ok, msgAndArgs...)
+	}
+
+	isOverLimit := func(err error, limit string) {
+		le, ok := unwrapError(err).(*ErrPatchLimitExceeded)
+		if assert.True(t, ok, "should exceed %s", limit) {
+			assert.Equal(t, limit, le.Limit)
+		}
+	}
+
+	outOfRange := cook(targetContainer, sourceContainer, rsync, blockRange(0, 3, 1), done)
+	isInvalid(check(outOfRange, nil), "block range past the end of the old file")
+	isInvalid(check(cook(targetContainer, sourceContainer, rsync, blockRange(0, -1, 1), done), nil), "negative block index")
+	isInvalid(check(cook(targetContainer, sourceContainer, rsync, blockRange(1, 0, 1), done), nil), "unknown old file")
+	isInvalid(check(cook(targetContainer, sourceContainer, rsync, data(newSize+1), done), nil), "too much data")
+	isInvalid(check(cook(targetContainer, sourceContainer, rsync, data(10), done), nil), "not enough data")
+	isInvalid(check(cook(targetContainer, sourceContainer, &SyncHeader{FileIndex: 3}, done), nil), "wrong series")
+
+	isInvalid(check(cook(targetContainer, sourceContainer, bsdiffSeries,
+		&BsdiffHeader{TargetIndex: 0},
+		&bsdiff.Control{Seek: 1 << 40},
+		&bsdiff.Control{Copy: make([]byte, newSize)},
+		&bsdiff.Control{Eof: true},
+		done), nil), "bsdiff seek past EOF")
+	isInvalid(check(cook(targetContainer, sourceContainer, bsdiffSeries,
+		&BsdiffHeader{TargetIndex: 0},
+		&bsdiff.Control{Seek: oldSize - 5},
+		&bsdiff.Control{Add: make([]byte, 10), Copy: make([]byte, newSize-10)},
+		&bsdiff.Control{Eof: true},
+		done), nil), "bsdiff add past EOF")
+	isInvalid(check(cook(targetContainer, sourceContainer, bsdiffSeries,
+		&BsdiffHeader{TargetIndex: 4},
+		&bsdiff.Control{Eof: true},
+		done), nil), "bsdiff from unknown old file")
+
+	hugeSource := &tlc.Container{
+		Files: []*tlc.File{{Path: "a/new", Size: newSize, Mode: 0644}},
+		Size:  1 << 50,
+	}
+	isInvalid(check(cook(targetContainer, hugeSource, rsync, blockRange(0, 0, 1), done), nil), "inconsistent container size")
+
+	isOverLimit(check(valid, &PatchLimits{MaxPathDepth: 1}), "MaxPathDepth")
+	isOverLimit(check(valid, &PatchLimits{MaxTotalSize: newSize}), "MaxTotalSize")
+	isOverLimit(check(cook(targetContainer, sourceContainer, rsync, data(newSize), done), &PatchLimits{MaxDataSize: 1024}), "MaxDataSize")
+	isOverLimit(check(cook(targetContainer, sourceContainer, rsync, data(2*wsync.MaxDataOp+1), done), &DefaultPatchLimits), "MaxDataSize")
+	isOverLimit(check(validBsdiff, &PatchLimits{MaxControlSize: 1024}), "MaxControlSize")
+	assert.NoError(t, check(validBsdiff, &PatchLimits{MaxDataSize: 1024}), "DATA limits don't apply to bsdiff controls")
+
+	t.Logf("Applying in strict mode")
+	mainDir, err := ioutil.TempDir("", "checkpatch")
+	must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	targetDir := filepath.Join(mainDir, "target")
+	must(t, os.MkdirAll(targetDir, 0755))
+	must(t, ioutil.WriteFile(filepath.Join(targetDir, "old"), make([]byte, oldSize), 0644))
+
+	apply := func(patch *bytes.Buffer, strict bool, vetApply VetApplyFunc) error {
+		patchReader := seeksource.FromBytes(patch.Bytes())
+		_, err := patchReader.Resume(nil)
+		must(t, err)
+
+		actx := &ApplyContext{
+			TargetPath: targetDir,
+			OutputPath: filepath.Join(mainDir, "output"),
+			Consumer:   &state.Consumer{},
+			Strict:     strict,
+			VetApply:   vetApply,
+		}
+		return actx.ApplyPatch(patchReader)
+	}
+
+	must(t, apply(valid, true, nil))
+	isInvalid(apply(outOfRange, true, nil), "strict apply should refuse invalid ops")
+	isOverLimit(apply(valid, false, VetApplyLimits(PatchLimits{MaxTotalSize: 100})), "MaxTotalSize")
+}
+
+func unwrapError(err error) error {
+	for {
+		se, ok := err.(*errors.Error)
+		if !ok {
+			return err
+		}
+		err = se.Err
+	}
+}
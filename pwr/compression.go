@@ -118,5 +118,7 @@ func DecompressWire(ctx *wire.ReadContext, compression *CompressionSettings) (*w
 		return nil, errors.Wrap(fmt.Errorf("expected source to resume at 0, got %d", finalOffset), 0)
 	}
 
-	return wire.NewReadContext(finalSource), nil
+	rctx := wire.NewReadContext(finalSource)
+	rctx.SetLimits(ctx.GetLimits())
+	return rctx, nil
 }
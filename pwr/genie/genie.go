@@ -28,6 +28,7 @@ type Genie struct {
 // the contents
 func (g *Genie) ParseHeader(patchReader savior.SeekSource) error {
 	rawPatchWire := wire.NewReadContext(patchReader)
+	rawPatchWire.SetLimits(pwr.PatchWireLimits.ContainerLimits())
 	err := rawPatchWire.ExpectMagic(pwr.PatchMagic)
 	if err != nil {
 		return errors.Wrap(err, 1)
@@ -56,6 +57,7 @@ func (g *Genie) ParseHeader(patchReader savior.SeekSource) error {
 	if err != nil {
 		return errors.Wrap(err, 1)
 	}
+	patchWire.SetLimits(pwr.PatchWireLimits.BodyLimits())
 
 	return nil
 }
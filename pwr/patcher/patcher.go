@@ -22,7 +22,9 @@ type savingPatcher struct {
 	rctx     *wire.ReadContext
 	consumer *state.Consumer
 
-	sc SaveConsumer
+	sc           SaveConsumer
+	selection    *tlc.Selection
+	enforceSpace bool
 
 	targetContainer *tlc.Container
 	sourceContainer *tlc.Container
@@ -36,7 +38,11 @@ var _ Patcher = (*savingPatcher)(nil)
 
 // New reads the patch header and returns a patcher that
 // is ready to Resume, either from the start (nil checkpoint)
-// or partway through the patch
+// or partway through the patch.
+//
+// Unlike pwr.ApplyContext, the patcher has no strict mode: it trusts
+// the patch's ops. Untrusted patches should go through pwr.CheckPatch
+// (with pwr.DefaultPatchLimits, for example) first.
 func New(patchReader savior.SeekSource, consumer *state.Consumer) (Patcher, error) {
 	// Reading the header & both containers is done even
 	// when we resume patching partway through (from a checkpoint)
@@ -53,6 +59,7 @@ func New(patchReader savior.SeekSource, consumer *state.Consumer) (Patcher, erro
 	}
 
 	rawWire := wire.NewReadContext(patchReader)
+	rawWire.SetLimits(pwr.PatchWireLimits.ContainerLimits())
 
 	// Ensure magic
 
@@ -88,6 +95,8 @@ func New(patchReader savior.SeekSource, consumer *state.Consumer) (Patcher, erro
 		return nil, errors.Wrap(err, 0)
 	}
 
+	rctx.SetLimits(pwr.PatchWireLimits.BodyLimits())
+
 	consumer.Debugf("→ Created patcher")
 	consumer.Debugf("before: %s", targetContainer.Stats())
 	consumer.Debugf(" after: %s", sourceContainer.Stats())
@@ -120,6 +129,18 @@ func (sp *savingPatcher) Resume(c *Checkpoint, targetPool wsync.Pool, bowl bowl.
 		c = &Checkpoint{
 			FileIndex: 0,
 		}
+
+		if sp.enforceSpace {
+			estimate, err := bowl.EstimateSpace()
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			err = estimate.Check()
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+		}
 	}
 
 	var numFiles = int64(len(sp.sourceContainer.Files))
@@ -156,9 +177,17 @@ func (sp *savingPatcher) Resume(c *Checkpoint, targetPool wsync.Pool, bowl bowl.
 			}
 		}
 
-		err := sp.processFile(c, targetPool, sh, bowl)
-		if err != nil {
-			return errors.Wrap(err, 0)
+		if sp.selection.Matches(f.Path) {
+			err := sp.processFile(c, targetPool, sh, bowl)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+		} else {
+			consumer.Debugf("Skipping unselected '%s'", f.Path)
+			err := pwr.SkipSeries(sp.rctx, sh)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
 		}
 
 		// reset checkpoint and increment
@@ -220,13 +249,18 @@ func (sp *savingPatcher) processRsync(c *Checkpoint, targetPool wsync.Pool, sh *
 			return errors.Wrap(err, 0)
 		}
 
+		err = sp.checkDependency(sh, op)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
 		if sp.isFullFileOp(sh, op) {
 			// oh dang it's either a true no-op, or a rename.
 			// either way, we're not troubling the rsync patcher
 			// for that.
 			sp.consumer.Debugf("Transpose: '%s' -> '%s'",
 				sp.targetContainer.Files[op.FileIndex].Path,
-				sp.sourceContainer.Files[op.FileIndex].Path,
+				sp.sourceContainer.Files[sh.FileIndex].Path,
 			)
 
 			err := bwl.Transpose(bowl.Transposition{
@@ -337,6 +371,11 @@ func (sp *savingPatcher) processRsync(c *Checkpoint, targetPool wsync.Pool, sh *
 			return nil
 		}
 
+		err = sp.checkDependency(sh, op)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
 		wop, err := makeWop(op)
 		if err != nil {
 			return errors.Wrap(err, 0)
@@ -375,6 +414,16 @@ func makeWop(op *pwr.SyncOp) (wsync.Operation, error) {
 	}
 }
 
+// checkDependency makes sure block range ops only refer to target
+// files that are part of the selection, if any
+func (sp *savingPatcher) checkDependency(sh *pwr.SyncHeader, op *pwr.SyncOp) error {
+	if op.Type != pwr.SyncOp_BLOCK_RANGE {
+		return nil
+	}
+
+	return pwr.CheckSelectedDependency(sp.selection, sp.sourceContainer, sh.FileIndex, sp.targetContainer, op.FileIndex)
+}
+
 func (sp *savingPatcher) isFullFileOp(sh *pwr.SyncHeader, op *pwr.SyncOp) bool {
 	// only block range ops can be full-file ops
 	if op.Type != pwr.SyncOp_BLOCK_RANGE {
@@ -387,7 +436,7 @@ func (sp *savingPatcher) isFullFileOp(sh *pwr.SyncHeader, op *pwr.SyncOp) bool {
 	}
 
 	targetFile := sp.targetContainer.Files[op.FileIndex]
-	outputFile := sp.targetContainer.Files[sh.FileIndex]
+	outputFile := sp.sourceContainer.Files[sh.FileIndex]
 
 	// and both files have gotta be the same size
 	if targetFile.Size != outputFile.Size {
@@ -452,6 +501,11 @@ func (sp *savingPatcher) processBsdiff(c *Checkpoint, targetPool wsync.Pool, sh
 
 		targetIndex = bh.TargetIndex
 
+		err = pwr.CheckSelectedDependency(sp.selection, sp.sourceContainer, sh.FileIndex, sp.targetContainer, targetIndex)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
 		old, err = targetPool.GetReadSeeker(targetIndex)
 		if err != nil {
 			return errors.Wrap(err, 0)
@@ -566,6 +620,14 @@ func (sp *savingPatcher) SetSaveConsumer(sc SaveConsumer) {
 	sp.sc = sc
 }
 
+func (sp *savingPatcher) SetSelection(selection *tlc.Selection) {
+	sp.selection = selection
+}
+
+func (sp *savingPatcher) SetEnforceSpace(enforceSpace bool) {
+	sp.enforceSpace = enforceSpace
+}
+
 func (sp *savingPatcher) GetSourceContainer() *tlc.Container {
 	return sp.sourceContainer
 }
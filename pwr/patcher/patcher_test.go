@@ -11,6 +11,7 @@ import (
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
 	"github.com/itchio/wharf/wsync"
 	"github.com/stretchr/testify/assert"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/itchio/wharf/pwr/patcher"
 	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
 
 	"github.com/itchio/wharf/wtest"
 
@@ -274,3 +276,48 @@ func (psc *patcherSaveConsumer) ShouldSave() bool {
 func (psc *patcherSaveConsumer) Save(checkpoint *patcher.Checkpoint) (patcher.AfterSaveAction, error) {
 	return psc.save(checkpoint)
 }
+
+func FuzzNew(f *testing.F) {
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "a", Size: wtest.BlockSize, Mode: 0644}},
+		Size:  wtest.BlockSize,
+	}
+
+	patchBuffer := new(bytes.Buffer)
+	wctx := wire.NewWriteContext(patchBuffer)
+	err := wctx.WriteMagic(pwr.PatchMagic)
+	if err == nil {
+		err = wctx.WriteMessage(&pwr.PatchHeader{
+			Compression: &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE},
+		})
+	}
+	for _, msg := range []proto.Message{
+		container,
+		container,
+		&pwr.SyncHeader{FileIndex: 0},
+		&pwr.SyncOp{Type: pwr.SyncOp_BLOCK_RANGE, BlockSpan: 1},
+		&pwr.SyncOp{Type: pwr.SyncOp_HEY_YOU_DID_IT},
+	} {
+		if err == nil {
+			err = wctx.WriteMessage(msg)
+		}
+	}
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(patchBuffer.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		patchReader := seeksource.FromBytes(data)
+		_, err := patchReader.Resume(nil)
+		if err != nil {
+			return
+		}
+
+		p, err := patcher.New(patchReader, &state.Consumer{})
+		if err == nil {
+			assert.NotNil(t, p.GetSourceContainer())
+			assert.NotNil(t, p.GetTargetContainer())
+		}
+	})
+}
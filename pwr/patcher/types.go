@@ -46,6 +46,13 @@ type BsdiffCheckpoint struct {
 
 type Patcher interface {
 	SetSaveConsumer(sc SaveConsumer)
+	// SetSelection restricts patching to a subset of the files, for partial
+	// installs. Unselected files are skipped, and never passed to the bowl.
+	SetSelection(selection *tlc.Selection)
+	// SetEnforceSpace makes Resume check the bowl's space estimate against
+	// free disk space before writing anything, when starting from scratch.
+	// If there isn't enough, it returns a *diskspace.ErrInsufficientSpace
+	SetEnforceSpace(enforceSpace bool)
 	Resume(checkpoint *Checkpoint, targetPool wsync.Pool, bowl bowl.Bowl) error
 	Progress() float64
 
@@ -614,6 +614,13 @@ func runPatchingScenario(t *testing.T, scenario patchScenario) {
 		must(t, dctx.WritePatch(patchBuffer, signatureBuffer))
 	}()
 
+	func() {
+		patchReader := seeksource.FromBytes(patchBuffer.Bytes())
+		_, rErr := patchReader.Resume(nil)
+		must(t, rErr)
+		must(t, CheckPatch(patchReader, &DefaultPatchLimits))
+	}()
+
 	v1Before := filepath.Join(mainDir, "v1Before")
 	cpDir(t, v1, v1Before)
 
@@ -0,0 +1,26 @@
+package pwr
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/tlc"
+)
+
+// VetApplyPortability returns a VetApplyFunc that refuses patches whose
+// new container has portability issues (see tlc.CheckPortability), with a
+// *tlc.ErrNotPortable. Case-only renames from the old container are
+// reported too, since they break patching in place on case-insensitive
+// filesystems.
+func VetApplyPortability(opts tlc.PortabilityOptions) VetApplyFunc {
+	return func(actx *ApplyContext) error {
+		applyOpts := opts
+		if applyOpts.Previous == nil {
+			applyOpts.Previous = actx.TargetContainer
+		}
+
+		issues := actx.SourceContainer.CheckPortability(&applyOpts)
+		if len(issues) > 0 {
+			return errors.Wrap(&tlc.ErrNotPortable{Issues: issues}, 0)
+		}
+		return nil
+	}
+}
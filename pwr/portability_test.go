@@ -0,0 +1,79 @@
+package pwr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VetApplyPortability(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "vetapply-portability")
+	must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	targetDir := filepath.Join(mainDir, "target")
+	must(t, os.MkdirAll(targetDir, 0755))
+	must(t, ioutil.WriteFile(filepath.Join(targetDir, "old"), make([]byte, BlockSize), 0644))
+
+	container := func(name string) *tlc.Container {
+		return &tlc.Container{
+			Files: []*tlc.File{{Path: name, Size: BlockSize, Mode: 0644}},
+			Size:  BlockSize,
+		}
+	}
+
+	cook := func(target *tlc.Container, source *tlc.Container) *bytes.Buffer {
+		return cookPatch(t, func(wctx *wire.WriteContext) {
+			must(t, wctx.WriteMagic(PatchMagic))
+			must(t, wctx.WriteMessage(&PatchHeader{
+				Compression: &CompressionSettings{Algorithm: CompressionAlgorithm_NONE},
+			}))
+			must(t, wctx.WriteMessage(target))
+			must(t, wctx.WriteMessage(source))
+			must(t, wctx.WriteMessage(&SyncHeader{FileIndex: 0, Type: SyncHeader_RSYNC}))
+			must(t, wctx.WriteMessage(&SyncOp{Type: SyncOp_BLOCK_RANGE, FileIndex: 0, BlockIndex: 0, BlockSpan: 1}))
+			must(t, wctx.WriteMessage(&SyncOp{Type: SyncOp_HEY_YOU_DID_IT}))
+		})
+	}
+
+	apply := func(patch *bytes.Buffer, vetApply VetApplyFunc) error {
+		patchReader := seeksource.FromBytes(patch.Bytes())
+		_, err := patchReader.Resume(nil)
+		must(t, err)
+
+		actx := &ApplyContext{
+			TargetPath: targetDir,
+			OutputPath: filepath.Join(mainDir, "output"),
+			Consumer:   &state.Consumer{},
+			VetApply:   vetApply,
+		}
+		return actx.ApplyPatch(patchReader)
+	}
+
+	isNotPortable := func(err error, kind tlc.PortabilityIssueKind) {
+		if np, ok := unwrapError(err).(*tlc.ErrNotPortable); assert.True(t, ok, "should not be portable") {
+			assert.Equal(t, kind, np.Issues[0].Kind)
+		}
+	}
+
+	vetApply := VetApplyPortability(tlc.PortabilityOptions{})
+	must(t, apply(cook(container("old"), container("new")), vetApply))
+	isNotPortable(apply(cook(container("old"), container("CON.txt")), vetApply), tlc.IssueReservedName)
+
+	t.Logf("Reusing the same VetApplyFunc across applies")
+	vetApply = VetApplyPortability(tlc.PortabilityOptions{})
+	isNotPortable(apply(cook(container("Old"), container("old")), vetApply), tlc.IssueCaseRename)
+	must(t, apply(cook(container("old"), container("old")), vetApply))
+
+	t.Logf("With an explicit previous container")
+	vetApply = VetApplyPortability(tlc.PortabilityOptions{Previous: container("Old")})
+	isNotPortable(apply(cook(container("old"), container("old")), vetApply), tlc.IssueCaseRename)
+}
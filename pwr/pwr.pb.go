@@ -19,6 +19,9 @@ It has these top-level messages:
 	ManifestBlockHash
 	WoundsHeader
 	Wound
+	ZipIndexHeader
+	ZipIndexEntry
+	ZipIndexCheckpoint
 */
 package pwr
 
@@ -418,6 +421,107 @@ func (m *Wound) GetKind() WoundKind {
 	return WoundKind_FILE
 }
 
+// Zip index file format: header, then for each deflated entry
+// of the zip, a ZipIndexEntry followed by its checkpoints
+type ZipIndexHeader struct {
+	Compression *CompressionSettings `protobuf:"bytes,1,opt,name=compression" json:"compression,omitempty"`
+}
+
+func (m *ZipIndexHeader) Reset()                    { *m = ZipIndexHeader{} }
+func (m *ZipIndexHeader) String() string            { return proto.CompactTextString(m) }
+func (*ZipIndexHeader) ProtoMessage()               {}
+func (*ZipIndexHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *ZipIndexHeader) GetCompression() *CompressionSettings {
+	if m != nil {
+		return m.Compression
+	}
+	return nil
+}
+
+type ZipIndexEntry struct {
+	Path             string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	CompressedSize   int64  `protobuf:"varint,2,opt,name=compressedSize" json:"compressedSize,omitempty"`
+	UncompressedSize int64  `protobuf:"varint,3,opt,name=uncompressedSize" json:"uncompressedSize,omitempty"`
+	NumCheckpoints   int64  `protobuf:"varint,4,opt,name=numCheckpoints" json:"numCheckpoints,omitempty"`
+	Crc32            uint32 `protobuf:"varint,5,opt,name=crc32" json:"crc32,omitempty"`
+}
+
+func (m *ZipIndexEntry) Reset()                    { *m = ZipIndexEntry{} }
+func (m *ZipIndexEntry) String() string            { return proto.CompactTextString(m) }
+func (*ZipIndexEntry) ProtoMessage()               {}
+func (*ZipIndexEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *ZipIndexEntry) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *ZipIndexEntry) GetCompressedSize() int64 {
+	if m != nil {
+		return m.CompressedSize
+	}
+	return 0
+}
+
+func (m *ZipIndexEntry) GetUncompressedSize() int64 {
+	if m != nil {
+		return m.UncompressedSize
+	}
+	return 0
+}
+
+func (m *ZipIndexEntry) GetNumCheckpoints() int64 {
+	if m != nil {
+		return m.NumCheckpoints
+	}
+	return 0
+}
+
+func (m *ZipIndexEntry) GetCrc32() uint32 {
+	if m != nil {
+		return m.Crc32
+	}
+	return 0
+}
+
+// Where inflating an entry can resume: the start of a deflate block,
+// as a bit offset into the entry's compressed data, along with the
+// (up to) 32KiB of uncompressed data that precede it
+type ZipIndexCheckpoint struct {
+	Offset    int64  `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	BitOffset int64  `protobuf:"varint,2,opt,name=bitOffset" json:"bitOffset,omitempty"`
+	Window    []byte `protobuf:"bytes,3,opt,name=window,proto3" json:"window,omitempty"`
+}
+
+func (m *ZipIndexCheckpoint) Reset()                    { *m = ZipIndexCheckpoint{} }
+func (m *ZipIndexCheckpoint) String() string            { return proto.CompactTextString(m) }
+func (*ZipIndexCheckpoint) ProtoMessage()               {}
+func (*ZipIndexCheckpoint) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ZipIndexCheckpoint) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *ZipIndexCheckpoint) GetBitOffset() int64 {
+	if m != nil {
+		return m.BitOffset
+	}
+	return 0
+}
+
+func (m *ZipIndexCheckpoint) GetWindow() []byte {
+	if m != nil {
+		return m.Window
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*PatchHeader)(nil), "io.itch.wharf.pwr.PatchHeader")
 	proto.RegisterType((*SyncHeader)(nil), "io.itch.wharf.pwr.SyncHeader")
@@ -430,6 +534,9 @@ func init() {
 	proto.RegisterType((*ManifestBlockHash)(nil), "io.itch.wharf.pwr.ManifestBlockHash")
 	proto.RegisterType((*WoundsHeader)(nil), "io.itch.wharf.pwr.WoundsHeader")
 	proto.RegisterType((*Wound)(nil), "io.itch.wharf.pwr.Wound")
+	proto.RegisterType((*ZipIndexHeader)(nil), "io.itch.wharf.pwr.ZipIndexHeader")
+	proto.RegisterType((*ZipIndexEntry)(nil), "io.itch.wharf.pwr.ZipIndexEntry")
+	proto.RegisterType((*ZipIndexCheckpoint)(nil), "io.itch.wharf.pwr.ZipIndexCheckpoint")
 	proto.RegisterEnum("io.itch.wharf.pwr.CompressionAlgorithm", CompressionAlgorithm_name, CompressionAlgorithm_value)
 	proto.RegisterEnum("io.itch.wharf.pwr.HashAlgorithm", HashAlgorithm_name, HashAlgorithm_value)
 	proto.RegisterEnum("io.itch.wharf.pwr.WoundKind", WoundKind_name, WoundKind_value)
@@ -440,46 +547,54 @@ func init() {
 func init() { proto.RegisterFile("pwr/pwr.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 653 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x94, 0xc1, 0x4e, 0xdb, 0x4c,
-	0x10, 0xc7, 0x71, 0xe2, 0x00, 0x99, 0x84, 0xb0, 0x2c, 0x1c, 0xa2, 0x4f, 0x7c, 0x28, 0xf2, 0xa1,
-	0x20, 0x54, 0xb9, 0xd4, 0x48, 0xa8, 0x87, 0xaa, 0x6a, 0x62, 0x07, 0x62, 0x25, 0xc4, 0x68, 0x9d,
-	0x0a, 0x85, 0x1e, 0xa2, 0x25, 0x76, 0x12, 0x8b, 0x60, 0xbb, 0xf6, 0x52, 0x37, 0x47, 0x5e, 0xa3,
-	0xcf, 0xd7, 0x07, 0xa9, 0x76, 0xed, 0x90, 0xd0, 0x86, 0x9e, 0xb8, 0xcd, 0xcc, 0xce, 0xfc, 0xe7,
-	0xb7, 0xb3, 0x63, 0xc3, 0x56, 0x98, 0x44, 0xef, 0xc2, 0x24, 0x52, 0xc3, 0x28, 0x60, 0x01, 0xde,
-	0xf1, 0x02, 0xd5, 0x63, 0xc3, 0x89, 0x9a, 0x4c, 0x68, 0x34, 0x52, 0xc3, 0x24, 0x52, 0xae, 0xa1,
-	0x74, 0x45, 0xd9, 0x70, 0xd2, 0x72, 0xa9, 0xe3, 0x46, 0xb8, 0x05, 0xa5, 0x61, 0x70, 0x1f, 0x46,
-	0x6e, 0x1c, 0x7b, 0x81, 0x5f, 0x95, 0x6a, 0xd2, 0x51, 0x49, 0x7b, 0xa3, 0xfe, 0x55, 0xa7, 0xea,
-	0x8b, 0x2c, 0xdb, 0x65, 0xcc, 0xf3, 0xc7, 0x31, 0x59, 0x2e, 0x55, 0x1e, 0x25, 0x00, 0x7b, 0xe6,
-	0x0f, 0x33, 0xe1, 0x33, 0x90, 0xd9, 0x2c, 0x74, 0x85, 0x62, 0x45, 0x53, 0x56, 0x28, 0x2e, 0x92,
-	0xd5, 0xde, 0x2c, 0x74, 0x89, 0xc8, 0xc7, 0xfb, 0x50, 0x1c, 0x79, 0x53, 0xd7, 0xf4, 0x1d, 0xf7,
-	0x47, 0x15, 0xd5, 0xa4, 0xa3, 0x3c, 0x59, 0x04, 0x94, 0xff, 0x41, 0xe6, 0xb9, 0xb8, 0x08, 0x05,
-	0x62, 0xf7, 0xbb, 0x3a, 0x5a, 0xc3, 0x00, 0xeb, 0x0d, 0xdb, 0x30, 0xcf, 0xcf, 0x91, 0xa4, 0x9c,
-	0x40, 0xb9, 0x11, 0x3b, 0xde, 0x68, 0x94, 0x41, 0xd4, 0xa0, 0xc4, 0x68, 0x34, 0x76, 0x59, 0x2a,
-	0x27, 0x09, 0xb9, 0xe5, 0x90, 0xf2, 0x4b, 0x82, 0x75, 0x0e, 0x62, 0x85, 0x58, 0x7b, 0x46, 0x7c,
-	0xf0, 0x02, 0xb1, 0x15, 0xbe, 0x48, 0x9b, 0xfb, 0x83, 0x16, 0x1f, 0x00, 0xdc, 0x4e, 0x83, 0xe1,
-	0x5d, 0x7a, 0x9c, 0x17, 0xc7, 0x4b, 0x11, 0x5e, 0x2d, 0x3c, 0x3b, 0xa4, 0x7e, 0x55, 0x4e, 0xab,
-	0x9f, 0x02, 0x18, 0x83, 0xec, 0x50, 0x46, 0xab, 0x85, 0x9a, 0x74, 0x54, 0x26, 0xc2, 0x56, 0xce,
-	0xb2, 0xfb, 0x6f, 0x43, 0xa9, 0xd1, 0xb1, 0xf4, 0xf6, 0x80, 0xd4, 0xbb, 0x17, 0x4d, 0xb4, 0x86,
-	0x37, 0x41, 0x36, 0xea, 0xbd, 0x3a, 0x92, 0xf0, 0x2e, 0x54, 0x5a, 0xcd, 0xfe, 0xa0, 0x6f, 0x7d,
-	0x19, 0x18, 0xa6, 0x31, 0x30, 0x7b, 0xe8, 0x11, 0x29, 0x5f, 0x61, 0xdb, 0xf6, 0xc6, 0x3e, 0x65,
-	0x0f, 0x91, 0xfb, 0xea, 0x2f, 0x7f, 0x01, 0xc5, 0x06, 0xa7, 0x6e, 0xd1, 0x78, 0x82, 0xff, 0x83,
-	0xcd, 0xc4, 0xa5, 0xc2, 0x16, 0x9a, 0x5b, 0xe4, 0xc9, 0xe7, 0xf3, 0x88, 0x59, 0x14, 0xf8, 0x63,
-	0x71, 0x9a, 0x13, 0xf7, 0x5a, 0x8a, 0x28, 0xdf, 0x61, 0x77, 0x45, 0x33, 0xdc, 0x84, 0x22, 0x9d,
-	0x8e, 0x83, 0xc8, 0x63, 0x93, 0xfb, 0xec, 0x75, 0x0e, 0xff, 0xcd, 0x59, 0x9f, 0xa7, 0x93, 0x45,
-	0x25, 0xae, 0xc2, 0xc6, 0xb7, 0x07, 0x3a, 0xf5, 0xd8, 0x4c, 0xb4, 0x2e, 0x90, 0xb9, 0xab, 0xfc,
-	0x94, 0xa0, 0x72, 0x49, 0x7d, 0x6f, 0xe4, 0xc6, 0xec, 0xb5, 0xa7, 0x83, 0x3f, 0x2d, 0xd3, 0xe7,
-	0x04, 0x7d, 0x6d, 0x85, 0x0e, 0x1f, 0xc0, 0x2a, 0x6c, 0xe5, 0x10, 0x76, 0xe6, 0x6c, 0x8b, 0x29,
-	0x63, 0x90, 0x27, 0xf3, 0x09, 0x97, 0x89, 0xb0, 0x95, 0x0a, 0x94, 0xaf, 0x83, 0x07, 0xdf, 0x89,
-	0xd3, 0x2b, 0x28, 0x09, 0x14, 0x84, 0x8f, 0xf7, 0xa0, 0xe0, 0x2d, 0xed, 0x7f, 0xea, 0xf0, 0x68,
-	0xcc, 0x68, 0xc4, 0xb2, 0xb5, 0x4d, 0x1d, 0x8c, 0x20, 0xef, 0xfa, 0x4e, 0xb6, 0xab, 0xdc, 0xc4,
-	0x27, 0x20, 0xdf, 0x79, 0xbe, 0x23, 0xf6, 0xb3, 0xa2, 0xed, 0xaf, 0x40, 0x17, 0x5d, 0xda, 0x9e,
-	0xef, 0x10, 0x91, 0x79, 0xfc, 0x19, 0xf6, 0x56, 0xbd, 0x05, 0xdf, 0xd1, 0xae, 0xd5, 0x6d, 0x66,
-	0xdf, 0x2c, 0xb1, 0x7a, 0x1d, 0x13, 0x49, 0x3c, 0x7a, 0x71, 0x63, 0x5e, 0xa1, 0x1c, 0xb7, 0x6e,
-	0xec, 0x9e, 0x81, 0xf2, 0xc7, 0x6f, 0x61, 0xeb, 0xd9, 0x3c, 0xf8, 0xbe, 0xdb, 0xad, 0x7a, 0xbb,
-	0xf9, 0x5e, 0xfb, 0x30, 0x38, 0xd5, 0x52, 0x05, 0x9d, 0xe8, 0xa7, 0x9a, 0x8e, 0xa4, 0xe3, 0x8f,
-	0x50, 0x7c, 0x42, 0xe0, 0x22, 0xe7, 0x66, 0x87, 0x37, 0x29, 0xc1, 0x86, 0xdd, 0xbf, 0xec, 0x98,
-	0xdd, 0x36, 0x92, 0xf0, 0x06, 0xe4, 0x0d, 0x93, 0xa0, 0x1c, 0x57, 0xd2, 0x3b, 0x96, 0xdd, 0x34,
-	0x06, 0x22, 0x2d, 0xdf, 0x28, 0xdc, 0xe4, 0xc3, 0x24, 0xba, 0x5d, 0x17, 0x7f, 0xcc, 0xd3, 0xdf,
-	0x01, 0x00, 0x00, 0xff, 0xff, 0x12, 0xce, 0x5c, 0x7e, 0x42, 0x05, 0x00, 0x00,
+	// 778 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0xcd, 0x8e, 0xe2, 0x46,
+	0x10, 0x5e, 0xf3, 0x37, 0x4b, 0xf1, 0xb3, 0xde, 0xde, 0x55, 0x84, 0xa2, 0xcd, 0x0a, 0xf9, 0xb0,
+	0x3b, 0x42, 0x11, 0xd9, 0x18, 0x69, 0x95, 0x43, 0x14, 0x05, 0x0c, 0x33, 0x58, 0xb0, 0x78, 0xd4,
+	0x26, 0x1a, 0x41, 0x0e, 0xc8, 0x63, 0x37, 0xd0, 0x1a, 0xa6, 0xed, 0xd8, 0x4d, 0x1c, 0x72, 0x9b,
+	0xd7, 0xc8, 0x7b, 0xe4, 0x8d, 0xf2, 0x20, 0x51, 0xb7, 0xcd, 0xcf, 0x30, 0xcc, 0x9e, 0xe6, 0x56,
+	0xf5, 0x75, 0xd5, 0xd7, 0x5f, 0x55, 0x97, 0xcb, 0x50, 0x09, 0xe2, 0xf0, 0x87, 0x20, 0x0e, 0x9b,
+	0x41, 0xe8, 0x73, 0x1f, 0xbd, 0xa6, 0x7e, 0x93, 0x72, 0x77, 0xd9, 0x8c, 0x97, 0x4e, 0x38, 0x6f,
+	0x06, 0x71, 0xa8, 0x5d, 0x43, 0xe9, 0xca, 0xe1, 0xee, 0xb2, 0x4f, 0x1c, 0x8f, 0x84, 0xa8, 0x0f,
+	0x25, 0xd7, 0xbf, 0x0b, 0x42, 0x12, 0x45, 0xd4, 0x67, 0x35, 0xa5, 0xae, 0x9c, 0x97, 0xf4, 0x0f,
+	0xcd, 0x47, 0x79, 0x4d, 0x63, 0x1f, 0x65, 0x13, 0xce, 0x29, 0x5b, 0x44, 0xf8, 0x30, 0x55, 0xbb,
+	0x57, 0x00, 0xec, 0x0d, 0x73, 0x53, 0xe2, 0xcf, 0x90, 0xe3, 0x9b, 0x80, 0x48, 0xc6, 0xaa, 0xae,
+	0x9d, 0x60, 0xdc, 0x07, 0x37, 0xc7, 0x9b, 0x80, 0x60, 0x19, 0x8f, 0xde, 0x41, 0x71, 0x4e, 0x57,
+	0xc4, 0x64, 0x1e, 0xf9, 0xab, 0xa6, 0xd6, 0x95, 0xf3, 0x2c, 0xde, 0x03, 0xda, 0x77, 0x90, 0x13,
+	0xb1, 0xa8, 0x08, 0x79, 0x6c, 0x4f, 0x46, 0x86, 0xfa, 0x02, 0x01, 0x14, 0x3a, 0x76, 0xd7, 0xbc,
+	0xb8, 0x50, 0x15, 0xed, 0x13, 0x94, 0x3b, 0x91, 0x47, 0xe7, 0xf3, 0x54, 0x44, 0x1d, 0x4a, 0xdc,
+	0x09, 0x17, 0x84, 0x27, 0x74, 0x8a, 0xa4, 0x3b, 0x84, 0xb4, 0xff, 0x14, 0x28, 0x08, 0x21, 0x56,
+	0x80, 0xf4, 0x07, 0x8a, 0xdf, 0x3f, 0xa1, 0xd8, 0x0a, 0x9e, 0x54, 0x9b, 0x39, 0x52, 0x8b, 0xde,
+	0x03, 0xdc, 0xac, 0x7c, 0xf7, 0x36, 0x39, 0xce, 0xca, 0xe3, 0x03, 0x44, 0x64, 0x4b, 0xcf, 0x0e,
+	0x1c, 0x56, 0xcb, 0x25, 0xd9, 0x3b, 0x00, 0x21, 0xc8, 0x79, 0x0e, 0x77, 0x6a, 0xf9, 0xba, 0x72,
+	0x5e, 0xc6, 0xd2, 0xd6, 0x3e, 0xa7, 0xf5, 0xbf, 0x82, 0x52, 0x67, 0x68, 0x19, 0x83, 0x19, 0x6e,
+	0x8f, 0x2e, 0x7b, 0xea, 0x0b, 0xf4, 0x12, 0x72, 0xdd, 0xf6, 0xb8, 0xad, 0x2a, 0xe8, 0x0d, 0x54,
+	0xfb, 0xbd, 0xc9, 0x6c, 0x62, 0xfd, 0x36, 0xeb, 0x9a, 0xdd, 0x99, 0x39, 0x56, 0xef, 0x55, 0xed,
+	0x77, 0x78, 0x65, 0xd3, 0x05, 0x73, 0xf8, 0x3a, 0x24, 0xcf, 0xfe, 0xf2, 0x97, 0x50, 0xec, 0x08,
+	0xd5, 0x7d, 0x27, 0x5a, 0xa2, 0x6f, 0xe1, 0x65, 0x4c, 0x1c, 0x69, 0x4b, 0xce, 0x0a, 0xde, 0xf9,
+	0xa2, 0x1f, 0x11, 0x0f, 0x7d, 0xb6, 0x90, 0xa7, 0x19, 0x59, 0xd7, 0x01, 0xa2, 0xfd, 0x09, 0x6f,
+	0x4e, 0x5c, 0x86, 0x7a, 0x50, 0x74, 0x56, 0x0b, 0x3f, 0xa4, 0x7c, 0x79, 0x97, 0xbe, 0xce, 0xc7,
+	0xaf, 0xeb, 0x6c, 0x6f, 0xc3, 0xf1, 0x3e, 0x13, 0xd5, 0xe0, 0xec, 0x8f, 0xb5, 0xb3, 0xa2, 0x7c,
+	0x23, 0xaf, 0xce, 0xe3, 0xad, 0xab, 0xfd, 0xa3, 0x40, 0xf5, 0x8b, 0xc3, 0xe8, 0x9c, 0x44, 0xfc,
+	0xb9, 0xbb, 0x83, 0x7e, 0x39, 0x54, 0x9f, 0x91, 0xea, 0xeb, 0x27, 0x78, 0x44, 0x03, 0x4e, 0xc9,
+	0xd6, 0x3e, 0xc2, 0xeb, 0xad, 0xb6, 0x7d, 0x97, 0x11, 0xe4, 0x96, 0xdb, 0x0e, 0x97, 0xb1, 0xb4,
+	0xb5, 0x2a, 0x94, 0xaf, 0xfd, 0x35, 0xf3, 0xa2, 0xa4, 0x04, 0x2d, 0x86, 0xbc, 0xf4, 0xd1, 0x5b,
+	0xc8, 0xd3, 0x83, 0xf9, 0x4f, 0x1c, 0x81, 0x46, 0xdc, 0x09, 0x79, 0x3a, 0xb6, 0x89, 0x83, 0x54,
+	0xc8, 0x12, 0xe6, 0xa5, 0xb3, 0x2a, 0x4c, 0xf4, 0x09, 0x72, 0xb7, 0x94, 0x79, 0x72, 0x3e, 0xab,
+	0xfa, 0xbb, 0x13, 0xd2, 0xe5, 0x2d, 0x03, 0xca, 0x3c, 0x2c, 0x23, 0xb5, 0x29, 0x54, 0xa7, 0x34,
+	0x90, 0x23, 0xfe, 0xec, 0xb3, 0xf6, 0xaf, 0x02, 0x95, 0x2d, 0x79, 0x8f, 0xf1, 0x70, 0x23, 0x5a,
+	0x11, 0x38, 0x3c, 0x69, 0x45, 0x11, 0x4b, 0x1b, 0x7d, 0x80, 0xea, 0x36, 0x89, 0x78, 0x36, 0xfd,
+	0x9b, 0xa4, 0x45, 0x1e, 0xa1, 0xa8, 0x01, 0xea, 0x9a, 0x1d, 0x45, 0x26, 0xa5, 0x3f, 0xc2, 0x05,
+	0x27, 0x5b, 0xdf, 0x19, 0x4b, 0xe2, 0xde, 0x06, 0x3e, 0x65, 0x3c, 0x4a, 0xbf, 0xd8, 0x23, 0x54,
+	0xf4, 0xd5, 0x0d, 0xdd, 0x96, 0x2e, 0xbf, 0xdb, 0x0a, 0x4e, 0x1c, 0xed, 0x06, 0xd0, 0x56, 0xf6,
+	0x3e, 0x18, 0x7d, 0x03, 0x05, 0x7f, 0x3e, 0x8f, 0x08, 0x4f, 0x9f, 0x26, 0xf5, 0xe4, 0x62, 0xa0,
+	0xdc, 0x4a, 0x8e, 0xd2, 0xb5, 0xb2, 0x03, 0x44, 0x56, 0x4c, 0x99, 0xe7, 0xc7, 0x52, 0x6b, 0x19,
+	0xa7, 0x5e, 0xe3, 0x57, 0x78, 0x7b, 0xea, 0x1b, 0x10, 0xbb, 0x61, 0x64, 0x8d, 0x7a, 0xe9, 0xae,
+	0xc4, 0xd6, 0x78, 0x68, 0xaa, 0x8a, 0x40, 0x2f, 0xa7, 0xe6, 0x95, 0x9a, 0x11, 0xd6, 0xd4, 0x1e,
+	0x77, 0xd5, 0x6c, 0xe3, 0x7b, 0xa8, 0x3c, 0x98, 0x43, 0xb1, 0x67, 0xec, 0x7e, 0x7b, 0xd0, 0xfb,
+	0x51, 0xff, 0x69, 0xd6, 0xd2, 0x13, 0x06, 0x03, 0x1b, 0x2d, 0xdd, 0x50, 0x95, 0xc6, 0xcf, 0x50,
+	0xdc, 0x3d, 0xbd, 0x20, 0xb9, 0x30, 0x87, 0xe2, 0x92, 0x12, 0x9c, 0xd9, 0x93, 0x2f, 0x43, 0x73,
+	0x34, 0x50, 0x15, 0x74, 0x06, 0xd9, 0xae, 0x89, 0xd5, 0x8c, 0x60, 0x32, 0x86, 0x96, 0xdd, 0xeb,
+	0xce, 0x64, 0x58, 0xb6, 0x93, 0x9f, 0x66, 0x83, 0x38, 0xbc, 0x29, 0xc8, 0x3f, 0x55, 0xeb, 0xff,
+	0x01, 0x00, 0x77, 0x47, 0xb1, 0xe6, 0xba, 0x06, 0x00, 0x00,
 }
@@ -98,6 +98,7 @@ func (rc *RediffContext) AnalyzePatch(patchReader savior.SeekSource) error {
 	var err error
 
 	rctx := wire.NewReadContext(patchReader)
+	rctx.SetLimits(PatchWireLimits.ContainerLimits())
 
 	err = rctx.ExpectMagic(PatchMagic)
 	if err != nil {
@@ -128,6 +129,7 @@ func (rc *RediffContext) AnalyzePatch(patchReader savior.SeekSource) error {
 		return errors.Wrap(err, 0)
 	}
 	rc.SourceContainer = sourceContainer
+	rctx.SetLimits(PatchWireLimits.BodyLimits())
 
 	rop := &SyncOp{}
 
@@ -253,6 +255,7 @@ func (rc *RediffContext) OptimizePatch(patchReader savior.SeekSource, patchWrite
 	}
 
 	rctx := wire.NewReadContext(patchReader)
+	rctx.SetLimits(PatchWireLimits.ContainerLimits())
 	wctx := wire.NewWriteContext(patchWriter)
 
 	err = wctx.WriteMagic(PatchMagic)
@@ -310,6 +313,7 @@ func (rc *RediffContext) OptimizePatch(patchReader savior.SeekSource, patchWrite
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
+	rctx.SetLimits(PatchWireLimits.BodyLimits())
 
 	err = wctx.WriteMessage(sourceContainer)
 	if err != nil {
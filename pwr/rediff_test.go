@@ -345,6 +345,14 @@ func runRediffScenario(t *testing.T, scenario patchScenario) {
 		assert.NoError(t, os.RemoveAll(v1Before))
 		cpDir(t, v1, v1Before)
 
+		func() {
+			patchReader := seeksource.FromBytes(optimizedPatchBuffer.Bytes())
+			_, rErr := patchReader.Resume(nil)
+			assert.NoError(t, rErr)
+
+			assert.NoError(t, CheckPatch(patchReader, &DefaultPatchLimits))
+		}()
+
 		func() {
 			actx := &ApplyContext{
 				TargetPath: v1Before,
@@ -363,6 +371,29 @@ func runRediffScenario(t *testing.T, scenario patchScenario) {
 			assert.NoError(t, AssertValid(v1After, signature))
 			log("Optimized patch applies cleanly.")
 		}()
+
+		assert.NoError(t, os.RemoveAll(v1After))
+
+		func() {
+			actx := &ApplyContext{
+				TargetPath: v1Before,
+				OutputPath: v1After,
+
+				Consumer: consumer,
+				Strict:   true,
+				Limits:   &DefaultPatchLimits,
+			}
+
+			patchReader := seeksource.FromBytes(optimizedPatchBuffer.Bytes())
+			_, rErr := patchReader.Resume(nil)
+			assert.NoError(t, rErr)
+
+			aErr := actx.ApplyPatch(patchReader)
+			assert.NoError(t, aErr)
+
+			assert.NoError(t, AssertValid(v1After, signature))
+			log("Optimized patch applies cleanly in strict mode.")
+		}()
 	}()
 }
 
@@ -0,0 +1,98 @@
+package pwr
+
+import (
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+)
+
+// ErrUnselectedDependency is returned when patching a partial install,
+// if a selected file needs data from a file of the old build that isn't
+// part of the selection (and thus isn't installed). Applying with a
+// healer works around it, by fetching the missing data from elsewhere.
+type ErrUnselectedDependency struct {
+	SourcePath string
+	TargetPath string
+}
+
+var _ error = (*ErrUnselectedDependency)(nil)
+
+func (e *ErrUnselectedDependency) Error() string {
+	return fmt.Sprintf("selected file '%s' needs data from unselected file '%s'", e.SourcePath, e.TargetPath)
+}
+
+// CheckSelectedDependency returns an *ErrUnselectedDependency if the given
+// target file isn't part of selection. sourceIndex and targetIndex are
+// indices into sourceContainer and targetContainer respectively.
+func CheckSelectedDependency(selection *tlc.Selection, sourceContainer *tlc.Container, sourceIndex int64,
+	targetContainer *tlc.Container, targetIndex int64) error {
+	if selection == nil {
+		return nil
+	}
+
+	if targetIndex < 0 || targetIndex >= int64(len(targetContainer.Files)) {
+		return errors.Wrap(ErrMalformedPatch, 1)
+	}
+
+	targetFile := targetContainer.Files[targetIndex]
+	if selection.Matches(targetFile.Path) {
+		return nil
+	}
+
+	return errors.Wrap(&ErrUnselectedDependency{
+		SourcePath: sourceContainer.Files[sourceIndex].Path,
+		TargetPath: targetFile.Path,
+	}, 1)
+}
+
+// SkipSeries reads and throws away all the messages of the patch series
+// announced by sh (rsync ops or bsdiff controls), up to and including
+// the sentinel SyncOp. It's used to leave out unselected files.
+func SkipSeries(rctx *wire.ReadContext, sh *SyncHeader) error {
+	switch sh.Type {
+	case SyncHeader_RSYNC:
+		// ops are followed by a sentinel, handled below
+	case SyncHeader_BSDIFF:
+		bh := &BsdiffHeader{}
+		err := rctx.ReadMessage(bh)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		ctrl := &bsdiff.Control{}
+		for {
+			ctrl.Reset()
+			err = rctx.ReadMessage(ctrl)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			if ctrl.Eof {
+				break
+			}
+		}
+	default:
+		return errors.Wrap(fmt.Errorf("unknown patch series kind %d", sh.Type), 0)
+	}
+
+	rop := &SyncOp{}
+	for {
+		rop.Reset()
+		err := rctx.ReadMessage(rop)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		if rop.Type == SyncOp_HEY_YOU_DID_IT {
+			return nil
+		}
+
+		if sh.Type == SyncHeader_BSDIFF {
+			// bsdiff series only ever have the sentinel after the controls
+			return errors.Wrap(ErrMalformedPatch, 0)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package pwr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PartialInstall(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "partial-install")
+	must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	v1 := filepath.Join(mainDir, "v1")
+	makeTestDir(t, v1, testDirSettings{
+		entries: []testDirEntry{
+			{path: "bin/game", seed: 0x1},
+			{path: "lang/en/strings", seed: 0x2, size: BlockSize*3 + 12},
+			{path: "lang/fr/strings", seed: 0x3, size: BlockSize*3 + 12},
+			// removed in v2
+			{path: "lang/fr/credits", seed: 0x6},
+		},
+	})
+
+	v2 := filepath.Join(mainDir, "v2")
+	makeTestDir(t, v2, testDirSettings{
+		entries: []testDirEntry{
+			{path: "bin/game", seed: 0x4},
+			{path: "lang/en/strings", seed: 0x2, size: BlockSize*4 + 12},
+			{path: "lang/fr/strings", seed: 0x5, size: BlockSize*4 + 12},
+			// same contents as the english strings from v1
+			{path: "lang/fr/fallback", seed: 0x2, size: BlockSize*3 + 12},
+		},
+	})
+
+	consumer := &state.Consumer{}
+	makePatch := func(target string, source string) (*bytes.Buffer, *SignatureInfo) {
+		targetContainer, err := tlc.WalkAny(target, &tlc.WalkOpts{})
+		must(t, err)
+		targetSignature, err := ComputeSignature(targetContainer, fspool.New(targetContainer, target), consumer)
+		must(t, err)
+
+		sourceContainer, err := tlc.WalkAny(source, &tlc.WalkOpts{})
+		must(t, err)
+
+		patchBuffer := new(bytes.Buffer)
+		signatureBuffer := new(bytes.Buffer)
+		dctx := &DiffContext{
+			Compression: &CompressionSettings{Algorithm: CompressionAlgorithm_NONE},
+			Consumer:    consumer,
+
+			SourceContainer: sourceContainer,
+			Pool:            fspool.New(sourceContainer, source),
+
+			TargetContainer: targetContainer,
+			TargetSignature: targetSignature,
+		}
+		must(t, dctx.WritePatch(patchBuffer, signatureBuffer))
+
+		sigReader := seeksource.FromBytes(signatureBuffer.Bytes())
+		_, err = sigReader.Resume(nil)
+		must(t, err)
+		signature, err := ReadSignature(sigReader)
+		must(t, err)
+
+		return patchBuffer, signature
+	}
+
+	apply := func(patch *bytes.Buffer, actx *ApplyContext) error {
+		patchReader := seeksource.FromBytes(patch.Bytes())
+		_, err := patchReader.Resume(nil)
+		must(t, err)
+		actx.Consumer = consumer
+		return actx.ApplyPatch(patchReader)
+	}
+
+	validate := func(dir string, signature *SignatureInfo, selection *tlc.Selection) error {
+		vctx := &ValidatorContext{
+			FailFast:  true,
+			Consumer:  consumer,
+			Selection: selection,
+		}
+		return vctx.Validate(dir, signature)
+	}
+
+	onlyEnglish := &tlc.Selection{Exclude: []string{"lang/fr"}}
+
+	t.Logf("Fresh partial install of v1")
+	_, v1Signature := makePatch(tlc.NullPath, v1)
+	freshPatch, _ := makePatch(tlc.NullPath, v1)
+	partial := filepath.Join(mainDir, "partial")
+	must(t, apply(freshPatch, &ApplyContext{
+		TargetPath: tlc.NullPath,
+		OutputPath: partial,
+		Selection:  onlyEnglish,
+	}))
+
+	_, err = os.Lstat(filepath.Join(partial, "lang", "fr"))
+	assert.True(t, os.IsNotExist(err), "unselected dirs should not be created")
+
+	must(t, validate(partial, v1Signature, onlyEnglish))
+	assert.Error(t, validate(partial, v1Signature, nil), "full validation should find missing files")
+
+	t.Logf("Patching partial install in-place to v2")
+	// an unselected file, installed some other way, that v2 removes
+	credits := filepath.Join(partial, "lang", "fr", "credits")
+	must(t, os.MkdirAll(filepath.Dir(credits), 0755))
+	must(t, ioutil.WriteFile(credits, []byte("credits"), 0644))
+
+	patch, v2Signature := makePatch(v1, v2)
+	must(t, apply(patch, &ApplyContext{
+		TargetPath: partial,
+		OutputPath: partial,
+		InPlace:    true,
+		Selection:  onlyEnglish,
+	}))
+	must(t, validate(partial, v2Signature, onlyEnglish))
+
+	_, err = os.Lstat(filepath.Join(partial, "lang", "fr", "strings"))
+	assert.True(t, os.IsNotExist(err), "unselected files should not be written")
+	_, err = os.Lstat(credits)
+	assert.NoError(t, err, "unselected files should not be deleted")
+
+	t.Logf("Patching with a selection that needs unselected data")
+	onlyFrench := &tlc.Selection{Exclude: []string{"lang/en"}}
+	frenchDir := filepath.Join(mainDir, "french")
+	must(t, apply(freshPatch, &ApplyContext{
+		TargetPath: tlc.NullPath,
+		OutputPath: frenchDir,
+		Selection:  onlyFrench,
+	}))
+
+	err = apply(patch, &ApplyContext{
+		TargetPath: frenchDir,
+		OutputPath: frenchDir,
+		InPlace:    true,
+		Selection:  onlyFrench,
+	})
+	ude, ok := unwrapError(err).(*ErrUnselectedDependency)
+	if assert.True(t, ok, "should be an unselected dependency error, got %v", err) {
+		assert.Equal(t, "lang/fr/fallback", ude.SourcePath)
+		assert.Equal(t, "lang/en/strings", ude.TargetPath)
+	}
+}
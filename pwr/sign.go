@@ -1,6 +1,9 @@
 package pwr
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
 	"io"
 
 	"github.com/go-errors/errors"
@@ -82,6 +85,7 @@ func ComputeSignatureToWriter(container *tlc.Container, pool wsync.Pool, consume
 // wharf signature file.
 func ReadSignature(signatureReader savior.SeekSource) (*SignatureInfo, error) {
 	rawSigWire := wire.NewReadContext(signatureReader)
+	rawSigWire.SetLimits(SignatureWireLimits.ContainerLimits())
 	err := rawSigWire.ExpectMagic(SignatureMagic)
 	if err != nil {
 		return nil, errors.Wrap(err, 0)
@@ -108,6 +112,8 @@ func ReadSignature(signatureReader savior.SeekSource) (*SignatureInfo, error) {
 		}
 	}
 
+	sigWire.SetLimits(SignatureWireLimits.BodyLimits())
+
 	var hashes []wsync.BlockHash
 	hash := &BlockHash{}
 
@@ -173,3 +179,25 @@ func ReadSignature(signatureReader savior.SeekSource) (*SignatureInfo, error) {
 	}
 	return signature, nil
 }
+
+// FileKeys returns a key for each file of the signature's container, equal
+// for files with the same contents, for tlc.CompareWithKeys
+func (si *SignatureInfo) FileKeys() []string {
+	hashers := make([]hash.Hash, len(si.Container.Files))
+	for i := range hashers {
+		hashers[i] = sha256.New()
+	}
+
+	for _, bh := range si.Hashes {
+		if bh.FileIndex < 0 || bh.FileIndex >= int64(len(hashers)) {
+			continue
+		}
+		hashers[bh.FileIndex].Write(bh.StrongHash)
+	}
+
+	keys := make([]string, len(hashers))
+	for i, h := range hashers {
+		keys[i] = fmt.Sprintf("%d:%x", si.Container.Files[i].Size, h.Sum(nil))
+	}
+	return keys
+}
@@ -0,0 +1,53 @@
+package pwr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileKeys(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "filekeys")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	sign := func(name string, entries []wtest.TestDirEntry) *SignatureInfo {
+		dir := filepath.Join(mainDir, name)
+		wtest.MakeTestDir(t, dir, wtest.TestDirSettings{Entries: entries})
+
+		container, err := tlc.WalkDir(dir, &tlc.WalkOpts{})
+		wtest.Must(t, err)
+
+		hashes, err := ComputeSignature(container, fspool.New(container, dir), &state.Consumer{})
+		wtest.Must(t, err)
+		return &SignatureInfo{Container: container, Hashes: hashes}
+	}
+
+	oldSig := sign("old", []wtest.TestDirEntry{
+		{Path: "data/level-1", Seed: 0x1},
+		{Path: "data/level-2", Seed: 0x2},
+	})
+	newSig := sign("new", []wtest.TestDirEntry{
+		{Path: "levels/level-1", Seed: 0x1},
+		{Path: "data/level-2", Seed: 0x3},
+	})
+
+	changes, err := tlc.CompareWithKeys(oldSig.Container, newSig.Container, oldSig.FileKeys(), newSig.FileKeys())
+	must(t, err)
+	if assert.EqualValues(t, 2, len(changes.Files)) {
+		assert.EqualValues(t, tlc.ChangeModified, changes.Files[0].Kind)
+		assert.EqualValues(t, "data/level-2", changes.Files[0].Path)
+		assert.True(t, changes.Files[0].ContentChanged)
+
+		assert.EqualValues(t, tlc.ChangeRenamed, changes.Files[1].Kind)
+		assert.EqualValues(t, "levels/level-1", changes.Files[1].Path)
+		assert.EqualValues(t, "data/level-1", changes.Files[1].OldPath)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/itchio/wharf/pools"
 	"github.com/itchio/wharf/pools/nullpool"
 	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
 )
 
 // MaxWoundSize is how large AggregateWounds will let an aggregat
@@ -36,6 +37,11 @@ type ValidatorContext struct {
 	// FailFast makes Validate return Wounds as errors and stop checking
 	FailFast bool
 
+	// Selection restricts validation to part of the container (for partial
+	// installs). Unselected entries are expected to be absent, and never
+	// reported as wounds.
+	Selection *tlc.Selection
+
 	// Result
 
 	// internal
@@ -51,6 +57,11 @@ func (vctx *ValidatorContext) Validate(target string, signature *SignatureInfo)
 		vctx.Consumer = &state.Consumer{}
 	}
 
+	err := vctx.Selection.Validate()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	numWorkers := vctx.NumWorkers
 	if numWorkers == 0 {
 		numWorkers = runtime.NumCPU() + 1
@@ -133,8 +144,19 @@ func (vctx *ValidatorContext) Validate(target string, signature *SignatureInfo)
 		}
 	}()
 
+	// unselected dirs may legitimately be missing: only
+	// check those that contain something we need
+	selectedDirs := make(map[string]bool)
+	for _, dir := range signature.Container.Subset(vctx.Selection).Dirs {
+		selectedDirs[dir.Path] = true
+	}
+
 	// validate dirs and symlinks first
 	for dirIndex, dir := range signature.Container.Dirs {
+		if !selectedDirs[dir.Path] {
+			continue
+		}
+
 		path := filepath.Join(target, filepath.FromSlash(dir.Path))
 		stats, err := os.Lstat(path)
 		if err != nil {
@@ -159,6 +181,10 @@ func (vctx *ValidatorContext) Validate(target string, signature *SignatureInfo)
 	}
 
 	for symlinkIndex, symlink := range signature.Container.Symlinks {
+		if !vctx.Selection.Matches(symlink.Path) {
+			continue
+		}
+
 		path := filepath.Join(target, filepath.FromSlash(symlink.Path))
 		dest, err := os.Readlink(path)
 		if err != nil {
@@ -276,6 +302,12 @@ func (vctx *ValidatorContext) validate(target string, signature *SignatureInfo,
 	doOne := func(fileIndex int64) error {
 		file := signature.Container.Files[fileIndex]
 
+		if !vctx.Selection.Matches(file.Path) {
+			// intentionally absent
+			onProgress(file.Size)
+			return nil
+		}
+
 		var reader io.Reader
 		reader, err = targetPool.GetReader(fileIndex)
 		if err != nil {
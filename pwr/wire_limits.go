@@ -0,0 +1,78 @@
+package pwr
+
+import (
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/wire"
+)
+
+// WireLimits bound the messages read from one of wharf's file formats.
+// Every format starts with a header and one or more containers, followed
+// by many small messages (hashes, ops, etc.), so the first messages get
+// a looser limit than the rest. Zero fields mean "no limit".
+type WireLimits struct {
+	// MaxContainerSize bounds the header and containers
+	MaxContainerSize int64
+	// MaxMessageSize bounds every message after the containers
+	MaxMessageSize int64
+	// MaxTotalSize bounds the (decompressed) size of the whole file
+	MaxTotalSize int64
+}
+
+// containerMessageSize fits containers with millions of entries
+const containerMessageSize = 256 * 1024 * 1024
+
+// hashMessageSize fits any hash or wound message with room to spare
+const hashMessageSize = 1024
+
+// checkpointMessageSize fits a zip index checkpoint, with its 32KiB window
+const checkpointMessageSize = 64 * 1024
+
+var (
+	// PatchWireLimits are used when reading patches. bsdiff controls
+	// aren't chunked, so a single one may hold a whole file.
+	PatchWireLimits = WireLimits{
+		MaxContainerSize: containerMessageSize,
+		MaxMessageSize:   2*bsdiff.MaxFileSize + hashMessageSize,
+	}
+
+	// SignatureWireLimits are used when reading signatures
+	SignatureWireLimits = WireLimits{
+		MaxContainerSize: containerMessageSize,
+		MaxMessageSize:   hashMessageSize,
+	}
+
+	// ManifestWireLimits are used when reading manifests
+	ManifestWireLimits = WireLimits{
+		MaxContainerSize: containerMessageSize,
+		MaxMessageSize:   hashMessageSize,
+	}
+
+	// WoundsWireLimits are used when reading wounds files
+	WoundsWireLimits = WireLimits{
+		MaxContainerSize: containerMessageSize,
+		MaxMessageSize:   hashMessageSize,
+	}
+
+	// ZipIndexWireLimits are used when reading zip indices. They have no
+	// container, so MaxContainerSize only bounds the header.
+	ZipIndexWireLimits = WireLimits{
+		MaxContainerSize: hashMessageSize,
+		MaxMessageSize:   checkpointMessageSize,
+	}
+)
+
+// ContainerLimits returns the wire limits for the header and containers
+func (wl WireLimits) ContainerLimits() wire.Limits {
+	return wire.Limits{
+		MaxMessageSize: wl.MaxContainerSize,
+		MaxTotalSize:   wl.MaxTotalSize,
+	}
+}
+
+// BodyLimits returns the wire limits for everything after the containers
+func (wl WireLimits) BodyLimits() wire.Limits {
+	return wire.Limits{
+		MaxMessageSize: wl.MaxMessageSize,
+		MaxTotalSize:   wl.MaxTotalSize,
+	}
+}
@@ -0,0 +1,93 @@
+package pwr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func cookSignature(msgs ...proto.Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	wctx := wire.NewWriteContext(buf)
+	err := wctx.WriteMagic(SignatureMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wctx.WriteMessage(&SignatureHeader{
+		Compression: &CompressionSettings{Algorithm: CompressionAlgorithm_NONE},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		err = wctx.WriteMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func readSignatureBytes(data []byte) (*SignatureInfo, error) {
+	source := seeksource.FromBytes(data)
+	_, err := source.Resume(nil)
+	if err != nil {
+		return nil, err
+	}
+	return ReadSignature(source)
+}
+
+func Test_SignatureWireLimits(t *testing.T) {
+	container := &tlc.Container{
+		Files: []*tlc.File{{Path: "a", Size: BlockSize + 1, Mode: 0644}},
+		Size:  BlockSize + 1,
+	}
+
+	sig, err := cookSignature(container,
+		&BlockHash{WeakHash: 1, StrongHash: make([]byte, 16)},
+		&BlockHash{WeakHash: 2, StrongHash: make([]byte, 16)})
+	must(t, err)
+
+	sigInfo, err := readSignatureBytes(sig)
+	must(t, err)
+	assert.EqualValues(t, 2, len(sigInfo.Hashes))
+
+	sig, err = cookSignature(container,
+		&BlockHash{WeakHash: 1, StrongHash: make([]byte, 16)},
+		&BlockHash{WeakHash: 2, StrongHash: make([]byte, 64*1024)})
+	must(t, err)
+
+	_, err = readSignatureBytes(sig)
+	_, ok := unwrapError(err).(*wire.ErrMessageTooLarge)
+	assert.True(t, ok, "oversized hash should be refused")
+}
+
+func FuzzReadSignature(f *testing.F) {
+	container := &tlc.Container{
+		Dirs:  []*tlc.Dir{{Path: "d", Mode: 0755}},
+		Files: []*tlc.File{{Path: "d/a", Size: BlockSize + 1, Mode: 0644}, {Path: "b", Mode: 0644}},
+		Size:  BlockSize + 1,
+	}
+	sig, err := cookSignature(container,
+		&BlockHash{WeakHash: 1, StrongHash: make([]byte, 16)},
+		&BlockHash{WeakHash: 2, StrongHash: make([]byte, 16)},
+		&BlockHash{WeakHash: 3, StrongHash: make([]byte, 16)})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(sig)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sigInfo, err := readSignatureBytes(data)
+		if err == nil {
+			assert.True(t, len(sigInfo.Hashes) <= len(data))
+		}
+	})
+}
@@ -0,0 +1,224 @@
+// Package safepath validates the slash-separated entry paths found in
+// containers and archives, so that writers never touch anything outside
+// of the directory they're supposed to write into.
+package safepath
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// maxSymlinkHops bounds how many symlinks are followed when resolving
+// a symlink destination, to detect loops
+const maxSymlinkHops = 255
+
+// ErrUnsafePath is returned when an entry path (from a container, or
+// an archive) would make a writer touch something outside of the
+// directory it's supposed to write into.
+type ErrUnsafePath struct {
+	Path   string
+	Reason string
+}
+
+var _ error = (*ErrUnsafePath)(nil)
+
+func (e *ErrUnsafePath) Error() string {
+	return fmt.Sprintf("unsafe path '%s': %s", e.Path, e.Reason)
+}
+
+// IsUnsafePath returns true if err is (or wraps) an *ErrUnsafePath
+func IsUnsafePath(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *ErrUnsafePath:
+			return true
+		case *errors.Error:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
+
+func unsafePath(entryPath string, reason string) error {
+	return errors.Wrap(&ErrUnsafePath{Path: entryPath, Reason: reason}, 1)
+}
+
+// Validate returns an *ErrUnsafePath if entryPath isn't a relative,
+// slash-separated path that stays within its root, regardless of the
+// platform it's written on. Trailing slashes (as used by zip directory
+// entries) are allowed.
+func Validate(entryPath string) error {
+	p := strings.TrimSuffix(entryPath, "/")
+
+	if p == "" {
+		return unsafePath(entryPath, "empty path")
+	}
+
+	if strings.ContainsRune(p, 0) {
+		return unsafePath(entryPath, "contains a NUL byte")
+	}
+
+	if strings.ContainsRune(p, '\\') {
+		// backslashes are separators on windows
+		return unsafePath(entryPath, "contains a backslash")
+	}
+
+	if strings.HasPrefix(p, "/") || hasVolumeName(p) {
+		return unsafePath(entryPath, "is absolute")
+	}
+
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return unsafePath(entryPath, "contains '..'")
+		}
+	}
+
+	return nil
+}
+
+// hasVolumeName returns true for paths like "C:" or "C:foo", which
+// would not be relative to the root on windows
+func hasVolumeName(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	c := p[0]
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// Join validates entryPath and returns the native path it designates
+// inside basePath. It also makes sure none of the existing parents of that
+// path (below basePath) are symlinks, so that writing to the result cannot
+// end up outside of basePath. The last element itself isn't checked: callers
+// replacing a symlink with a file should use RemoveIfSymlink first.
+func Join(basePath string, entryPath string) (string, error) {
+	err := Validate(entryPath)
+	if err != nil {
+		return "", err
+	}
+
+	elems := strings.Split(path.Clean(strings.TrimSuffix(entryPath, "/")), "/")
+	fullPath := basePath
+	for i, elem := range elems {
+		fullPath = filepath.Join(fullPath, elem)
+		if i == len(elems)-1 {
+			break
+		}
+
+		stats, err := os.Lstat(fullPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// nothing below a missing dir can be a symlink
+				return filepath.Join(basePath, filepath.FromSlash(entryPath)), nil
+			}
+			return "", errors.Wrap(err, 0)
+		}
+
+		if stats.Mode()&os.ModeSymlink != 0 {
+			return "", unsafePath(entryPath, fmt.Sprintf("parent '%s' is a symlink", path.Join(elems[:i+1]...)))
+		}
+	}
+
+	return fullPath, nil
+}
+
+// RemoveIfSymlink removes fullPath if it's a symlink, so that opening it
+// for writing creates a regular file instead of following the link.
+func RemoveIfSymlink(fullPath string) error {
+	stats, err := os.Lstat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, 0)
+	}
+
+	if stats.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	err = os.Remove(fullPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// CheckSymlinks returns an *ErrUnsafePath if any of the given symlinks
+// (a map of slash-separated paths to destinations) resolves outside of
+// their common root. Destinations are resolved through the other
+// symlinks of the set, so chains of links that each look harmless
+// are caught as well. Absolute destinations are always refused.
+func CheckSymlinks(links map[string]string) error {
+	for linkPath, dest := range links {
+		err := checkSymlink(links, linkPath, dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSymlink(links map[string]string, linkPath string, dest string) error {
+	linkPath = strings.TrimSuffix(linkPath, "/")
+
+	var resolved []string
+	if dir := path.Dir(linkPath); dir != "." {
+		resolved = strings.Split(dir, "/")
+	}
+
+	pending := splitDest(dest)
+	if pending == nil {
+		return unsafePath(linkPath, fmt.Sprintf("symlink destination '%s' is absolute", dest))
+	}
+
+	hops := 0
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return unsafePath(linkPath, fmt.Sprintf("symlink destination '%s' is outside the root", dest))
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		resolved = append(resolved, elem)
+		if next, ok := links[strings.Join(resolved, "/")]; ok {
+			hops++
+			if hops > maxSymlinkHops {
+				return unsafePath(linkPath, "too many levels of symbolic links")
+			}
+
+			nextElems := splitDest(next)
+			if nextElems == nil {
+				return unsafePath(linkPath, fmt.Sprintf("symlink destination '%s' goes through an absolute symlink", dest))
+			}
+			resolved = resolved[:len(resolved)-1]
+			pending = append(nextElems, pending...)
+		}
+	}
+
+	return nil
+}
+
+// splitDest splits a symlink destination into path elements, accepting
+// both kinds of separators. It returns nil for absolute destinations.
+func splitDest(dest string) []string {
+	dest = strings.Replace(dest, "\\", "/", -1)
+	if strings.HasPrefix(dest, "/") || hasVolumeName(dest) {
+		return nil
+	}
+	return append([]string{}, strings.Split(dest, "/")...)
+}
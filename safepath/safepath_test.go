@@ -0,0 +1,108 @@
+package safepath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Validate(t *testing.T) {
+	assert.NoError(t, Validate("foo"))
+	assert.NoError(t, Validate("foo/bar.txt"))
+	assert.NoError(t, Validate("foo/bar/"))
+	assert.NoError(t, Validate("foo..bar/..baz"))
+
+	for _, p := range []string{
+		"",
+		"/",
+		"/etc/passwd",
+		"../foo",
+		"foo/../../bar",
+		"foo/..",
+		"..\\foo",
+		"C:\\Windows",
+		"c:foo",
+		"foo\x00bar",
+	} {
+		err := Validate(p)
+		assert.Error(t, err, "'%s' should be refused", p)
+		assert.True(t, IsUnsafePath(err), "'%s' should give an ErrUnsafePath", p)
+	}
+}
+
+func Test_Join(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+
+	dir, err := ioutil.TempDir("", "safepath")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base")
+	assert.NoError(t, os.MkdirAll(filepath.Join(base, "sub"), 0755))
+	assert.NoError(t, os.Symlink(dir, filepath.Join(base, "escape")))
+
+	p, err := Join(base, "sub/file")
+	assert.NoError(t, err)
+	assert.EqualValues(t, filepath.Join(base, "sub", "file"), p)
+
+	p, err = Join(base, "missing/deeper/file")
+	assert.NoError(t, err)
+	assert.EqualValues(t, filepath.Join(base, "missing", "deeper", "file"), p)
+
+	// the symlink itself may be replaced, but not written through
+	_, err = Join(base, "escape")
+	assert.NoError(t, err)
+
+	_, err = Join(base, "escape/file")
+	assert.True(t, IsUnsafePath(err))
+
+	_, err = Join(base, "../file")
+	assert.True(t, IsUnsafePath(err))
+
+	link := filepath.Join(base, "escape")
+	assert.NoError(t, RemoveIfSymlink(link))
+	_, err = os.Lstat(link)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, RemoveIfSymlink(link))
+
+	assert.NoError(t, RemoveIfSymlink(filepath.Join(base, "sub")))
+	_, err = os.Lstat(filepath.Join(base, "sub"))
+	assert.NoError(t, err, "RemoveIfSymlink should leave dirs alone")
+}
+
+func Test_CheckSymlinks(t *testing.T) {
+	assert.NoError(t, CheckSymlinks(map[string]string{
+		"current":     "versions/1.0",
+		"lib/libfoo":  "libfoo.so.1",
+		"lib/libbar":  "../lib/./libfoo",
+		"bin/tool":    "../current/tool",
+		"bin/samedir": ".",
+	}))
+
+	for _, links := range []map[string]string{
+		{"passwd": "/etc/passwd"},
+		{"win": "C:\\Windows"},
+		{"up": ".."},
+		{"a/b/up": "../../.."},
+		{"a/b/up": "..\\..\\.."},
+		// each of these looks fine on its own, but together they escape
+		{
+			"p/q/y": "../../r",
+			"p/q/x": "y/../..",
+		},
+		// and this one goes nowhere
+		{
+			"loop1": "loop2",
+			"loop2": "loop1",
+		},
+	} {
+		err := CheckSymlinks(links)
+		assert.True(t, IsUnsafePath(err), "%v should be refused", links)
+	}
+}
@@ -0,0 +1,180 @@
+// Package server serves wharf files (patches, signatures, manifests) and
+// blockpool blocks over HTTP, so that eos.Open and HTTP block sources can
+// read them remotely.
+package server
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/itchio/wharf/inspect"
+	"github.com/itchio/wharf/pools/blockpool"
+	"github.com/itchio/wharf/safepath"
+	"github.com/itchio/wharf/state"
+)
+
+const (
+	// FilesPrefix is the URL prefix under which files of Config.StoragePath are served
+	FilesPrefix = "/files/"
+	// BlocksPrefix is the URL prefix under which blocks of Config.BlocksPath are served,
+	// by address (shake128-32/<hash>/<size>)
+	BlocksPrefix = "/blocks/"
+
+	// TypeHeader is set on file responses to the wharf file type (see inspect.FileType),
+	// when the file starts with a known magic number.
+	TypeHeader = "X-Wharf-Type"
+)
+
+// blockAddressRe matches the addresses DiskSink stores blocks at
+var blockAddressRe = regexp.MustCompile(`^shake128-32/[0-9a-f]{64}/([0-9]+)$`)
+
+// Config determines what a Server serves
+type Config struct {
+	// StoragePath is the folder patches, signatures and manifests are served from.
+	// If empty, no files are served.
+	StoragePath string
+
+	// BlocksPath is the folder blocks are served from, as stored by a
+	// blockpool.DiskSink. If empty, no blocks are served.
+	BlocksPath string
+
+	// Consumer, if set, is told about requests that failed because of
+	// something on our side
+	Consumer *state.Consumer
+}
+
+// Server is an http.Handler for wharf files and blocks. Only GET and HEAD
+// are supported, and both files and blocks can be requested by range.
+type Server struct {
+	config Config
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// New returns a server for the given configuration
+func New(config Config) *Server {
+	return &Server{
+		config: config,
+	}
+}
+
+// ServeHTTP routes requests to files or blocks
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, FilesPrefix) && s.config.StoragePath != "":
+		s.serveFile(w, r, strings.TrimPrefix(r.URL.Path, FilesPrefix))
+	case strings.HasPrefix(r.URL.Path, BlocksPrefix) && s.config.BlocksPath != "":
+		s.serveBlock(w, r, strings.TrimPrefix(r.URL.Path, BlocksPrefix))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, entryPath string) {
+	fullPath, err := safepath.Join(s.config.StoragePath, entryPath)
+	if err != nil {
+		if safepath.IsUnsafePath(err) {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		s.internalError(w, r, err)
+		return
+	}
+
+	file, ok := s.open(w, r, fullPath)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	if fileType, err := inspect.Detect(file); err == nil {
+		w.Header().Set(TypeHeader, fileType.String())
+	}
+
+	s.serveContent(w, r, file)
+}
+
+func (s *Server) serveBlock(w http.ResponseWriter, r *http.Request, addr string) {
+	matches := blockAddressRe.FindStringSubmatch(addr)
+	if matches == nil {
+		http.Error(w, "invalid block address", http.StatusBadRequest)
+		return
+	}
+
+	size, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil || size <= 0 || size > blockpool.BigBlockSize {
+		http.Error(w, "invalid block size", http.StatusBadRequest)
+		return
+	}
+
+	fullPath, err := safepath.Join(s.config.BlocksPath, addr)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+
+	file, ok := s.open(w, r, fullPath)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	// blocks are content-addressed, they never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	s.serveContent(w, r, file)
+}
+
+// open opens a regular file for serving, or writes an error response
+// and returns false.
+func (s *Server) open(w http.ResponseWriter, r *http.Request, fullPath string) (*os.File, bool) {
+	stats, err := os.Lstat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		s.internalError(w, r, err)
+		return nil, false
+	}
+
+	if !stats.Mode().IsRegular() {
+		// don't serve directories, and don't follow symlinks out of storage
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		s.internalError(w, r, err)
+		return nil, false
+	}
+	return file, true
+}
+
+// serveContent handles ranges, HEAD and conditional requests
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, file *os.File) {
+	stats, err := file.Stat()
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, stats.Name(), stats.ModTime(), file)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	if s.config.Consumer != nil {
+		s.config.Consumer.Warnf("%s %s: %s", r.Method, r.URL.Path, err.Error())
+	}
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
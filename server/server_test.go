@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/pools/blockpool"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func openRemote(t *testing.T, url string) (eos.File, savior.SeekSource) {
+	f, err := eos.Open(url)
+	wtest.Must(t, err)
+
+	source := seeksource.FromFile(f)
+	_, err = source.Resume(nil)
+	wtest.Must(t, err)
+	return f, source
+}
+
+func Test_Server(t *testing.T) {
+	mainDir, err := ioutil.TempDir("", "server")
+	wtest.Must(t, err)
+	defer os.RemoveAll(mainDir)
+
+	consumer := &state.Consumer{
+		OnMessage: func(level string, message string) {
+			t.Logf("[%s] %s", level, message)
+		},
+	}
+
+	v1 := filepath.Join(mainDir, "v1")
+	wtest.MakeTestDir(t, v1, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*4 + 14},
+			{Path: "file-1", Seed: 0x2},
+		},
+	})
+
+	v2 := filepath.Join(mainDir, "v2")
+	wtest.MakeTestDir(t, v2, wtest.TestDirSettings{
+		Entries: []wtest.TestDirEntry{
+			{Path: "subdir/file-1", Seed: 0x1, Size: wtest.BlockSize*6 + 14},
+			{Path: "file-1", Seed: 0x2},
+			{Path: "dir2/file-2", Seed: 0x3, Size: blockpool.BigBlockSize + 42},
+		},
+	})
+
+	storage := filepath.Join(mainDir, "storage")
+	blocks := filepath.Join(mainDir, "blocks")
+	wtest.Must(t, os.MkdirAll(filepath.Join(storage, "builds"), 0755))
+
+	targetContainer, err := tlc.WalkAny(v1, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	targetSignature, err := pwr.ComputeSignature(targetContainer, fspool.New(targetContainer, v1), consumer)
+	wtest.Must(t, err)
+
+	sourceContainer, err := tlc.WalkAny(v2, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	func() {
+		patchWriter, err := os.Create(filepath.Join(storage, "builds", "patch.pwr"))
+		wtest.Must(t, err)
+		defer patchWriter.Close()
+
+		signatureWriter, err := os.Create(filepath.Join(storage, "builds", "v2.pws"))
+		wtest.Must(t, err)
+		defer signatureWriter.Close()
+
+		dctx := &pwr.DiffContext{
+			Compression: &pwr.CompressionSettings{
+				Algorithm: pwr.CompressionAlgorithm_NONE,
+			},
+			Consumer: consumer,
+
+			SourceContainer: sourceContainer,
+			Pool:            fspool.New(sourceContainer, v2),
+
+			TargetContainer: targetContainer,
+			TargetSignature: targetSignature,
+		}
+		wtest.Must(t, dctx.WritePatch(patchWriter, signatureWriter))
+	}()
+
+	func() {
+		blockHashes := blockpool.NewBlockHashMap()
+		outPool := &blockpool.BlockPool{
+			Container: sourceContainer,
+			Downstream: &blockpool.DiskSink{
+				BasePath:    blocks,
+				Container:   sourceContainer,
+				BlockHashes: blockHashes,
+			},
+			Consumer: consumer,
+		}
+		wtest.Must(t, pwr.CopyContainer(sourceContainer, outPool, fspool.New(sourceContainer, v2), consumer))
+
+		manifestWriter, err := os.Create(filepath.Join(storage, "builds", "v2.pwm"))
+		wtest.Must(t, err)
+		defer manifestWriter.Close()
+
+		compression := &pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm_NONE,
+		}
+		wtest.Must(t, blockpool.WriteManifest(manifestWriter, compression, sourceContainer, blockHashes))
+	}()
+
+	ts := httptest.NewServer(New(Config{
+		StoragePath: storage,
+		BlocksPath:  blocks,
+		Consumer:    consumer,
+	}))
+	defer ts.Close()
+
+	t.Run("file types and ranges", func(t *testing.T) {
+		res, err := http.Head(ts.URL + FilesPrefix + "builds/patch.pwr")
+		wtest.Must(t, err)
+		res.Body.Close()
+		assert.EqualValues(t, http.StatusOK, res.StatusCode)
+		assert.EqualValues(t, "patch", res.Header.Get(TypeHeader))
+		assert.EqualValues(t, "bytes", res.Header.Get("Accept-Ranges"))
+
+		stats, err := os.Stat(filepath.Join(storage, "builds", "v2.pws"))
+		wtest.Must(t, err)
+
+		req, err := http.NewRequest("GET", ts.URL+FilesPrefix+"builds/v2.pws", nil)
+		wtest.Must(t, err)
+		req.Header.Set("Range", "bytes=4-11")
+		res, err = http.DefaultClient.Do(req)
+		wtest.Must(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		wtest.Must(t, err)
+		assert.EqualValues(t, http.StatusPartialContent, res.StatusCode)
+		assert.EqualValues(t, "signature", res.Header.Get(TypeHeader))
+		assert.EqualValues(t, fmt.Sprintf("bytes 4-11/%d", stats.Size()), res.Header.Get("Content-Range"))
+		assert.EqualValues(t, 8, len(body))
+	})
+
+	t.Run("refused requests", func(t *testing.T) {
+		expectStatus := func(method string, path string, status int) {
+			req, err := http.NewRequest(method, ts.URL+path, nil)
+			wtest.Must(t, err)
+			res, err := http.DefaultClient.Do(req)
+			wtest.Must(t, err)
+			res.Body.Close()
+			assert.EqualValues(t, status, res.StatusCode, "%s %s", method, path)
+		}
+
+		expectStatus("GET", FilesPrefix+"builds/missing.pwr", http.StatusNotFound)
+		expectStatus("GET", FilesPrefix+"builds", http.StatusNotFound)
+		expectStatus("GET", FilesPrefix+"..%2fv1%2ffile-1", http.StatusBadRequest)
+		expectStatus("GET", FilesPrefix+"%2fetc%2fpasswd", http.StatusBadRequest)
+		expectStatus("PUT", FilesPrefix+"builds/patch.pwr", http.StatusMethodNotAllowed)
+		expectStatus("GET", BlocksPrefix+"shake128-32/nope/12", http.StatusBadRequest)
+		expectStatus("GET", BlocksPrefix+fmt.Sprintf("shake128-32/%064x/%d", 0, blockpool.BigBlockSize+1), http.StatusBadRequest)
+		expectStatus("GET", BlocksPrefix+fmt.Sprintf("shake128-32/%064x/%d", 0, 12), http.StatusNotFound)
+		expectStatus("GET", "/other", http.StatusNotFound)
+	})
+
+	f, sigSource := openRemote(t, ts.URL+FilesPrefix+"builds/v2.pws")
+	signature, err := pwr.ReadSignature(sigSource)
+	wtest.Must(t, err)
+	wtest.Must(t, f.Close())
+
+	t.Run("apply a remote patch", func(t *testing.T) {
+		f, patchSource := openRemote(t, ts.URL+FilesPrefix+"builds/patch.pwr")
+		defer f.Close()
+
+		out := filepath.Join(mainDir, "out")
+		actx := &pwr.ApplyContext{
+			TargetPath: v1,
+			OutputPath: out,
+			Consumer:   consumer,
+			Signature:  signature,
+		}
+		wtest.Must(t, actx.ApplyPatch(patchSource))
+		wtest.Must(t, pwr.AssertValid(out, signature))
+	})
+
+	t.Run("pull remote blocks", func(t *testing.T) {
+		f, manifestSource := openRemote(t, ts.URL+FilesPrefix+"builds/v2.pwm")
+		defer f.Close()
+
+		container, blockHashes, err := blockpool.ReadManifest(manifestSource)
+		wtest.Must(t, err)
+
+		blockAddresses, err := blockHashes.ToAddressMap(container, pwr.HashAlgorithm_SHAKE128_32)
+		wtest.Must(t, err)
+
+		inPool := &blockpool.BlockPool{
+			Container: container,
+			Upstream: &blockpool.HTTPSource{
+				BaseURLs:       []string{ts.URL + BlocksPrefix},
+				BlockAddresses: blockAddresses,
+				Container:      container,
+			},
+			Consumer: consumer,
+		}
+
+		out := filepath.Join(mainDir, "pulled")
+		wtest.Must(t, container.Prepare(out))
+		wtest.Must(t, pwr.CopyContainer(container, fspool.New(container, out), inPool, consumer))
+		wtest.Must(t, pwr.AssertValid(out, signature))
+	})
+}
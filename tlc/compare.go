@@ -2,7 +2,10 @@ package tlc
 
 import (
 	"fmt"
+	"os"
 	"sort"
+
+	"github.com/go-errors/errors"
 )
 
 func (c1 *Container) EnsureEqual(c2 *Container) error {
@@ -96,3 +99,286 @@ func sortedFiles(c *Container) ([]string, map[string]*File) {
 	sort.Sort(sort.StringSlice(files))
 	return files, filesmap
 }
+
+// A ChangeKind is what happened to an entry between two containers
+type ChangeKind int
+
+const (
+	// ChangeAdded entries are only in the new container
+	ChangeAdded ChangeKind = iota
+	// ChangeRemoved entries are only in the old container
+	ChangeRemoved
+	// ChangeModified entries are in both containers, with different
+	// modes, sizes, contents or symlink destinations
+	ChangeModified
+	// ChangeRenamed files were moved to another path, with the same contents
+	ChangeRenamed
+)
+
+func (ck ChangeKind) String() string {
+	switch ck {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	case ChangeRenamed:
+		return "renamed"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(ck))
+}
+
+// Changes lists everything that differs between two containers, each
+// kind of entry sorted by path
+type Changes struct {
+	Files    []*FileChange
+	Dirs     []*DirChange
+	Symlinks []*SymlinkChange
+}
+
+// A FileChange describes a file that differs between two containers
+type FileChange struct {
+	Kind ChangeKind
+	// Path is the file's path in the new container, or in the old one if removed
+	Path string
+	// OldPath is the file's path in the old container, for renames
+	OldPath string
+
+	// OldMode and OldSize are zero for added files
+	OldMode uint32
+	OldSize int64
+	// NewMode and NewSize are zero for removed files
+	NewMode uint32
+	NewSize int64
+
+	// ContentChanged is true for modified files whose content keys differ
+	ContentChanged bool
+}
+
+// ModeChanged returns true if the file's permissions changed
+func (fc *FileChange) ModeChanged() bool {
+	return fc.Kind != ChangeAdded && fc.Kind != ChangeRemoved && !sameMode(fc.OldMode, fc.NewMode)
+}
+
+// A DirChange describes a directory that differs between two containers
+type DirChange struct {
+	Kind    ChangeKind
+	Path    string
+	OldMode uint32
+	NewMode uint32
+}
+
+// A SymlinkChange describes a symlink that differs between two containers
+type SymlinkChange struct {
+	Kind    ChangeKind
+	Path    string
+	OldMode uint32
+	NewMode uint32
+	OldDest string
+	NewDest string
+}
+
+// IsEmpty returns true if both containers were the same
+func (c *Changes) IsEmpty() bool {
+	return len(c.Files) == 0 && len(c.Dirs) == 0 && len(c.Symlinks) == 0
+}
+
+// Count returns how many entries changed with a given kind
+func (c *Changes) Count(kind ChangeKind) int {
+	count := 0
+	for _, f := range c.Files {
+		if f.Kind == kind {
+			count++
+		}
+	}
+	for _, d := range c.Dirs {
+		if d.Kind == kind {
+			count++
+		}
+	}
+	for _, s := range c.Symlinks {
+		if s.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Compare returns all the differences between an old and a new container.
+// File contents aren't known, so files with the same path, mode and size
+// are considered equal, and renames aren't detected. See CompareWithKeys.
+func Compare(oldContainer *Container, newContainer *Container) *Changes {
+	// without keys, there's nothing that can go wrong
+	changes, _ := CompareWithKeys(oldContainer, newContainer, nil, nil)
+	return changes
+}
+
+// CompareWithKeys is like Compare, but is given a content key for each file
+// of both containers (for example, from signatures: see
+// pwr.SignatureInfo.FileKeys). Files with the same path but different keys
+// are modified, and non-empty files that were removed and added with the
+// same key are renamed. Keys are ignored unless both containers have them,
+// and it returns an error if a container doesn't have one key per file.
+func CompareWithKeys(oldContainer *Container, newContainer *Container, oldKeys []string, newKeys []string) (*Changes, error) {
+	if oldKeys != nil && len(oldKeys) != len(oldContainer.Files) {
+		return nil, errors.Wrap(fmt.Errorf("got %d keys for %d old files", len(oldKeys), len(oldContainer.Files)), 0)
+	}
+	if newKeys != nil && len(newKeys) != len(newContainer.Files) {
+		return nil, errors.Wrap(fmt.Errorf("got %d keys for %d new files", len(newKeys), len(newContainer.Files)), 0)
+	}
+
+	changes := &Changes{}
+	hasKeys := oldKeys != nil && newKeys != nil
+
+	oldDirs := make(map[string]*Dir)
+	for _, d := range oldContainer.Dirs {
+		oldDirs[d.Path] = d
+	}
+	newDirs := make(map[string]*Dir)
+	for _, d := range newContainer.Dirs {
+		newDirs[d.Path] = d
+		old, ok := oldDirs[d.Path]
+		if !ok {
+			changes.Dirs = append(changes.Dirs, &DirChange{Kind: ChangeAdded, Path: d.Path, NewMode: d.Mode})
+		} else if !sameMode(old.Mode, d.Mode) {
+			changes.Dirs = append(changes.Dirs, &DirChange{Kind: ChangeModified, Path: d.Path, OldMode: old.Mode, NewMode: d.Mode})
+		}
+	}
+	for _, d := range oldContainer.Dirs {
+		if _, ok := newDirs[d.Path]; !ok {
+			changes.Dirs = append(changes.Dirs, &DirChange{Kind: ChangeRemoved, Path: d.Path, OldMode: d.Mode})
+		}
+	}
+
+	oldLinks := make(map[string]*Symlink)
+	for _, s := range oldContainer.Symlinks {
+		oldLinks[s.Path] = s
+	}
+	newLinks := make(map[string]*Symlink)
+	for _, s := range newContainer.Symlinks {
+		newLinks[s.Path] = s
+		old, ok := oldLinks[s.Path]
+		if !ok {
+			changes.Symlinks = append(changes.Symlinks, &SymlinkChange{Kind: ChangeAdded, Path: s.Path, NewMode: s.Mode, NewDest: s.Dest})
+		} else if old.Dest != s.Dest || !sameMode(old.Mode, s.Mode) {
+			changes.Symlinks = append(changes.Symlinks, &SymlinkChange{
+				Kind:    ChangeModified,
+				Path:    s.Path,
+				OldMode: old.Mode,
+				NewMode: s.Mode,
+				OldDest: old.Dest,
+				NewDest: s.Dest,
+			})
+		}
+	}
+	for _, s := range oldContainer.Symlinks {
+		if _, ok := newLinks[s.Path]; !ok {
+			changes.Symlinks = append(changes.Symlinks, &SymlinkChange{Kind: ChangeRemoved, Path: s.Path, OldMode: s.Mode, OldDest: s.Dest})
+		}
+	}
+
+	oldFiles := make(map[string]int)
+	for i, f := range oldContainer.Files {
+		oldFiles[f.Path] = i
+	}
+	newFiles := make(map[string]int)
+	var added []int
+	for i, f := range newContainer.Files {
+		newFiles[f.Path] = i
+		oldIndex, ok := oldFiles[f.Path]
+		if !ok {
+			added = append(added, i)
+			continue
+		}
+
+		old := oldContainer.Files[oldIndex]
+		contentChanged := hasKeys && oldKeys[oldIndex] != newKeys[i]
+		if contentChanged || old.Size != f.Size || !sameMode(old.Mode, f.Mode) {
+			changes.Files = append(changes.Files, &FileChange{
+				Kind:           ChangeModified,
+				Path:           f.Path,
+				OldMode:        old.Mode,
+				OldSize:        old.Size,
+				NewMode:        f.Mode,
+				NewSize:        f.Size,
+				ContentChanged: contentChanged,
+			})
+		}
+	}
+
+	// removed files, by content key, for renames
+	removedByKey := make(map[string][]int)
+	var removed []int
+	for i, f := range oldContainer.Files {
+		if _, ok := newFiles[f.Path]; ok {
+			continue
+		}
+		removed = append(removed, i)
+		if hasKeys && f.Size > 0 {
+			removedByKey[oldKeys[i]] = append(removedByKey[oldKeys[i]], i)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return oldContainer.Files[removed[i]].Path < oldContainer.Files[removed[j]].Path
+	})
+	for _, indices := range removedByKey {
+		sort.Slice(indices, func(i, j int) bool {
+			return oldContainer.Files[indices[i]].Path < oldContainer.Files[indices[j]].Path
+		})
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return newContainer.Files[added[i]].Path < newContainer.Files[added[j]].Path
+	})
+
+	renamed := make(map[int]bool)
+	for _, i := range added {
+		f := newContainer.Files[i]
+		if hasKeys && f.Size > 0 {
+			if candidates := removedByKey[newKeys[i]]; len(candidates) > 0 {
+				old := oldContainer.Files[candidates[0]]
+				removedByKey[newKeys[i]] = candidates[1:]
+				renamed[candidates[0]] = true
+
+				changes.Files = append(changes.Files, &FileChange{
+					Kind:    ChangeRenamed,
+					Path:    f.Path,
+					OldPath: old.Path,
+					OldMode: old.Mode,
+					OldSize: old.Size,
+					NewMode: f.Mode,
+					NewSize: f.Size,
+				})
+				continue
+			}
+		}
+
+		changes.Files = append(changes.Files, &FileChange{Kind: ChangeAdded, Path: f.Path, NewMode: f.Mode, NewSize: f.Size})
+	}
+
+	for _, i := range removed {
+		if renamed[i] {
+			continue
+		}
+		f := oldContainer.Files[i]
+		changes.Files = append(changes.Files, &FileChange{Kind: ChangeRemoved, Path: f.Path, OldMode: f.Mode, OldSize: f.Size})
+	}
+
+	sort.SliceStable(changes.Files, func(i, j int) bool {
+		return changes.Files[i].Path < changes.Files[j].Path
+	})
+	sort.SliceStable(changes.Dirs, func(i, j int) bool {
+		return changes.Dirs[i].Path < changes.Dirs[j].Path
+	})
+	sort.SliceStable(changes.Symlinks, func(i, j int) bool {
+		return changes.Symlinks[i].Path < changes.Symlinks[j].Path
+	})
+
+	return changes, nil
+}
+
+// sameMode compares permissions only, since some containers (like zips)
+// don't record file types consistently
+func sameMode(a uint32, b uint32) bool {
+	return os.FileMode(a).Perm() == os.FileMode(b).Perm()
+}
@@ -0,0 +1,114 @@
+package tlc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Compare(t *testing.T) {
+	oldContainer := &Container{
+		Dirs: []*Dir{
+			{Path: "bin", Mode: 0755},
+			{Path: "old-dir", Mode: 0755},
+			{Path: "data", Mode: 0755},
+		},
+		Files: []*File{
+			{Path: "bin/game", Mode: 0644, Size: 100},
+			{Path: "bin/tool", Mode: 0755, Size: 10},
+			{Path: "data/a.pak", Mode: 0644, Size: 1000},
+			{Path: "data/b.pak", Mode: 0644, Size: 2000},
+			{Path: "readme.txt", Mode: 0644, Size: 5},
+			{Path: "empty", Mode: 0644, Size: 0},
+		},
+		Symlinks: []*Symlink{
+			{Path: "current", Mode: 0777, Dest: "bin/game"},
+			{Path: "gone", Mode: 0777, Dest: "bin"},
+		},
+	}
+
+	newContainer := &Container{
+		Dirs: []*Dir{
+			{Path: "bin", Mode: 0700},
+			{Path: "data", Mode: 0755},
+			{Path: "data/packs", Mode: 0755},
+		},
+		Files: []*File{
+			{Path: "bin/game", Mode: 0755, Size: 100},
+			{Path: "bin/tool", Mode: 0755, Size: 10},
+			{Path: "data/packs/a.pak", Mode: 0644, Size: 1000},
+			{Path: "data/b.pak", Mode: 0644, Size: 2000},
+			{Path: "README.md", Mode: 0644, Size: 7},
+			{Path: "empty2", Mode: 0644, Size: 0},
+		},
+		Symlinks: []*Symlink{
+			{Path: "current", Mode: 0777, Dest: "bin/tool"},
+		},
+	}
+
+	assert.True(t, Compare(oldContainer, oldContainer).IsEmpty())
+
+	type fileChange struct {
+		kind    ChangeKind
+		path    string
+		oldPath string
+	}
+	summarize := func(changes *Changes) []fileChange {
+		var res []fileChange
+		for _, fc := range changes.Files {
+			res = append(res, fileChange{fc.Kind, fc.Path, fc.OldPath})
+		}
+		return res
+	}
+
+	changes := Compare(oldContainer, newContainer)
+	assert.EqualValues(t, []fileChange{
+		{ChangeAdded, "README.md", ""},
+		{ChangeModified, "bin/game", ""},
+		{ChangeRemoved, "data/a.pak", ""},
+		{ChangeAdded, "data/packs/a.pak", ""},
+		{ChangeRemoved, "empty", ""},
+		{ChangeAdded, "empty2", ""},
+		{ChangeRemoved, "readme.txt", ""},
+	}, summarize(changes))
+	assert.True(t, changes.Files[1].ModeChanged())
+	assert.False(t, changes.Files[1].ContentChanged)
+
+	assert.EqualValues(t, []*DirChange{
+		{Kind: ChangeModified, Path: "bin", OldMode: 0755, NewMode: 0700},
+		{Kind: ChangeAdded, Path: "data/packs", NewMode: 0755},
+		{Kind: ChangeRemoved, Path: "old-dir", OldMode: 0755},
+	}, changes.Dirs)
+
+	assert.EqualValues(t, []*SymlinkChange{
+		{Kind: ChangeModified, Path: "current", OldMode: 0777, NewMode: 0777, OldDest: "bin/game", NewDest: "bin/tool"},
+		{Kind: ChangeRemoved, Path: "gone", OldMode: 0777, OldDest: "bin"},
+	}, changes.Symlinks)
+
+	t.Logf("With content keys")
+	oldKeys := []string{"game", "tool", "a", "b", "readme", "empty"}
+	newKeys := []string{"game", "tool2", "a", "b", "readme2", "empty"}
+
+	changes, err := CompareWithKeys(oldContainer, newContainer, oldKeys, newKeys)
+	must(t, err)
+	assert.EqualValues(t, []fileChange{
+		{ChangeAdded, "README.md", ""},
+		{ChangeModified, "bin/game", ""},
+		{ChangeModified, "bin/tool", ""},
+		{ChangeRenamed, "data/packs/a.pak", "data/a.pak"},
+		{ChangeRemoved, "empty", ""},
+		{ChangeAdded, "empty2", ""},
+		{ChangeRemoved, "readme.txt", ""},
+	}, summarize(changes))
+	assert.True(t, changes.Files[2].ContentChanged)
+	assert.False(t, changes.Files[2].ModeChanged())
+	assert.EqualValues(t, 1, changes.Count(ChangeRenamed))
+	assert.EqualValues(t, 4, changes.Count(ChangeRemoved), "empty files aren't renamed")
+
+	_, err = CompareWithKeys(oldContainer, newContainer, oldKeys[1:], newKeys)
+	assert.Error(t, err, "missing old keys")
+	_, err = CompareWithKeys(oldContainer, newContainer, oldKeys, append(newKeys, "extra"))
+	assert.Error(t, err, "extra new keys")
+	_, err = CompareWithKeys(oldContainer, newContainer, oldKeys, nil)
+	assert.NoError(t, err, "keys are optional")
+}
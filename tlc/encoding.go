@@ -0,0 +1,357 @@
+package tlc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Containers can be encoded as JSON or as text, both lossless and meant
+// to be read by humans (in code review, golden files, etc.). Files can
+// come with hashes, one per file, for example from pwr.SignatureInfo.FileKeys.
+//
+// The text format has one entry per line, dirs first, then files, then
+// symlinks, in container order. Paths are quoted, modes are octal:
+//
+//   dir 020000000755 "bin"
+//   file 0755 1024 "bin/game" hash=1024:0a1b...
+//   symlink 01000000777 "current" "bin/game"
+//
+// Offsets are only written when they aren't the sum of the sizes of
+// previous files, and the container's size when it isn't the sum of all
+// sizes. Empty lines and lines starting with '#' are ignored.
+
+type jsonContainer struct {
+	Size     int64          `json:"size"`
+	Dirs     []*jsonDir     `json:"dirs"`
+	Files    []*jsonFile    `json:"files"`
+	Symlinks []*jsonSymlink `json:"symlinks"`
+}
+
+type jsonDir struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+type jsonFile struct {
+	Path   string `json:"path"`
+	Mode   string `json:"mode"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+type jsonSymlink struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Dest string `json:"dest"`
+}
+
+func formatMode(mode uint32) string {
+	return "0" + strconv.FormatUint(uint64(mode), 8)
+}
+
+func parseMode(s string) (uint32, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return uint32(mode), nil
+}
+
+func checkHashes(c *Container, hashes []string) error {
+	if hashes != nil && len(hashes) != len(c.Files) {
+		return errors.Wrap(fmt.Errorf("got %d hashes for %d files", len(hashes), len(c.Files)), 2)
+	}
+	return nil
+}
+
+// EncodeJSON writes a container as indented JSON. hashes, if not nil, has
+// one hash for each file.
+func (c *Container) EncodeJSON(w io.Writer, hashes []string) error {
+	err := checkHashes(c, hashes)
+	if err != nil {
+		return err
+	}
+
+	jc := &jsonContainer{
+		Size:     c.Size,
+		Dirs:     []*jsonDir{},
+		Files:    []*jsonFile{},
+		Symlinks: []*jsonSymlink{},
+	}
+	for _, d := range c.Dirs {
+		jc.Dirs = append(jc.Dirs, &jsonDir{Path: d.Path, Mode: formatMode(d.Mode)})
+	}
+	for i, f := range c.Files {
+		jf := &jsonFile{Path: f.Path, Mode: formatMode(f.Mode), Size: f.Size, Offset: f.Offset}
+		if hashes != nil {
+			jf.Hash = hashes[i]
+		}
+		jc.Files = append(jc.Files, jf)
+	}
+	for _, s := range c.Symlinks {
+		jc.Symlinks = append(jc.Symlinks, &jsonSymlink{Path: s.Path, Mode: formatMode(s.Mode), Dest: s.Dest})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(jc)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// DecodeJSON reads a container written by EncodeJSON, and the hashes of its
+// files, nil if it had none.
+func DecodeJSON(r io.Reader) (*Container, []string, error) {
+	jc := &jsonContainer{}
+	err := json.NewDecoder(r).Decode(jc)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, 0)
+	}
+
+	c := &Container{Size: jc.Size}
+	for _, jd := range jc.Dirs {
+		mode, err := parseMode(jd.Mode)
+		if err != nil {
+			return nil, nil, errors.WrapPrefix(err, jd.Path, 0)
+		}
+		c.Dirs = append(c.Dirs, &Dir{Path: jd.Path, Mode: mode})
+	}
+
+	var hashes []string
+	for i, jf := range jc.Files {
+		mode, err := parseMode(jf.Mode)
+		if err != nil {
+			return nil, nil, errors.WrapPrefix(err, jf.Path, 0)
+		}
+		c.Files = append(c.Files, &File{Path: jf.Path, Mode: mode, Size: jf.Size, Offset: jf.Offset})
+
+		if jf.Hash != "" && hashes == nil {
+			hashes = make([]string, len(jc.Files))
+		}
+		if hashes != nil {
+			hashes[i] = jf.Hash
+		}
+	}
+
+	for _, js := range jc.Symlinks {
+		mode, err := parseMode(js.Mode)
+		if err != nil {
+			return nil, nil, errors.WrapPrefix(err, js.Path, 0)
+		}
+		c.Symlinks = append(c.Symlinks, &Symlink{Path: js.Path, Mode: mode, Dest: js.Dest})
+	}
+
+	return c, hashes, nil
+}
+
+// EncodeText writes a container in wharf's line-based text format.
+// hashes, if not nil, has one hash for each file.
+func (c *Container) EncodeText(w io.Writer, hashes []string) error {
+	err := checkHashes(c, hashes)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", c.Stats())
+
+	for _, d := range c.Dirs {
+		fmt.Fprintf(bw, "dir %s %s\n", formatMode(d.Mode), strconv.Quote(d.Path))
+	}
+
+	offset := int64(0)
+	for i, f := range c.Files {
+		fmt.Fprintf(bw, "file %s %d %s", formatMode(f.Mode), f.Size, strconv.Quote(f.Path))
+		if f.Offset != offset {
+			fmt.Fprintf(bw, " offset=%d", f.Offset)
+		}
+		if hashes != nil && hashes[i] != "" {
+			fmt.Fprintf(bw, " hash=%s", hashes[i])
+		}
+		fmt.Fprintf(bw, "\n")
+		offset = f.Offset + f.Size
+	}
+
+	for _, s := range c.Symlinks {
+		fmt.Fprintf(bw, "symlink %s %s %s\n", formatMode(s.Mode), strconv.Quote(s.Path), strconv.Quote(s.Dest))
+	}
+
+	if c.Size != sumSizes(c) {
+		fmt.Fprintf(bw, "size %d\n", c.Size)
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+func sumSizes(c *Container) int64 {
+	size := int64(0)
+	for _, f := range c.Files {
+		size += f.Size
+	}
+	return size
+}
+
+// DecodeText reads a container written by EncodeText, and the hashes of
+// its files, nil if it had none.
+func DecodeText(r io.Reader) (*Container, []string, error) {
+	c := &Container{}
+	var hashes []string
+	hasSize := false
+
+	offset := int64(0)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := func() error {
+			fields, err := splitTextLine(line)
+			if err != nil {
+				return err
+			}
+
+			expect := func(n int) error {
+				if len(fields) < n {
+					return fmt.Errorf("expected %d fields, got %d", n, len(fields))
+				}
+				return nil
+			}
+
+			switch fields[0] {
+			case "dir":
+				if err := expect(3); err != nil {
+					return err
+				}
+				mode, err := parseMode(fields[1])
+				if err != nil {
+					return err
+				}
+				c.Dirs = append(c.Dirs, &Dir{Path: fields[2], Mode: mode})
+
+			case "file":
+				if err := expect(4); err != nil {
+					return err
+				}
+				mode, err := parseMode(fields[1])
+				if err != nil {
+					return err
+				}
+				size, err := strconv.ParseInt(fields[2], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid size %q", fields[2])
+				}
+				f := &File{Path: fields[3], Mode: mode, Size: size, Offset: offset}
+
+				hash := ""
+				for _, option := range fields[4:] {
+					switch {
+					case strings.HasPrefix(option, "offset="):
+						f.Offset, err = strconv.ParseInt(strings.TrimPrefix(option, "offset="), 10, 64)
+						if err != nil {
+							return fmt.Errorf("invalid %q", option)
+						}
+					case strings.HasPrefix(option, "hash="):
+						hash = strings.TrimPrefix(option, "hash=")
+					default:
+						return fmt.Errorf("unknown option %q", option)
+					}
+				}
+
+				if hash != "" && hashes == nil {
+					hashes = make([]string, len(c.Files), len(c.Files)+1)
+				}
+				if hashes != nil {
+					hashes = append(hashes, hash)
+				}
+				c.Files = append(c.Files, f)
+				offset = f.Offset + f.Size
+
+			case "symlink":
+				if err := expect(4); err != nil {
+					return err
+				}
+				mode, err := parseMode(fields[1])
+				if err != nil {
+					return err
+				}
+				c.Symlinks = append(c.Symlinks, &Symlink{Path: fields[2], Mode: mode, Dest: fields[3]})
+
+			case "size":
+				if err := expect(2); err != nil {
+					return err
+				}
+				c.Size, err = strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid size %q", fields[1])
+				}
+				hasSize = true
+
+			default:
+				return fmt.Errorf("unknown entry type %q", fields[0])
+			}
+			return nil
+		}()
+		if err != nil {
+			return nil, nil, errors.Wrap(fmt.Errorf("line %d: %s", lineNumber, err.Error()), 0)
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, 0)
+	}
+
+	if !hasSize {
+		c.Size = sumSizes(c)
+	}
+	return c, hashes, nil
+}
+
+// splitTextLine splits a line on spaces, unquoting quoted fields
+func splitTextLine(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string in %q", line)
+			}
+			field, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", quoted)
+			}
+			fields = append(fields, field)
+			line = line[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
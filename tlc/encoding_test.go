@@ -0,0 +1,101 @@
+package tlc
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Encodings(t *testing.T) {
+	tmpPath := mktestdir(t, "encodings")
+	defer os.RemoveAll(tmpPath)
+
+	walked, err := WalkDir(tmpPath, &WalkOpts{})
+	must(t, err)
+
+	odd := &Container{
+		Dirs:     []*Dir{{Path: "with space", Mode: uint32(os.ModeDir | 0755)}},
+		Files:    []*File{{Path: "with space/\"quoted\"\tname", Mode: 0600, Size: 12, Offset: 0}},
+		Symlinks: []*Symlink{{Path: "link", Mode: uint32(os.ModeSymlink | 0777), Dest: "with space"}},
+		Size:     12,
+	}
+	subset := walked.Subset(&Selection{Include: []string{"foo/dir_b/**"}})
+
+	for _, c := range []*Container{walked, odd, subset, {}} {
+		hashes := make([]string, len(c.Files))
+		for i := range hashes {
+			hashes[i] = strings.Repeat("ab", i+1)
+		}
+
+		for _, withHashes := range []bool{false, true} {
+			var h []string
+			if withHashes {
+				h = hashes
+			}
+
+			buf := new(bytes.Buffer)
+			must(t, c.EncodeJSON(buf, h))
+			decoded, decodedHashes, err := DecodeJSON(buf)
+			must(t, err)
+			assertSameContainer(t, c, decoded)
+			if withHashes && len(hashes) > 0 {
+				assert.EqualValues(t, hashes, decodedHashes)
+			} else {
+				assert.Nil(t, decodedHashes)
+			}
+
+			buf.Reset()
+			must(t, c.EncodeText(buf, h))
+			text := buf.String()
+			decoded, decodedHashes, err = DecodeText(buf)
+			must(t, err)
+			assertSameContainer(t, c, decoded)
+			if withHashes && len(hashes) > 0 {
+				assert.EqualValues(t, hashes, decodedHashes, text)
+			} else {
+				assert.Nil(t, decodedHashes)
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	must(t, odd.EncodeText(buf, nil))
+	assert.EqualValues(t, `# 1 files, 1 dirs, 1 symlinks
+dir 020000000755 "with space"
+file 0600 12 "with space/\"quoted\"\tname"
+symlink 01000000777 "link" "with space"
+`, buf.String())
+
+	assert.Error(t, walked.EncodeText(buf, []string{"too few"}))
+
+	for _, invalid := range []string{
+		"file 0644 12",
+		"file 0644 twelve \"a\"",
+		"file 0999 12 \"a\"",
+		"file 0644 12 \"a\" color=blue",
+		"dir 0755 \"unterminated",
+		"fifo 0644 \"a\"",
+	} {
+		_, _, err := DecodeText(strings.NewReader(invalid))
+		assert.Error(t, err, "should refuse %q", invalid)
+	}
+}
+
+func assertSameContainer(t *testing.T, expected *Container, actual *Container) {
+	assert.EqualValues(t, expected.Size, actual.Size)
+	assert.EqualValues(t, len(expected.Dirs), len(actual.Dirs))
+	for i, d := range expected.Dirs {
+		assert.EqualValues(t, *d, *actual.Dirs[i])
+	}
+	assert.EqualValues(t, len(expected.Files), len(actual.Files))
+	for i, f := range expected.Files {
+		assert.EqualValues(t, *f, *actual.Files[i])
+	}
+	assert.EqualValues(t, len(expected.Symlinks), len(actual.Symlinks))
+	for i, s := range expected.Symlinks {
+		assert.EqualValues(t, *s, *actual.Symlinks[i])
+	}
+}
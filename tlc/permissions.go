@@ -1,66 +1,341 @@
 package tlc
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"path"
+	"strings"
 
 	"github.com/go-errors/errors"
 	"github.com/itchio/wharf/wsync"
 )
 
-const minScannedFileSize = 4
+// headSize is how much of each file detectors get to look at
+const headSize = 64
 
-func (c *Container) FixPermissions(pool wsync.Pool) error {
-	defer pool.Close()
+// Executability is what a detector thinks of a file
+type Executability int
 
-	buf := make([]byte, minScannedFileSize)
-	for index, f := range c.Files {
-		if f.Size < minScannedFileSize {
-			continue
-		}
+const (
+	// ExecUnknown lets the next detector decide
+	ExecUnknown Executability = iota
+	// ExecYes means the file should be executable
+	ExecYes
+	// ExecNo means the file should not be executable
+	ExecNo
+)
 
-		r, err := pool.GetReader(int64(index))
-		if err != nil {
-			return errors.Wrap(err, 1)
-		}
+// An ExecutableDetector decides whether a file should be executable, from
+// its path and up to the first 64 bytes of its contents (fewer for
+// smaller files, none for empty files).
+type ExecutableDetector struct {
+	// Name is used as the reason of changes in a PermissionsReport
+	Name   string
+	Detect func(f *File, head []byte) Executability
+}
 
-		_, err = io.ReadFull(r, buf)
-		if err != nil {
-			return errors.Wrap(err, 1)
-		}
+// A MagicNumber identifies a file format by the bytes found at an offset
+type MagicNumber struct {
+	Description string
+	Offset      int
+	Bytes       []byte
+	// Check, if set, must also return true for the magic number to match
+	Check func(head []byte) bool
+	// Executable is true for executable formats. Files of other formats
+	// are known not to be executable.
+	Executable bool
+}
 
-		if isExecutable(buf) {
-			f.Mode |= 0111
-		}
+// Matches returns true if head starts with the magic number
+func (mn *MagicNumber) Matches(head []byte) bool {
+	end := mn.Offset + len(mn.Bytes)
+	if len(head) < end || !bytes.Equal(head[mn.Offset:end], mn.Bytes) {
+		return false
 	}
-
-	return nil
+	return mn.Check == nil || mn.Check(head)
 }
 
+// DefaultMagicNumbers are the formats recognized by MagicDetector.
 // cf. https://github.com/itchio/fnout/blob/master/src/index.js
-func isExecutable(buf []byte) bool {
+var DefaultMagicNumbers = []*MagicNumber{
 	// intel Mach-O executables start with 0xCEFAEDFE or 0xCFFAEDFE
 	// (old PowerPC Mach-O executables started with 0xFEEDFACE)
-	if (buf[0] == 0xCE || buf[0] == 0xCF) && buf[1] == 0xFA && buf[2] == 0xED && buf[3] == 0xFE {
-		return true
+	{Description: "Mach-O", Bytes: []byte{0xCE, 0xFA, 0xED, 0xFE}, Executable: true},
+	{Description: "Mach-O 64-bit", Bytes: []byte{0xCF, 0xFA, 0xED, 0xFE}, Executable: true},
+	{Description: "Mach-O", Bytes: []byte{0xFE, 0xED, 0xFA, 0xCE}, Executable: true},
+	{Description: "Mach-O 64-bit", Bytes: []byte{0xFE, 0xED, 0xFA, 0xCF}, Executable: true},
+	// Mach-O universal binaries start with 0xCAFEBABE, like Java classes.
+	// The next 4 bytes are the number of architectures for the former,
+	// and the class file version (45 or above) for the latter.
+	{Description: "Mach-O universal binary", Bytes: []byte{0xCA, 0xFE, 0xBA, 0xBE}, Executable: true,
+		Check: func(head []byte) bool {
+			return len(head) >= 8 && binary.BigEndian.Uint32(head[4:8]) < 45
+		}},
+	{Description: "Java class", Bytes: []byte{0xCA, 0xFE, 0xBA, 0xBE}},
+	// ELF executables and libraries start with 0x7F + 'ELF', AppImages too
+	{Description: "AppImage", Bytes: []byte{0x7F, 'E', 'L', 'F'}, Executable: true,
+		Check: func(head []byte) bool {
+			return len(head) >= 11 && head[8] == 'A' && head[9] == 'I' && (head[10] == 1 || head[10] == 2)
+		}},
+	{Description: "ELF", Bytes: []byte{0x7F, 'E', 'L', 'F'}, Executable: true},
+	// https://en.wikipedia.org/wiki/Shebang_(Unix)
+	{Description: "shebang", Bytes: []byte("#!"), Executable: true},
+
+	{Description: "PNG", Bytes: []byte{0x89, 'P', 'N', 'G'}},
+	{Description: "JPEG", Bytes: []byte{0xFF, 0xD8, 0xFF}},
+	{Description: "GIF", Bytes: []byte("GIF8")},
+	{Description: "zip", Bytes: []byte{'P', 'K', 0x03, 0x04}},
+	{Description: "gzip", Bytes: []byte{0x1F, 0x8B}},
+	{Description: "Ogg", Bytes: []byte("OggS")},
+	{Description: "RIFF", Bytes: []byte("RIFF")},
+	{Description: "FLAC", Bytes: []byte("fLaC")},
+	{Description: "PDF", Bytes: []byte("%PDF")},
+}
+
+// MagicDetector recognizes files by their magic numbers. The first
+// matching magic number decides.
+func MagicDetector(magics []*MagicNumber) *ExecutableDetector {
+	return &ExecutableDetector{
+		Name: "magic",
+		Detect: func(f *File, head []byte) Executability {
+			for _, mn := range magics {
+				if mn.Matches(head) {
+					if mn.Executable {
+						return ExecYes
+					}
+					return ExecNo
+				}
+			}
+			return ExecUnknown
+		},
 	}
+}
+
+// ScriptExtensions are the extensions ScriptDetector makes executable
+var ScriptExtensions = []string{".sh", ".bash", ".command", ".run"}
+
+// ScriptDetector makes shell scripts executable even when they
+// don't start with a shebang
+var ScriptDetector = &ExecutableDetector{
+	Name: "script",
+	Detect: func(f *File, head []byte) Executability {
+		ext := strings.ToLower(path.Ext(f.Path))
+		for _, scriptExt := range ScriptExtensions {
+			if ext == scriptExt {
+				return ExecYes
+			}
+		}
+		return ExecUnknown
+	},
+}
+
+// AppBundleDetector makes the files of the 'Contents/MacOS' directory
+// of macOS app bundles executable
+var AppBundleDetector = &ExecutableDetector{
+	Name: "app bundle",
+	Detect: func(f *File, head []byte) Executability {
+		if MatchPattern("**/*.app/Contents/MacOS/*", f.Path) {
+			return ExecYes
+		}
+		return ExecUnknown
+	},
+}
+
+// DefaultDetectors are used when PermissionSettings.Detectors is nil
+var DefaultDetectors = []*ExecutableDetector{
+	MagicDetector(DefaultMagicNumbers),
+	ScriptDetector,
+	AppBundleDetector,
+}
+
+// A PermissionRule forces the permissions of the files matching a
+// selection pattern (see MatchPattern)
+type PermissionRule struct {
+	Pattern string
+	Mode    uint32
+}
+
+// ParsePermissionRules reads rules, one per line, as a pattern followed
+// by a colon and an octal mode:
+//
+//	bin/**: 0755
+//	**/*.so: 0755
+//	docs/**: 0644
+//
+// Empty lines and lines starting with '#' are ignored.
+func ParsePermissionRules(r io.Reader) ([]PermissionRule, error) {
+	var rules []PermissionRule
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := func() error {
+			colon := strings.LastIndex(line, ":")
+			if colon < 0 {
+				return fmt.Errorf("expected 'pattern: mode', got %q", line)
+			}
 
-	// Mach-O universal binaries start with 0xCAFEBABE
-	// it's Apple's 'fat binary' stuff that contains multiple architectures
-	if buf[0] == 0xCA && buf[1] == 0xFE && buf[2] == 0xBA && buf[3] == 0xBE {
-		return true
+			rule := PermissionRule{Pattern: strings.TrimSpace(line[:colon])}
+			err := ValidatePattern(rule.Pattern)
+			if err != nil {
+				return err
+			}
+
+			rule.Mode, err = parseMode(strings.TrimSpace(line[colon+1:]))
+			if err != nil {
+				return err
+			}
+			if rule.Mode&^0777 != 0 {
+				return fmt.Errorf("mode %s has more than permission bits", formatMode(rule.Mode))
+			}
+
+			rules = append(rules, rule)
+			return nil
+		}()
+		if err != nil {
+			return nil, errors.Wrap(fmt.Errorf("line %d: %s", lineNumber, err.Error()), 0)
+		}
 	}
 
-	// ELF executables start with 0x7F454C46
-	// (e.g. 0x7F + 'ELF' in ASCII)
-	if buf[0] == 0x7F && buf[1] == 0x45 && buf[2] == 0x4C && buf[3] == 0x46 {
-		return true
+	err := scanner.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
 	}
+	return rules, nil
+}
 
-	// Shell scripts start with a shebang (#!)
-	// https://en.wikipedia.org/wiki/Shebang_(Unix)
-	if buf[0] == 0x23 && buf[1] == 0x21 {
-		return true
+// PermissionSettings tune FixPermissionsWithSettings
+type PermissionSettings struct {
+	// Rules force the permissions of the files they match, and take
+	// precedence over detectors. When several rules match a file, the
+	// last one wins. Rules only apply to files, not directories.
+	Rules []PermissionRule
+
+	// Detectors decide which files should be executable, the first one
+	// that doesn't return ExecUnknown wins. DefaultDetectors if nil.
+	Detectors []*ExecutableDetector
+
+	// ClearExecutable removes the executable bits of files that no rule
+	// or detector says should be executable. Otherwise, files are only
+	// ever made executable.
+	ClearExecutable bool
+}
+
+// A PermissionChange is the mode change of a file
+type PermissionChange struct {
+	Path    string
+	OldMode uint32
+	NewMode uint32
+	// Reason is the pattern of the rule, or the name of the detector
+	// that decided the new mode
+	Reason string
+}
+
+func (pc *PermissionChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s (%s)", pc.Path, formatMode(pc.OldMode&0777), formatMode(pc.NewMode&0777), pc.Reason)
+}
+
+// A PermissionsReport lists the changes made by FixPermissionsWithSettings,
+// in container order
+type PermissionsReport struct {
+	Changes []*PermissionChange
+}
+
+// FixPermissions makes the files of a container that look like
+// executables executable, reading them from pool, which it closes.
+func (c *Container) FixPermissions(pool wsync.Pool) error {
+	_, err := c.FixPermissionsWithSettings(pool, nil)
+	return err
+}
+
+// FixPermissionsWithSettings adjusts the modes of the files of a container
+// according to settings, reading them from pool, which it closes.
+// A nil *PermissionSettings uses DefaultDetectors and no rules.
+func (c *Container) FixPermissionsWithSettings(pool wsync.Pool, settings *PermissionSettings) (*PermissionsReport, error) {
+	defer pool.Close()
+
+	if settings == nil {
+		settings = &PermissionSettings{}
+	}
+	detectors := settings.Detectors
+	if detectors == nil {
+		detectors = DefaultDetectors
+	}
+
+	report := &PermissionsReport{}
+	buf := make([]byte, headSize)
+	for index, f := range c.Files {
+		newMode := f.Mode
+		reason := ""
+
+		ruleIndex := -1
+		for i, rule := range settings.Rules {
+			if MatchPattern(rule.Pattern, f.Path) {
+				ruleIndex = i
+			}
+		}
+
+		if ruleIndex >= 0 {
+			rule := settings.Rules[ruleIndex]
+			newMode = f.Mode&^0777 | rule.Mode
+			reason = fmt.Sprintf("rule '%s'", rule.Pattern)
+		} else if len(detectors) > 0 {
+			head := buf[:0]
+			if f.Size > 0 {
+				r, err := pool.GetReader(int64(index))
+				if err != nil {
+					return nil, errors.Wrap(err, 1)
+				}
+
+				n, err := io.ReadFull(r, buf)
+				if err != nil && err != io.ErrUnexpectedEOF {
+					return nil, errors.Wrap(err, 1)
+				}
+				head = buf[:n]
+			}
+
+			verdict := ExecUnknown
+			for _, detector := range detectors {
+				verdict = detector.Detect(f, head)
+				if verdict != ExecUnknown {
+					reason = detector.Name
+					break
+				}
+			}
+
+			switch {
+			case verdict == ExecYes:
+				newMode |= 0111
+			case settings.ClearExecutable:
+				newMode &^= 0111
+				if reason == "" {
+					reason = "not detected as executable"
+				}
+			}
+		} else if settings.ClearExecutable {
+			newMode &^= 0111
+			reason = "not detected as executable"
+		}
+
+		if newMode != f.Mode {
+			report.Changes = append(report.Changes, &PermissionChange{
+				Path:    f.Path,
+				OldMode: f.Mode,
+				NewMode: newMode,
+				Reason:  reason,
+			})
+			f.Mode = newMode
+		}
 	}
 
-	return false
+	return report, nil
 }
@@ -0,0 +1,151 @@
+package tlc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryPool struct {
+	contents [][]byte
+	closed   bool
+}
+
+func (mp *memoryPool) GetSize(fileIndex int64) int64 {
+	return int64(len(mp.contents[fileIndex]))
+}
+
+func (mp *memoryPool) GetReader(fileIndex int64) (io.Reader, error) {
+	return mp.GetReadSeeker(fileIndex)
+}
+
+func (mp *memoryPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
+	return bytes.NewReader(mp.contents[fileIndex]), nil
+}
+
+func (mp *memoryPool) Close() error {
+	mp.closed = true
+	return nil
+}
+
+func Test_FixPermissions(t *testing.T) {
+	entries := []struct {
+		path     string
+		mode     uint32
+		contents []byte
+	}{
+		{"bin/game", 0644, []byte{0x7F, 'E', 'L', 'F', 2, 1, 1, 0}},
+		{"Game.AppImage", 0644, []byte{0x7F, 'E', 'L', 'F', 2, 1, 1, 0, 'A', 'I', 2, 0}},
+		{"run", 0644, []byte("#!/bin/sh\n")},
+		{"launch.sh", 0644, []byte("java -jar game.jar\n")},
+		{"Game.app/Contents/MacOS/Game", 0644, []byte("not really a binary")},
+		{"Game.app/Contents/Info.plist", 0644, []byte("<plist>")},
+		{"universal", 0644, []byte{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 2}},
+		{"Main.class", 0755, []byte{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 52}},
+		{"logo.png", 0755, []byte{0x89, 'P', 'N', 'G', '\r', '\n'}},
+		{"readme.txt", 0755, []byte("hello")},
+		{"empty", 0644, nil},
+	}
+
+	makeContainer := func() (*Container, *memoryPool) {
+		c := &Container{}
+		pool := &memoryPool{}
+		for _, e := range entries {
+			c.Files = append(c.Files, &File{Path: e.path, Mode: e.mode, Size: int64(len(e.contents))})
+			pool.contents = append(pool.contents, e.contents)
+		}
+		return c, pool
+	}
+
+	modes := func(c *Container) map[string]uint32 {
+		res := make(map[string]uint32)
+		for _, f := range c.Files {
+			res[f.Path] = f.Mode
+		}
+		return res
+	}
+
+	c, pool := makeContainer()
+	must(t, c.FixPermissions(pool))
+	assert.True(t, pool.closed)
+	assert.EqualValues(t, map[string]uint32{
+		"bin/game":                     0755,
+		"Game.AppImage":                0755,
+		"run":                          0755,
+		"launch.sh":                    0755,
+		"Game.app/Contents/MacOS/Game": 0755,
+		"Game.app/Contents/Info.plist": 0644,
+		"universal":                    0755,
+		"Main.class":                   0755,
+		"logo.png":                     0755,
+		"readme.txt":                   0755,
+		"empty":                        0644,
+	}, modes(c), "without settings, files are only made executable")
+
+	c, pool = makeContainer()
+	rules, err := ParsePermissionRules(strings.NewReader(`
+# rules for the test build
+Game.app/**: 0644
+**/Info.plist: 0600
+readme.txt: 0755
+`))
+	must(t, err)
+
+	report, err := c.FixPermissionsWithSettings(pool, &PermissionSettings{
+		Rules:           rules,
+		ClearExecutable: true,
+	})
+	must(t, err)
+	assert.EqualValues(t, map[string]uint32{
+		"bin/game":                     0755,
+		"Game.AppImage":                0755,
+		"run":                          0755,
+		"launch.sh":                    0755,
+		"Game.app/Contents/MacOS/Game": 0644,
+		"Game.app/Contents/Info.plist": 0600,
+		"universal":                    0755,
+		"Main.class":                   0644,
+		"logo.png":                     0644,
+		"readme.txt":                   0755,
+		"empty":                        0644,
+	}, modes(c))
+
+	var reasons []string
+	for _, change := range report.Changes {
+		reasons = append(reasons, change.String())
+	}
+	assert.EqualValues(t, []string{
+		"bin/game: 0644 -> 0755 (magic)",
+		"Game.AppImage: 0644 -> 0755 (magic)",
+		"run: 0644 -> 0755 (magic)",
+		"launch.sh: 0644 -> 0755 (script)",
+		"Game.app/Contents/Info.plist: 0644 -> 0600 (rule '**/Info.plist')",
+		"universal: 0644 -> 0755 (magic)",
+		"Main.class: 0755 -> 0644 (magic)",
+		"logo.png: 0755 -> 0644 (magic)",
+	}, reasons)
+
+	t.Logf("Custom detectors")
+	c, pool = makeContainer()
+	report, err = c.FixPermissionsWithSettings(pool, &PermissionSettings{
+		Detectors: []*ExecutableDetector{{
+			Name: "everything",
+			Detect: func(f *File, head []byte) Executability {
+				return ExecYes
+			},
+		}},
+	})
+	must(t, err)
+	for _, f := range c.Files {
+		assert.EqualValues(t, 0755, f.Mode, f.Path)
+	}
+	assert.Len(t, report.Changes, 8)
+
+	for _, invalid := range []string{"bin/**", "bin/**: 0800", "bin/**: 04755", "[: 0755"} {
+		_, err := ParsePermissionRules(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
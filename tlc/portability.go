@@ -0,0 +1,231 @@
+package tlc
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A PortabilityIssueKind is a reason a path may not work on every platform
+type PortabilityIssueKind int
+
+const (
+	// IssueCaseCollision paths only differ by case, and are the same
+	// path on case-insensitive filesystems (Windows, macOS by default)
+	IssueCaseCollision PortabilityIssueKind = iota
+	// IssueNormalizationCollision paths only differ by Unicode normalization
+	// (NFC or NFD), and are the same path on macOS
+	IssueNormalizationCollision
+	// IssueReservedName paths have a component Windows reserves for
+	// devices, like CON or LPT1.txt
+	IssueReservedName
+	// IssueInvalidCharacter paths have a character Windows refuses,
+	// like ':' or '?', or a control character
+	IssueInvalidCharacter
+	// IssueTrailingDotOrSpace paths have a component ending with a dot or a
+	// space, which Windows strips
+	IssueTrailingDotOrSpace
+	// IssuePathTooLong paths are longer than PortabilityOptions.MaxPathLength
+	IssuePathTooLong
+	// IssueCaseRename paths were renamed from a path that only differs by case
+	// or normalization, which case-insensitive filesystems can't tell apart
+	IssueCaseRename
+)
+
+func (kind PortabilityIssueKind) String() string {
+	switch kind {
+	case IssueCaseCollision:
+		return "case collision"
+	case IssueNormalizationCollision:
+		return "normalization collision"
+	case IssueReservedName:
+		return "reserved name"
+	case IssueInvalidCharacter:
+		return "invalid character"
+	case IssueTrailingDotOrSpace:
+		return "trailing dot or space"
+	case IssuePathTooLong:
+		return "path too long"
+	case IssueCaseRename:
+		return "case-only rename"
+	}
+	return fmt.Sprintf("PortabilityIssueKind(%d)", int(kind))
+}
+
+// A PortabilityIssue is a path of a container that may not work on every platform
+type PortabilityIssue struct {
+	Kind PortabilityIssueKind
+	Path string
+	// Other is the path Path collides with, or was renamed from
+	Other string
+}
+
+func (pi *PortabilityIssue) String() string {
+	if pi.Other != "" {
+		return fmt.Sprintf("%s: '%s' and '%s'", pi.Kind, pi.Path, pi.Other)
+	}
+	return fmt.Sprintf("%s: '%s'", pi.Kind, pi.Path)
+}
+
+// ErrNotPortable is returned when a container has portability issues
+type ErrNotPortable struct {
+	Issues []*PortabilityIssue
+}
+
+var _ error = (*ErrNotPortable)(nil)
+
+func (e *ErrNotPortable) Error() string {
+	if len(e.Issues) == 1 {
+		return fmt.Sprintf("container is not portable: %s", e.Issues[0])
+	}
+	return fmt.Sprintf("container is not portable: %s (and %d more issues)", e.Issues[0], len(e.Issues)-1)
+}
+
+// DefaultMaxPathLength is Windows' MAX_PATH
+const DefaultMaxPathLength = 260
+
+// PortabilityOptions tune CheckPortability
+type PortabilityOptions struct {
+	// MaxPathLength is the longest a path may be, in UTF-16 code units like
+	// Windows counts them, DefaultMaxPathLength if 0. Installs don't happen
+	// at the root of a drive, so it's worth keeping some margin.
+	MaxPathLength int
+	// Previous is the container being upgraded from, if any, to report
+	// case-only renames
+	Previous *Container
+}
+
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+const invalidCharacters = `<>:"|?*\`
+
+// CheckPortability returns everything about a container's paths that may
+// break on other platforms or filesystems, sorted by path. Parent
+// directories are checked too, even if the container doesn't list them.
+func (c *Container) CheckPortability(opts *PortabilityOptions) []*PortabilityIssue {
+	if opts == nil {
+		opts = &PortabilityOptions{}
+	}
+	maxPathLength := opts.MaxPathLength
+	if maxPathLength <= 0 {
+		maxPathLength = DefaultMaxPathLength
+	}
+
+	var issues []*PortabilityIssue
+	paths := allPaths(c)
+
+	for _, p := range paths {
+		if len(utf16.Encode([]rune(p))) > maxPathLength {
+			issues = append(issues, &PortabilityIssue{Kind: IssuePathTooLong, Path: p})
+		}
+
+		// parents are checked on their own
+		name := path.Base(p)
+
+		if strings.IndexFunc(name, func(r rune) bool {
+			return r < 32 || strings.ContainsRune(invalidCharacters, r)
+		}) >= 0 {
+			issues = append(issues, &PortabilityIssue{Kind: IssueInvalidCharacter, Path: p})
+		}
+
+		if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			issues = append(issues, &PortabilityIssue{Kind: IssueTrailingDotOrSpace, Path: p})
+		}
+
+		stem := strings.ToLower(strings.TrimRight(strings.SplitN(name, ".", 2)[0], " "))
+		if reservedNames[stem] {
+			issues = append(issues, &PortabilityIssue{Kind: IssueReservedName, Path: p})
+		}
+	}
+
+	// paths are sorted, so the first path of a group is reported as Other
+	byNormalized := make(map[string]string)
+	byFolded := make(map[string]string)
+	for _, p := range paths {
+		normalized := norm.NFC.String(p)
+		if other, ok := byNormalized[normalized]; ok {
+			issues = append(issues, &PortabilityIssue{Kind: IssueNormalizationCollision, Path: p, Other: other})
+			continue
+		}
+		byNormalized[normalized] = p
+
+		folded := foldPath(p)
+		if other, ok := byFolded[folded]; ok {
+			issues = append(issues, &PortabilityIssue{Kind: IssueCaseCollision, Path: p, Other: other})
+			continue
+		}
+		byFolded[folded] = p
+	}
+
+	if opts.Previous != nil {
+		previous := make(map[string]string)
+		previousPaths := make(map[string]bool)
+		for _, p := range allPaths(opts.Previous) {
+			previous[foldPath(p)] = p
+			previousPaths[p] = true
+		}
+
+		current := make(map[string]bool)
+		for _, p := range paths {
+			current[p] = true
+		}
+
+		for _, p := range paths {
+			if previousPaths[p] {
+				continue
+			}
+			if other, ok := previous[foldPath(p)]; ok && !current[other] {
+				issues = append(issues, &PortabilityIssue{Kind: IssueCaseRename, Path: p, Other: other})
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Path < issues[j].Path
+	})
+	return issues
+}
+
+// foldPath returns a path that's the same for paths that only differ by
+// case or normalization
+func foldPath(p string) string {
+	return strings.ToLower(norm.NFC.String(p))
+}
+
+// allPaths returns the sorted paths of all entries of a container, and
+// of their parents
+func allPaths(c *Container) []string {
+	set := make(map[string]bool)
+	add := func(p string) {
+		for ; p != "." && p != "/" && p != "" && !set[p]; p = path.Dir(p) {
+			set[p] = true
+		}
+	}
+
+	for _, d := range c.Dirs {
+		add(d.Path)
+	}
+	for _, f := range c.Files {
+		add(f.Path)
+	}
+	for _, s := range c.Symlinks {
+		add(s.Path)
+	}
+
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,68 @@
+package tlc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckPortability(t *testing.T) {
+	file := func(p string) *File {
+		return &File{Path: p, Mode: 0644}
+	}
+
+	portable := &Container{
+		Dirs:  []*Dir{{Path: "data", Mode: 0755}},
+		Files: []*File{file("data/Foo.png"), file("data/bar.png"), file("readme.txt")},
+	}
+	assert.Empty(t, portable.CheckPortability(nil))
+
+	container := &Container{
+		Files: []*File{
+			file("Foo.png"),
+			file("foo.png"),
+			file("caf\u00e9.txt"),
+			file("cafe\u0301.txt"),
+			file("CON.txt"),
+			file("a:b"),
+			file("trailing."),
+		},
+	}
+
+	assert.EqualValues(t, []*PortabilityIssue{
+		{Kind: IssueReservedName, Path: "CON.txt"},
+		{Kind: IssueInvalidCharacter, Path: "a:b"},
+		{Kind: IssueNormalizationCollision, Path: "caf\u00e9.txt", Other: "cafe\u0301.txt"},
+		{Kind: IssueCaseCollision, Path: "foo.png", Other: "Foo.png"},
+		{Kind: IssueTrailingDotOrSpace, Path: "trailing."},
+	}, container.CheckPortability(nil))
+
+	// parents are checked even when the container doesn't list them
+	parents := &Container{
+		Files: []*File{file("Data/a.png"), file("data/b.png"), file("lpt1/c.png")},
+	}
+	assert.EqualValues(t, []*PortabilityIssue{
+		{Kind: IssueCaseCollision, Path: "data", Other: "Data"},
+		{Kind: IssueReservedName, Path: "lpt1"},
+	}, parents.CheckPortability(nil))
+
+	long := &Container{
+		Files: []*File{file(strings.Repeat("a", 20) + "/" + strings.Repeat("b", 20))},
+	}
+	assert.Empty(t, long.CheckPortability(nil))
+	assert.EqualValues(t, []*PortabilityIssue{
+		{Kind: IssuePathTooLong, Path: long.Files[0].Path},
+	}, long.CheckPortability(&PortabilityOptions{MaxPathLength: 30}))
+
+	previous := &Container{
+		Files: []*File{file("Readme.txt"), file("data.pak"), file("Kept.txt")},
+	}
+	next := &Container{
+		Files: []*File{file("readme.txt"), file("data.pak"), file("Kept.txt"), file("kept.txt")},
+	}
+	assert.EqualValues(t, []*PortabilityIssue{
+		{Kind: IssueCaseCollision, Path: "kept.txt", Other: "Kept.txt"},
+		{Kind: IssueCaseRename, Path: "readme.txt", Other: "Readme.txt"},
+	}, next.CheckPortability(&PortabilityOptions{Previous: previous}))
+}
@@ -2,15 +2,21 @@ package tlc
 
 import (
 	"os"
-	"path/filepath"
 
 	"github.com/go-errors/errors"
+	"github.com/itchio/wharf/safepath"
 )
 
 // Prepare creates all directories, files, and symlinks.
-// It also applies the proper permissions if the files already exist
+// It also applies the proper permissions if the files already exist.
+// It refuses to write anything outside of basePath (see safepath.Join).
 func (c *Container) Prepare(basePath string) error {
-	err := os.MkdirAll(basePath, 0755)
+	err := c.ValidatePaths()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(basePath, 0755)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
@@ -40,8 +46,17 @@ func (c *Container) Prepare(basePath string) error {
 }
 
 func (c *Container) prepareDir(basePath string, dirEntry *Dir) error {
-	fullPath := filepath.Join(basePath, dirEntry.Path)
-	err := os.MkdirAll(fullPath, os.FileMode(dirEntry.Mode))
+	fullPath, err := safepath.Join(basePath, dirEntry.Path)
+	if err != nil {
+		return err
+	}
+
+	err = safepath.RemoveIfSymlink(fullPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	err = os.MkdirAll(fullPath, os.FileMode(dirEntry.Mode))
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
@@ -54,7 +69,16 @@ func (c *Container) prepareDir(basePath string, dirEntry *Dir) error {
 }
 
 func (c *Container) prepareFile(basePath string, fileEntry *File) error {
-	fullPath := filepath.Join(basePath, fileEntry.Path)
+	fullPath, err := safepath.Join(basePath, fileEntry.Path)
+	if err != nil {
+		return err
+	}
+
+	err = safepath.RemoveIfSymlink(fullPath)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_TRUNC, os.FileMode(fileEntry.Mode))
 	if err != nil {
 		return errors.Wrap(err, 0)
@@ -79,8 +103,12 @@ func (c *Container) prepareFile(basePath string, fileEntry *File) error {
 }
 
 func (c *Container) prepareSymlink(basePath string, link *Symlink) error {
-	fullPath := filepath.Join(basePath, link.Path)
-	err := os.RemoveAll(fullPath)
+	fullPath, err := safepath.Join(basePath, link.Path)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(fullPath)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
@@ -0,0 +1,40 @@
+package tlc
+
+import "github.com/itchio/wharf/safepath"
+
+// ValidatePaths returns a *safepath.ErrUnsafePath if any entry of the
+// container has an unsafe path (see safepath.Validate).
+func (c *Container) ValidatePaths() error {
+	for _, d := range c.Dirs {
+		err := safepath.Validate(d.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range c.Files {
+		err := safepath.Validate(f.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.Symlinks {
+		err := safepath.Validate(s.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckSymlinks returns a *safepath.ErrUnsafePath if any of the
+// container's symlinks resolves outside of the container's root.
+func (c *Container) CheckSymlinks() error {
+	links := make(map[string]string)
+	for _, s := range c.Symlinks {
+		links[s.Path] = s.Dest
+	}
+	return safepath.CheckSymlinks(links)
+}
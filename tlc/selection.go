@@ -0,0 +1,236 @@
+package tlc
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// A Selection picks a subset of the entries of a container, by path.
+// It is used to install, patch, or validate only part of a build, for
+// example a single language pack, or everything but optional HD textures.
+//
+// Patterns are slash-separated and follow path.Match syntax, with the
+// addition of "**", which matches any number of path elements. A pattern
+// that matches a directory also matches everything inside it.
+//
+// A nil *Selection selects everything.
+type Selection struct {
+	// Include lists patterns of entries to select. If empty,
+	// every entry is selected (save for Exclude)
+	Include []string
+
+	// Exclude lists patterns of entries to leave out, even
+	// if they match Include
+	Exclude []string
+
+	// Keep lists patterns of entries to select, even if they
+	// match Exclude
+	Keep []string
+}
+
+// A TagMap associates tag names (like "lang-fr", or "hd-textures")
+// with the patterns of the entries that belong to them. Anything that
+// isn't tagged is always part of a selection built from a TagMap.
+type TagMap map[string][]string
+
+// Selection returns a selection that includes all untagged entries,
+// and the entries of the given tags. The entries of other tags are
+// excluded, unless they're also part of an enabled tag.
+// It returns an error if one of the tags is unknown.
+func (tm TagMap) Selection(tags ...string) (*Selection, error) {
+	enabled := make(map[string]bool)
+	for _, tag := range tags {
+		if _, ok := tm[tag]; !ok {
+			return nil, errors.Wrap(fmt.Errorf("unknown tag '%s'", tag), 0)
+		}
+		enabled[tag] = true
+	}
+
+	var names []string
+	for name := range tm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sel := &Selection{}
+	for _, name := range names {
+		if enabled[name] {
+			sel.Keep = append(sel.Keep, tm[name]...)
+		} else {
+			sel.Exclude = append(sel.Exclude, tm[name]...)
+		}
+	}
+
+	return sel, nil
+}
+
+// Validate returns an error if any of the selection's patterns is malformed
+func (sel *Selection) Validate() error {
+	if sel == nil {
+		return nil
+	}
+
+	for _, patterns := range [][]string{sel.Include, sel.Exclude, sel.Keep} {
+		for _, pattern := range patterns {
+			err := ValidatePattern(pattern)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the entry at entryPath is part of the selection
+func (sel *Selection) Matches(entryPath string) bool {
+	if sel == nil {
+		return true
+	}
+
+	if matchAny(sel.Keep, entryPath) {
+		return true
+	}
+
+	if matchAny(sel.Exclude, entryPath) {
+		return false
+	}
+
+	if len(sel.Include) == 0 {
+		return true
+	}
+
+	return matchAny(sel.Include, entryPath)
+}
+
+// FileIndices returns the set of indices of the files of c that are
+// part of the selection. It can be used as ApplyContext.SourceIndexWhiteList
+func (sel *Selection) FileIndices(c *Container) map[int64]bool {
+	indices := make(map[int64]bool)
+	for index, f := range c.Files {
+		if sel.Matches(f.Path) {
+			indices[int64(index)] = true
+		}
+	}
+	return indices
+}
+
+// Subset returns a new container with only the selected files and
+// symlinks, and the directories that either match the selection or
+// contain selected entries. Files keep their original offsets, so
+// the returned container's indices must not be used with pools made
+// for the original container.
+func (c *Container) Subset(sel *Selection) *Container {
+	if sel == nil {
+		return c
+	}
+
+	res := &Container{}
+	neededDirs := make(map[string]bool)
+	markParents := func(entryPath string) {
+		for dir := path.Dir(entryPath); dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+			neededDirs[dir] = true
+		}
+	}
+
+	for _, f := range c.Files {
+		if sel.Matches(f.Path) {
+			res.Files = append(res.Files, f)
+			res.Size += f.Size
+			markParents(f.Path)
+		}
+	}
+
+	for _, s := range c.Symlinks {
+		if sel.Matches(s.Path) {
+			res.Symlinks = append(res.Symlinks, s)
+			markParents(s.Path)
+		}
+	}
+
+	for _, d := range c.Dirs {
+		if sel.Matches(d.Path) {
+			markParents(d.Path)
+		}
+	}
+
+	for _, d := range c.Dirs {
+		if neededDirs[d.Path] || sel.Matches(d.Path) {
+			res.Dirs = append(res.Dirs, d)
+		}
+	}
+
+	return res
+}
+
+// ValidatePattern returns an error if pattern is not a valid selection pattern
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "**" {
+			continue
+		}
+		_, err := path.Match(elem, "")
+		if err != nil {
+			return errors.Wrap(fmt.Errorf("invalid pattern '%s': %s", pattern, err.Error()), 0)
+		}
+	}
+	return nil
+}
+
+// MatchPattern returns true if pattern matches entryPath, or one of
+// its parent directories. Malformed patterns never match.
+func MatchPattern(pattern string, entryPath string) bool {
+	patternElems := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathElems := strings.Split(path.Clean(entryPath), "/")
+
+	for i := len(pathElems); i > 0; i-- {
+		if matchElems(patternElems, pathElems[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchElems(pattern []string, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// try to match the rest of the pattern at every possible position
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(elems) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], elems[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		elems = elems[1:]
+	}
+
+	return len(elems) == 0
+}
+
+func matchAny(patterns []string, entryPath string) bool {
+	for _, pattern := range patterns {
+		if MatchPattern(pattern, entryPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package tlc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MatchPattern(t *testing.T) {
+	assert.True(t, MatchPattern("foo", "foo"))
+	assert.True(t, MatchPattern("foo", "foo/bar/baz"))
+	assert.False(t, MatchPattern("foo", "foobar"))
+	assert.True(t, MatchPattern("*.pak", "data.pak"))
+	assert.False(t, MatchPattern("*.pak", "lang/data.pak"))
+	assert.True(t, MatchPattern("**/*.pak", "lang/fr/data.pak"))
+	assert.True(t, MatchPattern("**/*.pak", "data.pak"))
+	assert.True(t, MatchPattern("lang/**/voice", "lang/fr/hd/voice/a.ogg"))
+	assert.False(t, MatchPattern("lang/**/voice", "music/voice"))
+	assert.False(t, MatchPattern("[", "["))
+
+	assert.NoError(t, ValidatePattern("lang/**/*.ogg"))
+	assert.Error(t, ValidatePattern("lang/[/x"))
+	assert.Error(t, ValidatePattern(""))
+}
+
+func Test_Selection(t *testing.T) {
+	var nilSel *Selection
+	assert.True(t, nilSel.Matches("anything"))
+	assert.NoError(t, nilSel.Validate())
+
+	sel := &Selection{
+		Include: []string{"bin", "lang/fr"},
+		Exclude: []string{"**/*.pdb"},
+	}
+	assert.True(t, sel.Matches("bin/game"))
+	assert.False(t, sel.Matches("bin/game.pdb"))
+	assert.True(t, sel.Matches("lang/fr/strings.txt"))
+	assert.False(t, sel.Matches("lang/en/strings.txt"))
+
+	tags := TagMap{
+		"lang-en":     {"lang/en"},
+		"lang-fr":     {"lang/fr"},
+		"hd-textures": {"**/*.hd.png"},
+	}
+
+	_, err := tags.Selection("lang-de")
+	assert.Error(t, err)
+
+	sel, err = tags.Selection("lang-fr")
+	must(t, err)
+	assert.True(t, sel.Matches("game.exe"))
+	assert.True(t, sel.Matches("lang/fr/strings.txt"))
+	assert.False(t, sel.Matches("lang/en/strings.txt"))
+	assert.True(t, sel.Matches("lang/fr/flag.hd.png"), "lang-fr is enabled")
+	assert.False(t, sel.Matches("textures/sky.hd.png"))
+
+	t.Logf("Entries with both enabled and disabled tags")
+	sel, err = tags.Selection("hd-textures")
+	must(t, err)
+	assert.True(t, sel.Matches("lang/fr/flag.hd.png"))
+	assert.True(t, sel.Matches("textures/sky.hd.png"))
+	assert.False(t, sel.Matches("lang/fr/strings.txt"))
+
+	sel, err = tags.Selection("lang-fr", "hd-textures")
+	must(t, err)
+	assert.True(t, sel.Matches("lang/fr/flag.hd.png"))
+	assert.True(t, sel.Matches("lang/en/flag.hd.png"), "hd-textures is enabled")
+	assert.False(t, sel.Matches("lang/en/strings.txt"))
+}
+
+func Test_Subset(t *testing.T) {
+	container := &Container{
+		Dirs: []*Dir{
+			{Path: "lang"},
+			{Path: "lang/en"},
+			{Path: "lang/fr"},
+			{Path: "bin"},
+		},
+		Files: []*File{
+			{Path: "bin/game", Size: 10},
+			{Path: "lang/en/strings.txt", Size: 20, Offset: 10},
+			{Path: "lang/fr/strings.txt", Size: 40, Offset: 30},
+		},
+		Symlinks: []*Symlink{
+			{Path: "lang/current", Dest: "en"},
+		},
+		Size: 70,
+	}
+
+	assert.True(t, container == container.Subset(nil), "nil selections should not copy")
+
+	sel := &Selection{Exclude: []string{"lang/en", "lang/current"}}
+	subset := container.Subset(sel)
+	assert.Equal(t, "2 files, 3 dirs, 0 symlinks", subset.Stats())
+	assert.EqualValues(t, 50, subset.Size)
+	assert.Equal(t, "lang/fr/strings.txt", subset.Files[1].Path)
+	assert.EqualValues(t, 30, subset.Files[1].Offset)
+
+	indices := sel.FileIndices(container)
+	assert.Equal(t, map[int64]bool{0: true, 2: true}, indices)
+
+	subset = container.Subset(&Selection{Include: []string{"lang/fr/*.txt"}})
+	assert.Equal(t, "1 files, 2 dirs, 0 symlinks", subset.Stats())
+}
@@ -0,0 +1,198 @@
+package tlc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Datadog/zstd"
+	"github.com/go-errors/errors"
+)
+
+// TarCompression is how a tar archive is compressed, as a whole
+type TarCompression int
+
+const (
+	// TarNone is for plain .tar files
+	TarNone TarCompression = iota
+	// TarGzip is for .tar.gz and .tgz files
+	TarGzip
+	// TarZstd is for .tar.zst and .tzst files
+	TarZstd
+)
+
+var tarSuffixes = []struct {
+	suffix      string
+	compression TarCompression
+}{
+	{".tar", TarNone},
+	{".tar.gz", TarGzip},
+	{".tgz", TarGzip},
+	{".tar.zst", TarZstd},
+	{".tzst", TarZstd},
+}
+
+// TarCompressionOf returns how a tar archive is compressed, judging by its
+// name, and false if the name isn't that of a tar archive
+func TarCompressionOf(name string) (TarCompression, bool) {
+	name = strings.ToLower(name)
+	for _, ts := range tarSuffixes {
+		if strings.HasSuffix(name, ts.suffix) {
+			return ts.compression, true
+		}
+	}
+	return TarNone, false
+}
+
+// NewTarDecompressor returns a reader for the uncompressed contents of a tar
+// archive. Closing it does not close r.
+func NewTarDecompressor(r io.Reader, compression TarCompression) (io.ReadCloser, error) {
+	switch compression {
+	case TarNone:
+		return ioutil.NopCloser(r), nil
+	case TarGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, 1)
+		}
+		return gr, nil
+	case TarZstd:
+		return zstd.NewReader(r), nil
+	}
+	return nil, errors.Wrap(fmt.Errorf("unknown tar compression %d", compression), 1)
+}
+
+// TarEntryKey returns the path a tar entry has in a container
+func TarEntryKey(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// WalkTar walks all entries of an (uncompressed) tar archive and returns a
+// container. Files are listed in archive order. Hard links are listed as
+// files with the contents of their target. Entries other than regular files,
+// hard links, directories and symlinks, or listed twice, are refused.
+func WalkTar(r io.Reader, opts *WalkOpts) (*Container, error) {
+	filter := opts.Filter
+	if filter == nil {
+		filter = DefaultFilter
+	}
+
+	if opts.Dereference {
+		return nil, errors.New("Dereference is not supported when walking a tar")
+	}
+
+	var Dirs []*Dir
+	var Symlinks []*Symlink
+	var Files []*File
+
+	seen := make(map[string]bool)
+	fileByPath := make(map[string]*File)
+	dirSeen := make(map[string]bool)
+	var addParents func(entryPath string)
+	addParents = func(entryPath string) {
+		// tar files don't always have directory entries either
+		dir := path.Dir(entryPath)
+		if dir == "." || dir == "/" || dirSeen[dir] {
+			return
+		}
+		addParents(dir)
+		dirSeen[dir] = true
+		Dirs = append(Dirs, &Dir{Path: dir, Mode: uint32(os.ModeDir | 0755)})
+	}
+
+	TotalOffset := int64(0)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.Wrap(err, 0)
+		}
+
+		entryPath := TarEntryKey(header.Name)
+		if entryPath == "." {
+			continue
+		}
+
+		info := header.FileInfo()
+		if !filter(info) {
+			continue
+		}
+
+		mode := info.Mode() | ModeMask
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			addParents(entryPath)
+			if dirSeen[entryPath] {
+				// it was implicit so far, or listed twice
+				for _, d := range Dirs {
+					if d.Path == entryPath {
+						d.Mode = uint32(mode)
+					}
+				}
+				continue
+			}
+			dirSeen[entryPath] = true
+			Dirs = append(Dirs, &Dir{Path: entryPath, Mode: uint32(mode)})
+			continue
+
+		case tar.TypeReg, tar.TypeRegA, tar.TypeLink, tar.TypeSymlink:
+			// handled below
+
+		default:
+			return nil, errors.Wrap(fmt.Errorf("%s: unsupported tar entry type %q", entryPath, header.Typeflag), 0)
+		}
+
+		if seen[entryPath] {
+			return nil, errors.Wrap(fmt.Errorf("%s: listed twice in tar", entryPath), 0)
+		}
+		seen[entryPath] = true
+		addParents(entryPath)
+
+		if header.Typeflag == tar.TypeSymlink {
+			Symlinks = append(Symlinks, &Symlink{
+				Path: entryPath,
+				Mode: uint32(mode),
+				Dest: filepath.ToSlash(header.Linkname),
+			})
+			continue
+		}
+
+		size := header.Size
+		if header.Typeflag == tar.TypeLink {
+			target, ok := fileByPath[TarEntryKey(header.Linkname)]
+			if !ok {
+				return nil, errors.Wrap(fmt.Errorf("%s: hard link to unknown file %s", entryPath, header.Linkname), 0)
+			}
+			size = target.Size
+		}
+
+		f := &File{
+			Path:   entryPath,
+			Mode:   uint32(mode),
+			Size:   size,
+			Offset: TotalOffset,
+		}
+		fileByPath[entryPath] = f
+		Files = append(Files, f)
+		TotalOffset += size
+	}
+
+	container := &Container{
+		Size:     TotalOffset,
+		Dirs:     Dirs,
+		Symlinks: Symlinks,
+		Files:    Files,
+	}
+	return container, nil
+}
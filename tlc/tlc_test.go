@@ -1,6 +1,9 @@
 package tlc
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
@@ -12,6 +15,7 @@ import (
 	"github.com/itchio/arkive/zip"
 
 	"github.com/itchio/wharf/archiver"
+	"github.com/itchio/wharf/safepath"
 	"github.com/itchio/wharf/state"
 	"github.com/stretchr/testify/assert"
 )
@@ -73,6 +77,74 @@ func Test_WalkZip(t *testing.T) {
 	must(t, container.EnsureEqual(zipContainer))
 }
 
+func Test_WalkTar(t *testing.T) {
+	tmpPath := mktestdir(t, "walktar")
+	defer os.RemoveAll(tmpPath)
+
+	tmpPath2, err := ioutil.TempDir("", "walktar2")
+	must(t, err)
+	defer os.RemoveAll(tmpPath2)
+
+	container, err := WalkDir(tmpPath, &WalkOpts{})
+	must(t, err)
+
+	for _, name := range []string{"container.tar", "container.tar.gz"} {
+		tarPath := filepath.Join(tmpPath2, name)
+		tarFile, err := os.Create(tarPath)
+		must(t, err)
+
+		if compression, _ := TarCompressionOf(name); compression == TarGzip {
+			gw := gzip.NewWriter(tarFile)
+			_, err = archiver.CompressTar(gw, tmpPath, &state.Consumer{})
+			must(t, err)
+			must(t, gw.Close())
+		} else {
+			_, err = archiver.CompressTar(tarFile, tmpPath, &state.Consumer{})
+			must(t, err)
+		}
+		must(t, tarFile.Close())
+
+		tarContainer, err := WalkAny(tarPath, &WalkOpts{})
+		must(t, err)
+		must(t, container.EnsureEqual(tarContainer))
+		assert.Equal(t, container.Size, tarContainer.Size, "%s: should report correct size", name)
+	}
+
+	t.Logf("Refusing duplicate entries")
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for i := 0; i < 2; i++ {
+		must(t, tw.WriteHeader(&tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644}))
+	}
+	must(t, tw.Close())
+	_, err = WalkTar(buf, &WalkOpts{})
+	assert.Error(t, err)
+
+	t.Logf("Hard links")
+	buf = new(bytes.Buffer)
+	tw = tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	_, err = tw.Write([]byte("data"))
+	must(t, err)
+	must(t, tw.WriteHeader(&tar.Header{Name: "dir/link", Typeflag: tar.TypeLink, Linkname: "file", Mode: 0755}))
+	must(t, tw.Close())
+	linkContainer, err := WalkTar(bytes.NewReader(buf.Bytes()), &WalkOpts{})
+	must(t, err)
+	if assert.Len(t, linkContainer.Files, 2) {
+		assert.EqualValues(t, "dir/link", linkContainer.Files[1].Path)
+		assert.EqualValues(t, 4, linkContainer.Files[1].Size)
+		assert.EqualValues(t, 4, linkContainer.Files[1].Offset)
+	}
+	assert.EqualValues(t, 8, linkContainer.Size)
+
+	buf = new(bytes.Buffer)
+	tw = tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "nowhere", Mode: 0644}))
+	must(t, tw.Close())
+	_, err = WalkTar(buf, &WalkOpts{})
+	assert.Error(t, err, "hard links to unknown files should be refused")
+}
+
 func Test_Walk(t *testing.T) {
 	tmpPath := mktestdir(t, "walk")
 	defer os.RemoveAll(tmpPath)
@@ -165,6 +237,42 @@ func Test_Prepare(t *testing.T) {
 	must(t, container.EnsureEqual(container2))
 }
 
+func Test_PrepareUnsafe(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "prepare-unsafe")
+	must(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	basePath := filepath.Join(tmpPath, "base")
+
+	for _, c := range []*Container{
+		{Files: []*File{{Path: "../escaped", Mode: 0644}}},
+		{Dirs: []*Dir{{Path: "/escaped", Mode: 0755}}},
+		{Symlinks: []*Symlink{{Path: "ok/../../escaped", Dest: "foo"}}},
+	} {
+		err = c.Prepare(basePath)
+		assert.True(t, safepath.IsUnsafePath(err), "%s should be refused", c.Stats())
+
+		_, err = os.Lstat(filepath.Join(tmpPath, "escaped"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	c := &Container{
+		Symlinks: []*Symlink{{Path: "link", Dest: ".."}},
+	}
+	must(t, c.Prepare(basePath))
+	assert.True(t, safepath.IsUnsafePath(c.CheckSymlinks()))
+
+	c = &Container{
+		Files: []*File{{Path: "link/escaped", Mode: 0644}},
+	}
+	err = c.Prepare(basePath)
+	assert.True(t, safepath.IsUnsafePath(err), "writes through symlinks should be refused")
+}
+
 // Support code
 
 func must(t *testing.T, err error) {
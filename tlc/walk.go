@@ -24,7 +24,7 @@ const (
 )
 
 var (
-	ErrUnrecognizedContainer = errors.New("Unrecognized container: should either be a directory, or a .zip archive")
+	ErrUnrecognizedContainer = errors.New("Unrecognized container: should either be a directory, a .zip or a tar archive")
 )
 
 // A FilterFunc allows ignoring certain files or directories when walking the filesystem
@@ -45,7 +45,8 @@ type WalkOpts struct {
 }
 
 // WalkAny tries to retrieve container information on containerPath. It supports:
-// the empty container (/dev/null), local directories, zip archives, or single files
+// the empty container (/dev/null), local directories, zip archives, tar archives
+// (see TarCompressionOf), or single files
 func WalkAny(containerPath string, opts *WalkOpts) (*Container, error) {
 	// empty container case
 	if containerPath == NullPath {
@@ -78,6 +79,16 @@ func WalkAny(containerPath string, opts *WalkOpts) (*Container, error) {
 		return WalkZip(zr, opts)
 	}
 
+	// tar archive case
+	if compression, ok := TarCompressionOf(stat.Name()); ok {
+		tr, err := NewTarDecompressor(file, compression)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+		defer tr.Close()
+		return WalkTar(tr, opts)
+	}
+
 	// single file case
 	return WalkSingle(file)
 }
@@ -0,0 +1,61 @@
+package wire
+
+import (
+	"fmt"
+)
+
+// Limits bound how much a ReadContext is willing to read, so that
+// corrupted or hostile files can't make it allocate or read arbitrary
+// amounts. Zero fields mean "no limit".
+type Limits struct {
+	// MaxMessageSize is the maximum size of a single message
+	MaxMessageSize int64
+	// MaxTotalSize is the maximum number of bytes read in total,
+	// magic numbers and length prefixes included
+	MaxTotalSize int64
+}
+
+// DefaultLimits are the limits of contexts returned by NewReadContext.
+// They're larger than any message wharf writes: callers reading a known
+// file format should set tighter ones.
+var DefaultLimits = Limits{
+	MaxMessageSize: 4 * 1024 * 1024 * 1024,
+}
+
+// ErrMessageTooLarge is returned by ReadMessage when a message's length
+// prefix is larger than what the ReadContext's limits allow. Nothing past
+// the length prefix is read (or allocated) in that case.
+type ErrMessageTooLarge struct {
+	// Offset is the position of the length prefix
+	Offset int64
+	// Size is the length the message claims to have
+	Size uint64
+	// Max is the limit that was exceeded
+	Max int64
+	// Total is true when the message would go past MaxTotalSize,
+	// rather than exceed MaxMessageSize
+	Total bool
+}
+
+var _ error = (*ErrMessageTooLarge)(nil)
+
+func (e *ErrMessageTooLarge) Error() string {
+	if e.Total {
+		return fmt.Sprintf("wire: message of %d bytes at offset %d would go past the total size limit (%d bytes)", e.Size, e.Offset, e.Max)
+	}
+	return fmt.Sprintf("wire: message of %d bytes at offset %d exceeds the message size limit (%d bytes)", e.Size, e.Offset, e.Max)
+}
+
+// check returns an *ErrMessageTooLarge if a message of the given size,
+// whose content starts at offset contentOffset, isn't allowed
+func (l Limits) check(prefixOffset int64, contentOffset int64, size uint64) error {
+	if l.MaxMessageSize > 0 && size > uint64(l.MaxMessageSize) {
+		return &ErrMessageTooLarge{Offset: prefixOffset, Size: size, Max: l.MaxMessageSize}
+	}
+
+	if l.MaxTotalSize > 0 && (contentOffset > l.MaxTotalSize || size > uint64(l.MaxTotalSize-contentOffset)) {
+		return &ErrMessageTooLarge{Offset: prefixOffset, Size: size, Max: l.MaxTotalSize, Total: true}
+	}
+
+	return nil
+}
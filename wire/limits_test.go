@@ -0,0 +1,106 @@
+package wire_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limits(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := wire.NewWriteContext(buf)
+	must(t, w.WriteMagic(magic))
+	must(t, w.WriteMessage(&wire.Sample{Data: make([]byte, 100)}))
+	must(t, w.WriteMessage(&wire.Sample{Data: make([]byte, 1000)}))
+	must(t, w.Close())
+
+	open := func(data []byte, limits wire.Limits) *wire.ReadContext {
+		r := wire.NewReadContext(seeksource.FromBytes(data))
+		r.SetLimits(limits)
+		must(t, r.Resume(nil))
+		must(t, r.ExpectMagic(magic))
+		return r
+	}
+
+	tooLarge := func(err error) *wire.ErrMessageTooLarge {
+		if se, ok := err.(*errors.Error); ok {
+			err = se.Err
+		}
+		mtl, ok := err.(*wire.ErrMessageTooLarge)
+		assert.True(t, ok, "should be an *ErrMessageTooLarge, got %v", err)
+		return mtl
+	}
+
+	msg := &wire.Sample{}
+
+	r := open(buf.Bytes(), wire.Limits{MaxMessageSize: 512})
+	must(t, r.ReadMessage(msg))
+	assert.EqualValues(t, 100, len(msg.Data))
+	if mtl := tooLarge(r.ReadMessage(msg)); mtl != nil {
+		assert.False(t, mtl.Total)
+		assert.EqualValues(t, 512, mtl.Max)
+	}
+
+	r = open(buf.Bytes(), wire.Limits{MaxTotalSize: int64(buf.Len() - 1)})
+	must(t, r.ReadMessage(msg))
+	if mtl := tooLarge(r.ReadMessage(msg)); mtl != nil {
+		assert.True(t, mtl.Total)
+	}
+
+	r = open(buf.Bytes(), wire.Limits{MaxTotalSize: int64(buf.Len())})
+	must(t, r.ReadMessage(msg))
+	must(t, r.ReadMessage(msg))
+	assert.EqualValues(t, 1000, len(msg.Data))
+
+	t.Logf("Reading a bogus length prefix")
+	bogus := new(bytes.Buffer)
+	must(t, binary.Write(bogus, wire.Endianness, magic))
+	prefix := make([]byte, binary.MaxVarintLen64)
+	bogus.Write(prefix[:binary.PutUvarint(prefix, 1024*1024*1024)])
+	bogus.Write(make([]byte, 64))
+
+	if mtl := tooLarge(open(bogus.Bytes(), wire.Limits{MaxMessageSize: 1024}).ReadMessage(msg)); mtl != nil {
+		assert.EqualValues(t, 4, mtl.Offset)
+		assert.EqualValues(t, 1024*1024*1024, mtl.Size)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := open(bogus.Bytes(), wire.Limits{}).ReadMessage(msg)
+	runtime.ReadMemStats(&after)
+	assert.Error(t, err, "should fail on truncated message")
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 16*1024*1024, "should not allocate for a truncated message")
+}
+
+func FuzzReadContext(f *testing.F) {
+	buf := new(bytes.Buffer)
+	w := wire.NewWriteContext(buf)
+	err := w.WriteMagic(magic)
+	for i := 0; i < 4 && err == nil; i++ {
+		err = w.WriteMessage(&wire.Sample{Data: make([]byte, 16*i), Number: int64(i)})
+	}
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte{0x0d, 0xad, 0xaf, 0x0f, 0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := wire.NewReadContext(seeksource.FromBytes(data))
+		r.SetLimits(wire.Limits{MaxMessageSize: 64 * 1024, MaxTotalSize: int64(len(data))})
+		if r.Resume(nil) != nil || r.ExpectMagic(magic) != nil {
+			return
+		}
+
+		msg := &wire.Sample{}
+		for r.ReadMessage(msg) == nil {
+			assert.True(t, len(msg.Data) <= len(data))
+		}
+	})
+}
@@ -24,6 +24,7 @@ type ReadContext struct {
 	offset         int64
 
 	protoBuffer *proto.Buffer
+	limits      Limits
 
 	saveState               saveState
 	sourceCheckpoint        *savior.SourceCheckpoint
@@ -48,6 +49,7 @@ func NewReadContext(source savior.Source) *ReadContext {
 		offset: 0,
 
 		protoBuffer: proto.NewBuffer(make([]byte, 32*1024)),
+		limits:      DefaultLimits,
 	}
 	r.countingReader = &countingReader{r}
 
@@ -71,6 +73,17 @@ func (r *ReadContext) GetSource() savior.Source {
 	return r.source
 }
 
+// SetLimits changes the limits enforced by ReadMessage, effective
+// from the next message on.
+func (r *ReadContext) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// GetLimits returns the limits currently enforced by ReadMessage
+func (r *ReadContext) GetLimits() Limits {
+	return r.limits
+}
+
 func (r *ReadContext) Resume(checkpoint *MessageReaderCheckpoint) error {
 	r.saveState = saveStateIdle
 	r.sourceCheckpoint = nil
@@ -143,12 +156,22 @@ func (r *ReadContext) PopCheckpoint() *MessageReaderCheckpoint {
 	return nil
 }
 
+// ReadMagic reads the next 32-bit int, for callers that accept several
+// kinds of files
+func (r *ReadContext) ReadMagic() (int32, error) {
+	var magic int32
+	err := binary.Read(r.countingReader, Endianness, &magic)
+	if err != nil {
+		return 0, errors.Wrap(err, 0)
+	}
+	return magic, nil
+}
+
 // ExpectMagic returns an error if the next 32-bit int is not the magic number specified
 func (r *ReadContext) ExpectMagic(magic int32) error {
-	var readMagic int32
-	err := binary.Read(r.countingReader, Endianness, &readMagic)
+	readMagic, err := r.ReadMagic()
 	if err != nil {
-		return errors.Wrap(err, 0)
+		return err
 	}
 
 	if magic != readMagic {
@@ -162,21 +185,26 @@ func (r *ReadContext) ExpectMagic(magic int32) error {
 func (r *ReadContext) ReadMessage(msg proto.Message) error {
 	savior.Debugf("wire.ReadContext: Reading message at %d", r.offset)
 
+	prefixOffset := r.offset
 	length, err := binary.ReadUvarint(r.countingReader)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
 
-	msgBuf := r.protoBuffer.Bytes()
-	if cap(msgBuf) < int(length) {
-		msgBuf = make([]byte, nextPowerOf2(int(length)))
+	err = r.limits.check(prefixOffset, r.offset, length)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if length > uint64(maxInt) {
+		return errors.Wrap(&ErrMessageTooLarge{Offset: prefixOffset, Size: length, Max: maxInt}, 0)
 	}
 
-	_, err = io.ReadFull(r.countingReader, msgBuf[:length])
+	msgBuf, err := r.readFrame(int(length))
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
-	r.protoBuffer.SetBuf(msgBuf[:length])
+	r.protoBuffer.SetBuf(msgBuf)
 
 	msg.Reset()
 
@@ -192,6 +220,61 @@ func (r *ReadContext) ReadMessage(msg proto.Message) error {
 	return nil
 }
 
+// maxInt is the largest message we could ever hold in memory
+const maxInt = int64(^uint(0) >> 1)
+
+// eagerFrameSize is the largest message for which we trust the length
+// prefix enough to allocate a buffer upfront. Larger buffers are grown
+// as data actually comes in, so that a bogus length prefix at the end of
+// a small file can't make us allocate gigabytes.
+const eagerFrameSize = 1024 * 1024
+
+// readFrame reads the next length bytes, re-using the proto buffer's
+// storage when possible
+func (r *ReadContext) readFrame(length int) ([]byte, error) {
+	msgBuf := r.protoBuffer.Bytes()
+	if cap(msgBuf) < length && length <= eagerFrameSize {
+		msgBuf = make([]byte, nextPowerOf2(length))
+	}
+
+	if cap(msgBuf) >= length {
+		msgBuf = msgBuf[:length]
+		_, err := io.ReadFull(r.countingReader, msgBuf)
+		if err != nil {
+			return nil, err
+		}
+		return msgBuf, nil
+	}
+
+	msgBuf = msgBuf[:0]
+	for len(msgBuf) < length {
+		chunkSize := length - len(msgBuf)
+		if chunkSize > eagerFrameSize {
+			chunkSize = eagerFrameSize
+		}
+
+		if cap(msgBuf)-len(msgBuf) < chunkSize {
+			newCap := 2 * cap(msgBuf)
+			if newCap < len(msgBuf)+chunkSize {
+				newCap = len(msgBuf) + chunkSize
+			}
+			if newCap > length {
+				newCap = length
+			}
+			grown := make([]byte, len(msgBuf), newCap)
+			copy(grown, msgBuf)
+			msgBuf = grown
+		}
+
+		n, err := io.ReadFull(r.countingReader, msgBuf[len(msgBuf):len(msgBuf)+chunkSize])
+		msgBuf = msgBuf[:len(msgBuf)+n]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msgBuf, nil
+}
+
 func nextPowerOf2(v int) int {
 	v--
 	v |= v >> 1
@@ -0,0 +1,519 @@
+package zipindex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/go-errors/errors"
+)
+
+// compress/flate can't start in the middle of a stream, and doesn't say
+// where its blocks start, so this is a small inflater (RFC 1951) that can
+// do both. Huffman codes are decoded the way zlib's puff.c does it: slower
+// than a lookup table, but short and easy to check.
+
+const (
+	// windowSize is how far back deflate matches can reach
+	windowSize = 32 * 1024
+	// chunkSize is how much is inflated at a time
+	chunkSize = 64 * 1024
+
+	maxCodeLen  = 15
+	maxLitCodes = 288
+	maxDstCodes = 30
+)
+
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// order in which code length code lengths are stored in dynamic blocks
+	codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLitCodes, fixedDstCodes = makeFixedCodes()
+)
+
+// ErrCorruptDeflate is returned when an entry's compressed data isn't valid deflate
+type ErrCorruptDeflate struct {
+	BitOffset int64
+	Reason    string
+}
+
+var _ error = (*ErrCorruptDeflate)(nil)
+
+func (e *ErrCorruptDeflate) Error() string {
+	return fmt.Sprintf("zipindex: corrupt deflate data at bit %d: %s", e.BitOffset, e.Reason)
+}
+
+// huffman is a canonical huffman code: the number of codes of each length,
+// and the symbols ordered by code
+type huffman struct {
+	count  [maxCodeLen + 1]int
+	symbol []int
+}
+
+func (h *huffman) init(lengths []uint8) bool {
+	h.count = [maxCodeLen + 1]int{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+
+	left := 1
+	for l := 1; l <= maxCodeLen; l++ {
+		left <<= 1
+		left -= h.count[l]
+		if left < 0 {
+			// over-subscribed. incomplete codes are tolerated, since
+			// decode fails on any code that's not in the set.
+			return false
+		}
+	}
+
+	var offsets [maxCodeLen + 1]int
+	for l := 1; l < maxCodeLen; l++ {
+		offsets[l+1] = offsets[l] + h.count[l]
+	}
+
+	h.symbol = make([]int, len(lengths))
+	for symbol, l := range lengths {
+		if l != 0 {
+			h.symbol[offsets[l]] = symbol
+			offsets[l]++
+		}
+	}
+	return true
+}
+
+func makeFixedCodes() (*huffman, *huffman) {
+	var lengths [maxLitCodes]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	lit := &huffman{}
+	lit.init(lengths[:])
+
+	var dstLengths [maxDstCodes]uint8
+	for i := range dstLengths {
+		dstLengths[i] = 5
+	}
+	dst := &huffman{}
+	dst.init(dstLengths[:])
+
+	return lit, dst
+}
+
+// An inflater decodes a raw deflate stream, starting either at its
+// beginning or at the start of any block, given the data that precedes it.
+type inflater struct {
+	r        *bufio.Reader
+	bitBuf   uint32
+	numBits  uint
+	consumed int64
+	startBit int64
+
+	// out holds decoded data: at least a window's worth of history,
+	// followed by data that hasn't been read yet, from rpos on
+	out  []byte
+	rpos int
+	// base is the uncompressed offset of out[0]
+	base int64
+
+	inBlock    bool
+	final      bool
+	done       bool
+	storedLeft int
+	litCodes   *huffman
+	dstCodes   *huffman
+	dynLit     huffman
+	dynDst     huffman
+
+	// onBlock, if set, is called before each block header is read
+	onBlock func(bitOffset int64, offset int64)
+}
+
+// newInflater returns an inflater reading compressed data from r. If
+// bitOffset isn't 0, r must start at byte bitOffset/8 of the stream, and
+// window must hold the uncompressed data before offset.
+func newInflater(r io.Reader, bitOffset int64, offset int64, window []byte) (*inflater, error) {
+	f := &inflater{
+		r:        bufio.NewReaderSize(r, chunkSize),
+		startBit: bitOffset &^ 7,
+		out:      make([]byte, len(window), 2*windowSize+chunkSize+lengthBase[28]),
+		rpos:     len(window),
+		base:     offset - int64(len(window)),
+	}
+	copy(f.out, window)
+
+	_, err := f.bits(uint(bitOffset & 7))
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// offset returns the uncompressed offset of the next byte Read returns
+func (f *inflater) offset() int64 {
+	return f.base + int64(f.rpos)
+}
+
+// bitOffset returns the offset, in bits, of the next bit to decode
+func (f *inflater) bitOffset() int64 {
+	return f.startBit + f.consumed*8 - int64(f.numBits)
+}
+
+// window returns a copy of (up to) a window's worth of data before
+// the current decoding position
+func (f *inflater) window() []byte {
+	start := len(f.out) - windowSize
+	if start < 0 {
+		start = 0
+	}
+	return append([]byte{}, f.out[start:]...)
+}
+
+func (f *inflater) corrupt(reason string) error {
+	return errors.Wrap(&ErrCorruptDeflate{BitOffset: f.bitOffset(), Reason: reason}, 1)
+}
+
+func (f *inflater) Read(p []byte) (int, error) {
+	for f.rpos == len(f.out) {
+		if f.done {
+			return 0, io.EOF
+		}
+
+		err := f.decodeChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.out[f.rpos:])
+	f.rpos += n
+	return n, nil
+}
+
+func (f *inflater) bits(n uint) (int, error) {
+	for f.numBits < n {
+		c, err := f.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, errors.Wrap(err, 1)
+		}
+		f.bitBuf |= uint32(c) << f.numBits
+		f.numBits += 8
+		f.consumed++
+	}
+
+	v := f.bitBuf & (1<<n - 1)
+	f.bitBuf >>= n
+	f.numBits -= n
+	return int(v), nil
+}
+
+func (f *inflater) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeLen; l++ {
+		bit, err := f.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit
+
+		count := h.count[l]
+		if code-count < first {
+			return h.symbol[index+(code-first)], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, f.corrupt("invalid huffman code")
+}
+
+// decodeChunk inflates about chunkSize bytes, or up to the end of the
+// stream. It's only called once all decoded data has been read.
+func (f *inflater) decodeChunk() error {
+	// keep a window's worth of history, drop the rest
+	if len(f.out) > windowSize {
+		drop := len(f.out) - windowSize
+		copy(f.out, f.out[drop:])
+		f.out = f.out[:windowSize]
+		f.rpos -= drop
+		f.base += int64(drop)
+	}
+
+	start := len(f.out)
+	for len(f.out)-start < chunkSize {
+		if !f.inBlock {
+			if f.final {
+				f.done = true
+				return nil
+			}
+
+			err := f.readBlockHeader()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.litCodes == nil {
+			err := f.copyStored(start)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		symbol, err := f.decode(f.litCodes)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case symbol < 256:
+			f.out = append(f.out, byte(symbol))
+		case symbol == 256:
+			f.inBlock = false
+		default:
+			symbol -= 257
+			if symbol >= len(lengthBase) {
+				return f.corrupt("invalid length symbol")
+			}
+			extra, err := f.bits(lengthExtra[symbol])
+			if err != nil {
+				return err
+			}
+			length := lengthBase[symbol] + extra
+
+			symbol, err = f.decode(f.dstCodes)
+			if err != nil {
+				return err
+			}
+			if symbol >= len(distBase) {
+				return f.corrupt("invalid distance symbol")
+			}
+			extra, err = f.bits(distExtra[symbol])
+			if err != nil {
+				return err
+			}
+			dist := distBase[symbol] + extra
+			if dist > len(f.out) {
+				return f.corrupt("distance too far back")
+			}
+
+			// matches may overlap with what they produce, copy one byte at a time
+			from := len(f.out) - dist
+			for i := 0; i < length; i++ {
+				f.out = append(f.out, f.out[from+i])
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *inflater) readBlockHeader() error {
+	if f.onBlock != nil {
+		f.onBlock(f.bitOffset(), f.base+int64(len(f.out)))
+	}
+
+	final, err := f.bits(1)
+	if err != nil {
+		return err
+	}
+	f.final = final == 1
+
+	blockType, err := f.bits(2)
+	if err != nil {
+		return err
+	}
+
+	switch blockType {
+	case 0:
+		err = f.readStoredHeader()
+	case 1:
+		f.litCodes, f.dstCodes = fixedLitCodes, fixedDstCodes
+	case 2:
+		err = f.readDynamicHeader()
+	default:
+		err = f.corrupt("invalid block type")
+	}
+	if err != nil {
+		return err
+	}
+
+	f.inBlock = true
+	return nil
+}
+
+func (f *inflater) readStoredHeader() error {
+	// stored blocks start on a byte boundary
+	f.bits(f.numBits & 7)
+
+	length, err := f.bits(16)
+	if err != nil {
+		return err
+	}
+	nlength, err := f.bits(16)
+	if err != nil {
+		return err
+	}
+	if length != ^nlength&0xffff {
+		return f.corrupt("stored block length doesn't match its complement")
+	}
+
+	f.litCodes, f.dstCodes = nil, nil
+	f.storedLeft = length
+	if length == 0 {
+		f.inBlock = false
+	}
+	return nil
+}
+
+// copyStored copies (part of) a stored block, up to the end of the chunk
+func (f *inflater) copyStored(chunkStart int) error {
+	n := f.storedLeft
+	if room := chunkSize - (len(f.out) - chunkStart); n > room {
+		n = room
+	}
+
+	// whatever is left in the bit buffer is whole bytes
+	for n > 0 && f.numBits > 0 {
+		c, _ := f.bits(8)
+		f.out = append(f.out, byte(c))
+		f.storedLeft--
+		n--
+	}
+
+	start := len(f.out)
+	f.out = f.out[:start+n]
+	read, err := io.ReadFull(f.r, f.out[start:])
+	f.consumed += int64(read)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, 0)
+	}
+
+	f.storedLeft -= n
+	if f.storedLeft == 0 {
+		f.inBlock = false
+	}
+	return nil
+}
+
+func (f *inflater) readDynamicHeader() error {
+	numLit, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	numLit += 257
+
+	numDst, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	numDst++
+
+	numCode, err := f.bits(4)
+	if err != nil {
+		return err
+	}
+	numCode += 4
+
+	if numLit > maxLitCodes || numDst > maxDstCodes {
+		return f.corrupt("too many length or distance codes")
+	}
+
+	var lengths [maxLitCodes + maxDstCodes]uint8
+	for i := 0; i < numCode; i++ {
+		l, err := f.bits(3)
+		if err != nil {
+			return err
+		}
+		lengths[codeLengthOrder[i]] = uint8(l)
+	}
+
+	var lengthCodes huffman
+	if !lengthCodes.init(lengths[:19]) {
+		return f.corrupt("invalid code lengths code")
+	}
+
+	for i := range lengths[:19] {
+		lengths[i] = 0
+	}
+
+	for i := 0; i < numLit+numDst; {
+		symbol, err := f.decode(&lengthCodes)
+		if err != nil {
+			return err
+		}
+
+		if symbol < 16 {
+			lengths[i] = uint8(symbol)
+			i++
+			continue
+		}
+
+		var value uint8
+		var repeat int
+		switch symbol {
+		case 16:
+			if i == 0 {
+				return f.corrupt("repeated length with no first length")
+			}
+			value = lengths[i-1]
+			repeat, err = f.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.bits(3)
+			repeat += 3
+		default:
+			repeat, err = f.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+
+		if i+repeat > numLit+numDst {
+			return f.corrupt("too many lengths")
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+
+	if lengths[256] == 0 {
+		return f.corrupt("no end-of-block code")
+	}
+
+	if !f.dynLit.init(lengths[:numLit]) {
+		return f.corrupt("invalid literal/length code")
+	}
+	if !f.dynDst.init(lengths[numLit : numLit+numDst]) {
+		return f.corrupt("invalid distance code")
+	}
+
+	f.litCodes, f.dstCodes = &f.dynLit, &f.dynDst
+	return nil
+}
@@ -0,0 +1,167 @@
+package zipindex
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/wharf/pools/zippool"
+)
+
+// Open returns a reader for a zip entry that seeks efficiently: stored
+// entries are read directly, and deflated entries are inflated from the
+// checkpoint closest to the offset being read. r must be what the zip.Reader
+// f comes from reads from. It returns nil, nil for entries the index doesn't
+// have, or has for a different version of the zip. It implements zippool.Index.
+//
+// Deflated entries are checked against their CRC32 when they're read through
+// from start to end, which is how they're typically read.
+func (index *Index) Open(r io.ReaderAt, f *zip.File) (io.ReadSeeker, error) {
+	dataOffset, err := f.DataOffset()
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	switch f.Method {
+	case zip.Store:
+		return io.NewSectionReader(r, dataOffset, int64(f.UncompressedSize64)), nil
+	case zip.Deflate:
+		entry := index.Entries[EntryKey(f.Name)]
+		if entry == nil ||
+			entry.CompressedSize != int64(f.CompressedSize64) ||
+			entry.UncompressedSize != int64(f.UncompressedSize64) ||
+			entry.CRC32 != f.CRC32 {
+			return nil, nil
+		}
+
+		return &entryReader{
+			entry:  entry,
+			data:   io.NewSectionReader(r, dataOffset, entry.CompressedSize),
+			hasher: crc32.NewIEEE(),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+var _ zippool.Index = (*Index)(nil)
+
+// entryReader reads a deflated entry, resuming at checkpoints when seeking
+type entryReader struct {
+	entry *Entry
+	data  *io.SectionReader
+
+	fl      *inflater
+	offset  int64
+	discard []byte
+
+	// hasher has seen the first hashed bytes of the entry
+	hasher hash.Hash32
+	hashed int64
+}
+
+var _ io.ReadSeeker = (*entryReader)(nil)
+
+func (er *entryReader) Read(p []byte) (int, error) {
+	size := er.entry.UncompressedSize
+	if er.offset >= size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > size-er.offset {
+		p = p[:size-er.offset]
+	}
+
+	err := er.catchUp()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := er.fl.Read(p)
+	if er.hashed == er.offset {
+		er.hasher.Write(p[:n])
+		er.hashed += int64(n)
+		if er.hashed == size && er.hasher.Sum32() != er.entry.CRC32 {
+			return n, errors.Wrap(fmt.Errorf("zipindex: %s: checksum mismatch", er.entry.Path), 0)
+		}
+	}
+	er.offset += int64(n)
+	if err == io.EOF {
+		// the entry said it was bigger than that
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// catchUp makes sure the inflater's next byte is the one at er.offset,
+// resuming from a checkpoint if that's closer than where it is now
+func (er *entryReader) catchUp() error {
+	checkpoints := er.entry.Checkpoints
+	i := sort.Search(len(checkpoints), func(i int) bool {
+		return checkpoints[i].Offset > er.offset
+	})
+
+	resumeOffset := int64(0)
+	if i > 0 {
+		resumeOffset = checkpoints[i-1].Offset
+	}
+
+	if er.fl == nil || er.fl.offset() > er.offset || er.fl.offset() < resumeOffset {
+		var err error
+		if i > 0 {
+			checkpoint := checkpoints[i-1]
+			data := io.NewSectionReader(er.data, checkpoint.BitOffset/8, er.entry.CompressedSize)
+			er.fl, err = newInflater(data, checkpoint.BitOffset, checkpoint.Offset, checkpoint.Window)
+		} else {
+			er.fl, err = newInflater(io.NewSectionReader(er.data, 0, er.entry.CompressedSize), 0, 0, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for er.fl.offset() < er.offset {
+		if er.discard == nil {
+			er.discard = make([]byte, chunkSize)
+		}
+
+		buf := er.discard
+		if left := er.offset - er.fl.offset(); int64(len(buf)) > left {
+			buf = buf[:left]
+		}
+
+		_, err := er.fl.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (er *entryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		// muffin
+	case io.SeekCurrent:
+		offset += er.offset
+	case io.SeekEnd:
+		offset += er.entry.UncompressedSize
+	default:
+		return er.offset, errors.Wrap(fmt.Errorf("zipindex: invalid whence %d", whence), 0)
+	}
+
+	if offset < 0 {
+		return er.offset, errors.Wrap(fmt.Errorf("zipindex: negative seek offset %d", offset), 0)
+	}
+
+	// the inflater catches up lazily, on next read
+	er.offset = offset
+	return er.offset, nil
+}
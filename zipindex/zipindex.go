@@ -0,0 +1,333 @@
+// Package zipindex builds and reads zip indices (.pzi files), which list
+// checkpoints inside the deflated entries of a zip, so that any offset
+// can be read without inflating an entry from the start.
+package zipindex
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/pools/zippool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+)
+
+// DefaultSpan is the minimum amount of uncompressed data between two
+// checkpoints. Seeking reads at most about that much, and an index takes
+// up about 32KiB per span (before compression).
+const DefaultSpan int64 = 1024 * 1024
+
+// An Index holds the checkpoints of the deflated entries of a zip
+type Index struct {
+	// Entries are indexed by slashed, clean path, like ZipPool does
+	Entries map[string]*Entry
+}
+
+// An Entry is an indexed zip entry
+type Entry struct {
+	Path             string
+	CompressedSize   int64
+	UncompressedSize int64
+	// CRC32 is the checksum of the uncompressed entry, as listed in the zip
+	CRC32 uint32
+	// Checkpoints are ordered by offset. The start of the entry isn't one.
+	Checkpoints []*pwr.ZipIndexCheckpoint
+}
+
+// BuildOptions tune how an index is built
+type BuildOptions struct {
+	// Span is the minimum amount of uncompressed data between checkpoints,
+	// DefaultSpan if 0
+	Span     int64
+	Consumer *state.Consumer
+}
+
+// EntryKey returns the key of a zip entry in Index.Entries
+func EntryKey(name string) string {
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// indexable returns true for regular files, ie. entries a ZipPool can read
+func indexable(f *zip.File) bool {
+	info := f.FileInfo()
+	return !info.IsDir() && info.Mode()&os.ModeSymlink == 0
+}
+
+// Build inflates all the deflated entries of a zip, and records a checkpoint
+// at the start of the first deflate block after every span. r must be what
+// zr reads from.
+func Build(zr *zip.Reader, r io.ReaderAt, opts BuildOptions) (*Index, error) {
+	span := opts.Span
+	if span <= 0 {
+		span = DefaultSpan
+	}
+
+	index := &Index{
+		Entries: make(map[string]*Entry),
+	}
+
+	for _, f := range zr.File {
+		if f.Method != zip.Deflate || !indexable(f) {
+			continue
+		}
+
+		entry, err := buildEntry(f, r, span)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Consumer != nil {
+			opts.Consumer.Debugf("%s: %d checkpoints", entry.Path, len(entry.Checkpoints))
+		}
+		index.Entries[entry.Path] = entry
+	}
+
+	return index, nil
+}
+
+func buildEntry(f *zip.File, r io.ReaderAt, span int64) (*Entry, error) {
+	entry := &Entry{
+		Path:             EntryKey(f.Name),
+		CompressedSize:   int64(f.CompressedSize64),
+		UncompressedSize: int64(f.UncompressedSize64),
+		CRC32:            f.CRC32,
+	}
+
+	dataOffset, err := f.DataOffset()
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	fl, err := newInflater(io.NewSectionReader(r, dataOffset, entry.CompressedSize), 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lastOffset := int64(0)
+	fl.onBlock = func(bitOffset int64, offset int64) {
+		if offset-lastOffset < span {
+			return
+		}
+		lastOffset = offset
+
+		entry.Checkpoints = append(entry.Checkpoints, &pwr.ZipIndexCheckpoint{
+			Offset:    offset,
+			BitOffset: bitOffset,
+			Window:    fl.window(),
+		})
+	}
+
+	hasher := crc32.NewIEEE()
+	size, err := io.Copy(hasher, fl)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, entry.Path, 0)
+	}
+
+	if size != entry.UncompressedSize || hasher.Sum32() != f.CRC32 {
+		err = fmt.Errorf("%s: inflated data doesn't match size and checksum in zip", entry.Path)
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return entry, nil
+}
+
+// WriteIndex writes an index in wharf's zip index format (.pzi), entries
+// sorted by path. Does not close indexWriter.
+func WriteIndex(indexWriter io.Writer, compression *pwr.CompressionSettings, index *Index) error {
+	rawWire := wire.NewWriteContext(indexWriter)
+	err := rawWire.WriteMagic(pwr.ZipIndexMagic)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	err = rawWire.WriteMessage(&pwr.ZipIndexHeader{
+		Compression: compression,
+	})
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	wire, err := pwr.CompressWire(rawWire, compression)
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	paths := make([]string, 0, len(index.Entries))
+	for path := range index.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	zie := &pwr.ZipIndexEntry{}
+	for _, path := range paths {
+		entry := index.Entries[path]
+
+		zie.Reset()
+		zie.Path = entry.Path
+		zie.CompressedSize = entry.CompressedSize
+		zie.UncompressedSize = entry.UncompressedSize
+		zie.Crc32 = entry.CRC32
+		zie.NumCheckpoints = int64(len(entry.Checkpoints))
+		err = wire.WriteMessage(zie)
+		if err != nil {
+			return errors.Wrap(err, 1)
+		}
+
+		for _, checkpoint := range entry.Checkpoints {
+			err = wire.WriteMessage(checkpoint)
+			if err != nil {
+				return errors.Wrap(err, 1)
+			}
+		}
+	}
+
+	err = wire.Close()
+	if err != nil {
+		return errors.Wrap(err, 1)
+	}
+
+	return nil
+}
+
+// ReadIndex reads an index from a wharf zip index file (.pzi)
+func ReadIndex(indexReader savior.SeekSource) (*Index, error) {
+	index := &Index{
+		Entries: make(map[string]*Entry),
+	}
+
+	rawWire := wire.NewReadContext(indexReader)
+	rawWire.SetLimits(pwr.ZipIndexWireLimits.ContainerLimits())
+	err := rawWire.ExpectMagic(pwr.ZipIndexMagic)
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+
+	zih := &pwr.ZipIndexHeader{}
+	err = rawWire.ReadMessage(zih)
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+
+	wire, err := pwr.DecompressWire(rawWire, zih.GetCompression())
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+
+	wire.SetLimits(pwr.ZipIndexWireLimits.BodyLimits())
+
+	for {
+		zie := &pwr.ZipIndexEntry{}
+		err = wire.ReadMessage(zie)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return index, nil
+			}
+			return nil, errors.Wrap(err, 1)
+		}
+
+		entry := &Entry{
+			Path:             zie.Path,
+			CompressedSize:   zie.CompressedSize,
+			UncompressedSize: zie.UncompressedSize,
+			CRC32:            zie.Crc32,
+		}
+
+		lastOffset := int64(0)
+		for i := int64(0); i < zie.NumCheckpoints; i++ {
+			checkpoint := &pwr.ZipIndexCheckpoint{}
+			err = wire.ReadMessage(checkpoint)
+			if err != nil {
+				return nil, errors.Wrap(err, 1)
+			}
+
+			if checkpoint.Offset <= lastOffset || checkpoint.Offset > entry.UncompressedSize ||
+				checkpoint.BitOffset < 0 || checkpoint.BitOffset > entry.CompressedSize*8 ||
+				len(checkpoint.Window) > windowSize {
+				err = fmt.Errorf("zip index format error: invalid checkpoint %d for %s", i, entry.Path)
+				return nil, errors.Wrap(err, 1)
+			}
+			lastOffset = checkpoint.Offset
+
+			entry.Checkpoints = append(entry.Checkpoints, checkpoint)
+		}
+
+		index.Entries[entry.Path] = entry
+	}
+}
+
+// Load reads an index from a .pzi file, local or remote (see eos.Open)
+func Load(indexPath string) (*Index, error) {
+	f, err := eos.Open(indexPath)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+	defer f.Close()
+
+	source := seeksource.FromFile(f)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	return ReadIndex(source)
+}
+
+// A Pool is a ZipPool that closes the zip it reads from when it's closed,
+// after which it can't be used anymore
+type Pool struct {
+	*zippool.ZipPool
+	file io.Closer
+}
+
+// Close closes the ZipPool, then the zip
+func (p *Pool) Close() error {
+	err := p.ZipPool.Close()
+	if err != nil {
+		return err
+	}
+
+	if p.file != nil {
+		err = p.file.Close()
+		p.file = nil
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+	}
+	return nil
+}
+
+// NewPool opens a zip, local or remote (see eos.Open), and returns a pool
+// for it that uses index to seek inside entries
+func NewPool(c *tlc.Container, zipPath string, index *Index) (*Pool, error) {
+	f, err := eos.Open(zipPath)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	stats, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, 0)
+	}
+
+	zr, err := zip.NewReader(f, stats.Size())
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, 0)
+	}
+
+	zp := zippool.New(c, zr)
+	zp.UseIndex(f, index)
+	return &Pool{ZipPool: zp, file: f}, nil
+}
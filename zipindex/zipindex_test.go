@@ -0,0 +1,269 @@
+package zipindex
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pools/zippool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeText returns data that deflates to many dynamic blocks
+func makeText(size int, seed int64) []byte {
+	words := []string{"wharf", "patch", "block", "signature", "zip", "deflate", "window", "offset", "\n"}
+	r := rand.New(rand.NewSource(seed))
+
+	buf := new(bytes.Buffer)
+	for buf.Len() < size {
+		fmt.Fprintf(buf, "%s %d ", words[r.Intn(len(words))], r.Intn(1000))
+	}
+	return buf.Bytes()[:size]
+}
+
+func makeRandom(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+type testEntry struct {
+	name   string
+	method uint16
+	data   []byte
+}
+
+func makeZip(t *testing.T, entries []testEntry) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		wtest.Must(t, err)
+		_, err = w.Write(e.data)
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, zw.Close())
+	return buf.Bytes()
+}
+
+func Test_Inflater(t *testing.T) {
+	for _, data := range [][]byte{
+		makeText(300*1024, 0x1),
+		makeRandom(200*1024, 0x2),
+		append(makeText(100*1024, 0x3), makeRandom(100*1024, 0x4)...),
+		[]byte("a"),
+		nil,
+	} {
+		for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression, flate.HuffmanOnly} {
+			compressed := new(bytes.Buffer)
+			fw, err := flate.NewWriter(compressed, level)
+			wtest.Must(t, err)
+			_, err = fw.Write(data)
+			wtest.Must(t, err)
+			wtest.Must(t, fw.Close())
+
+			type block struct {
+				bitOffset int64
+				offset    int64
+				window    []byte
+			}
+			var blocks []block
+
+			fl, err := newInflater(bytes.NewReader(compressed.Bytes()), 0, 0, nil)
+			wtest.Must(t, err)
+			fl.onBlock = func(bitOffset int64, offset int64) {
+				blocks = append(blocks, block{bitOffset, offset, fl.window()})
+			}
+
+			inflated, err := ioutil.ReadAll(fl)
+			wtest.Must(t, err)
+			assert.True(t, bytes.Equal(data, inflated), "level %d: should inflate to the original", level)
+
+			// resuming from any block should give the rest of the data
+			for _, b := range blocks {
+				r := bytes.NewReader(compressed.Bytes()[b.bitOffset/8:])
+				fl, err := newInflater(r, b.bitOffset, b.offset, b.window)
+				wtest.Must(t, err)
+
+				rest, err := ioutil.ReadAll(fl)
+				wtest.Must(t, err)
+				assert.True(t, bytes.Equal(data[b.offset:], rest), "level %d: should resume at bit %d", level, b.bitOffset)
+			}
+		}
+	}
+
+	_, err := ioutil.ReadAll(&io.LimitedReader{R: mustInflater(t, []byte{0xff, 0xff}), N: 1024})
+	assert.Error(t, err, "invalid block type")
+}
+
+func mustInflater(t *testing.T, compressed []byte) *inflater {
+	fl, err := newInflater(bytes.NewReader(compressed), 0, 0, nil)
+	wtest.Must(t, err)
+	return fl
+}
+
+func Test_ZipIndex(t *testing.T) {
+	entries := []testEntry{
+		{"text", zip.Deflate, makeText(3*1024*1024, 0x1)},
+		{"subdir/random", zip.Deflate, makeRandom(1024*1024, 0x2)},
+		{"subdir/mixed", zip.Deflate, append(makeText(512*1024, 0x3), makeRandom(512*1024, 0x4)...)},
+		{"small", zip.Deflate, []byte("hello")},
+		{"stored", zip.Store, makeRandom(300*1024, 0x5)},
+	}
+	zipData := makeZip(t, entries)
+	zipReaderAt := bytes.NewReader(zipData)
+
+	zr, err := zip.NewReader(zipReaderAt, int64(len(zipData)))
+	wtest.Must(t, err)
+
+	built, err := Build(zr, zipReaderAt, BuildOptions{Span: 256 * 1024})
+	wtest.Must(t, err)
+	assert.EqualValues(t, 4, len(built.Entries), "only deflated entries are indexed")
+	assert.True(t, len(built.Entries["text"].Checkpoints) > 4)
+	assert.EqualValues(t, 0, len(built.Entries["small"].Checkpoints))
+
+	indexBuf := new(bytes.Buffer)
+	wtest.Must(t, WriteIndex(indexBuf, &pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_NONE}, built))
+
+	source := seeksource.FromBytes(indexBuf.Bytes())
+	_, err = source.Resume(nil)
+	wtest.Must(t, err)
+	index, err := ReadIndex(source)
+	wtest.Must(t, err)
+	assert.EqualValues(t, built, index)
+
+	r := rand.New(rand.NewSource(0x5eed))
+	for _, e := range entries {
+		var f *zip.File
+		for _, zf := range zr.File {
+			if zf.Name == e.name {
+				f = zf
+			}
+		}
+
+		rs, err := index.Open(zipReaderAt, f)
+		wtest.Must(t, err)
+
+		size := int64(len(e.data))
+		buf := make([]byte, 4096)
+		for i := 0; i < 50; i++ {
+			offset := r.Int63n(size)
+			_, err := rs.Seek(offset, io.SeekStart)
+			wtest.Must(t, err)
+
+			n, err := io.ReadFull(rs, buf)
+			if err != io.ErrUnexpectedEOF {
+				wtest.Must(t, err)
+			}
+			assert.True(t, bytes.Equal(e.data[offset:offset+int64(n)], buf[:n]), "%s: should read at %d", e.name, offset)
+		}
+
+		end, err := rs.Seek(0, io.SeekEnd)
+		wtest.Must(t, err)
+		assert.EqualValues(t, size, end)
+		n, err := rs.Read(buf)
+		assert.EqualValues(t, 0, n)
+		assert.Equal(t, io.EOF, err)
+	}
+
+	t.Logf("Ignoring stale entries")
+	index.Entries["text"].UncompressedSize++
+	rs, err := index.Open(zipReaderAt, zr.File[0])
+	wtest.Must(t, err)
+	assert.Nil(t, rs)
+	index.Entries["text"].UncompressedSize--
+
+	index.Entries["text"].CRC32++
+	rs, err = index.Open(zipReaderAt, zr.File[0])
+	wtest.Must(t, err)
+	assert.Nil(t, rs, "entries with the same sizes but another checksum are stale")
+
+	t.Logf("Verifying checksums")
+	mismatched := *zr.File[0]
+	mismatched.CRC32 = index.Entries["text"].CRC32
+	rs, err = index.Open(zipReaderAt, &mismatched)
+	wtest.Must(t, err)
+	_, err = ioutil.ReadAll(rs)
+	assert.Error(t, err, "reading through should verify the checksum")
+	index.Entries["text"].CRC32--
+
+	rs, err = index.Open(zipReaderAt, zr.File[0])
+	wtest.Must(t, err)
+	_, err = ioutil.ReadAll(rs)
+	wtest.Must(t, err)
+
+	t.Logf("Seeking in a zippool")
+	container, err := tlc.WalkZip(zr, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	zp := zippool.New(container, zr)
+	zp.UseIndex(zipReaderAt, built)
+	defer zp.Close()
+
+	for fileIndex, f := range container.Files {
+		var expected []byte
+		for _, e := range entries {
+			if e.name == f.Path {
+				expected = e.data
+			}
+		}
+
+		rs, err := zp.GetReadSeeker(int64(fileIndex))
+		wtest.Must(t, err)
+
+		offset := f.Size / 2
+		_, err = rs.Seek(offset, io.SeekStart)
+		wtest.Must(t, err)
+		rest, err := ioutil.ReadAll(rs)
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(expected[offset:], rest), "%s: should read from zippool", f.Path)
+	}
+}
+
+func Test_NewPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zipindex-pool")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	data := makeText(512*1024, 0x6)
+	zipPath := filepath.Join(dir, "build.zip")
+	wtest.Must(t, ioutil.WriteFile(zipPath, makeZip(t, []testEntry{{"text", zip.Deflate, data}}), 0644))
+
+	zipFile, err := os.Open(zipPath)
+	wtest.Must(t, err)
+	stats, err := zipFile.Stat()
+	wtest.Must(t, err)
+	zr, err := zip.NewReader(zipFile, stats.Size())
+	wtest.Must(t, err)
+	index, err := Build(zr, zipFile, BuildOptions{Span: 64 * 1024})
+	wtest.Must(t, err)
+	container, err := tlc.WalkZip(zr, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	wtest.Must(t, zipFile.Close())
+
+	pool, err := NewPool(container, zipPath, index)
+	wtest.Must(t, err)
+
+	rs, err := pool.GetReadSeeker(0)
+	wtest.Must(t, err)
+	_, err = rs.Seek(int64(len(data)/2), io.SeekStart)
+	wtest.Must(t, err)
+	rest, err := ioutil.ReadAll(rs)
+	wtest.Must(t, err)
+	assert.True(t, bytes.Equal(data[len(data)/2:], rest))
+
+	wtest.Must(t, pool.Close())
+	assert.Nil(t, pool.file, "closing the pool should close the zip")
+	wtest.Must(t, pool.Close())
+}